@@ -1,17 +1,23 @@
 package main
 
 import (
-	"context"
 	"fmt"
 	"net"
 	"os"
 	"strconv"
-	"strings"
 	"time"
+
+	"github.com/ourines/GateShift/internal/dns"
 )
 
+// lookup is a name and record type to query
+type lookup struct {
+	name  string
+	qtype uint16
+}
+
 func main() {
-	// 要解析的域名
+	// 要解析的域名或IP地址（IP地址会进行反向查询）
 	domain := "example.com"
 	if len(os.Args) > 1 {
 		domain = os.Args[1]
@@ -26,144 +32,68 @@ func main() {
 		}
 	}
 
-	// 检查 DNS 服务是否正在运行
-	dnsAddr := fmt.Sprintf("127.0.0.1:%d", port)
+	dnsAddr := net.JoinHostPort("127.0.0.1", strconv.Itoa(port))
 	fmt.Printf("Testing DNS resolution for %s using %s...\n", domain, dnsAddr)
 
-	// 首先检查 DNS 服务器可连接性
-	conn, err := net.DialTimeout("udp", dnsAddr, 2*time.Second)
-	if err != nil {
-		fmt.Printf("Failed to connect to DNS server at %s: %v\n", dnsAddr, err)
-		fmt.Println("Make sure the DNS service is running with 'sudo ./bin/gateshift dns show'")
-		fmt.Println("You can restart it with 'sudo ./bin/gateshift dns restart'")
+	var queries []lookup
+	if ip := net.ParseIP(domain); ip != nil {
+		queries = append(queries, lookup{dns.ReverseName(ip), dns.TypePTR})
 	} else {
-		fmt.Printf("Successfully connected to DNS server at %s\n", dnsAddr)
-		conn.Close()
+		queries = append(queries, lookup{domain, dns.TypeA}, lookup{domain, dns.TypeAAAA})
 	}
 
-	// 使用系统的 DNS 设置进行解析
-	fmt.Println("\n1. Using system DNS settings:")
-	start := time.Now()
+	failed := false
+	for _, q := range queries {
+		fmt.Printf("\n%s %s:\n", q.name, dns.TypeString(q.qtype))
 
-	// 使用系统默认 DNS 解析
-	ips, err := net.LookupIP(domain)
-	elapsed := time.Since(start)
-
-	if err != nil {
-		fmt.Printf("Failed to resolve %s: %v\n", domain, err)
-	} else {
-		fmt.Printf("Successfully resolved %s in %v\n", domain, elapsed.Round(time.Millisecond))
-		fmt.Println("IP Addresses found:")
-		for _, ip := range ips {
-			fmt.Printf("  %s\n", ip.String())
+		// 连续发送两次相同查询，第二次应由代理缓存应答
+		cold, coldLatency, err := query(dnsAddr, q.name, q.qtype)
+		if err != nil {
+			fmt.Printf("  Query failed: %v\n", err)
+			failed = true
+			continue
 		}
-	}
+		printResponse(cold)
 
-	// 明确指定我们的 DNS 服务器
-	fmt.Printf("\n2. Using explicit DNS server at %s:\n", dnsAddr)
-	r := &net.Resolver{
-		PreferGo: true,
-		Dial: func(ctx context.Context, network, address string) (net.Conn, error) {
-			d := net.Dialer{
-				Timeout: time.Second * 10,
-			}
-			return d.DialContext(ctx, network, dnsAddr)
-		},
-	}
-
-	start = time.Now()
-	ipsExplicit, err := r.LookupIPAddr(context.Background(), domain)
-	elapsedExplicit := time.Since(start)
+		_, warmLatency, err := query(dnsAddr, q.name, q.qtype)
+		if err != nil {
+			fmt.Printf("  Repeated query failed: %v\n", err)
+			failed = true
+			continue
+		}
 
-	if err != nil {
-		fmt.Printf("Failed to resolve %s via explicit resolver: %v\n", domain, err)
-		fmt.Println("Try running with sudo or checking your DNS proxy status")
-	} else {
-		fmt.Printf("Successfully resolved %s via explicit resolver in %v\n", domain, elapsedExplicit.Round(time.Millisecond))
-		fmt.Println("IP Addresses found:")
-		for _, ip := range ipsExplicit {
-			fmt.Printf("  %s\n", ip.String())
+		fmt.Printf("  Latency: cold %v, warm %v", coldLatency.Round(time.Microsecond), warmLatency.Round(time.Microsecond))
+		if warmLatency < coldLatency {
+			fmt.Printf(" (%.1fx faster, answered from cache)\n", float64(coldLatency)/float64(warmLatency))
+		} else {
+			fmt.Println(" (no cache speed-up observed)")
 		}
 	}
 
-	// 测试原始 UDP DNS 查询
-	fmt.Printf("\n3. Testing raw UDP DNS query to %s:\n", dnsAddr)
-	result := testRawDNSQuery(domain, dnsAddr)
-	if result {
-		fmt.Printf("Successfully received response for raw DNS query to %s\n", domain)
-	} else {
-		fmt.Printf("Failed to receive response for raw DNS query\n")
+	if failed {
+		fmt.Println("\nMake sure the DNS service is running with 'gateshift dns status'")
+		os.Exit(1)
 	}
 }
 
-// 测试原始 DNS 查询
-func testRawDNSQuery(domain string, serverAddr string) bool {
-	// 创建 UDP 连接
-	conn, err := net.Dial("udp", serverAddr)
-	if err != nil {
-		fmt.Printf("Error creating UDP connection: %v\n", err)
-		return false
-	}
-	defer conn.Close()
-
-	// 设置超时
-	conn.SetDeadline(time.Now().Add(5 * time.Second))
-
-	// 构建一个简单的 DNS 查询包
-	query := buildDNSQuery(domain)
-
-	// 发送查询
-	_, err = conn.Write(query)
-	if err != nil {
-		fmt.Printf("Error sending DNS query: %v\n", err)
-		return false
-	}
-
-	// 接收响应
-	resp := make([]byte, 512)
-	n, err := conn.Read(resp)
-	if err != nil {
-		fmt.Printf("Error receiving DNS response: %v\n", err)
-		return false
-	}
-
-	// 如果收到响应，则认为测试成功
-	fmt.Printf("Received %d bytes in response\n", n)
-	return true
+// query sends a single query to server and returns the decoded response and its latency
+func query(server, name string, qtype uint16) (*dns.Message, time.Duration, error) {
+	return dns.Lookup(server, name, qtype, 5*time.Second)
 }
 
-// 构建一个简单的 DNS 查询包
-func buildDNSQuery(domain string) []byte {
-	// 简单的 DNS 查询包，查询 A 记录
-	query := []byte{
-		0x00, 0x01, // ID
-		0x01, 0x00, // 标准查询
-		0x00, 0x01, // 问题数: 1
-		0x00, 0x00, // 应答数: 0
-		0x00, 0x00, // 权威应答数: 0
-		0x00, 0x00, // 附加数: 0
+// printResponse prints the rcode and answer records of a response
+func printResponse(resp *dns.Message) {
+	fmt.Printf("  Rcode: %s", dns.RcodeString(resp.Rcode))
+	if resp.Truncated {
+		fmt.Print(" (truncated)")
 	}
+	fmt.Println()
 
-	// 添加查询的域名
-	parts := net.ParseIP(domain)
-	if parts == nil {
-		// 如果不是 IP 地址，按照域名处理
-		labels := strings.Split(domain, ".")
-		for _, label := range labels {
-			query = append(query, byte(len(label)))
-			query = append(query, []byte(label)...)
-		}
-	} else {
-		// 如果是 IP 地址，构建反向查询
-		query = append(query, 0x07) // 7 字节
-		query = append(query, []byte("example")...)
-		query = append(query, 0x03) // 3 字节
-		query = append(query, []byte("com")...)
+	if len(resp.Answers) == 0 {
+		fmt.Println("  No answer records")
+		return
+	}
+	for _, rr := range resp.Answers {
+		fmt.Printf("  %-30s %6ds  %-5s %s\n", rr.Name, rr.TTL, dns.TypeString(rr.Type), rr.Value)
 	}
-
-	query = append(query, 0x00)       // 域名结束
-	query = append(query, 0x00, 0x01) // 类型: A
-	query = append(query, 0x00, 0x01) // 类: IN
-
-	return query
 }
@@ -0,0 +1,234 @@
+package main
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"time"
+
+	"github.com/spf13/cobra"
+
+	"github.com/ourines/GateShift/internal/dns"
+	"github.com/ourines/GateShift/internal/gateway"
+	"github.com/ourines/GateShift/internal/notify"
+	"github.com/ourines/GateShift/internal/utils"
+	"github.com/ourines/GateShift/pkg/config"
+)
+
+// defaultReconcileInterval is the default of the --interval flag of daemon
+const defaultReconcileInterval = 10 * time.Second
+
+func daemonCmd() *cobra.Command {
+	var ifaceName string
+	var interval, timeout time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "daemon",
+		Short: "Keep the proxy gateway applied and run the DNS proxy in one process",
+		Long: `Run GateShift as a single long-lived process: switch to the proxy gateway,
+start the DNS proxy (with its control API when dns.control_addr is set) and
+keep both applied until stopped.
+
+The gateway of the interface is checked whenever the default route changes
+(see 'gateshift gateway watch') and every --interval, and switched back to a
+proxy gateway whenever something else changed it, such as a DHCP renewal or
+a network change. On SIGHUP the DNS and gateway settings are re-read from
+the config file; on Windows, reload the DNS settings with 'gateshift dns
+reload' and restart the daemon for gateway settings.
+
+On Ctrl+C or SIGTERM, including 'gateshift dns stop', and when
+dns.idle_timeout is reached, the DNS proxy is stopped, the system DNS is
+restored and the interface is switched back to the default gateway.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if interval <= 0 {
+				return fmt.Errorf("--interval must be positive")
+			}
+			if timeout <= 0 {
+				return fmt.Errorf("--timeout must be positive")
+			}
+			if isServiceRunning() {
+				return fmt.Errorf("the DNS service is already running; stop it with 'gateshift dns stop' first")
+			}
+			if err := checkNoPassthrough(); err != nil {
+				return err
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+			if err := cfg.Validate(); err != nil {
+				return err
+			}
+
+			notify.SetEnabled(cfg.Notifications)
+			return runDaemon(cfg, ifaceName, interval, timeout)
+		},
+	}
+
+	cmd.Flags().StringVarP(&ifaceName, "interface", "i", "", "Network interface to use instead of auto-detecting")
+	cmd.Flags().DurationVar(&interval, "interval", defaultReconcileInterval, "How often to check that the proxy gateway is still applied, besides on route changes")
+	cmd.Flags().DurationVar(&timeout, "timeout", defaultSwitchTimeout, "Maximum time to wait for each gateway change")
+	cmd.Flags().StringVar(&DNSPIDFile, "pid-file", DNSPIDFile, "Path of the DNS service PID file")
+	cmd.Flags().MarkHidden("pid-file")
+	return cmd
+}
+
+// runDaemon 切换到代理网关并启动DNS服务，之后在路由变化时和定期核对网关，直到收到停止信号；
+// 退出时停止DNS服务并切换回默认网关
+func runDaemon(cfg *config.Config, ifaceName string, interval, timeout time.Duration) error {
+	supervisor := &gatewaySupervisor{ifaceName: ifaceName, timeout: timeout, cfg: cfg}
+
+	// 预演模式下只显示将要执行的网关切换与DNS设置
+	if utils.DryRun() {
+		if err := supervisor.apply(); err != nil {
+			return err
+		}
+		printDNSDryRun(cfg)
+		return nil
+	}
+
+	// 代理网关暂时不可用时仍启动DNS服务，之后定期重试
+	supervisor.reconcile()
+
+	shutdown, err := startDNSService(cfg, false)
+	if err != nil {
+		supervisor.restore()
+		var inUse *dns.PortInUseError
+		if errors.As(err, &inUse) {
+			return fmt.Errorf("%w\n%s", err, portOwnerHint(inUse.Owner))
+		}
+		return err
+	}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	fmt.Printf("GateShift daemon running, checking the gateway on route changes and every %v. Press Ctrl+C to stop.\n", interval)
+	waitForStop(func(sig os.Signal) {
+		reloadDNSProxy(sig)
+		supervisor.reload()
+	}, gatewayChecks(ctx, interval), supervisor.reconcile)
+
+	// 按与启动相反的顺序恢复：先停止DNS服务，再切换回默认网关
+	utils.Infof("Shutting down GateShift daemon...\n")
+	shutdown()
+	supervisor.restore()
+	return nil
+}
+
+// gatewayChecks 在默认路由变化时以及每隔 interval 触发一次网关核对，直到 ctx 结束；
+// 核对期间到来的多次触发合并为一次
+func gatewayChecks(ctx context.Context, interval time.Duration) <-chan struct{} {
+	routes := gateway.WatchDefaultRoute(ctx)
+	ticker := time.NewTicker(interval)
+	wake := make(chan struct{}, 1)
+	go func() {
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case event, ok := <-routes:
+				if !ok {
+					return
+				}
+				utils.Logf("Default route changed: %s (was %s)", event.Route, event.Previous)
+			case <-ticker.C:
+			}
+			select {
+			case wake <- struct{}{}:
+			default:
+			}
+		}
+	}()
+	return wake
+}
+
+// gatewaySupervisor 让接口保持使用代理网关。它只在 waitForStop 的循环中使用，
+// 不需要加锁
+type gatewaySupervisor struct {
+	ifaceName string
+	timeout   time.Duration
+	cfg       *config.Config
+	// lastErr 是上次核对失败的原因，相同的失败只报告一次
+	lastErr string
+}
+
+// apply 切换到第一个可达且能连通互联网的代理网关
+func (s *gatewaySupervisor) apply() error {
+	_, err := switchProxyGateway(s.ifaceName, s.cfg.ProxyGateways, s.cfg.Hooks.OnProxy, s.cfg.Hooks.Timeout, s.timeout)
+	return err
+}
+
+// reconcile 在接口的网关不是代理网关时重新切换
+func (s *gatewaySupervisor) reconcile() {
+	iface, err := gateway.GetInterface(s.ifaceName)
+	if err != nil {
+		s.report(fmt.Errorf("failed to get active interface: %w", err))
+		return
+	}
+	if usesProxyGateway(iface, s.cfg.ProxyGateways) {
+		s.report(nil)
+		return
+	}
+
+	utils.Logf("Interface %s uses gateway %s instead of a proxy gateway, switching", iface.Name, valueOrDash(iface.Gateway))
+	s.report(s.apply())
+}
+
+// report 报告核对结果：失败原因变化时输出警告，从失败中恢复时记录日志
+func (s *gatewaySupervisor) report(err error) {
+	msg := ""
+	if err != nil {
+		msg = err.Error()
+	}
+	if msg == s.lastErr {
+		return
+	}
+
+	if err != nil {
+		fmt.Printf("Warning: could not apply a proxy gateway, retrying: %v\n", err)
+	} else {
+		utils.Logf("Proxy gateway applied again")
+	}
+	s.lastErr = msg
+}
+
+// reload 重新读取配置中的网关与钩子设置并立即核对；配置有误时保留原设置
+func (s *gatewaySupervisor) reload() {
+	cfg, err := config.LoadConfig()
+	if err == nil {
+		err = cfg.Validate()
+	}
+	if err != nil {
+		fmt.Printf("Error: reload failed, keeping previous gateway settings: %v\n", err)
+		return
+	}
+
+	s.cfg = cfg
+	notify.SetEnabled(cfg.Notifications)
+	s.reconcile()
+}
+
+// restore 切换回默认网关
+func (s *gatewaySupervisor) restore() {
+	if err := switchGateway(s.ifaceName, s.cfg.DefaultGateway, "on_default", s.cfg.Hooks.OnDefault, s.cfg.Hooks.Timeout, s.timeout); err != nil {
+		fmt.Printf("Warning: Failed to restore the default gateway: %v\n", err)
+	}
+}
+
+// usesProxyGateway 判断接口当前是否使用其中一个代理网关
+func usesProxyGateway(iface *gateway.NetworkInterface, gateways []string) bool {
+	current := net.ParseIP(iface.Gateway)
+	if current == nil {
+		return false
+	}
+	for _, gw := range gateways {
+		if current.Equal(net.ParseIP(gw)) {
+			return true
+		}
+	}
+	return false
+}
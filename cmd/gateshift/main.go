@@ -1,30 +1,56 @@
 package main
 
 import (
+	"archive/tar"
+	"archive/zip"
+	"bytes"
+	"compress/gzip"
+	"context"
+	"crypto/sha256"
+	"encoding/binary"
+	"encoding/hex"
 	"encoding/json"
+	"errors"
 	"fmt"
 	"io"
+	"log"
 	"net"
 	"net/http"
+	"net/url"
 	"os"
 	"os/exec"
+	"os/signal"
+	"path"
 	"path/filepath"
 	"runtime"
+	"strconv"
 	"strings"
+	"sync"
 	"syscall"
+	"text/tabwriter"
 	"time"
 
 	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
 
 	"github.com/ourines/GateShift/internal/dns"
 	"github.com/ourines/GateShift/internal/gateway"
+	"github.com/ourines/GateShift/internal/notify"
+	"github.com/ourines/GateShift/internal/service"
 	"github.com/ourines/GateShift/internal/utils"
 	"github.com/ourines/GateShift/pkg/config"
 )
 
 var (
 	cfgFile string
-	rootCmd = &cobra.Command{
+	dryRun  bool
+	quiet   bool
+	verbose bool
+	noColor bool
+	noSudo  bool
+	// sudoPrompt 为空时使用配置文件中的 sudo_prompt
+	sudoPrompt string
+	rootCmd    = &cobra.Command{
 		Use:   "gateshift",
 		Short: "A tool to switch between gateway configurations",
 		Long: `GateShift is a cross-platform tool for switching between gateway configurations.
@@ -56,77 +82,198 @@ func init() {
 		DNSPIDFile = filepath.Join(dataDir, "dns.pid")
 	}
 
-	// Cobra 初始化前检查是否有配置文件路径参数
-	for i, arg := range os.Args {
-		if arg == "--config" && i+1 < len(os.Args) {
-			cfgFile = os.Args[i+1]
-			break
-		}
-	}
-
 	// Add commands
 	rootCmd.AddCommand(proxyCmd())
 	rootCmd.AddCommand(defaultCmd())
 	rootCmd.AddCommand(configCmd())
 	rootCmd.AddCommand(statusCmd())
+	rootCmd.AddCommand(interfacesCmd())
+	rootCmd.AddCommand(gatewayCmd())
 	rootCmd.AddCommand(versionCmd())
 	rootCmd.AddCommand(installCmd())
 	rootCmd.AddCommand(uninstallCmd())
 	rootCmd.AddCommand(upgradeCmd())
 	rootCmd.AddCommand(dnsCmd)
+	rootCmd.AddCommand(daemonCmd())
 
 	// Add flags
 	rootCmd.PersistentFlags().StringVar(&cfgFile, "config", "", "config file (default is $HOME/.gateshift/config.yaml)")
+	rootCmd.PersistentFlags().BoolVar(&dryRun, "dry-run", false, "Print the commands that would change gateway or DNS settings without running them")
+	rootCmd.PersistentFlags().BoolVarP(&quiet, "quiet", "q", false, "Print only errors and essential results")
+	rootCmd.PersistentFlags().BoolVarP(&verbose, "verbose", "v", false, "Also print the commands that are executed")
+	rootCmd.PersistentFlags().BoolVar(&noColor, "no-color", false, "Disable colored output")
+	rootCmd.PersistentFlags().BoolVar(&noSudo, "no-sudo", false, "Never run commands with elevated privileges: skip or fail them instead of asking for a password")
+	rootCmd.PersistentFlags().StringVar(&sudoPrompt, "sudo-prompt", "", "How to ask for the password when privileges are needed: auto, terminal or gui (default from config, auto)")
+
+	// 在执行任何子命令前应用配置文件路径、dry-run 模式、提权与密码提示方式、输出级别与颜色
+	rootCmd.PersistentPreRunE = func(cmd *cobra.Command, args []string) error {
+		if quiet && verbose {
+			return fmt.Errorf("--quiet and --verbose cannot be used together")
+		}
+
+		config.SetConfigFile(cfgFile)
+		if err := applySudoSettings(); err != nil {
+			return err
+		}
+		utils.SetDryRun(dryRun)
+		utils.SetNoSudo(noSudo)
+		// 启用 sudo_keepalive 时延续之前命令的提权会话，避免再次输入密码
+		utils.ResumeSudoSession(15 * time.Minute)
+		// 仅在终端中且未设置 NO_COLOR 时输出颜色
+		utils.SetColor(!noColor && utils.ColorSupported(os.Stdout))
+		switch {
+		case quiet:
+			utils.SetLogLevel(utils.LevelQuiet)
+		case verbose:
+			utils.SetLogLevel(utils.LevelVerbose)
+		}
+		return nil
+	}
+}
+
+// applySudoSettings 应用配置文件中的提权设置；密码提示方式可由 --sudo-prompt 覆盖
+func applySudoSettings() error {
+	// 配置文件有误时由各命令自行报告，这里保持默认设置
+	mode, keepAlive, err := config.LoadSudoSettings()
+	if err == nil {
+		utils.SetSudoKeepAlive(keepAlive)
+	}
+
+	if sudoPrompt != "" {
+		if err := utils.SetSudoPrompt(sudoPrompt); err != nil {
+			return fmt.Errorf("--sudo-prompt: %w", err)
+		}
+	} else if err == nil {
+		utils.SetSudoPrompt(mode)
+	}
+	return nil
 }
 
+// defaultSwitchTimeout is the default of the --timeout flag of proxy and default
+const defaultSwitchTimeout = 30 * time.Second
+
 func proxyCmd() *cobra.Command {
+	var ifaceName string
+	var timeout time.Duration
+	var setDNS bool
+
 	cmd := &cobra.Command{
 		Use:   "proxy",
 		Short: "Switch to the proxy gateway",
-		Long:  `Switch the current active network interface to use the configured proxy gateway.`,
+		Long: `Switch the current active network interface to use the configured proxy gateway.
+When several proxy gateways are configured they are tried in order, and the
+first one that is reachable and provides internet connectivity is selected.
+
+With --set-dns the system DNS is also pointed at the selected proxy gateway,
+as 'gateshift dns set-system' does, when it answers DNS queries on UDP port
+53; 'gateshift default --restore-dns' restores it.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if timeout <= 0 {
+				return fmt.Errorf("--timeout must be positive")
+			}
+			if setDNS && isServiceRunning() {
+				return fmt.Errorf("--set-dns cannot be used while the DNS service points the system DNS at the proxy; stop it with 'gateshift dns stop' first")
+			}
 			cfg, err := config.LoadConfig()
 			if err != nil {
 				return err
 			}
 
-			err = switchGateway(cfg.ProxyGateway)
+			notify.SetEnabled(cfg.Notifications)
+			gw, err := switchProxyGateway(ifaceName, cfg.ProxyGateways, cfg.Hooks.OnProxy, cfg.Hooks.Timeout, timeout)
 			if err != nil {
 				return err
 			}
 
-			fmt.Println("Switched to proxy gateway successfully")
-			fmt.Println("Note: For DNS leak protection, you may want to run: gateshift dns start")
-
+			if !utils.DryRun() {
+				fmt.Println("Switched to proxy gateway successfully")
+			}
+			if setDNS {
+				return setGatewayDNS(gw, cfg.DNS.AllNetworkServices)
+			}
+			if !utils.DryRun() {
+				utils.Infof("Note: For DNS leak protection, you may want to run: gateshift dns start\n")
+			}
 			return nil
 		},
 	}
 
+	cmd.Flags().StringVarP(&ifaceName, "interface", "i", "", "Network interface to use instead of auto-detecting")
+	cmd.Flags().DurationVar(&timeout, "timeout", defaultSwitchTimeout, "Maximum time to wait for each gateway change")
+	cmd.Flags().BoolVar(&setDNS, "set-dns", false, "Also point the system DNS at the proxy gateway")
 	return cmd
 }
 
+// setGatewayDNS 将系统DNS指向代理网关；网关不响应UDP 53端口的DNS查询时只警告，不修改系统DNS
+func setGatewayDNS(gw string, allServices bool) error {
+	if err := dns.ProbeDNSServer(gw); err != nil {
+		fmt.Printf("Warning: proxy gateway %s does not answer DNS queries on UDP port 53 (%v); system DNS left unchanged\n", gw, err)
+		return nil
+	}
+	if err := dns.SetPassthroughDNS([]string{gw}, allServices); err != nil {
+		return fmt.Errorf("switched to proxy gateway but failed to set the system DNS: %w", err)
+	}
+	if utils.DryRun() {
+		return nil
+	}
+	fmt.Printf("System DNS set to %s\n", gw)
+	fmt.Println("Run 'gateshift default --restore-dns' or 'gateshift dns restore-system' to restore the original settings")
+	return nil
+}
+
 func defaultCmd() *cobra.Command {
-	return &cobra.Command{
+	var ifaceName string
+	var timeout time.Duration
+	var restoreDNS bool
+
+	cmd := &cobra.Command{
 		Use:   "default",
 		Short: "Switch to the default gateway",
-		Long:  `Switch the current active network interface to use the default gateway.`,
+		Long: `Switch the current active network interface to use the default gateway.
+
+With --restore-dns the system DNS settings changed by 'gateshift proxy
+--set-dns' or 'gateshift dns set-system' are restored as well.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
+			if timeout <= 0 {
+				return fmt.Errorf("--timeout must be positive")
+			}
+			if restoreDNS && isServiceRunning() {
+				return fmt.Errorf("--restore-dns cannot be used while the DNS service runs; 'gateshift dns stop' restores the system DNS")
+			}
 			cfg, err := config.LoadConfig()
 			if err != nil {
 				return err
 			}
 
-			err = switchGateway(cfg.DefaultGateway)
+			notify.SetEnabled(cfg.Notifications)
+			err = switchGateway(ifaceName, cfg.DefaultGateway, "on_default", cfg.Hooks.OnDefault, cfg.Hooks.Timeout, timeout)
 			if err != nil {
 				return err
 			}
 
-			fmt.Println("Switched to default gateway successfully")
-			fmt.Println("Note: If DNS proxy is running, you may want to stop it with: gateshift dns stop")
-
+			if !utils.DryRun() {
+				fmt.Println("Switched to default gateway successfully")
+			}
+			if restoreDNS {
+				if err := dns.RestorePassthroughDNS(); err != nil {
+					return fmt.Errorf("switched to default gateway but failed to restore the system DNS: %w", err)
+				}
+				if !utils.DryRun() {
+					fmt.Println("System DNS settings restored.")
+				}
+				return nil
+			}
+			if !utils.DryRun() {
+				utils.Infof("Note: If DNS proxy is running, you may want to stop it with: gateshift dns stop\n")
+			}
 			return nil
 		},
 	}
+
+	cmd.Flags().StringVarP(&ifaceName, "interface", "i", "", "Network interface to use instead of auto-detecting")
+	cmd.Flags().DurationVar(&timeout, "timeout", defaultSwitchTimeout, "Maximum time to wait for the gateway change")
+	cmd.Flags().BoolVar(&restoreDNS, "restore-dns", false, "Also restore the system DNS changed by proxy --set-dns")
+	return cmd
 }
 
 func configCmd() *cobra.Command {
@@ -140,21 +287,24 @@ func configCmd() *cobra.Command {
 	}
 
 	setProxy := &cobra.Command{
-		Use:   "set-proxy [gateway-ip]",
-		Short: "Set the proxy gateway IP address",
-		Args:  cobra.ExactArgs(1),
+		Use:   "set-proxy [gateway-ip...]",
+		Short: "Set the proxy gateway IP addresses",
+		Long: `Set one or more proxy gateway IP addresses. With several addresses,
+gateshift proxy tries them in order and fails over to the next one when a
+gateway is unreachable or provides no internet connectivity.`,
+		Args: cobra.MinimumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := config.LoadConfig()
 			if err != nil {
 				return err
 			}
 
-			cfg.ProxyGateway = args[0]
+			cfg.ProxyGateways = args
 			if err := config.SaveConfig(cfg); err != nil {
 				return err
 			}
 
-			fmt.Printf("Proxy gateway set to: %s\n", args[0])
+			fmt.Printf("Proxy gateway set to: %s\n", strings.Join(args, ", "))
 			return nil
 		},
 	}
@@ -179,17 +329,74 @@ func configCmd() *cobra.Command {
 		},
 	}
 
+	var output string
 	show := &cobra.Command{
 		Use:   "show",
 		Short: "Show the current configuration",
+		Long: `Show the effective configuration: the config file with environment
+overrides and defaults applied. --output yaml or json prints it with the same
+keys as the config file, with secret values redacted.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			cfg, err := config.LoadConfig()
 			if err != nil {
 				return err
 			}
 
-			fmt.Printf("Proxy Gateway: %s\n", cfg.ProxyGateway)
-			fmt.Printf("Default Gateway: %s\n", cfg.DefaultGateway)
+			switch output {
+			case "text":
+				return printConfig(os.Stdout, cfg)
+			case "yaml":
+				data, err := yaml.Marshal(config.Settings(cfg))
+				if err != nil {
+					return err
+				}
+				_, err = os.Stdout.Write(data)
+				return err
+			case "json":
+				data, err := json.MarshalIndent(config.Settings(cfg), "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(data))
+				return nil
+			default:
+				return fmt.Errorf("invalid output format: %s (must be text, yaml or json)", output)
+			}
+		},
+	}
+	show.Flags().StringVarP(&output, "output", "o", "text", "Output format: text, yaml or json")
+
+	setHook := &cobra.Command{
+		Use:   "set-hook [on_proxy|on_default] [command]",
+		Short: "Set a command to run after switching gateway",
+		Long: `Set a shell command to run after a successful switch to the proxy (on_proxy)
+or default (on_default) gateway. The command receives GATESHIFT_GATEWAY,
+GATESHIFT_PREVIOUS_GATEWAY, GATESHIFT_INTERFACE and GATESHIFT_HOOK in its
+environment. Pass an empty command to remove the hook.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+
+			switch args[0] {
+			case "on_proxy":
+				cfg.Hooks.OnProxy = args[1]
+			case "on_default":
+				cfg.Hooks.OnDefault = args[1]
+			default:
+				return fmt.Errorf("unknown hook %s (must be on_proxy or on_default)", args[0])
+			}
+			if err := config.SaveConfig(cfg); err != nil {
+				return err
+			}
+
+			if args[1] == "" {
+				fmt.Printf("Hook %s removed\n", args[0])
+			} else {
+				fmt.Printf("Hook %s set to: %s\n", args[0], args[1])
+			}
 			return nil
 		},
 	}
@@ -216,9 +423,10 @@ func configCmd() *cobra.Command {
 			}
 
 			fmt.Println("Configuration reset to default values:")
-			fmt.Printf("Proxy Gateway: %s\n", cfg.ProxyGateway)
+			fmt.Printf("Proxy Gateway: %s\n", strings.Join(cfg.ProxyGateways, ", "))
 			fmt.Printf("Default Gateway: %s\n", cfg.DefaultGateway)
 			fmt.Printf("DNS Listen Address: %s\n", cfg.DNS.ListenAddr)
+			fmt.Printf("DNS Listen Port: %d\n", cfg.DNS.ListenPort)
 			fmt.Printf("DNS Upstream Servers: %v\n", cfg.DNS.UpstreamDNS)
 
 			// Stop DNS proxy if it's running
@@ -228,7 +436,7 @@ func configCmd() *cobra.Command {
 					fmt.Printf("Warning: Failed to stop DNS proxy: %v\n", err)
 				}
 
-				if err := dns.RestoreSystemDNS(); err != nil {
+				if err := restoreSystemDNS(); err != nil {
 					fmt.Printf("Warning: Failed to restore system DNS: %v\n", err)
 				}
 			}
@@ -239,987 +447,4582 @@ func configCmd() *cobra.Command {
 
 	reset.Flags().BoolP("yes", "y", false, "Skip confirmation prompt")
 
-	cmd.AddCommand(setProxy, setDefault, show, reset)
-	return cmd
-}
-
-func statusCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "status",
-		Short: "Show the current network status",
-		Long:  `Display information about the current network interface and gateway.`,
+	setNotifications := &cobra.Command{
+		Use:   "set-notifications [on|off]",
+		Short: "Enable or disable desktop notifications on gateway switch",
+		Args:  cobra.ExactArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Get the active interface
-			iface, err := gateway.GetActiveInterface()
+			cfg, err := config.LoadConfig()
 			if err != nil {
-				return fmt.Errorf("failed to get active interface: %w", err)
+				return err
 			}
 
-			// Check internet connectivity
-			hasInternet := gateway.CheckInternetConnectivity()
+			switch args[0] {
+			case "on":
+				cfg.Notifications = true
+			case "off":
+				cfg.Notifications = false
+			default:
+				return fmt.Errorf("invalid value %s (must be on or off)", args[0])
+			}
+			if err := config.SaveConfig(cfg); err != nil {
+				return err
+			}
 
-			// Get public IP address
-			publicIP, err := getPublicIP()
-			publicIPv6, err6 := getPublicIPv6()
+			fmt.Printf("Desktop notifications turned %s\n", args[0])
+			return nil
+		},
+	}
 
-			// Print status information
-			fmt.Printf("Active Network Interface: %s\n", iface.Name)
-			fmt.Printf("Service Name: %s\n", iface.ServiceName)
-			fmt.Printf("IP Address: %s\n", iface.IP)
-			fmt.Printf("Subnet Mask: %s\n", iface.Subnet)
-			fmt.Printf("Current Gateway: %s\n", iface.Gateway)
-			fmt.Printf("Internet Connectivity: %v\n", hasInternet)
+	setSudoPrompt := &cobra.Command{
+		Use:   "set-sudo-prompt [auto|terminal|gui]",
+		Short: "Set how the password is asked for when privileges are needed",
+		Long: `Set how the password is asked for when gateway or DNS changes need root
+privileges on Linux and macOS:
 
-			if err == nil {
-				fmt.Printf("Public IPv4: %s\n", publicIP)
-			} else {
-				fmt.Printf("Public IPv4: Not available\n")
-			}
+  auto      ask on the terminal, or with a graphical prompt when there is none
+  terminal  always ask on the terminal
+  gui       always use a graphical prompt: sudo -A with SUDO_ASKPASS on Linux,
+            an administrator dialog on macOS
 
-			if err6 == nil {
-				fmt.Printf("Public IPv6: %s\n", publicIPv6)
-			} else {
-				fmt.Printf("Public IPv6: Not available\n")
+The graphical prompt lets GateShift run from app launchers and menu-bar
+wrappers. The --sudo-prompt flag overrides this setting for one command.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if err := utils.ValidateSudoPrompt(args[0]); err != nil {
+				return err
 			}
 
-			// DNS Proxy status
 			cfg, err := config.LoadConfig()
-			if err == nil {
-				fmt.Printf("\nDNS Proxy Settings:\n")
-
-				// 使用 isServiceRunning 函数检查服务是否在运行
-				running := isServiceRunning()
-				if running || (dnsProxy != nil && dnsProxy.IsRunning()) {
-					fmt.Printf("  Status: Running\n")
-					fmt.Printf("  Listen Address: %s\n", cfg.DNS.ListenAddr)
-					fmt.Printf("  Upstream DNS: %v\n", cfg.DNS.UpstreamDNS)
-				} else {
-					fmt.Printf("  Status: Stopped\n")
-				}
+			if err != nil {
+				return err
+			}
+			cfg.SudoPrompt = args[0]
+			if err := config.SaveConfig(cfg); err != nil {
+				return err
 			}
 
+			fmt.Printf("Sudo prompt set to %s\n", args[0])
 			return nil
 		},
 	}
-}
-
-// getPublicIP 通过 Cloudflare 获取公网 IPv4 地址
-func getPublicIP() (string, error) {
-	client := &http.Client{Timeout: 5 * time.Second}
-	req, err := http.NewRequest("GET", cloudflareURL, nil)
-	if err != nil {
-		return "", err
-	}
 
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
+	setSudoKeepAlive := &cobra.Command{
+		Use:   "set-sudo-keepalive [on|off]",
+		Short: "Keep the sudo credential fresh while GateShift is in use",
+		Long: `Keep the sudo credential fresh for up to 15 minutes after the last privileged
+step of a GateShift command, so later steps and later commands, such as
+'gateshift proxy' and then 'gateshift dns start', do not prompt again.
+Applies to Linux and macOS.
+
+The credential is refreshed right after the first privileged step and then
+every minute while the command runs, so long-running commands such as
+'gateshift daemon' keep it fresh past sudo's own timeout. The time of the
+last privileged step is recorded in ~/.gateshift/sudo-session; a later
+command started within the 15 minutes refreshes the credential as it starts.
+sudo cannot refresh an expired credential, so between commands the gap must
+stay within sudo's own timeout (5 minutes by default).
+
+While the credential is fresh, other processes of your user that sudo lets
+share it (those on the same terminal, by default) can also run sudo without
+a password. It is off by default.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
+			switch args[0] {
+			case "on":
+				cfg.SudoKeepAlive = true
+			case "off":
+				cfg.SudoKeepAlive = false
+			default:
+				return fmt.Errorf("invalid value %s (must be on or off)", args[0])
+			}
+			if err := config.SaveConfig(cfg); err != nil {
+				return err
+			}
 
-	lines := strings.Split(string(body), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "ip=") {
-			return strings.TrimPrefix(line, "ip="), nil
-		}
+			fmt.Printf("Sudo keep-alive turned %s\n", args[0])
+			return nil
+		},
 	}
 
-	return "", fmt.Errorf("IP not found in response")
+	cmd.AddCommand(setProxy, setDefault, setHook, setNotifications, setSudoPrompt, setSudoKeepAlive, discoverCmd(), editCmd(), validateCmd(), exportCmd(), importCmd(), show, reset)
+	return cmd
 }
 
-// getPublicIPv6 通过 Cloudflare 获取公网 IPv6 地址
-func getPublicIPv6() (string, error) {
-	client := &http.Client{Timeout: 5 * time.Second}
-	req, err := http.NewRequest("GET", cloudflareIPv6URL, nil)
-	if err != nil {
-		return "", err
-	}
-
-	resp, err := client.Do(req)
-	if err != nil {
-		return "", err
-	}
-	defer resp.Body.Close()
+// validateReport 是 config validate 的检查结果
+type validateReport struct {
+	Path     string   `json:"path"`
+	Valid    bool     `json:"valid"`
+	Problems []string `json:"problems"`
+}
 
-	body, err := io.ReadAll(resp.Body)
-	if err != nil {
-		return "", err
-	}
+func validateCmd() *cobra.Command {
+	var jsonOutput bool
 
-	lines := strings.Split(string(body), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "ip=") {
-			return strings.TrimPrefix(line, "ip="), nil
-		}
-	}
+	cmd := &cobra.Command{
+		Use:   "validate [path]",
+		Short: "Check a configuration file for problems",
+		Long: `Load a configuration file, or the one in use if no path is given, without
+making it the active configuration and check every setting. All problems
+found are listed, and the command exits with a non-zero status if there
+are any.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := config.GetConfigPath()
+			if len(args) == 1 {
+				path = args[0]
+			}
 
-	return "", fmt.Errorf("IPv6 not found in response")
-}
+			report := validateConfigFile(path)
+			if jsonOutput {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(report); err != nil {
+					return err
+				}
+			} else {
+				printValidateReport(report)
+			}
 
-func versionCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "version",
-		Short: "Show the version information",
-		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Printf("Proxy Gateway Switcher v%s\n", strings.TrimPrefix(Version, "v"))
-			fmt.Printf("Build time: %s\n", BuildTime)
+			if !report.Valid {
+				os.Exit(1)
+			}
+			return nil
 		},
 	}
+
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output as JSON")
+	return cmd
 }
 
-func installCmd() *cobra.Command {
+func exportCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "install",
-		Short: "Install GateShift to system",
+		Use:   "export [file]",
+		Short: "Write the complete configuration to a portable file",
+		Long: `Write the complete configuration, including settings left at their defaults,
+to a file that "gateshift config import" accepts on another machine. The
+configuration is written to standard output if no file (or "-") is given,
+so it can be piped between machines.`,
+		Args: cobra.MaximumNArgs(1),
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// 获取当前二进制文件的路径
-			ex, err := os.Executable()
+			cfg, err := config.LoadConfig()
 			if err != nil {
-				return fmt.Errorf("failed to get executable path: %w", err)
+				return err
 			}
 
-			// 检查目标路径是否已存在
-			targetPath := "/usr/local/bin/gateshift"
-			if _, err := os.Stat(targetPath); err == nil {
-				// 如果文件已存在，检查是否是相同文件
-				if isSameFile(ex, targetPath) {
-					fmt.Println("GateShift is already installed at /usr/local/bin/gateshift")
-					fmt.Println("If you want to reinstall, please run 'sudo rm /usr/local/bin/gateshift' first")
-					return nil
-				}
+			if len(args) == 0 || args[0] == "-" {
+				return config.Export(cfg, os.Stdout)
 			}
 
-			fmt.Printf("Installing GateShift to %s\n", targetPath)
-
-			// 复制文件
-			if err := copyFile(ex, targetPath); err != nil {
-				return fmt.Errorf("failed to copy binary: %w", err)
+			var buf bytes.Buffer
+			if err := config.Export(cfg, &buf); err != nil {
+				return err
 			}
-
-			// 设置执行权限
-			if err := os.Chmod(targetPath, 0755); err != nil {
-				return fmt.Errorf("failed to set executable permission: %w", err)
+			if err := os.WriteFile(args[0], buf.Bytes(), 0644); err != nil {
+				return fmt.Errorf("failed to write export: %w", err)
 			}
+			fmt.Printf("Configuration exported to %s\n", args[0])
+			return nil
+		},
+	}
+}
 
-			fmt.Println("Installation completed successfully!")
+func importCmd() *cobra.Command {
+	var merge bool
 
-			// 获取当前工作目录
-			cwd, err := os.Getwd()
-			if err == nil {
-				// 检查当前目录下是否存在二进制文件，如果存在则删除
-				binPath := filepath.Join(cwd, filepath.Base(ex))
-				if binPath != ex { // 确保不会删除正在运行的文件
-					if err := os.Remove(binPath); err == nil {
-						fmt.Println("Cleaned up downloaded binary file")
-					}
+	cmd := &cobra.Command{
+		Use:   "import [file]",
+		Short: "Load a configuration written by config export",
+		Long: `Load a configuration written by "gateshift config export", or any
+configuration file, and make it the active configuration once it passes
+validation. It is read from standard input if no file (or "-") is given.
+
+By default the imported file replaces the configuration, and settings it
+does not contain get their defaults. With --merge, they keep their current
+values instead; lists such as the blocklist are still replaced as a whole.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var in io.Reader = os.Stdin
+			source := "standard input"
+			if len(args) == 1 && args[0] != "-" {
+				f, err := os.Open(args[0])
+				if err != nil {
+					return fmt.Errorf("failed to open import: %w", err)
 				}
+				defer f.Close()
+				in, source = f, args[0]
 			}
 
-			fmt.Println("\nRequesting elevated privileges for network configuration...")
+			current, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+			cfg, err := config.Import(in, current, merge)
+			if err != nil {
+				for _, problem := range config.Problems(err) {
+					fmt.Fprintf(os.Stderr, "  - %v\n", problem)
+				}
+				return fmt.Errorf("%s was not imported", source)
+			}
+			if err := config.SaveConfig(cfg); err != nil {
+				return err
+			}
+
+			fmt.Printf("Configuration imported from %s\n", source)
+			applyDNSConfig(cfg)
 			return nil
 		},
 	}
+
+	cmd.Flags().BoolVar(&merge, "merge", false, "Keep current values of settings the file does not contain")
+	return cmd
 }
 
-// 检查两个文件是否相同
-func isSameFile(file1, file2 string) bool {
-	info1, err1 := os.Stat(file1)
-	info2, err2 := os.Stat(file2)
-	if err1 != nil || err2 != nil {
-		return false
+// validateConfigFile 校验 path 处的配置文件，收集所有问题
+func validateConfigFile(path string) *validateReport {
+	report := &validateReport{Path: path, Problems: []string{}}
+	_, err := config.ValidateFile(path)
+	for _, problem := range config.Problems(err) {
+		report.Problems = append(report.Problems, problem.Error())
 	}
-	return info1.Size() == info2.Size() && info1.ModTime().Equal(info2.ModTime())
+	report.Valid = len(report.Problems) == 0
+	return report
 }
 
-// 复制文件
-func copyFile(src, dst string) error {
-	sourceFile, err := os.Open(src)
-	if err != nil {
-		return err
+// printValidateReport 以文本格式输出配置校验结果
+func printValidateReport(report *validateReport) {
+	if report.Valid {
+		fmt.Printf("%s: %s\n", report.Path, utils.Green("valid"))
+		return
 	}
-	defer sourceFile.Close()
 
-	destFile, err := os.Create(dst)
-	if err != nil {
-		return err
+	fmt.Printf("%s: %s (%d problems)\n", report.Path, utils.Red("invalid"), len(report.Problems))
+	for _, problem := range report.Problems {
+		fmt.Printf("  - %s\n", problem)
 	}
-	defer destFile.Close()
-
-	_, err = io.Copy(destFile, sourceFile)
-	return err
 }
 
-func uninstallCmd() *cobra.Command {
+func editCmd() *cobra.Command {
 	return &cobra.Command{
-		Use:   "uninstall",
-		Short: "Uninstall GateShift from the system",
-		Long:  `Remove GateShift from system-wide installation.`,
+		Use:   "edit",
+		Short: "Open the configuration file in an editor",
+		Long: `Open the configuration file in $VISUAL or $EDITOR (vi, or notepad on Windows,
+if neither is set). The changes are written to a temporary copy and only
+replace the configuration once they pass validation.`,
+		Args: cobra.NoArgs,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Initialize sudo session with 15-minute timeout
-			sudoSession := utils.NewSudoSession(15 * time.Minute)
-
-			// Get GOPATH
-			gopath := os.Getenv("GOPATH")
-			if gopath == "" {
-				gopath = filepath.Join(os.Getenv("HOME"), "go")
+			// 确保配置文件存在，缺失时写入默认配置
+			if _, err := config.LoadConfig(); err != nil {
+				return err
 			}
-			gopathBin := filepath.Join(gopath, "bin")
+			return editConfigFile(config.GetConfigPath())
+		},
+	}
+}
 
-			// Define the installation path based on OS
-			var installPath string
-			var installDir string
-			var gopathVersion string
+// editConfigFile 在临时副本上编辑配置，校验通过后才替换原文件，
+// 校验失败时可重新打开编辑器，放弃修改则原文件保持不变
+func editConfigFile(path string) error {
+	original, err := os.ReadFile(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
+	info, err := os.Stat(path)
+	if err != nil {
+		return fmt.Errorf("failed to read config: %w", err)
+	}
 
-			switch runtime.GOOS {
-			case "darwin", "linux":
-				installPath = "/usr/local/bin/gateshift"
-				gopathVersion = filepath.Join(gopathBin, "gateshift")
-			case "windows":
-				// On Windows, check Program Files
-				installDir = os.Getenv("ProgramFiles")
-				if installDir == "" {
-					installDir = "C:\\Program Files"
-				}
-				installDir = filepath.Join(installDir, "GateShift")
-				installPath = filepath.Join(installDir, "gateshift.exe")
-				gopathVersion = filepath.Join(gopathBin, "gateshift.exe")
-			default:
-				return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
-			}
+	// 临时文件与配置位于同一目录，保证最终的重命名是原子操作
+	tmp, err := os.CreateTemp(filepath.Dir(path), ".config-*.yaml")
+	if err != nil {
+		return fmt.Errorf("failed to create temporary file: %w", err)
+	}
+	tmpPath := tmp.Name()
+	defer os.Remove(tmpPath)
 
-			// Check both system and GOPATH installations
-			systemInstalled := false
-			gopathInstalled := false
+	_, err = tmp.Write(original)
+	if closeErr := tmp.Close(); err == nil {
+		err = closeErr
+	}
+	if err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := os.Chmod(tmpPath, info.Mode().Perm()); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
+	if err := utils.ChownLike(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to write temporary file: %w", err)
+	}
 
-			if _, err := os.Stat(installPath); err == nil {
-				systemInstalled = true
-			}
-			if _, err := os.Stat(gopathVersion); err == nil {
-				gopathInstalled = true
-			}
+	editor := resolveEditor()
+	for {
+		if err := runEditor(editor, tmpPath); err != nil {
+			return fmt.Errorf("editor %s failed: %w", strings.Join(editor, " "), err)
+		}
 
-			if !systemInstalled && !gopathInstalled {
-				return fmt.Errorf("GateShift is not installed at %s or %s", installPath, gopathVersion)
-			}
+		edited, err := os.ReadFile(tmpPath)
+		if err != nil {
+			return fmt.Errorf("failed to read edited config: %w", err)
+		}
+		if bytes.Equal(edited, original) {
+			fmt.Println("No changes made")
+			return nil
+		}
 
-			// Remove system installation if exists
-			if systemInstalled {
-				fmt.Printf("Uninstalling GateShift from %s\n", installPath)
+		_, err = config.ValidateFile(tmpPath)
+		if err == nil {
+			break
+		}
 
-				switch runtime.GOOS {
-				case "darwin", "linux":
-					if err := sudoSession.RunWithPrivileges("rm", installPath); err != nil {
-						return fmt.Errorf("failed to remove binary: %w", err)
-					}
-				case "windows":
-					if err := os.Remove(installPath); err != nil {
-						return fmt.Errorf("failed to remove binary: %w", err)
-					}
+		fmt.Printf("Invalid configuration: %v\n", err)
+		fmt.Print("Reopen the editor to fix it? [Y/n] ")
+		var response string
+		fmt.Scanln(&response)
+		if response == "n" || response == "N" {
+			return fmt.Errorf("changes discarded, %s was not modified", path)
+		}
+	}
 
-					// Try to remove the directory if it's empty
-					if err := os.Remove(installDir); err != nil {
-						fmt.Printf("Note: Could not remove directory %s. It may not be empty.\n", installDir)
-					}
+	if err := os.Rename(tmpPath, path); err != nil {
+		return fmt.Errorf("failed to save config: %w", err)
+	}
+	fmt.Printf("Configuration saved to %s\n", path)
+	return nil
+}
 
-					// Remove from PATH using PowerShell
-					removeFromPathCmd := fmt.Sprintf(
-						"$currentPath = [Environment]::GetEnvironmentVariable('Path', 'Machine'); "+
-							"if ($currentPath -like '*%s*') { "+
-							"$newPath = $currentPath -replace '%s;', '' -replace ';%s', '' -replace '%s'; "+
-							"[Environment]::SetEnvironmentVariable('Path', $newPath, 'Machine') "+
-							"}", installDir, installDir, installDir, installDir)
+// resolveEditor 返回编辑器命令及其参数，依次使用 $VISUAL、$EDITOR，
+// 都未设置时使用系统默认编辑器
+func resolveEditor() []string {
+	for _, env := range []string{"VISUAL", "EDITOR"} {
+		if fields := strings.Fields(os.Getenv(env)); len(fields) > 0 {
+			return fields
+		}
+	}
+	if runtime.GOOS == "windows" {
+		return []string{"notepad"}
+	}
+	return []string{"vi"}
+}
 
-					psCmd := exec.Command("powershell", "-Command", removeFromPathCmd)
-					if err := sudoSession.RunWithPrivileges(psCmd.Path, psCmd.Args[1:]...); err != nil {
-						fmt.Println("Warning: Failed to remove from PATH automatically.")
-						fmt.Printf("Please remove %s from your PATH manually if needed.\n", installDir)
-					}
-				}
+// runEditor 在当前终端中打开编辑器并等待其退出
+func runEditor(editor []string, path string) error {
+	cmd := exec.Command(editor[0], append(editor[1:], path)...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
 
-				fmt.Println("System-wide installation removed successfully!")
+func discoverCmd() *cobra.Command {
+	var (
+		ifaceName string
+		timeout   time.Duration
+		ports     []int
+	)
+
+	cmd := &cobra.Command{
+		Use:   "discover",
+		Short: "Discover candidate proxy gateways on the local subnet",
+		Long: `Scan the subnet of the active interface for hosts that accept connections
+on common proxy ports or answer ARP, and optionally save one as the proxy gateway.
+The interface's own address and its current gateway are skipped.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			iface, err := gateway.GetInterface(ifaceName)
+			if err != nil {
+				return fmt.Errorf("failed to get active interface: %w", err)
 			}
 
-			// Remove GOPATH installation if exists
-			if gopathInstalled {
-				fmt.Printf("Removing GOPATH installation from %s\n", gopathVersion)
-				if err := os.Remove(gopathVersion); err != nil {
-					return fmt.Errorf("failed to remove GOPATH binary: %w", err)
+			fmt.Printf("Scanning %s (%s/%d) for %v...\n", iface.Name, iface.IP, iface.PrefixLen, timeout)
+			candidates, err := gateway.DiscoverGateways(iface, ports, timeout)
+			if err != nil {
+				return fmt.Errorf("failed to scan subnet: %w", err)
+			}
+			if len(candidates) == 0 {
+				fmt.Println("No candidate gateways found")
+				return nil
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "#\tIP\tOPEN PORTS\tARP")
+			for i, c := range candidates {
+				open := make([]string, len(c.Ports))
+				for j, port := range c.Ports {
+					open[j] = strconv.Itoa(port)
 				}
-				fmt.Println("GOPATH installation removed successfully!")
+				arp := ""
+				if c.ARP {
+					arp = "yes"
+				}
+				fmt.Fprintf(w, "%d\t%s\t%s\t%s\n", i+1, c.IP, valueOrDash(strings.Join(open, ",")), valueOrDash(arp))
+			}
+			if err := w.Flush(); err != nil {
+				return err
 			}
 
-			if runtime.GOOS == "windows" {
-				fmt.Println("You may need to restart your terminal or system for the PATH changes to take effect.")
+			fmt.Print("Enter a number to save it as the proxy gateway (empty to skip): ")
+			var response string
+			fmt.Scanln(&response)
+			if response == "" {
+				return nil
+			}
+			choice, err := strconv.Atoi(response)
+			if err != nil || choice < 1 || choice > len(candidates) {
+				return fmt.Errorf("invalid selection: %s", response)
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				return err
+			}
+			cfg.ProxyGateways = []string{candidates[choice-1].IP}
+			if err := config.SaveConfig(cfg); err != nil {
+				return err
 			}
 
+			fmt.Printf("Proxy gateway set to: %s\n", cfg.ProxyGateways[0])
 			return nil
 		},
 	}
+
+	cmd.Flags().StringVarP(&ifaceName, "interface", "i", "", "Network interface to use instead of auto-detecting")
+	cmd.Flags().DurationVar(&timeout, "timeout", 10*time.Second, "Maximum time to spend scanning")
+	cmd.Flags().IntSliceVar(&ports, "ports", gateway.DefaultDiscoverPorts, "TCP ports to probe")
+	return cmd
 }
 
-func upgradeCmd() *cobra.Command {
-	var autoApprove bool
+// statusInfo is the structured result of the status command. Fields that
+// could not be determined are nil so they serialize as JSON null.
+type statusInfo struct {
+	Interface            string                `json:"interface"`
+	ServiceName          string                `json:"service_name"`
+	IP                   string                `json:"ip"`
+	Subnet               string                `json:"subnet"`
+	PrefixLen            int                   `json:"prefix_len"`
+	Gateway              string                `json:"gateway"`
+	InternetConnectivity bool                  `json:"internet_connectivity"`
+	Checks               []gateway.CheckResult `json:"checks,omitempty"`
+	PublicIPv4           *string               `json:"public_ipv4"`
+	PublicIPv6           *string               `json:"public_ipv6"`
+	DNSProxy             *dnsProxyStatus       `json:"dns_proxy"`
+
+	// NoActiveInterface 说明为什么没有活动接口（没有默认路由或没有带地址的接口），此时接口字段为空
+	NoActiveInterface string `json:"no_active_interface,omitempty"`
+	// Addresses 是没有默认路由时带 IPv4 地址的各个接口
+	Addresses []*gateway.NetworkInterface `json:"addresses,omitempty"`
+}
 
-	cmd := &cobra.Command{
-		Use:   "upgrade",
-		Short: "Check for updates and upgrade GateShift",
-		Long: `Check for new versions of GateShift and upgrade if available.
-If a new version is found, it will be downloaded and installed automatically.`,
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// Remove 'v' prefix from current version if present
-			currentVersion := strings.TrimPrefix(Version, "v")
-			fmt.Printf("Current version: v%s\n", currentVersion)
-			fmt.Println("Checking for updates...")
+// dnsProxyStatus describes the DNS proxy block of the status output
+type dnsProxyStatus struct {
+	Running     bool     `json:"running"`
+	ListenAddr  *string  `json:"listen_addr"`
+	UpstreamDNS []string `json:"upstream_dns"`
+}
 
-			// Get latest release info from GitHub
-			latestVersion, downloadURL, err := getLatestRelease()
-			if err != nil {
-				return fmt.Errorf("failed to check for updates: %w", err)
-			}
+const (
+	// publicIPCacheTTL 公网 IP 查询结果的缓存时间，避免 watch 模式频繁请求 Cloudflare
+	publicIPCacheTTL = 30 * time.Second
+	// publicIPTimeout 公网 IP 查询的总超时时间
+	publicIPTimeout = 5 * time.Second
+)
 
-			// Compare versions without 'v' prefix
-			if latestVersion == currentVersion {
-				fmt.Println("You are already running the latest version!")
-				return nil
-			}
+// publicIPCache 缓存最近一次公网 IP 查询结果
+type publicIPCache struct {
+	ipv4    *string
+	ipv6    *string
+	fetched time.Time
+}
 
-			fmt.Printf("New version available: v%s\n", latestVersion)
+var publicIPs publicIPCache
 
-			// Ask for confirmation unless auto-approve is set
-			if !autoApprove {
-				fmt.Print("Do you want to upgrade? [y/N] ")
-				var response string
-				fmt.Scanln(&response)
-				if response != "y" && response != "Y" {
-					fmt.Println("Upgrade cancelled")
-					return nil
-				}
-			}
+// get 返回缓存的公网 IP，缓存过期时重新查询
+func (c *publicIPCache) get() (*string, *string) {
+	if !c.fetched.IsZero() && time.Since(c.fetched) < publicIPCacheTTL {
+		return c.ipv4, c.ipv6
+	}
 
-			// Create temporary directory for download
-			tmpDir, err := os.MkdirTemp("", "gateshift-upgrade")
-			if err != nil {
-				return fmt.Errorf("failed to create temporary directory: %w", err)
-			}
-			defer os.RemoveAll(tmpDir)
+	// IPv4 与 IPv6 并发查询，共用一个总超时，超时后未返回的请求会被取消
+	ctx, cancel := context.WithTimeout(context.Background(), publicIPTimeout)
+	defer cancel()
+
+	var wg sync.WaitGroup
+	var ipv4, ipv6 *string
+	wg.Add(2)
+	go func() {
+		defer wg.Done()
+		if publicIP, err := getPublicIP(ctx); err == nil {
+			ipv4 = &publicIP
+		}
+	}()
+	go func() {
+		defer wg.Done()
+		if publicIPv6, err := getPublicIPv6(ctx); err == nil {
+			ipv6 = &publicIPv6
+		}
+	}()
+	wg.Wait()
 
-			// Download the new version
-			fmt.Println("Downloading new version...")
-			binaryPath := filepath.Join(tmpDir, "gateshift")
-			if runtime.GOOS == "windows" {
-				binaryPath += ".exe"
-			}
+	c.ipv4, c.ipv6 = ipv4, ipv6
+	c.fetched = time.Now()
+	return c.ipv4, c.ipv6
+}
 
-			if err := downloadFile(downloadURL, binaryPath); err != nil {
-				return fmt.Errorf("failed to download new version: %w", err)
-			}
+func statusCmd() *cobra.Command {
+	var ifaceName string
+	var jsonOutput bool
+	var watch bool
+	var interval time.Duration
+	var checks []string
 
-			// Make the downloaded file executable
-			if runtime.GOOS != "windows" {
-				if err := os.Chmod(binaryPath, 0755); err != nil {
-					return fmt.Errorf("failed to make binary executable: %w", err)
-				}
-			}
+	cmd := &cobra.Command{
+		Use:   "status",
+		Short: "Show the current network status",
+		Long: `Display information about the current network interface and gateway.
 
-			// Get current executable path
-			execPath, err := os.Executable()
-			if err != nil {
-				return fmt.Errorf("failed to get executable path: %w", err)
+Use --check to also probe specific endpoints, e.g. --check example.com:443
+or --check 1.1.1.1 (port 443 by default), --check https://example.com.
+Each endpoint is reported with pass/fail and latency.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// 先解析所有检查目标，格式错误时直接报错
+			targets := make([]gateway.CheckTarget, 0, len(checks))
+			for _, check := range checks {
+				target, err := gateway.ParseCheckTarget(check)
+				if err != nil {
+					return err
+				}
+				targets = append(targets, target)
 			}
 
-			// Initialize sudo session
-			sudoSession := utils.NewSudoSession(15 * time.Minute)
-
-			// Replace the current binary
-			fmt.Println("Installing new version...")
-			if runtime.GOOS == "windows" {
-				// On Windows, we need to rename instead of direct replacement
-				backupPath := execPath + ".old"
-				if err := os.Rename(execPath, backupPath); err != nil {
-					return fmt.Errorf("failed to backup current binary: %w", err)
-				}
-				if err := os.Rename(binaryPath, execPath); err != nil {
-					// Try to restore backup
-					os.Rename(backupPath, execPath)
-					return fmt.Errorf("failed to install new version: %w", err)
+			show := func() error {
+				status, err := collectStatus(ifaceName, targets)
+				if err != nil {
+					return err
 				}
-				os.Remove(backupPath)
-			} else {
-				if err := sudoSession.RunWithPrivileges("cp", binaryPath, execPath); err != nil {
-					return fmt.Errorf("failed to install new version: %w", err)
+
+				if jsonOutput {
+					encoder := json.NewEncoder(os.Stdout)
+					encoder.SetIndent("", "  ")
+					return encoder.Encode(status)
 				}
+
+				printStatus(status)
+				return nil
 			}
 
-			fmt.Printf("Successfully upgraded to v%s!\n", latestVersion)
-			fmt.Println("Please restart GateShift to use the new version.")
-			return nil
+			if !watch {
+				return show()
+			}
+
+			if interval <= 0 {
+				return fmt.Errorf("interval must be positive")
+			}
+			return watchStatus(show, interval, !jsonOutput)
 		},
 	}
 
-	cmd.Flags().BoolVarP(&autoApprove, "yes", "y", false, "Automatically approve upgrade without confirmation")
+	cmd.Flags().StringVarP(&ifaceName, "interface", "i", "", "Network interface to show instead of auto-detecting")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output as JSON")
+	cmd.Flags().BoolVarP(&watch, "watch", "w", false, "Refresh the status until interrupted")
+	cmd.Flags().DurationVar(&interval, "interval", 2*time.Second, "Refresh interval in watch mode")
+	cmd.Flags().StringArrayVar(&checks, "check", nil, "Endpoint to probe (host[:port] or http(s) URL); may be repeated")
 	return cmd
 }
 
-func getLatestRelease() (version string, downloadURL string, err error) {
-	// GitHub API URL for latest release
-	apiURL := "https://api.github.com/repos/ourines/GateShift/releases/latest"
-
-	// Create HTTP client with timeout
-	client := &http.Client{Timeout: 10 * time.Second}
+// watchStatus 按固定间隔刷新状态，直到收到中断信号
+func watchStatus(show func() error, interval time.Duration, clearScreen bool) error {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
 
-	// Make request
-	resp, err := client.Get(apiURL)
-	if err != nil {
-		return "", "", err
+	if clearScreen {
+		// 隐藏光标，退出时恢复
+		fmt.Print("\033[?25l")
+		defer fmt.Print("\033[?25h")
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return "", "", fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		if clearScreen {
+			fmt.Print("\033[H\033[2J")
+		}
+		if err := show(); err != nil {
+			fmt.Printf("Error: %v\n", err)
+		}
+		if clearScreen {
+			fmt.Printf("\nRefreshing every %v. Press Ctrl+C to exit.\n", interval)
+		}
+
+		select {
+		case <-sigChan:
+			return nil
+		case <-ticker.C:
+		}
 	}
+}
 
-	// Parse response
-	var release struct {
-		TagName string `json:"tag_name"`
-		Assets  []struct {
-			Name               string `json:"name"`
-			BrowserDownloadURL string `json:"browser_download_url"`
-		} `json:"assets"`
+// collectStatus 收集网络接口、连通性、公网 IP 与 DNS 代理状态，并探测 checks 中的目标
+func collectStatus(ifaceName string, checks []gateway.CheckTarget) (*statusInfo, error) {
+	// Get the active interface
+	status := &statusInfo{}
+	iface, err := gateway.GetInterface(ifaceName)
+	var noRoute *gateway.NoDefaultRouteError
+	switch {
+	case err == nil:
+		status.Interface = iface.Name
+		status.ServiceName = iface.ServiceName
+		status.IP = iface.IP
+		status.Subnet = iface.Subnet
+		status.PrefixLen = iface.PrefixLen
+		status.Gateway = iface.Gateway
+	case errors.As(err, &noRoute):
+		// 网络尚未配置好时仍显示各接口的地址以及其余状态
+		status.NoActiveInterface = "no default route"
+		status.Addresses = noRoute.Interfaces
+	case errors.Is(err, gateway.ErrNoActiveInterface):
+		status.NoActiveInterface = "no interface has an IPv4 address"
+	default:
+		return nil, fmt.Errorf("failed to get active interface: %w", err)
 	}
 
-	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
-		return "", "", err
+	// Check internet connectivity
+	status.InternetConnectivity = gateway.CheckInternetConnectivity()
+	if len(checks) > 0 {
+		status.Checks = gateway.CheckTargets(checks)
 	}
 
-	// Remove 'v' prefix from version if present
-	version = strings.TrimPrefix(release.TagName, "v")
+	// Get public IP address
+	status.PublicIPv4, status.PublicIPv6 = publicIPs.get()
 
-	// Find the appropriate asset for current platform
-	var assetName string
-	switch runtime.GOOS {
-	case "darwin":
-		if runtime.GOARCH == "arm64" {
-			assetName = fmt.Sprintf("gateshift-darwin-arm64")
-		} else {
-			assetName = fmt.Sprintf("gateshift-darwin-amd64")
+	// DNS Proxy status
+	cfg, err := config.LoadConfig()
+	if err == nil {
+		// 使用 isServiceRunning 函数检查服务是否在运行
+		running := isServiceRunning() || (dnsProxy != nil && dnsProxy.IsRunning())
+		status.DNSProxy = &dnsProxyStatus{Running: running}
+		if running {
+			status.DNSProxy.ListenAddr = &cfg.DNS.ListenAddr
+			status.DNSProxy.UpstreamDNS = cfg.DNS.UpstreamDNS
+		}
+	}
+
+	return status, nil
+}
+
+// printStatus 以文本格式输出状态信息
+func printStatus(status *statusInfo) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if status.NoActiveInterface != "" {
+		fmt.Fprintf(w, "Active Network Interface:\t%s\n", utils.Yellow("None ("+status.NoActiveInterface+")"))
+		for _, iface := range status.Addresses {
+			fmt.Fprintf(w, "Interface %s:\t%s\n", iface.Name, formatAddress(iface.IP, iface.PrefixLen))
 		}
-	case "linux":
-		if runtime.GOARCH == "arm64" {
-			assetName = fmt.Sprintf("gateshift-linux-arm64")
+	} else {
+		fmt.Fprintf(w, "Active Network Interface:\t%s\n", status.Interface)
+		fmt.Fprintf(w, "Service Name:\t%s\n", status.ServiceName)
+		fmt.Fprintf(w, "IP Address:\t%s\n", status.IP)
+		fmt.Fprintf(w, "Subnet Mask:\t%s\n", formatSubnet(status.Subnet, status.PrefixLen))
+		fmt.Fprintf(w, "Current Gateway:\t%s\n", status.Gateway)
+	}
+	if status.InternetConnectivity {
+		fmt.Fprintf(w, "Internet Connectivity:\t%s\n", utils.Green("Connected"))
+	} else {
+		fmt.Fprintf(w, "Internet Connectivity:\t%s\n", utils.Red("No internet"))
+	}
+	for _, check := range status.Checks {
+		if check.Reachable {
+			fmt.Fprintf(w, "Check %s:\t%s (%.1f ms)\n", check.Target, utils.Green("OK"), check.LatencyMs)
 		} else {
-			assetName = fmt.Sprintf("gateshift-linux-amd64")
+			fmt.Fprintf(w, "Check %s:\t%s (%s)\n", check.Target, utils.Red("Failed"), check.Error)
 		}
-	case "windows":
-		assetName = fmt.Sprintf("gateshift-windows-amd64.exe")
-	default:
-		return "", "", fmt.Errorf("unsupported platform: %s", runtime.GOOS)
 	}
 
-	// Find download URL for the appropriate asset
-	for _, asset := range release.Assets {
-		if asset.Name == assetName {
-			return version, asset.BrowserDownloadURL, nil
+	if status.PublicIPv4 != nil {
+		fmt.Fprintf(w, "Public IPv4:\t%s\n", *status.PublicIPv4)
+	} else {
+		fmt.Fprintf(w, "Public IPv4:\t%s\n", utils.Yellow("Not available"))
+	}
+
+	if status.PublicIPv6 != nil {
+		fmt.Fprintf(w, "Public IPv6:\t%s\n", *status.PublicIPv6)
+	} else {
+		fmt.Fprintf(w, "Public IPv6:\t%s\n", utils.Yellow("Not available"))
+	}
+
+	if status.DNSProxy != nil {
+		fmt.Fprintf(w, "\n%s\n", utils.Bold("DNS Proxy Settings:"))
+		fmt.Fprintf(w, "  Status:\t%s\n", runningText(status.DNSProxy.Running))
+		if status.DNSProxy.Running {
+			fmt.Fprintf(w, "  Listen Address:\t%s\n", *status.DNSProxy.ListenAddr)
+			fmt.Fprintf(w, "  Upstream DNS:\t%s\n", strings.Join(status.DNSProxy.UpstreamDNS, ", "))
 		}
 	}
+	w.Flush()
+}
 
-	return "", "", fmt.Errorf("no suitable binary found for platform %s/%s", runtime.GOOS, runtime.GOARCH)
+// runningText 以绿色显示运行中、红色显示已停止
+func runningText(running bool) string {
+	if running {
+		return utils.Green("Running")
+	}
+	return utils.Red("Stopped")
 }
 
-func downloadFile(url string, filepath string) error {
-	// Create HTTP client with timeout
-	client := &http.Client{Timeout: 5 * time.Minute}
+// enabledText 以绿色显示已启用、红色显示已禁用
+func enabledText(enabled bool) string {
+	if enabled {
+		return utils.Green("Enabled")
+	}
+	return utils.Red("Disabled")
+}
 
-	// Make request
-	resp, err := client.Get(url)
-	if err != nil {
-		return err
+func interfacesCmd() *cobra.Command {
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "interfaces",
+		Short: "List network interfaces and their gateways",
+		Long:  `List all non-loopback network interfaces with their IP, subnet and gateway, marking the one that carries the default route.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ifaces, err := gateway.ListInterfaces()
+			if err != nil {
+				return fmt.Errorf("failed to list interfaces: %w", err)
+			}
+
+			if jsonOutput {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(ifaces)
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "DEFAULT\tNAME\tSERVICE\tIP\tSUBNET\tGATEWAY")
+			for _, iface := range ifaces {
+				marker := ""
+				if iface.IsDefault {
+					marker = "*"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", marker, iface.Name, valueOrDash(iface.ServiceName),
+					valueOrDash(iface.IP), formatSubnet(iface.Subnet, iface.PrefixLen), valueOrDash(iface.Gateway))
+			}
+			return w.Flush()
+		},
 	}
-	defer resp.Body.Close()
 
-	if resp.StatusCode != http.StatusOK {
-		return fmt.Errorf("download failed with status %d", resp.StatusCode)
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output as JSON")
+	return cmd
+}
+
+func gatewayCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "gateway",
+		Short: "Inspect the default route",
 	}
 
-	// Create output file
-	out, err := os.Create(filepath)
-	if err != nil {
-		return err
+	var jsonOutput bool
+	watch := &cobra.Command{
+		Use:   "watch",
+		Short: "Print changes of the default route as they happen",
+		Long: `Print a line whenever the interface or gateway of the default route changes,
+for example when roaming between networks or when a DHCP renewal resets the
+gateway, until interrupted. Changes are detected through netlink on Linux and
+'route -n monitor' on macOS, and by polling on Windows.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			events := gateway.WatchDefaultRoute(ctx)
+			if !jsonOutput {
+				fmt.Printf("Default route: %s. Watching for changes, press Ctrl+C to stop.\n", gateway.CurrentDefaultRoute())
+			}
+			// 每行一个 JSON 对象，便于管道中逐行处理
+			encoder := json.NewEncoder(os.Stdout)
+			for event := range events {
+				if jsonOutput {
+					if err := encoder.Encode(event); err != nil {
+						return err
+					}
+					continue
+				}
+				fmt.Printf("%s  Default route changed: %s (was %s)\n",
+					event.Time.Format("2006-01-02 15:04:05"), event.Route, event.Previous)
+			}
+			return nil
+		},
 	}
-	defer out.Close()
+	watch.Flags().BoolVar(&jsonOutput, "json", false, "Print each change as a line of JSON")
 
-	// Create progress bar
-	size := resp.ContentLength
-	progress := &ProgressWriter{
-		Total:     size,
-		Writer:    out,
-		LastPrint: time.Now(),
+	cmd.AddCommand(watch)
+	return cmd
+}
+
+// printConfig 按分组列出完整配置
+func printConfig(out io.Writer, cfg *config.Config) error {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "Gateways")
+	fmt.Fprintf(w, "  Proxy Gateway:\t%s\n", strings.Join(cfg.ProxyGateways, ", "))
+	fmt.Fprintf(w, "  Default Gateway:\t%s\n", cfg.DefaultGateway)
+
+	fmt.Fprintln(w, "\nHooks")
+	fmt.Fprintf(w, "  On Proxy:\t%s\n", valueOrDash(cfg.Hooks.OnProxy))
+	fmt.Fprintf(w, "  On Default:\t%s\n", valueOrDash(cfg.Hooks.OnDefault))
+	fmt.Fprintf(w, "  Timeout:\t%v\n", cfg.Hooks.Timeout)
+
+	fmt.Fprintln(w, "\nDNS")
+	fmt.Fprintf(w, "  Listen Address:\t%s\n", net.JoinHostPort(cfg.DNS.ListenAddr, strconv.Itoa(cfg.DNS.ListenPort)))
+	fmt.Fprintf(w, "  Upstream DNS Servers:\t%s\n", strings.Join(cfg.DNS.UpstreamDNS, ", "))
+	fmt.Fprintf(w, "  Fallback DNS Server:\t%s\n", valueOrDash(cfg.DNS.FallbackDNS))
+	fmt.Fprintf(w, "  Upstream Strategy:\t%s\n", cfg.DNS.Strategy)
+	fmt.Fprintf(w, "  AAAA Filter:\t%s\n", enabledText(cfg.DNS.FilterAAAA))
+	fmt.Fprintf(w, "  QNAME Minimization:\t%s\n", enabledText(cfg.DNS.QNAMEMinimization))
+	fmt.Fprintf(w, "  Recursion:\t%s\n", cfg.DNS.Recursion)
+	fmt.Fprintf(w, "  Hosts File:\t%s\n", valueOrDash(cfg.DNS.HostsFile))
+	fmt.Fprintf(w, "  Blocked Domains:\t%d (%s)\n", len(cfg.DNS.Blocklist), cfg.DNS.BlocklistResponse)
+	fmt.Fprintf(w, "  Idle Timeout:\t%s\n", durationOrOff(cfg.DNS.IdleTimeout))
+	fmt.Fprintf(w, "  Upstream Retries:\t%s\n", retriesText(cfg.DNS.UpstreamRetries, cfg.DNS.RetryBackoff))
+	fmt.Fprintf(w, "  Cache TTL:\t%s\n", cacheTTLText(cfg.DNS.CacheMinTTL, cfg.DNS.CacheMaxTTL))
+	fmt.Fprintf(w, "  Serve Stale:\t%s\n", durationOrOff(cfg.DNS.ServeStaleMaxAge))
+	fmt.Fprintf(w, "  Max In-Flight Queries:\t%d\n", cfg.DNS.MaxInflight)
+	fmt.Fprintf(w, "  Log Format:\t%s\n", cfg.DNS.LogFormat)
+	fmt.Fprintf(w, "  Query Log Rotation:\t%d MB, keep %d\n", cfg.DNS.QueryLogMaxSize, cfg.DNS.QueryLogKeep)
+	fmt.Fprintf(w, "  Metrics Address:\t%s\n", valueOrDash(cfg.DNS.MetricsAddr))
+	fmt.Fprintf(w, "  Control API Address:\t%s\n", valueOrDash(cfg.DNS.ControlAddr))
+	if runtime.GOOS == "darwin" {
+		fmt.Fprintf(w, "  All Network Services:\t%s\n", enabledText(cfg.DNS.AllNetworkServices))
 	}
 
-	// Copy with progress
-	_, err = io.Copy(progress, resp.Body)
-	fmt.Println() // New line after progress bar
-	return err
+	fmt.Fprintln(w, "\nGeneral")
+	fmt.Fprintf(w, "  Notifications:\t%s\n", enabledText(cfg.Notifications))
+	fmt.Fprintf(w, "  Sudo Prompt:\t%s\n", valueOrDash(cfg.SudoPrompt))
+	fmt.Fprintf(w, "  Sudo Keep-Alive:\t%s\n", enabledText(cfg.SudoKeepAlive))
+	return w.Flush()
 }
 
-type ProgressWriter struct {
-	Total     int64
-	Current   int64
-	Writer    io.Writer
-	LastPrint time.Time
+// durationOrOff 显示时长，0 表示未启用
+func durationOrOff(d time.Duration) string {
+	if d == 0 {
+		return "off"
+	}
+	return d.String()
 }
 
-func (pw *ProgressWriter) Write(p []byte) (int, error) {
-	n, err := pw.Writer.Write(p)
-	pw.Current += int64(n)
+// retriesText 描述上游查询超时后的重试次数与首次重试前的等待时间
+func retriesText(retries int, backoff time.Duration) string {
+	if retries == 0 {
+		return "off"
+	}
+	return fmt.Sprintf("%d (backoff %v)", retries, backoff)
+}
 
-	// Update progress every 100ms
-	if time.Since(pw.LastPrint) >= 100*time.Millisecond {
-		percentage := float64(pw.Current) / float64(pw.Total) * 100
-		fmt.Printf("\rDownloading... %.1f%% (%d/%d bytes)", percentage, pw.Current, pw.Total)
-		pw.LastPrint = time.Now()
+// cacheTTLText 描述缓存时长的上下限，0 表示不限
+func cacheTTLText(min, max time.Duration) string {
+	if min == 0 && max == 0 {
+		return "record TTL"
+	}
+	bound := func(d time.Duration) string {
+		if d == 0 {
+			return "-"
+		}
+		return d.String()
 	}
+	return fmt.Sprintf("min %s, max %s", bound(min), bound(max))
+}
 
-	return n, err
+// valueOrDash 将空值显示为 "-"
+func valueOrDash(s string) string {
+	if s == "" {
+		return "-"
+	}
+	return s
 }
 
-func switchGateway(newGateway string) error {
-	// Get the active interface
-	iface, err := gateway.GetActiveInterface()
-	if err != nil {
-		return fmt.Errorf("failed to get active interface: %w", err)
+// formatSubnet 同时显示点分掩码与前缀长度，例如 "255.255.255.0 (/24)"
+func formatSubnet(subnet string, prefixLen int) string {
+	if subnet == "" {
+		return "-"
 	}
+	if prefixLen == 0 {
+		return subnet
+	}
+	return fmt.Sprintf("%s (/%d)", subnet, prefixLen)
+}
 
-	// Check if already using the target gateway
-	if iface.Gateway == newGateway {
-		fmt.Printf("Already using gateway: %s\n", newGateway)
-		return nil
+// formatAddress 以 CIDR 形式显示接口地址，前缀长度未知时只显示地址
+func formatAddress(ip string, prefixLen int) string {
+	if prefixLen == 0 {
+		return ip
 	}
+	return fmt.Sprintf("%s/%d", ip, prefixLen)
+}
 
-	// Switch to the new gateway
-	fmt.Printf("Switching gateway from %s to %s...\n", iface.Gateway, newGateway)
-	startTime := time.Now()
+// getPublicIP 通过 Cloudflare 获取公网 IPv4 地址
+func getPublicIP(ctx context.Context) (string, error) {
+	ip, err := getTraceIP(ctx, cloudflareURL)
+	if err != nil {
+		return "", err
+	}
+	if ip == "" {
+		return "", fmt.Errorf("IP not found in response")
+	}
+	return ip, nil
+}
 
-	if err := gateway.SwitchGateway(iface, newGateway); err != nil {
-		return fmt.Errorf("failed to switch gateway: %w", err)
+// getPublicIPv6 通过 Cloudflare 获取公网 IPv6 地址
+func getPublicIPv6(ctx context.Context) (string, error) {
+	ip, err := getTraceIP(ctx, cloudflareIPv6URL)
+	if err != nil {
+		return "", err
+	}
+	if ip == "" {
+		return "", fmt.Errorf("IPv6 not found in response")
 	}
+	return ip, nil
+}
 
-	elapsed := time.Since(startTime)
-	fmt.Printf("Gateway switched successfully (took %v)\n", elapsed.Round(time.Millisecond))
+// getTraceIP 请求 Cloudflare trace 接口并解析其中的 ip 字段，请求随 ctx 取消
+func getTraceIP(ctx context.Context, url string) (string, error) {
+	req, err := http.NewRequestWithContext(ctx, "GET", url, nil)
+	if err != nil {
+		return "", err
+	}
 
-	// Verify internet connectivity
-	if gateway.CheckInternetConnectivity() {
-		fmt.Println("Internet connectivity confirmed")
-	} else {
-		fmt.Println("Warning: No internet connectivity detected")
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return "", err
 	}
+	defer resp.Body.Close()
 
-	return nil
-}
+	body, err := io.ReadAll(resp.Body)
+	if err != nil {
+		return "", err
+	}
 
-// dnsCmd represents the dns command
-var dnsCmd = &cobra.Command{
-	Use:   "dns",
-	Short: "Manage DNS proxy service",
-	Long:  `This command is used to manage the DNS proxy service, which can intercept DNS requests and forward them to upstream DNS servers.`,
-	Run: func(cmd *cobra.Command, args []string) {
-		cmd.Help()
-	},
+	lines := strings.Split(string(body), "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "ip=") {
+			return strings.TrimPrefix(line, "ip="), nil
+		}
+	}
+
+	return "", nil
 }
 
-func init() {
-	// show command
-	var showCmd = &cobra.Command{
-		Use:   "show",
-		Short: "Show DNS proxy configuration",
-		Long:  `Show the current configuration of the DNS proxy service.`,
+func versionCmd() *cobra.Command {
+	var jsonOutput bool
+	cmd := &cobra.Command{
+		Use:   "version",
+		Short: "Show the version information",
 		Run: func(cmd *cobra.Command, args []string) {
-			// Display DNS configuration
-			cfg, err := config.LoadConfig()
-			if err != nil {
-				fmt.Println("Error loading config:", err)
+			info := currentVersionInfo()
+			if jsonOutput {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(info); err != nil {
+					fmt.Println("Error:", err)
+				}
 				return
 			}
 
-			fmt.Printf("Listen Address: %s\n", cfg.DNS.ListenAddr)
-			fmt.Printf("Upstream DNS Servers: %v\n", cfg.DNS.UpstreamDNS)
-
-			// Check if DNS proxy is running
-			if pid := getPID(DNSPIDFile); pid > 0 {
-				fmt.Println("Status: Running")
-			} else {
-				fmt.Println("Status: Stopped")
-			}
+			fmt.Printf("Proxy Gateway Switcher v%s\n", strings.TrimPrefix(info.Version, "v"))
+			fmt.Printf("Build time: %s\n", info.BuildTime)
+			fmt.Printf("Commit: %s\n", info.Commit)
+			fmt.Printf("Platform: %s/%s (%s)\n", info.OS, info.Arch, info.GoVersion)
 		},
 	}
-	dnsCmd.AddCommand(showCmd)
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output the version information as JSON")
+	return cmd
+}
 
-	// logs command
-	var follow bool
-	var lines int
-	var filterText string
-	var logsCmd = &cobra.Command{
-		Use:   "logs",
-		Short: "View DNS service logs",
-		Long:  `View and filter logs from the DNS proxy service.`,
-		Run: func(cmd *cobra.Command, args []string) {
-			// 获取日志文件路径
-			homeDir, err := os.UserHomeDir()
+func installCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "install",
+		Short: "Install GateShift to system",
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// 获取当前二进制文件的路径
+			ex, err := os.Executable()
 			if err != nil {
-				fmt.Println("Error finding home directory:", err)
-				return
+				return fmt.Errorf("failed to get executable path: %w", err)
 			}
-			logFile := filepath.Join(homeDir, ".gateshift", "logs", "gateshift-dns.log")
 
-			// 检查日志文件是否存在
-			if _, err := os.Stat(logFile); os.IsNotExist(err) {
-				fmt.Println("Log file not found. Has the DNS service been started?")
-				return
+			// 检查目标路径是否已存在
+			targetPath := "/usr/local/bin/gateshift"
+			if _, err := os.Stat(targetPath); err == nil {
+				// 如果文件已存在，检查是否是相同文件
+				if isSameFile(ex, targetPath) {
+					fmt.Println("GateShift is already installed at /usr/local/bin/gateshift")
+					fmt.Println("If you want to reinstall, please run 'sudo rm /usr/local/bin/gateshift' first")
+					return nil
+				}
 			}
 
-			// 构建命令
-			var cmdArgs []string
-			if follow {
-				// 使用tail -f实时查看日志
-				cmdArgs = append(cmdArgs, "-f")
-			}
+			fmt.Printf("Installing GateShift to %s\n", targetPath)
 
-			// 指定行数
-			cmdArgs = append(cmdArgs, "-n", fmt.Sprintf("%d", lines))
-			cmdArgs = append(cmdArgs, logFile)
+			// 复制文件
+			if err := copyFile(ex, targetPath); err != nil {
+				return fmt.Errorf("failed to copy binary: %w", err)
+			}
 
-			if filterText != "" {
-				// 如果有过滤条件，使用grep过滤
-				tailCmd := exec.Command("tail", cmdArgs...)
-				grepCmd := exec.Command("grep", "-i", filterText)
+			// 设置执行权限
+			if err := os.Chmod(targetPath, 0755); err != nil {
+				return fmt.Errorf("failed to set executable permission: %w", err)
+			}
 
-				// 连接两个命令的标准输入/输出
-				pipe, err := tailCmd.StdoutPipe()
-				if err != nil {
-					fmt.Println("Error setting up command:", err)
-					return
-				}
-				grepCmd.Stdin = pipe
-				grepCmd.Stdout = os.Stdout
-				grepCmd.Stderr = os.Stderr
+			fmt.Println("Installation completed successfully!")
 
-				// 启动命令
-				if err := grepCmd.Start(); err != nil {
-					fmt.Println("Error starting grep command:", err)
-					return
-				}
-				if err := tailCmd.Run(); err != nil {
-					if !follow { // 如果非follow模式，tail可能正常结束，不视为错误
-						fmt.Println("Error running tail command:", err)
-					}
-				}
-				grepCmd.Wait()
-			} else {
-				// 直接使用tail命令
-				execCmd := exec.Command("tail", cmdArgs...)
-				execCmd.Stdout = os.Stdout
-				execCmd.Stderr = os.Stderr
-
-				if err := execCmd.Run(); err != nil {
-					if !follow { // 同上
-						fmt.Println("Error viewing logs:", err)
+			// 获取当前工作目录
+			cwd, err := os.Getwd()
+			if err == nil {
+				// 检查当前目录下是否存在二进制文件，如果存在则删除
+				binPath := filepath.Join(cwd, filepath.Base(ex))
+				if binPath != ex { // 确保不会删除正在运行的文件
+					if err := os.Remove(binPath); err == nil {
+						fmt.Println("Cleaned up downloaded binary file")
 					}
 				}
 			}
+
+			fmt.Println("\nRequesting elevated privileges for network configuration...")
+			return nil
 		},
 	}
+}
 
-	// 添加flags
-	logsCmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow log output in real-time")
-	logsCmd.Flags().IntVarP(&lines, "lines", "n", 50, "Number of lines to show")
-	logsCmd.Flags().StringVarP(&filterText, "filter", "F", "", "Filter logs containing the specified text (case insensitive)")
-
-	dnsCmd.AddCommand(logsCmd)
+// 检查两个文件是否相同
+func isSameFile(file1, file2 string) bool {
+	info1, err1 := os.Stat(file1)
+	info2, err2 := os.Stat(file2)
+	if err1 != nil || err2 != nil {
+		return false
+	}
+	return info1.Size() == info2.Size() && info1.ModTime().Equal(info2.ModTime())
+}
 
-	// start command
-	var startForeground bool
-	var startCmd = &cobra.Command{
-		Use:   "start",
-		Short: "Start the DNS proxy service",
-		Long:  `Start the DNS proxy service.`,
-		Run: func(cmd *cobra.Command, args []string) {
-			// Check if DNS proxy is already running
-			if pid := getPID(DNSPIDFile); pid > 0 {
-				fmt.Println("DNS service is already running")
-				return
-			}
+// 复制文件
+func copyFile(src, dst string) error {
+	sourceFile, err := os.Open(src)
+	if err != nil {
+		return err
+	}
+	defer sourceFile.Close()
 
-			// Load configuration
-			cfg, err := config.LoadConfig()
-			if err != nil {
-				fmt.Println("Error loading config:", err)
-				return
+	destFile, err := os.Create(dst)
+	if err != nil {
+		return err
+	}
+	defer destFile.Close()
+
+	_, err = io.Copy(destFile, sourceFile)
+	return err
+}
+
+func uninstallCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "uninstall",
+		Short: "Uninstall GateShift from the system",
+		Long:  `Remove GateShift from system-wide installation.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Initialize sudo session with 15-minute timeout
+			sudoSession := utils.NewSudoSession(15 * time.Minute)
+
+			// Get GOPATH
+			gopath := os.Getenv("GOPATH")
+			if gopath == "" {
+				gopath = filepath.Join(os.Getenv("HOME"), "go")
 			}
+			gopathBin := filepath.Join(gopath, "bin")
 
-			if startForeground {
-				fmt.Println("Starting DNS service in foreground mode. Press Ctrl+C to stop...")
-				startDNSForeground(cfg)
-			} else {
-				fmt.Println("Starting DNS service in the background...")
-				if err := startDNSBackground(cfg); err != nil {
-					fmt.Println("Error starting DNS service:", err)
-					return
+			// Define the installation path based on OS
+			var installPath string
+			var installDir string
+			var gopathVersion string
+
+			switch runtime.GOOS {
+			case "darwin", "linux":
+				installPath = "/usr/local/bin/gateshift"
+				gopathVersion = filepath.Join(gopathBin, "gateshift")
+			case "windows":
+				// On Windows, check Program Files
+				installDir = os.Getenv("ProgramFiles")
+				if installDir == "" {
+					installDir = "C:\\Program Files"
 				}
-				fmt.Println("DNS service started successfully in the background")
+				installDir = filepath.Join(installDir, "GateShift")
+				installPath = filepath.Join(installDir, "gateshift.exe")
+				gopathVersion = filepath.Join(gopathBin, "gateshift.exe")
+			default:
+				return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
 			}
-		},
-	}
-	startCmd.Flags().BoolVarP(&startForeground, "foreground", "f", false, "Run the DNS proxy in the foreground")
-	dnsCmd.AddCommand(startCmd)
 
-	// stop command
-	var stopCmd = &cobra.Command{
-		Use:   "stop",
-		Short: "Stop the DNS proxy service",
-		Long:  `Stop the DNS proxy service.`,
-		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println("Stopping DNS service...")
-			if err := stopDNS(); err != nil {
-				fmt.Println("Error stopping DNS service:", err)
-				return
-			}
-		},
-	}
-	dnsCmd.AddCommand(stopCmd)
+			// Check both system and GOPATH installations
+			systemInstalled := false
+			gopathInstalled := false
 
-	// restart command
-	var restartCmd = &cobra.Command{
-		Use:   "restart",
-		Short: "Restart the DNS proxy service",
-		Long:  `Restart the DNS proxy service.`,
-		Run: func(cmd *cobra.Command, args []string) {
-			fmt.Println("Restarting DNS service...")
-			// Stop the DNS service first
-			if err := stopDNS(); err != nil {
-				fmt.Println("Error stopping DNS service:", err)
-				return
+			if _, err := os.Stat(installPath); err == nil {
+				systemInstalled = true
 			}
-
-			// Load configuration
-			cfg, err := config.LoadConfig()
-			if err != nil {
-				fmt.Println("Error loading config:", err)
-				return
+			if _, err := os.Stat(gopathVersion); err == nil {
+				gopathInstalled = true
 			}
 
-			// Start the DNS service
-			fmt.Println("Starting DNS service...")
-			if err := startDNSBackground(cfg); err != nil {
-				fmt.Println("Error starting DNS service:", err)
-				return
+			if !systemInstalled && !gopathInstalled {
+				return fmt.Errorf("GateShift is not installed at %s or %s", installPath, gopathVersion)
 			}
-			fmt.Println("DNS service restarted successfully")
-		},
-	}
-	dnsCmd.AddCommand(restartCmd)
 
-	// add-server command
-	var addServerCmd = &cobra.Command{
-		Use:   "add-server [server]",
-		Short: "Add an upstream DNS server",
-		Long:  `Add an upstream DNS server to the DNS proxy configuration.`,
-		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
-			server := args[0]
-			// Ensure the server has a port (default to 53 if not specified)
-			if _, _, err := net.SplitHostPort(server); err != nil {
-				server = net.JoinHostPort(server, "53")
-			}
+			// Remove system installation if exists
+			if systemInstalled {
+				fmt.Printf("Uninstalling GateShift from %s\n", installPath)
 
-			// Load configuration
-			cfg, err := config.LoadConfig()
-			if err != nil {
-				fmt.Println("Error loading config:", err)
-				return
-			}
+				switch runtime.GOOS {
+				case "darwin", "linux":
+					if err := sudoSession.RunWithPrivileges("rm", installPath); err != nil {
+						return fmt.Errorf("failed to remove binary: %w", err)
+					}
+				case "windows":
+					if err := os.Remove(installPath); err != nil {
+						return fmt.Errorf("failed to remove binary: %w", err)
+					}
 
-			// Check if the server already exists
-			for _, s := range cfg.DNS.UpstreamDNS {
-				if s == server {
-					fmt.Printf("Upstream DNS server %s already exists\n", server)
-					return
+					// Try to remove the directory if it's empty
+					if err := os.Remove(installDir); err != nil {
+						fmt.Printf("Note: Could not remove directory %s. It may not be empty.\n", installDir)
+					}
+
+					// Remove from PATH using PowerShell
+					removeFromPathCmd := fmt.Sprintf(
+						"$currentPath = [Environment]::GetEnvironmentVariable('Path', 'Machine'); "+
+							"if ($currentPath -like '*%s*') { "+
+							"$newPath = $currentPath -replace '%s;', '' -replace ';%s', '' -replace '%s'; "+
+							"[Environment]::SetEnvironmentVariable('Path', $newPath, 'Machine') "+
+							"}", installDir, installDir, installDir, installDir)
+
+					psCmd := exec.Command("powershell", "-Command", removeFromPathCmd)
+					if err := sudoSession.RunWithPrivileges(psCmd.Path, psCmd.Args[1:]...); err != nil {
+						fmt.Println("Warning: Failed to remove from PATH automatically.")
+						fmt.Printf("Please remove %s from your PATH manually if needed.\n", installDir)
+					}
 				}
+
+				fmt.Println("System-wide installation removed successfully!")
 			}
 
-			// Add the server
-			cfg.DNS.UpstreamDNS = append(cfg.DNS.UpstreamDNS, server)
+			// Remove GOPATH installation if exists
+			if gopathInstalled {
+				fmt.Printf("Removing GOPATH installation from %s\n", gopathVersion)
+				if err := os.Remove(gopathVersion); err != nil {
+					return fmt.Errorf("failed to remove GOPATH binary: %w", err)
+				}
+				fmt.Println("GOPATH installation removed successfully!")
+			}
 
-			// Save configuration
-			if err := config.SaveConfig(cfg); err != nil {
-				fmt.Println("Error saving config:", err)
-				return
+			if runtime.GOOS == "windows" {
+				fmt.Println("You may need to restart your terminal or system for the PATH changes to take effect.")
 			}
 
-			fmt.Printf("Upstream DNS server %s added successfully\n", server)
-			fmt.Println("Restart the DNS service to apply changes: gateshift dns restart")
+			return nil
 		},
 	}
-	dnsCmd.AddCommand(addServerCmd)
+}
 
-	// remove-server command
-	var removeServerCmd = &cobra.Command{
-		Use:   "remove-server [server]",
-		Short: "Remove an upstream DNS server",
-		Long:  `Remove an upstream DNS server from the DNS proxy configuration.`,
-		Args:  cobra.ExactArgs(1),
-		Run: func(cmd *cobra.Command, args []string) {
-			server := args[0]
-			// Ensure the server has a port (default to 53 if not specified)
-			if _, _, err := net.SplitHostPort(server); err != nil {
-				server = net.JoinHostPort(server, "53")
+// exitUpdateAvailable 是 upgrade --check-only 发现可用更新时的退出状态
+const exitUpdateAvailable = 10
+
+// upgradeCheckResult 是 upgrade --check-only --json 的输出
+type upgradeCheckResult struct {
+	CurrentVersion  string `json:"current_version"`
+	LatestVersion   string `json:"latest_version"`
+	UpdateAvailable bool   `json:"update_available"`
+}
+
+func upgradeCmd() *cobra.Command {
+	var autoApprove bool
+	var allowDowngrade bool
+	var targetVersion string
+	var checkOnly bool
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "upgrade",
+		Short: "Check for updates and upgrade GateShift",
+		Long: `Check for new versions of GateShift and upgrade if available.
+If a newer version is found, it will be downloaded and installed automatically.
+Versions are compared as semantic versions; an older release is only
+installed with --allow-downgrade. Development builds can always be upgraded.
+
+Use --version to install a specific release instead of the latest one, for
+example to roll back with --version v1.2.3 --allow-downgrade.
+
+With --check-only nothing is installed: the command only reports whether an
+update is available and exits with status 10 if so, 0 otherwise.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			// Remove 'v' prefix from current version if present
+			currentVersion := strings.TrimPrefix(Version, "v")
+			if jsonOutput && !checkOnly {
+				return fmt.Errorf("--json requires --check-only")
+			}
+			if !jsonOutput {
+				fmt.Printf("Current version: v%s\n", currentVersion)
+				fmt.Println("Checking for updates...")
 			}
 
-			// Load configuration
-			cfg, err := config.LoadConfig()
+			// Get the latest or the requested release info from GitHub
+			tag := ""
+			if targetVersion != "" {
+				var err error
+				if tag, err = releaseTag(targetVersion); err != nil {
+					return err
+				}
+			}
+			releaseVersion, downloadURL, checksumURL, err := getRelease(tag)
 			if err != nil {
-				fmt.Println("Error loading config:", err)
-				return
+				return fmt.Errorf("failed to check for updates: %w", err)
 			}
 
-			// Find and remove the server
-			found := false
-			for i, s := range cfg.DNS.UpstreamDNS {
-				if s == server {
-					cfg.DNS.UpstreamDNS = append(cfg.DNS.UpstreamDNS[:i], cfg.DNS.UpstreamDNS[i+1:]...)
-					found = true
-					break
+			release, ok := parseVersion(releaseVersion)
+			if !ok {
+				return fmt.Errorf("release has an invalid version: %s", releaseVersion)
+			}
+
+			if checkOnly {
+				result := upgradeCheckResult{
+					CurrentVersion:  currentVersion,
+					LatestVersion:   releaseVersion,
+					UpdateAvailable: true,
+				}
+				if current, ok := parseVersion(currentVersion); ok {
+					c := compareVersions(release, current)
+					result.UpdateAvailable = c > 0 || c < 0 && allowDowngrade
+				}
+
+				if jsonOutput {
+					data, err := json.MarshalIndent(result, "", "  ")
+					if err != nil {
+						return fmt.Errorf("failed to encode result: %w", err)
+					}
+					fmt.Println(string(data))
+				} else if result.UpdateAvailable {
+					fmt.Printf("Update available: v%s\n", releaseVersion)
+				} else {
+					fmt.Println("You are already running the latest version!")
+				}
+				if result.UpdateAvailable {
+					os.Exit(exitUpdateAvailable)
 				}
+				return nil
 			}
 
-			if !found {
-				fmt.Printf("Upstream DNS server %s not found\n", server)
-				return
+			// Development builds have no release version to compare with
+			// and may always be replaced by a release
+			if current, ok := parseVersion(currentVersion); !ok {
+				fmt.Printf("Current build is not a release, installing release: v%s\n", releaseVersion)
+			} else {
+				switch compareVersions(release, current) {
+				case 0:
+					fmt.Printf("You are already running v%s!\n", releaseVersion)
+					return nil
+				case -1:
+					if !allowDowngrade {
+						fmt.Printf("Release v%s is older than the current version, use --allow-downgrade to install it\n", releaseVersion)
+						return nil
+					}
+					fmt.Printf("Downgrading to v%s\n", releaseVersion)
+				default:
+					fmt.Printf("New version available: v%s\n", releaseVersion)
+				}
 			}
 
-			// Save configuration
-			if err := config.SaveConfig(cfg); err != nil {
-				fmt.Println("Error saving config:", err)
-				return
+			// Ask for confirmation unless auto-approve is set
+			if !autoApprove {
+				fmt.Print("Do you want to upgrade? [y/N] ")
+				var response string
+				fmt.Scanln(&response)
+				if response != "y" && response != "Y" {
+					fmt.Println("Upgrade cancelled")
+					return nil
+				}
 			}
 
-			fmt.Printf("Upstream DNS server %s removed successfully\n", server)
-			fmt.Println("Restart the DNS service to apply changes: gateshift dns restart")
-		},
-	}
-	dnsCmd.AddCommand(removeServerCmd)
+			// Create temporary directory for download
+			tmpDir, err := os.MkdirTemp("", "gateshift-upgrade")
+			if err != nil {
+				return fmt.Errorf("failed to create temporary directory: %w", err)
+			}
+			defer os.RemoveAll(tmpDir)
 
-	// list-servers command
+			// Download the new version
+			fmt.Println("Downloading new version...")
+			assetName := path.Base(downloadURL)
+			assetPath := filepath.Join(tmpDir, assetName)
+			binaryPath := filepath.Join(tmpDir, "gateshift")
+			if runtime.GOOS == "windows" {
+				binaryPath += ".exe"
+			}
+
+			if err := downloadFile(downloadURL, assetPath); err != nil {
+				return fmt.Errorf("failed to download new version: %w", err)
+			}
+
+			// Verify the download against the published SHA-256 checksum
+			fmt.Println("Verifying checksum...")
+			if err := verifyChecksum(assetPath, checksumURL, assetName); err != nil {
+				return fmt.Errorf("checksum verification failed, aborting upgrade: %w", err)
+			}
+
+			// Extract the binary from archived release assets
+			if isArchive(assetName) {
+				fmt.Println("Extracting new version...")
+				if err := extractBinary(assetPath, binaryPath); err != nil {
+					return fmt.Errorf("failed to extract new version: %w", err)
+				}
+			} else if err := os.Rename(assetPath, binaryPath); err != nil {
+				return fmt.Errorf("failed to prepare new version: %w", err)
+			}
+
+			if err := checkExecutable(binaryPath); err != nil {
+				return fmt.Errorf("downloaded file is not a valid executable: %w", err)
+			}
+
+			// Make the downloaded file executable
+			if runtime.GOOS != "windows" {
+				if err := os.Chmod(binaryPath, 0755); err != nil {
+					return fmt.Errorf("failed to make binary executable: %w", err)
+				}
+			}
+
+			// Get current executable path
+			execPath, err := os.Executable()
+			if err != nil {
+				return fmt.Errorf("failed to get executable path: %w", err)
+			}
+
+			// Initialize sudo session
+			sudoSession := utils.NewSudoSession(15 * time.Minute)
+
+			// Replace the current binary
+			fmt.Println("Installing new version...")
+			if runtime.GOOS == "windows" {
+				// On Windows, we need to rename instead of direct replacement
+				backupPath := execPath + ".old"
+				if err := os.Rename(execPath, backupPath); err != nil {
+					return fmt.Errorf("failed to backup current binary: %w", err)
+				}
+				if err := os.Rename(binaryPath, execPath); err != nil {
+					// Try to restore backup
+					os.Rename(backupPath, execPath)
+					return fmt.Errorf("failed to install new version: %w", err)
+				}
+				os.Remove(backupPath)
+			} else {
+				if err := sudoSession.RunWithPrivileges("cp", binaryPath, execPath); err != nil {
+					return fmt.Errorf("failed to install new version: %w", err)
+				}
+			}
+
+			fmt.Printf("Successfully upgraded to v%s!\n", releaseVersion)
+			fmt.Println("Please restart GateShift to use the new version.")
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVarP(&autoApprove, "yes", "y", false, "Automatically approve upgrade without confirmation")
+	cmd.Flags().StringVar(&targetVersion, "version", "", "Install this release (e.g. v1.2.3) instead of the latest one")
+	cmd.Flags().BoolVar(&checkOnly, "check-only", false, "Only report whether an update is available (exit status 10 if so)")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output the --check-only result as JSON")
+	cmd.Flags().BoolVar(&allowDowngrade, "allow-downgrade", false, "Install the release even if it is older than the current version")
+	return cmd
+}
+
+// releasesAPI is the GitHub API endpoint for GateShift releases
+var releasesAPI = "https://api.github.com/repos/ourines/GateShift/releases"
+
+// getRelease returns the version of the release tagged tag, or of the latest
+// release when tag is empty, together with the download URL of the binary
+// for this platform and of its checksum file
+func getRelease(tag string) (version string, downloadURL string, checksumURL string, err error) {
+	apiURL := releasesAPI + "/latest"
+	if tag != "" {
+		apiURL = releasesAPI + "/tags/" + url.PathEscape(tag)
+	}
+
+	// Create HTTP client with timeout
+	client := &http.Client{Timeout: 10 * time.Second}
+
+	// Make request
+	resp, err := client.Get(apiURL)
+	if err != nil {
+		return "", "", "", err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode == http.StatusNotFound && tag != "" {
+		tags, err := listReleaseTags(client)
+		if err != nil {
+			return "", "", "", fmt.Errorf("release %s not found", tag)
+		}
+		return "", "", "", fmt.Errorf("release %s not found (available: %s)", tag, strings.Join(tags, ", "))
+	}
+	if resp.StatusCode != http.StatusOK {
+		return "", "", "", fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+	}
+
+	// Parse response
+	var release struct {
+		TagName string         `json:"tag_name"`
+		Assets  []releaseAsset `json:"assets"`
+	}
+
+	if err := json.NewDecoder(resp.Body).Decode(&release); err != nil {
+		return "", "", "", err
+	}
+
+	// Remove 'v' prefix from version if present
+	version = strings.TrimPrefix(release.TagName, "v")
+
+	// Find the appropriate asset for current platform
+	assetName, downloadURL, checksumURL, err := selectReleaseAsset(release.Assets, runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		return "", "", "", err
+	}
+	if checksumURL == "" {
+		return "", "", "", fmt.Errorf("release v%s does not publish a checksum for %s", version, assetName)
+	}
+
+	return version, downloadURL, checksumURL, nil
+}
+
+// listReleaseTags returns the tags of the most recent releases, newest first
+func listReleaseTags(client *http.Client) ([]string, error) {
+	resp, err := client.Get(releasesAPI + "?per_page=30")
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("GitHub API returned status %d", resp.StatusCode)
+	}
+
+	var releases []struct {
+		TagName string `json:"tag_name"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&releases); err != nil {
+		return nil, err
+	}
+	if len(releases) == 0 {
+		return nil, fmt.Errorf("no releases published")
+	}
+
+	tags := make([]string, 0, len(releases))
+	for _, release := range releases {
+		tags = append(tags, release.TagName)
+	}
+	return tags, nil
+}
+
+// releaseTag returns the release tag for a version given to upgrade
+// --version, adding the "v" prefix the tags use
+func releaseTag(version string) (string, error) {
+	if _, ok := parseVersion(version); !ok {
+		return "", fmt.Errorf("invalid version: %s (expected a version like v1.2.3)", version)
+	}
+	return "v" + strings.TrimPrefix(version, "v"), nil
+}
+
+// releaseAsset is a file attached to a GitHub release
+type releaseAsset struct {
+	Name               string `json:"name"`
+	BrowserDownloadURL string `json:"browser_download_url"`
+}
+
+// releasePlatforms lists the GOOS/GOARCH pairs published with each release,
+// matching the build-all target and the release workflow
+var releasePlatforms = map[string][]string{
+	"linux":   {"amd64", "arm64", "arm"},
+	"darwin":  {"amd64", "arm64"},
+	"windows": {"amd64", "arm64"},
+}
+
+// platformAssetName returns the name of the release binary for goos/goarch
+func platformAssetName(goos, goarch string) (string, error) {
+	arches, ok := releasePlatforms[goos]
+	if !ok {
+		return "", fmt.Errorf("unsupported platform: %s", goos)
+	}
+
+	supported := false
+	for _, arch := range arches {
+		if arch == goarch {
+			supported = true
+			break
+		}
+	}
+	if !supported {
+		return "", fmt.Errorf("no prebuilt release for %s/%s (available: %s); build from source with go install instead",
+			goos, goarch, strings.Join(arches, ", "))
+	}
+
+	name := fmt.Sprintf("gateshift-%s-%s", goos, goarch)
+	if goos == "windows" {
+		name += ".exe"
+	}
+	return name, nil
+}
+
+// selectReleaseAsset picks the release asset for goos/goarch, preferring the
+// raw binary over archives of it, and the URL of its checksum file if any
+func selectReleaseAsset(assets []releaseAsset, goos, goarch string) (name, downloadURL, checksumURL string, err error) {
+	assetName, err := platformAssetName(goos, goarch)
+	if err != nil {
+		return "", "", "", err
+	}
+
+	urls := make(map[string]string, len(assets))
+	available := make([]string, 0, len(assets))
+	for _, asset := range assets {
+		urls[asset.Name] = asset.BrowserDownloadURL
+		available = append(available, asset.Name)
+	}
+
+	base := strings.TrimSuffix(assetName, ".exe")
+	for _, candidate := range []string{assetName, base + ".tar.gz", base + ".tgz", base + ".zip"} {
+		if url, ok := urls[candidate]; ok {
+			name, downloadURL = candidate, url
+			break
+		}
+	}
+	if downloadURL == "" {
+		return "", "", "", fmt.Errorf("no asset for %s/%s (looked for %s; available: %s)",
+			goos, goarch, assetName, strings.Join(available, ", "))
+	}
+
+	// Prefer a per-asset .sha256 file over the combined checksums.txt
+	checksumURL = urls[name+".sha256"]
+	if checksumURL == "" {
+		checksumURL = urls["checksums.txt"]
+	}
+
+	return name, downloadURL, checksumURL, nil
+}
+
+// isArchive reports whether a release asset is a compressed archive
+func isArchive(name string) bool {
+	return strings.HasSuffix(name, ".tar.gz") || strings.HasSuffix(name, ".tgz") || strings.HasSuffix(name, ".zip")
+}
+
+// binaryNames returns the file names accepted as the GateShift binary inside
+// a release archive
+func binaryNames(archiveName string) []string {
+	base := strings.TrimSuffix(strings.TrimSuffix(strings.TrimSuffix(archiveName, ".zip"), ".tgz"), ".tar.gz")
+	return []string{"gateshift", "gateshift.exe", base, base + ".exe"}
+}
+
+// safeArchivePath rejects archive entry names that could escape the
+// extraction directory (zip-slip)
+func safeArchivePath(name string) error {
+	clean := path.Clean(strings.ReplaceAll(name, "\\", "/"))
+	if path.IsAbs(clean) || clean == ".." || strings.HasPrefix(clean, "../") || filepath.VolumeName(name) != "" {
+		return fmt.Errorf("unsafe path in archive: %s", name)
+	}
+	return nil
+}
+
+// isBinaryEntry reports whether an archive entry is the GateShift binary
+func isBinaryEntry(name string, names []string) bool {
+	base := path.Base(strings.ReplaceAll(name, "\\", "/"))
+	for _, n := range names {
+		if base == n {
+			return true
+		}
+	}
+	return false
+}
+
+// extractBinary extracts the GateShift binary from a .tar.gz or .zip archive to destPath
+func extractBinary(archivePath, destPath string) error {
+	names := binaryNames(filepath.Base(archivePath))
+	if strings.HasSuffix(archivePath, ".zip") {
+		return extractFromZip(archivePath, destPath, names)
+	}
+	return extractFromTarGz(archivePath, destPath, names)
+}
+
+func extractFromTarGz(archivePath, destPath string, names []string) error {
+	f, err := os.Open(archivePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	gz, err := gzip.NewReader(f)
+	if err != nil {
+		return err
+	}
+	defer gz.Close()
+
+	tr := tar.NewReader(gz)
+	for {
+		hdr, err := tr.Next()
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return err
+		}
+		if err := safeArchivePath(hdr.Name); err != nil {
+			return err
+		}
+		if hdr.Typeflag != tar.TypeReg || !isBinaryEntry(hdr.Name, names) {
+			continue
+		}
+		return writeExtracted(destPath, tr)
+	}
+
+	return fmt.Errorf("no gateshift binary found in %s", filepath.Base(archivePath))
+}
+
+func extractFromZip(archivePath, destPath string, names []string) error {
+	zr, err := zip.OpenReader(archivePath)
+	if err != nil {
+		return err
+	}
+	defer zr.Close()
+
+	for _, entry := range zr.File {
+		if err := safeArchivePath(entry.Name); err != nil {
+			return err
+		}
+		if !entry.Mode().IsRegular() || !isBinaryEntry(entry.Name, names) {
+			continue
+		}
+
+		rc, err := entry.Open()
+		if err != nil {
+			return err
+		}
+		defer rc.Close()
+		return writeExtracted(destPath, rc)
+	}
+
+	return fmt.Errorf("no gateshift binary found in %s", filepath.Base(archivePath))
+}
+
+// writeExtracted writes an extracted archive entry to destPath
+func writeExtracted(destPath string, r io.Reader) error {
+	out, err := os.OpenFile(destPath, os.O_CREATE|os.O_WRONLY|os.O_TRUNC, 0755)
+	if err != nil {
+		return err
+	}
+	if _, err := io.Copy(out, r); err != nil {
+		out.Close()
+		return err
+	}
+	return out.Close()
+}
+
+// checkExecutable verifies that a file is an executable for this platform by
+// its magic number
+func checkExecutable(filePath string) error {
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	magic := make([]byte, 4)
+	if _, err := io.ReadFull(f, magic); err != nil {
+		return fmt.Errorf("file too short")
+	}
+
+	var ok bool
+	switch runtime.GOOS {
+	case "windows":
+		ok = magic[0] == 'M' && magic[1] == 'Z'
+	case "darwin":
+		// Mach-O 32/64-bit (either byte order) and universal binaries
+		switch binary.BigEndian.Uint32(magic) {
+		case 0xfeedface, 0xfeedfacf, 0xcefaedfe, 0xcffaedfe, 0xcafebabe:
+			ok = true
+		}
+	default:
+		ok = string(magic) == "\x7fELF"
+	}
+	if !ok {
+		return fmt.Errorf("unrecognized executable format for %s", runtime.GOOS)
+	}
+	return nil
+}
+
+// verifyChecksum checks the SHA-256 of the file at filePath against the
+// entry for assetName in the checksum file at checksumURL. The checksum file
+// may be in sha256sum format or hold a single bare hash.
+func verifyChecksum(filePath, checksumURL, assetName string) error {
+	client := &http.Client{Timeout: 30 * time.Second}
+	resp, err := client.Get(checksumURL)
+	if err != nil {
+		return fmt.Errorf("failed to download checksum: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("checksum download failed with status %d", resp.StatusCode)
+	}
+
+	sums, err := io.ReadAll(io.LimitReader(resp.Body, 1<<20))
+	if err != nil {
+		return fmt.Errorf("failed to read checksum: %w", err)
+	}
+
+	var expected string
+	for _, line := range strings.Split(string(sums), "\n") {
+		fields := strings.Fields(line)
+		// A per-asset .sha256 file may hold just the hash
+		if len(fields) == 1 || len(fields) >= 2 && strings.TrimPrefix(fields[1], "*") == assetName {
+			expected = fields[0]
+			break
+		}
+	}
+	if expected == "" {
+		return fmt.Errorf("no checksum listed for %s", assetName)
+	}
+
+	f, err := os.Open(filePath)
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	hash := sha256.New()
+	if _, err := io.Copy(hash, f); err != nil {
+		return err
+	}
+
+	actual := hex.EncodeToString(hash.Sum(nil))
+	if !strings.EqualFold(actual, expected) {
+		return fmt.Errorf("SHA-256 mismatch for %s: expected %s, got %s", assetName, expected, actual)
+	}
+	return nil
+}
+
+func downloadFile(url string, filepath string) error {
+	// Create HTTP client with timeout
+	client := &http.Client{Timeout: 5 * time.Minute}
+
+	// Make request
+	resp, err := client.Get(url)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("download failed with status %d", resp.StatusCode)
+	}
+
+	// Create output file
+	out, err := os.Create(filepath)
+	if err != nil {
+		return err
+	}
+	defer out.Close()
+
+	// Create progress bar
+	size := resp.ContentLength
+	progress := &ProgressWriter{
+		Total:     size,
+		Writer:    out,
+		LastPrint: time.Now(),
+	}
+
+	// Copy with progress
+	_, err = io.Copy(progress, resp.Body)
+	fmt.Println() // New line after progress bar
+	return err
+}
+
+type ProgressWriter struct {
+	Total     int64
+	Current   int64
+	Writer    io.Writer
+	LastPrint time.Time
+}
+
+func (pw *ProgressWriter) Write(p []byte) (int, error) {
+	n, err := pw.Writer.Write(p)
+	pw.Current += int64(n)
+
+	// Update progress every 100ms
+	if time.Since(pw.LastPrint) >= 100*time.Millisecond {
+		percentage := float64(pw.Current) / float64(pw.Total) * 100
+		fmt.Printf("\rDownloading... %.1f%% (%d/%d bytes)", percentage, pw.Current, pw.Total)
+		pw.LastPrint = time.Now()
+	}
+
+	return n, err
+}
+
+// switchGateway switches the gateway of the interface and then runs the
+// configured hook command for the new mode
+func switchGateway(ifaceName, newGateway, hookName, hook string, hookTimeout, switchTimeout time.Duration) error {
+	// Get the active interface, or the one explicitly requested
+	iface, err := gateway.GetInterface(ifaceName)
+	if err != nil {
+		return fmt.Errorf("failed to get active interface: %w", err)
+	}
+
+	// 网关必须与接口处于同一子网，否则路由无法生效
+	if err := iface.CheckGateway(newGateway); err != nil {
+		return err
+	}
+
+	// Check if already using the target gateway
+	if iface.Gateway == newGateway {
+		fmt.Printf("Already using gateway: %s\n", newGateway)
+		return nil
+	}
+
+	// Switch to the new gateway
+	utils.Infof("Switching gateway from %s to %s...\n", iface.Gateway, newGateway)
+	oldGateway := iface.Gateway
+	startTime := time.Now()
+
+	if err := switchInterfaceGateway(iface, newGateway, switchTimeout); err != nil {
+		notify.Send("GateShift", fmt.Sprintf("Failed to switch gateway to %s", newGateway))
+		return fmt.Errorf("failed to switch gateway: %w", err)
+	}
+
+	// 预演模式下网关并未切换，不检查连通性也不发送通知
+	if utils.DryRun() {
+		return utils.RunHook(hookName, hook, nil, hookTimeout)
+	}
+
+	elapsed := time.Since(startTime)
+	utils.Infof("Gateway switched successfully (took %v)\n", elapsed.Round(time.Millisecond))
+
+	// Verify internet connectivity
+	if gateway.CheckInternetConnectivity() {
+		utils.Infof("Internet connectivity confirmed\n")
+		notify.Send("GateShift", fmt.Sprintf("Switched gateway to %s", newGateway))
+	} else {
+		fmt.Println("Warning: No internet connectivity detected")
+		notify.Send("GateShift", fmt.Sprintf("Switched gateway to %s, but no internet connectivity", newGateway))
+	}
+
+	runSwitchHook(hookName, hook, hookTimeout, iface.Name, newGateway, oldGateway)
+	return nil
+}
+
+// switchInterfaceGateway 切换接口的网关，超过 timeout 时终止切换命令
+func switchInterfaceGateway(iface *gateway.NetworkInterface, newGateway string, timeout time.Duration) error {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	err := gateway.SwitchGateway(ctx, iface, newGateway)
+	if errors.Is(err, context.DeadlineExceeded) {
+		return fmt.Errorf("timed out after %v (use --timeout to allow longer): %w", timeout, err)
+	}
+	return err
+}
+
+// runSwitchHook 运行切换后的钩子命令，失败只警告而不回滚切换
+func runSwitchHook(hookName, hook string, hookTimeout time.Duration, ifaceName, newGateway, oldGateway string) {
+	if hook == "" {
+		return
+	}
+
+	utils.Infof("Running %s hook...\n", hookName)
+	env := []string{
+		"GATESHIFT_GATEWAY=" + newGateway,
+		"GATESHIFT_PREVIOUS_GATEWAY=" + oldGateway,
+		"GATESHIFT_INTERFACE=" + ifaceName,
+		"GATESHIFT_HOOK=" + hookName,
+	}
+	if err := utils.RunHook(hookName, hook, env, hookTimeout); err != nil {
+		fmt.Printf("Warning: %v\n", err)
+	}
+}
+
+// proxyReachTimeout bounds the reachability probe of each proxy gateway
+const proxyReachTimeout = 2 * time.Second
+
+// switchProxyGateway 依次尝试配置的代理网关，选择第一个可达且切换后能连通
+// 互联网的网关并返回它；全部失败时恢复原网关。只有一个代理网关时与 switchGateway 相同。
+func switchProxyGateway(ifaceName string, gateways []string, hook string, hookTimeout, switchTimeout time.Duration) (string, error) {
+	if len(gateways) == 1 {
+		return gateways[0], switchGateway(ifaceName, gateways[0], "on_proxy", hook, hookTimeout, switchTimeout)
+	}
+
+	iface, err := gateway.GetInterface(ifaceName)
+	if err != nil {
+		return "", fmt.Errorf("failed to get active interface: %w", err)
+	}
+	oldGateway := iface.Gateway
+
+	var skipped []string
+	for _, gw := range gateways {
+		if err := iface.CheckGateway(gw); err != nil {
+			skipped = append(skipped, fmt.Sprintf("%s: not on the subnet of %s", gw, iface.Name))
+			continue
+		}
+		if !gateway.IsReachable(gw, gateway.DefaultDiscoverPorts, proxyReachTimeout) {
+			skipped = append(skipped, fmt.Sprintf("%s: unreachable", gw))
+			continue
+		}
+
+		// 预演模式下无法验证切换后的连通性，选择第一个可达的网关
+		if utils.DryRun() {
+			printSkippedGateways(skipped)
+			fmt.Printf("[dry-run] would select proxy gateway %s\n", gw)
+			return gw, switchGateway(ifaceName, gw, "on_proxy", hook, hookTimeout, switchTimeout)
+		}
+
+		if iface.Gateway != gw {
+			utils.Infof("Switching gateway from %s to %s...\n", iface.Gateway, gw)
+			if err := switchInterfaceGateway(iface, gw, switchTimeout); err != nil {
+				skipped = append(skipped, fmt.Sprintf("%s: %v", gw, err))
+				continue
+			}
+			iface.Gateway = gw
+		}
+
+		if !gateway.CheckInternetConnectivity() {
+			skipped = append(skipped, fmt.Sprintf("%s: no internet connectivity", gw))
+			continue
+		}
+
+		printSkippedGateways(skipped)
+		fmt.Printf("Selected proxy gateway %s\n", gw)
+		utils.Infof("Internet connectivity confirmed\n")
+		notify.Send("GateShift", fmt.Sprintf("Switched gateway to %s", gw))
+		runSwitchHook("on_proxy", hook, hookTimeout, iface.Name, gw, oldGateway)
+		return gw, nil
+	}
+
+	printSkippedGateways(skipped)
+	if iface.Gateway != oldGateway {
+		fmt.Printf("Restoring gateway %s...\n", oldGateway)
+		if err := switchInterfaceGateway(iface, oldGateway, switchTimeout); err != nil {
+			fmt.Printf("Warning: failed to restore gateway %s: %v\n", oldGateway, err)
+		}
+	}
+	notify.Send("GateShift", "No proxy gateway is available")
+	return "", fmt.Errorf("no proxy gateway passed the reachability and connectivity checks")
+}
+
+// printSkippedGateways 输出被跳过的代理网关及原因
+func printSkippedGateways(skipped []string) {
+	for _, reason := range skipped {
+		fmt.Printf("Skipped proxy gateway %s\n", reason)
+	}
+}
+
+// dnsCmd represents the dns command
+var dnsCmd = &cobra.Command{
+	Use:   "dns",
+	Short: "Manage DNS proxy service",
+	Long:  `This command is used to manage the DNS proxy service, which can intercept DNS requests and forward them to upstream DNS servers.`,
+	Run: func(cmd *cobra.Command, args []string) {
+		cmd.Help()
+	},
+}
+
+func init() {
+	// show command
+	var showCmd = &cobra.Command{
+		Use:   "show",
+		Short: "Show DNS proxy configuration",
+		Long:  `Show the current configuration of the DNS proxy service.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Display DNS configuration
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "Listen Address:\t%s\n", cfg.DNS.ListenAddr)
+			fmt.Fprintf(w, "Listen Port:\t%d\n", cfg.DNS.ListenPort)
+			strategy := cfg.DNS.Strategy
+			if cfg.DNS.ControlAddr != "" && isServiceRunning() {
+				// 运行中的服务尚未重载时，同时显示其实际使用的策略
+				client := dns.NewControlClient(cfg.DNS.ControlAddr, controlTokenFile())
+				if status, err := client.Status(); err == nil && status.Strategy != cfg.DNS.Strategy {
+					strategy = fmt.Sprintf("%s (running: %s)", cfg.DNS.Strategy, status.Strategy)
+				}
+			}
+			fmt.Fprintf(w, "Upstream Strategy:\t%s\n", strategy)
+			fmt.Fprintf(w, "Log Format:\t%s\n", cfg.DNS.LogFormat)
+			fmt.Fprintf(w, "AAAA Filter:\t%s\n", enabledText(cfg.DNS.FilterAAAA))
+			fmt.Fprintf(w, "QNAME Minimization:\t%s\n", enabledText(cfg.DNS.QNAMEMinimization))
+			fmt.Fprintf(w, "Recursion:\t%s\n", cfg.DNS.Recursion)
+			fmt.Fprintf(w, "Hosts File:\t%s\n", valueOrDash(cfg.DNS.HostsFile))
+			if runtime.GOOS == "darwin" {
+				fmt.Fprintf(w, "All Network Services:\t%s\n", enabledText(cfg.DNS.AllNetworkServices))
+			}
+			if cfg.DNS.MetricsAddr != "" {
+				fmt.Fprintf(w, "Metrics Address:\t%s\n", cfg.DNS.MetricsAddr)
+			}
+			fmt.Fprintf(w, "Upstream DNS Servers:\t%s\n", strings.Join(cfg.DNS.UpstreamDNS, ", "))
+			fmt.Fprintf(w, "Fallback DNS Server:\t%s\n", valueOrDash(cfg.DNS.FallbackDNS))
+			fmt.Fprintf(w, "Blocked Domains:\t%d (%s)\n", len(cfg.DNS.Blocklist), cfg.DNS.BlocklistResponse)
+			fmt.Fprintf(w, "Idle Timeout:\t%s\n", durationOrOff(cfg.DNS.IdleTimeout))
+			fmt.Fprintf(w, "Upstream Retries:\t%s\n", retriesText(cfg.DNS.UpstreamRetries, cfg.DNS.RetryBackoff))
+			fmt.Fprintf(w, "Cache TTL:\t%s\n", cacheTTLText(cfg.DNS.CacheMinTTL, cfg.DNS.CacheMaxTTL))
+			fmt.Fprintf(w, "Serve Stale:\t%s\n", durationOrOff(cfg.DNS.ServeStaleMaxAge))
+			fmt.Fprintf(w, "Max In-Flight Queries:\t%d\n", cfg.DNS.MaxInflight)
+
+			if servers, _ := dns.PassthroughDNS(); len(servers) > 0 {
+				fmt.Fprintf(w, "System DNS:\t%s (set-system)\n", strings.Join(servers, ", "))
+			}
+
+			// Check if DNS proxy is running
+			running := isServiceRunning()
+			fmt.Fprintf(w, "Status:\t%s\n", runningText(running))
+			w.Flush()
+
+			// 服务未运行时，提示占用监听端口的其他进程
+			if !running {
+				if owner, _ := dns.FindPortOwner(cfg.DNS.ListenAddr, cfg.DNS.ListenPort); owner != nil {
+					fmt.Printf("\nWarning: port %d is in use by %s\n", cfg.DNS.ListenPort, owner)
+					fmt.Println(portOwnerHint(owner))
+				}
+			}
+		},
+	}
+	dnsCmd.AddCommand(showCmd)
+
+	// leak-test command
+	var leakJSON bool
+	var leakTestCmd = &cobra.Command{
+		Use:   "leak-test",
+		Short: "Check whether DNS queries go through the DNS proxy",
+		Long: `Check the DNS servers configured on the system against the DNS proxy,
+then resolve a unique host name and ask a leak test service which resolver
+performed the lookup. The test passes when the active interface resolves
+only through the running proxy.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			report := runLeakTest(cfg)
+			if leakJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(report); err != nil {
+					fmt.Println("Error encoding leak test result:", err)
+				}
+				return
+			}
+			printLeakReport(report)
+		},
+	}
+	leakTestCmd.Flags().BoolVar(&leakJSON, "json", false, "Output as JSON")
+	dnsCmd.AddCommand(leakTestCmd)
+
+	// logs command
+	var follow bool
+	var lines int
+	var filterText string
+	var filterRegex string
+	var queriesLog bool
+	var logsCmd = &cobra.Command{
+		Use:   "logs",
+		Short: "View DNS service logs",
+		Long: `View and filter logs from the DNS proxy service. The service log records
+startup, shutdown and errors; use --queries to view the per-query log.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// 获取日志文件路径
+			logDir, err := dnsLogDir()
+			if err != nil {
+				fmt.Println("Error finding home directory:", err)
+				return
+			}
+			logFile := filepath.Join(logDir, "gateshift-dns.log")
+			if queriesLog {
+				logFile = filepath.Join(logDir, "queries.log")
+			}
+
+			// 正则表达式在读取日志前编译，无效时直接报错
+			var match utils.LineMatcher
+			switch {
+			case filterRegex != "":
+				match, err = utils.RegexMatcher(filterRegex)
+				if err != nil {
+					fmt.Println("Error:", err)
+					return
+				}
+			case filterText != "":
+				match = utils.SubstringMatcher(filterText)
+			}
+
+			// 检查日志文件是否存在
+			if _, err := os.Stat(logFile); os.IsNotExist(err) {
+				fmt.Println("Log file not found. Has the DNS service been started?")
+				return
+			}
+			if err := showLogs(logFile, lines, follow, match); err != nil {
+				fmt.Println("Error viewing logs:", err)
+			}
+		},
+	}
+
+	// 添加flags
+	logsCmd.Flags().BoolVarP(&follow, "follow", "f", false, "Follow log output in real-time")
+	logsCmd.Flags().IntVarP(&lines, "lines", "n", 50, "Number of lines to show")
+	logsCmd.Flags().StringVarP(&filterText, "filter", "F", "", "Filter logs containing the specified text (case insensitive)")
+	logsCmd.Flags().StringVar(&filterRegex, "regex", "", "Filter logs matching the specified regular expression, e.g. 'NXDOMAIN|SERVFAIL'")
+	logsCmd.Flags().BoolVar(&queriesLog, "queries", false, "Show the per-query log (queries.log) instead of the service log")
+	logsCmd.MarkFlagsMutuallyExclusive("filter", "regex")
+
+	dnsCmd.AddCommand(logsCmd)
+
+	// start command
+	var startForeground bool
+	var metricsAddr string
+	var strategy string
+	var queryLogMaxSize, queryLogKeep int
+	var allNetworkServices bool
+	var trace bool
+	var takeOver, assumeYes bool
+	var startCmd = &cobra.Command{
+		Use:   "start",
+		Short: "Start the DNS proxy service",
+		Long: `Start the DNS proxy service.
+
+With --take-over, a system stub resolver holding the DNS port (systemd-resolved
+on Linux) is asked to give it up first: after confirmation, its stub listener
+is turned off and it is pointed at the proxy. The change is recorded in the
+configuration directory and undone when the DNS service stops, or by
+'gateshift dns stop' after a crash.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			// Check if DNS proxy is already running. The foreground process may itself
+			// be launched by the service manager, so it only consults the PID file.
+			if startForeground && getRunningPID() > 0 || !startForeground && isServiceRunning() {
+				fmt.Println("DNS service is already running")
+				return
+			}
+			if trace && !startForeground {
+				fmt.Println("Error: --trace can only be used with --foreground")
+				return
+			}
+			if assumeYes && !takeOver {
+				fmt.Println("Error: --yes can only be used with --take-over")
+				return
+			}
+
+			// Load configuration
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+			if cmd.Flags().Changed("metrics-addr") {
+				cfg.DNS.MetricsAddr = metricsAddr
+			}
+			if cmd.Flags().Changed("strategy") {
+				if err := dns.ValidateStrategy(strategy); err != nil {
+					fmt.Println("Error:", err)
+					return
+				}
+				cfg.DNS.Strategy = strategy
+			}
+			if cmd.Flags().Changed("query-log-max-size") {
+				cfg.DNS.QueryLogMaxSize = queryLogMaxSize
+			}
+			if cmd.Flags().Changed("query-log-keep") {
+				cfg.DNS.QueryLogKeep = queryLogKeep
+			}
+			if cmd.Flags().Changed("all-network-services") {
+				cfg.DNS.AllNetworkServices = allNetworkServices
+			}
+			if err := cfg.DNS.Validate(); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			if err := checkNoPassthrough(); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			// 让占用DNS端口的系统解析器让出端口，服务停止时恢复
+			if takeOver {
+				if err := takeOverDNSPort(cfg, assumeYes); err != nil {
+					fmt.Println("Error:", err)
+					return
+				}
+			}
+
+			// 预演模式下不绑定端口，只显示将要修改的系统DNS设置
+			if utils.DryRun() {
+				printDNSDryRun(cfg)
+				return
+			}
+
+			if startForeground {
+				fmt.Println("Starting DNS service in foreground mode. Press Ctrl+C to stop...")
+				startDNSForeground(cfg, trace)
+			} else if service.IsInstalled() {
+				// 服务管理器按已安装的参数启动守护进程，命令行覆盖无法传递给它
+				for _, name := range []string{"metrics-addr", "strategy", "query-log-max-size", "query-log-keep", "all-network-services"} {
+					if cmd.Flags().Changed(name) {
+						fmt.Printf("Error: --%s cannot be used when the DNS service is installed;\n", name)
+						fmt.Printf("set dns.%s in the config file instead, or run with -f\n", strings.ReplaceAll(name, "-", "_"))
+						return
+					}
+				}
+				fmt.Println("Starting DNS service via the system service manager...")
+				if err := service.Start(); err != nil {
+					fmt.Println("Error starting DNS service:", err)
+					restoreResolver()
+					return
+				}
+				fmt.Println("DNS service started successfully")
+			} else {
+				fmt.Println("Starting DNS service in the background...")
+				if err := startDNSBackground(cfg); err != nil {
+					fmt.Println("Error starting DNS service:", err)
+					restoreResolver()
+					return
+				}
+				fmt.Println("DNS service started successfully in the background")
+			}
+		},
+	}
+	startCmd.Flags().BoolVarP(&startForeground, "foreground", "f", false, "Run the DNS proxy in the foreground")
+	startCmd.Flags().BoolVar(&trace, "trace", false, "With --foreground, print one line per query to the terminal and write the service log to its file")
+	startCmd.Flags().StringVar(&metricsAddr, "metrics-addr", "", "Serve Prometheus metrics on this address (e.g. :9153, binds to 127.0.0.1 when no host is given)")
+	startCmd.Flags().StringVar(&strategy, "strategy", "", "Upstream selection strategy: parallel, round-robin or priority (overrides config)")
+	startCmd.Flags().IntVar(&queryLogMaxSize, "query-log-max-size", 10, "Rotate queries.log when it reaches this size in MB (overrides config)")
+	startCmd.Flags().IntVar(&queryLogKeep, "query-log-keep", 5, "Number of rotated query logs to keep (overrides config)")
+	startCmd.Flags().BoolVar(&allNetworkServices, "all-network-services", false, "macOS: point every enabled network service at the proxy, not just the active one (overrides config)")
+	startCmd.Flags().BoolVar(&takeOver, "take-over", false, "Turn off a system stub resolver holding the DNS port until the DNS service stops")
+	startCmd.Flags().BoolVarP(&assumeYes, "yes", "y", false, "With --take-over, skip the confirmation prompt")
+	startCmd.Flags().StringVar(&DNSPIDFile, "pid-file", DNSPIDFile, "Path of the DNS service PID file")
+	startCmd.Flags().MarkHidden("pid-file")
+	dnsCmd.AddCommand(startCmd)
+
+	// stop command
+	var stopCmd = &cobra.Command{
+		Use:   "stop",
+		Short: "Stop the DNS proxy service",
+		Long:  `Stop the DNS proxy service.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Println("Stopping DNS service...")
+			if err := stopDNS(); err != nil {
+				fmt.Println("Error stopping DNS service:", err)
+				return
+			}
+		},
+	}
+	dnsCmd.AddCommand(stopCmd)
+
+	// reload command
+	var reloadCmd = &cobra.Command{
+		Use:   "reload",
+		Short: "Reload the DNS service configuration without restarting",
+		Long: `Make the running DNS service re-read its configuration file and apply
+the upstream servers, upstream strategy and AAAA filter in place, keeping
+the listener and cache. On Unix the service is sent SIGHUP; on Windows the
+reload goes through the control API.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := reloadDNS(); err != nil {
+				fmt.Println("Error:", err)
+			}
+		},
+	}
+	dnsCmd.AddCommand(reloadCmd)
+
+	// restart command
+	var restartCmd = &cobra.Command{
+		Use:   "restart",
+		Short: "Restart the DNS proxy service",
+		Long:  `Restart the DNS proxy service.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			fmt.Println("Restarting DNS service...")
+			// Stop the DNS service first
+			if err := stopDNS(); err != nil {
+				fmt.Println("Error stopping DNS service:", err)
+				return
+			}
+
+			// Load configuration
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			// Start the DNS service
+			fmt.Println("Starting DNS service...")
+			if service.IsInstalled() {
+				err = service.Start()
+			} else {
+				err = startDNSBackground(cfg)
+			}
+			if err != nil {
+				fmt.Println("Error starting DNS service:", err)
+				return
+			}
+			fmt.Println("DNS service restarted successfully")
+		},
+	}
+	dnsCmd.AddCommand(restartCmd)
+
+	// status command
+	var statusCmd = &cobra.Command{
+		Use:   "status",
+		Short: "Show the DNS proxy service status",
+		Long:  `Show whether the DNS proxy service is installed with the system service manager and whether it is running.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if service.IsInstalled() {
+				fmt.Println("Service: Installed")
+			} else {
+				fmt.Println("Service: Not installed")
+			}
+
+			if isServiceRunning() {
+				fmt.Println("Status: Running")
+			} else {
+				fmt.Println("Status: Stopped")
+			}
+		},
+	}
+	dnsCmd.AddCommand(statusCmd)
+
+	// set-system command
+	var setSystemAllServices bool
+	var setSystemCmd = &cobra.Command{
+		Use:   "set-system [server...]",
+		Short: "Point the system DNS at servers without running the DNS proxy",
+		Long: `Point the system DNS directly at resolvers, such as the router or a public
+resolver, without running the DNS proxy. Several servers are used in the
+order given (the Linux resolver queries at most the first 3). The original
+settings are backed up as when the DNS service starts; 'gateshift dns
+restore-system' brings them back. Servers must be IP addresses: system
+resolvers always use port 53.
+
+This cannot be combined with the DNS service, which points the system DNS at
+the proxy while it runs.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			for _, server := range args {
+				if err := dns.ValidatePassthroughServer(server); err != nil {
+					fmt.Println("Error:", err)
+					return
+				}
+			}
+			if isServiceRunning() {
+				fmt.Println("Error: the DNS service points the system DNS at the proxy while it runs; stop it with 'gateshift dns stop' first")
+				return
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+			if !cmd.Flags().Changed("all-network-services") {
+				setSystemAllServices = cfg.DNS.AllNetworkServices
+			}
+
+			if err := dns.SetPassthroughDNS(args, setSystemAllServices); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			if utils.DryRun() {
+				return
+			}
+			fmt.Printf("System DNS set to %s\n", strings.Join(args, ", "))
+			fmt.Println("Run 'gateshift dns restore-system' to restore the original settings")
+		},
+	}
+	setSystemCmd.Flags().BoolVar(&setSystemAllServices, "all-network-services", false, "macOS: set every enabled network service, not just the active one (default from config)")
+	dnsCmd.AddCommand(setSystemCmd)
+
+	// restore-system command
+	var restoreSystemCmd = &cobra.Command{
+		Use:   "restore-system",
+		Short: "Restore the system DNS settings changed by set-system",
+		Long:  `Restore the system DNS settings backed up by 'gateshift dns set-system'.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if isServiceRunning() {
+				fmt.Println("Error: the DNS service is running; 'gateshift dns stop' restores the system DNS")
+				return
+			}
+			if err := dns.RestorePassthroughDNS(); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			if utils.DryRun() {
+				return
+			}
+			fmt.Println("System DNS settings restored.")
+		},
+	}
+	dnsCmd.AddCommand(restoreSystemCmd)
+
+	// get-system command
+	var getSystemJSON bool
+	var getSystemCmd = &cobra.Command{
+		Use:   "get-system",
+		Short: "Show the DNS servers currently configured on the system",
+		Long: `Show the DNS servers the system currently resolves through, for each
+network service on macOS, each interface on Windows and /etc/resolv.conf on
+Linux. Interfaces that take their servers from DHCP are shown as such; on
+macOS the DHCP servers themselves are not listed. When /etc/resolv.conf only
+points at the systemd-resolved stub, the servers systemd-resolved forwards
+to are listed as well.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			report, err := systemDNSReportNow()
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			if getSystemJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				if err := encoder.Encode(report); err != nil {
+					fmt.Println("Error encoding system DNS servers:", err)
+				}
+				return
+			}
+			printSystemDNSReport(report)
+		},
+	}
+	getSystemCmd.Flags().BoolVar(&getSystemJSON, "json", false, "Output as JSON")
+	dnsCmd.AddCommand(getSystemCmd)
+
+	// install-service command
+	var installServiceCmd = &cobra.Command{
+		Use:   "install-service",
+		Short: "Install the DNS proxy as a system service",
+		Long: `Register the DNS proxy with the system service manager (launchd on macOS, systemd on Linux),
+so that it starts at boot and is restarted if it crashes.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if service.IsInstalled() {
+				fmt.Println("DNS service is already installed")
+				return
+			}
+
+			opts, err := dnsServiceOptions()
+			if err != nil {
+				fmt.Println("Error preparing DNS service:", err)
+				return
+			}
+
+			if err := service.Install(opts); err != nil {
+				fmt.Println("Error installing DNS service:", err)
+				return
+			}
+
+			fmt.Println("DNS service installed successfully")
+			fmt.Println("Start it with: gateshift dns start")
+		},
+	}
+	dnsCmd.AddCommand(installServiceCmd)
+
+	// uninstall-service command
+	var uninstallServiceCmd = &cobra.Command{
+		Use:   "uninstall-service",
+		Short: "Remove the DNS proxy system service",
+		Long:  `Stop the DNS proxy and remove it from the system service manager.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !service.IsInstalled() {
+				fmt.Println("DNS service is not installed")
+				return
+			}
+
+			pid := getRunningPID()
+			if err := service.Uninstall(); err != nil {
+				fmt.Println("Error uninstalling DNS service:", err)
+				return
+			}
+
+			// 守护进程正常退出时已自行恢复系统DNS
+			if pid <= 0 || !waitForCleanExit(pid, dnsStopTimeout) {
+				if err := restoreSystemDNS(); err != nil {
+					fmt.Printf("Warning: Failed to restore system DNS: %v\n", err)
+				}
+			}
+			removePIDFile(DNSPIDFile)
+
+			fmt.Println("DNS service uninstalled successfully")
+		},
+	}
+	dnsCmd.AddCommand(uninstallServiceCmd)
+
+	// add-server command
+	var addServerCmd = &cobra.Command{
+		Use:   "add-server [server]",
+		Short: "Add an upstream DNS server",
+		Long:  `Add an upstream DNS server to the DNS proxy configuration.`,
+		Args:  cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			// Ensure the server has a port (default to 53 if not specified)
+			servers, err := dns.NormalizeUpstreams(args)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			server := servers[0]
+
+			// Load configuration
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			// Add the server unless it already exists
+			upstreams, added := appendUpstreams(cfg.DNS.UpstreamDNS, servers)
+			if len(added) == 0 {
+				fmt.Printf("Upstream DNS server %s already exists\n", server)
+				return
+			}
+			cfg.DNS.UpstreamDNS = upstreams
+
+			// Save configuration
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			fmt.Printf("Upstream DNS server %s added successfully\n", server)
+			applyUpstreams(cfg)
+		},
+	}
+	dnsCmd.AddCommand(addServerCmd)
+
+	// remove-server command
+	var removeServerCmd = &cobra.Command{
+		Use:     "remove-server [server]",
+		Aliases: []string{"remove-upstream"},
+		Short:   "Remove an upstream DNS server",
+		Long:    `Remove an upstream DNS server from the DNS proxy configuration.`,
+		Args:    cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			// Ensure the server has a port (default to 53 if not specified)
+			server, err := dns.NormalizeUpstream(args[0])
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			// Load configuration
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			// Find and remove the server
+			upstreams, found := removeUpstream(cfg.DNS.UpstreamDNS, server)
+			if !found {
+				fmt.Printf("Upstream DNS server %s not found\n", server)
+				return
+			}
+			cfg.DNS.UpstreamDNS = upstreams
+
+			// Save configuration
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			fmt.Printf("Upstream DNS server %s removed successfully\n", server)
+			applyUpstreams(cfg)
+		},
+	}
+	dnsCmd.AddCommand(removeServerCmd)
+
+	// set-upstreams command
+	var appendUpstream bool
+	var setUpstreamsCmd = &cobra.Command{
+		Use:     "set-upstreams [server...]",
+		Aliases: []string{"set-upstream"},
+		Short:   "Replace all upstream DNS servers",
+		Long: `Replace the upstream DNS servers in the configuration, or add them to the
+existing ones with --append. Servers already configured are not added twice.
+If the DNS service is running, the new servers are applied immediately
+without a restart.`,
+		Args: cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			// Ensure each server has a port (default to 53 if not specified)
+			servers, err := dns.NormalizeUpstreams(args)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			// Load configuration
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			if appendUpstream {
+				upstreams, added := appendUpstreams(cfg.DNS.UpstreamDNS, servers)
+				if len(added) == 0 {
+					fmt.Printf("Upstream DNS servers unchanged, already configured: %v\n", servers)
+					return
+				}
+				servers = upstreams
+			}
+			cfg.DNS.UpstreamDNS = servers
+
+			// Save configuration
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			fmt.Printf("Upstream DNS servers set to: %v\n", servers)
+			applyUpstreams(cfg)
+		},
+	}
+	setUpstreamsCmd.Flags().BoolVar(&appendUpstream, "append", false, "Add the servers to the existing upstreams instead of replacing them")
+	dnsCmd.AddCommand(setUpstreamsCmd)
+
+	// set-fallback command
+	var setFallbackCmd = &cobra.Command{
+		Use:   "set-fallback [server|off]",
+		Short: "Set the resolver used when all upstream DNS servers fail",
+		Long: `Set the DNS server queried as a last resort when every upstream DNS server
+fails. Its answers are cached for at most 30 seconds, so normal resolution
+resumes as soon as the upstreams recover. Use "off" to disable the fallback.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			server := ""
+			if args[0] != "off" {
+				var err error
+				if server, err = dns.NormalizeUpstream(args[0]); err != nil {
+					fmt.Println("Error:", err)
+					return
+				}
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			cfg.DNS.FallbackDNS = server
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			if server == "" {
+				fmt.Println("Fallback DNS server disabled")
+			} else {
+				fmt.Printf("Fallback DNS server set to: %s\n", server)
+			}
+			applyDNSConfig(cfg)
+		},
+	}
+	dnsCmd.AddCommand(setFallbackCmd)
+
+	// set-aaaa-filter command
+	var setAAAAFilterCmd = &cobra.Command{
+		Use:   "set-aaaa-filter [on|off]",
+		Short: "Answer AAAA queries with no records",
+		Long: `When on, the DNS proxy answers AAAA (IPv6 address) queries with an empty
+response so that clients on networks with broken IPv6 fall back to IPv4
+immediately. A queries are served normally.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			switch args[0] {
+			case "on":
+				cfg.DNS.FilterAAAA = true
+			case "off":
+				cfg.DNS.FilterAAAA = false
+			default:
+				fmt.Printf("Error: invalid value %s (must be on or off)\n", args[0])
+				return
+			}
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			fmt.Printf("AAAA filtering turned %s\n", args[0])
+			applyDNSConfig(cfg)
+		},
+	}
+	dnsCmd.AddCommand(setAAAAFilterCmd)
+
+	// set-qname-minimization command
+	var setQNAMEMinimizationCmd = &cobra.Command{
+		Use:   "set-qname-minimization [on|off]",
+		Short: "Look up the parent of names before forwarding them",
+		Long: `When on, the DNS proxy first asks the upstreams for the NS records of the
+name one label up (b.example.com for a.b.example.com). If that name does not
+exist, the query is answered NXDOMAIN and the full name is never sent;
+otherwise the query is forwarded as usual, as it is whenever the lookup fails
+or its answer is unclear. This is a conservative form of QNAME minimization
+(RFC 9156).
+
+It costs an extra query for every name not in the cache, and only hides
+names under parents that do not exist: a recursive upstream still sees every
+other name, so it gains little when the upstream is a trusted resolver.
+DNSSEC (DO or CD) queries are always forwarded in full. Off by default.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			switch args[0] {
+			case "on":
+				cfg.DNS.QNAMEMinimization = true
+			case "off":
+				cfg.DNS.QNAMEMinimization = false
+			default:
+				fmt.Printf("Error: invalid value %s (must be on or off)\n", args[0])
+				return
+			}
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			fmt.Printf("QNAME minimization turned %s\n", args[0])
+			applyDNSConfig(cfg)
+		},
+	}
+	dnsCmd.AddCommand(setQNAMEMinimizationCmd)
+
+	// set-address command
+	var setAddressCmd = &cobra.Command{
+		Use:   "set-address [ip]",
+		Short: "Set the address the DNS proxy listens on",
+		Long: `Set the address the DNS proxy listens on, IPv4 or IPv6, e.g. 127.0.0.1 or ::1.
+0.0.0.0 listens on all IPv4 interfaces and :: on all IPv6 interfaces.
+The listener is not reloaded, so a running DNS service keeps its address
+until it is restarted.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			ip := net.ParseIP(strings.Trim(args[0], "[]"))
+			if ip == nil {
+				fmt.Printf("Error: invalid IP address: %s\n", args[0])
+				return
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			cfg.DNS.ListenAddr = ip.String()
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			fmt.Printf("DNS listen address set to: %s\n", net.JoinHostPort(cfg.DNS.ListenAddr, strconv.Itoa(cfg.DNS.ListenPort)))
+			if isServiceRunning() {
+				fmt.Println("Restart the DNS service to listen on the new address: gateshift dns restart")
+			}
+		},
+	}
+	dnsCmd.AddCommand(setAddressCmd)
+
+	// set-strategy command
+	var setStrategyCmd = &cobra.Command{
+		Use:   "set-strategy [parallel|round-robin|priority]",
+		Short: "Set how upstream DNS servers are selected",
+		Long: `Set how the DNS proxy selects the upstream DNS servers a query is sent to:
+
+  parallel     query every upstream at once and use the first answer (default);
+               fastest and most resilient, but every upstream sees every query
+  round-robin  start with a different upstream for each query, trying the next
+               when one fails; spreads queries, each upstream sees only some
+  priority     try the upstreams in the configured order; the first one gets
+               nearly all queries, the others only serve when it fails
+
+Sequential strategies wait up to 2 seconds for an upstream before trying the
+next one. A running service picks up the new strategy through the control API
+if it is enabled, otherwise on its next start.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := dns.ValidateStrategy(args[0]); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			cfg.DNS.Strategy = args[0]
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			fmt.Printf("Upstream strategy set to: %s\n", args[0])
+			applyDNSConfig(cfg)
+		},
+	}
+	dnsCmd.AddCommand(setStrategyCmd)
+
+	// set-recursion command
+	var setRecursionCmd = &cobra.Command{
+		Use:   "set-recursion [forward|force|strip|require]",
+		Short: "Set how the recursion desired bit of queries is handled",
+		Long: `Set how the DNS proxy handles the recursion desired (RD) bit of client queries:
+
+  forward  forward queries with the RD bit the client sent (default)
+  force    set the RD bit on forwarded queries
+  strip    clear the RD bit on forwarded queries
+  require  refuse queries without the RD bit, serving only recursive clients
+
+Some upstreams misbehave unless the bit is set or cleared. Clients always
+get a response with the RD bit they sent.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := dns.ValidateRecursionMode(args[0]); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			cfg.DNS.Recursion = args[0]
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			fmt.Printf("Recursion mode set to: %s\n", args[0])
+			applyDNSConfig(cfg)
+		},
+	}
+	dnsCmd.AddCommand(setRecursionCmd)
+
+	// set-hosts-file command
+	var setHostsFileCmd = &cobra.Command{
+		Use:   "set-hosts-file [path|off]",
+		Short: "Answer address queries from a hosts-format file",
+		Long: `Answer A and AAAA queries for the names listed in a hosts-format file
+("IP hostname [aliases...]" lines, # starts a comment) from the file instead
+of forwarding them. A name listed on several lines gets all its addresses.
+The running DNS service picks up edits to the file within a few seconds;
+malformed lines are logged and skipped. "off" stops using the file.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			path := ""
+			if args[0] != "off" {
+				abs, err := filepath.Abs(args[0])
+				if err != nil {
+					fmt.Println("Error:", err)
+					return
+				}
+				hosts, err := dns.ReadHostsFile(abs)
+				if err != nil {
+					fmt.Println("Error:", err)
+					return
+				}
+				path = abs
+				fmt.Printf("Read %d names from %s\n", len(hosts), path)
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			cfg.DNS.HostsFile = path
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			if path == "" {
+				fmt.Println("Hosts file turned off")
+			} else {
+				fmt.Printf("Hosts file set to: %s\n", path)
+			}
+			applyDNSConfig(cfg)
+		},
+	}
+	dnsCmd.AddCommand(setHostsFileCmd)
+
+	// set-idle-timeout command
+	var setIdleTimeoutCmd = &cobra.Command{
+		Use:   "set-idle-timeout [duration|off]",
+		Short: "Stop the DNS service after a period without queries",
+		Long: `Stop the DNS service and restore the system DNS settings once it has
+received no queries for the given duration, e.g. 30m, so a forgotten proxy
+does not keep the system pointed at it. The check runs every 30 seconds.
+A service installed with "gateshift dns install-service" is not restarted
+after an idle stop. Use "off" to keep the service running (the default).`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var timeout time.Duration
+			if args[0] != "off" {
+				var err error
+				if timeout, err = time.ParseDuration(args[0]); err != nil || timeout <= 0 {
+					fmt.Printf("Error: invalid duration %s (e.g. 30m, or off)\n", args[0])
+					return
+				}
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			cfg.DNS.IdleTimeout = timeout
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			if timeout == 0 {
+				fmt.Println("Idle timeout disabled")
+			} else {
+				fmt.Printf("Idle timeout set to: %v\n", timeout)
+			}
+			applyDNSConfig(cfg)
+		},
+	}
+	dnsCmd.AddCommand(setIdleTimeoutCmd)
+
+	// set-retries command
+	var retryBackoff time.Duration
+	var setRetriesCmd = &cobra.Command{
+		Use:   "set-retries [count]",
+		Short: "Set how many times a timed out upstream query is retried",
+		Long: `Set how many times a query to an upstream DNS server that does not answer
+in time is sent again (0 to 5, default 1), so a single lost packet does not
+fail the query. Error responses are not retried. The attempts share the time
+a query may take, and each retry waits --backoff, doubled after every retry.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			retries, err := strconv.Atoi(args[0])
+			if err != nil {
+				fmt.Printf("Error: invalid number of retries %s\n", args[0])
+				return
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			backoff := cfg.DNS.RetryBackoff
+			if cmd.Flags().Changed("backoff") {
+				backoff = retryBackoff
+			}
+			if err := dns.ValidateRetries(retries, backoff); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			cfg.DNS.UpstreamRetries = retries
+			cfg.DNS.RetryBackoff = backoff
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			fmt.Printf("Upstream retries set to: %s\n", retriesText(retries, backoff))
+			applyDNSConfig(cfg)
+		},
+	}
+	setRetriesCmd.Flags().DurationVar(&retryBackoff, "backoff", dns.DefaultRetryBackoff, "Wait before the first retry, doubled after every retry")
+	dnsCmd.AddCommand(setRetriesCmd)
+
+	// set-cache-ttl command
+	var cacheMinTTL, cacheMaxTTL time.Duration
+	var setCacheTTLCmd = &cobra.Command{
+		Use:   "set-cache-ttl",
+		Short: "Set the minimum and maximum time responses are cached",
+		Long: `Clamp how long the DNS proxy caches responses, whatever TTL the upstream
+returned. --min keeps records with tiny or zero TTLs cached for a while;
+--max keeps records with very long TTLs from going stale, and lowers the
+TTLs served from the cache to it. 0 removes a bound; a flag not given keeps
+the current value. For example: gateshift dns set-cache-ttl --min 10s --max 1h`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			if !cmd.Flags().Changed("min") && !cmd.Flags().Changed("max") {
+				fmt.Println("Error: specify --min, --max or both")
+				return
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			min, max := cfg.DNS.CacheMinTTL, cfg.DNS.CacheMaxTTL
+			if cmd.Flags().Changed("min") {
+				min = cacheMinTTL
+			}
+			if cmd.Flags().Changed("max") {
+				max = cacheMaxTTL
+			}
+			if err := dns.ValidateCacheTTL(min, max); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			cfg.DNS.CacheMinTTL, cfg.DNS.CacheMaxTTL = min, max
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			fmt.Printf("Cache TTL set to: %s\n", cacheTTLText(min, max))
+			applyDNSConfig(cfg)
+		},
+	}
+	setCacheTTLCmd.Flags().DurationVar(&cacheMinTTL, "min", 0, "Cache responses for at least this long (0 for no minimum)")
+	setCacheTTLCmd.Flags().DurationVar(&cacheMaxTTL, "max", 0, "Cache responses for at most this long (0 for no maximum)")
+	dnsCmd.AddCommand(setCacheTTLCmd)
+
+	// set-serve-stale command
+	var setServeStaleCmd = &cobra.Command{
+		Use:   "set-serve-stale [duration|off]",
+		Short: "Serve expired cache entries when the upstreams are unavailable",
+		Long: `When every upstream DNS server and the fallback resolver fail, answer from a
+cache entry that expired up to the given duration ago, e.g. 1h, with a
+30 second TTL instead of failing the query, and refresh it in the
+background. Use "off" to fail such queries (the default).`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			var maxAge time.Duration
+			if args[0] != "off" {
+				var err error
+				if maxAge, err = time.ParseDuration(args[0]); err != nil || maxAge <= 0 {
+					fmt.Printf("Error: invalid duration %s (e.g. 1h, or off)\n", args[0])
+					return
+				}
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			cfg.DNS.ServeStaleMaxAge = maxAge
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			if maxAge == 0 {
+				fmt.Println("Serving stale answers disabled")
+			} else {
+				fmt.Printf("Serving stale answers up to %v after expiry\n", maxAge)
+			}
+			applyDNSConfig(cfg)
+		},
+	}
+	dnsCmd.AddCommand(setServeStaleCmd)
+
+	// list-servers command
 	var listServersCmd = &cobra.Command{
 		Use:   "list-servers",
 		Short: "List all upstream DNS servers",
 		Long:  `List all upstream DNS servers configured for the DNS proxy service.`,
 		Run: func(cmd *cobra.Command, args []string) {
-			// Load configuration
+			// Load configuration
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			if len(cfg.DNS.UpstreamDNS) == 0 {
+				fmt.Println("No upstream DNS servers configured")
+				return
+			}
+
+			fmt.Println("Upstream DNS servers:")
+			for _, server := range cfg.DNS.UpstreamDNS {
+				fmt.Printf("- %s\n", server)
+			}
+		},
+	}
+	dnsCmd.AddCommand(listServersCmd)
+
+	dnsCmd.AddCommand(dnsCacheCmd())
+	dnsCmd.AddCommand(dnsFlushCmd())
+	dnsCmd.AddCommand(dnsBlocklistCmd())
+	dnsCmd.AddCommand(dnsTestCmd())
+
+	// upstreams command
+	var upstreamsJSON bool
+	var upstreamsCmd = &cobra.Command{
+		Use:   "upstreams",
+		Short: "Show the health and query statistics of upstream DNS servers",
+		Long: `Show the health check status of each upstream DNS server used by the running
+DNS service, along with how many queries it was sent, how many it answered
+or failed, how often its answer was the one returned to the client, and its
+average response time.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			client, err := dnsControlClient()
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			health, err := client.UpstreamHealth()
+			if err != nil {
+				fmt.Println("Error reading upstream health:", err)
+				return
+			}
+			// 旧版本的守护进程不提供统计信息，此时只显示健康状态
+			stats, err := client.UpstreamStats()
+			if err != nil {
+				utils.Debugf("Could not read upstream statistics: %v", err)
+			}
+			upstreams := mergeUpstreamStatus(health, stats)
+
+			if upstreamsJSON {
+				data, err := json.MarshalIndent(upstreams, "", "  ")
+				if err != nil {
+					fmt.Println("Error encoding upstream health:", err)
+					return
+				}
+				fmt.Println(string(data))
+				return
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "UPSTREAM\tSTATUS\tQUERIES\tRESPONSES\tERRORS\tANSWERED\tAVG LATENCY\tLAST CHECK\tERROR")
+			for _, u := range upstreams {
+				status := "up"
+				if !u.Healthy {
+					status = "down"
+				}
+				lastCheck := "-"
+				if !u.LastCheck.IsZero() {
+					lastCheck = u.LastCheck.Format("15:04:05")
+				}
+				queries, responses, errs, answered, latency := "-", "-", "-", "-", "-"
+				if u.Stats != nil {
+					queries = strconv.FormatUint(u.Stats.Queries, 10)
+					responses = strconv.FormatUint(u.Stats.Responses, 10)
+					errs = strconv.FormatUint(u.Stats.Errors, 10)
+					answered = strconv.FormatUint(u.Stats.Answered, 10)
+					if u.Stats.Responses > 0 {
+						latency = fmt.Sprintf("%.1fms", u.Stats.AvgLatencyMs)
+					}
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\t%s\n", u.Upstream, status, queries, responses, errs, answered,
+					latency, lastCheck, valueOrDash(u.LastError))
+			}
+			w.Flush()
+		},
+	}
+	upstreamsCmd.Flags().BoolVar(&upstreamsJSON, "json", false, "Output upstream health as JSON")
+	dnsCmd.AddCommand(upstreamsCmd)
+
+	// bench command
+	var benchQPS int
+	var benchDuration time.Duration
+	var benchServer, benchNamesFile string
+	var benchJSON bool
+	var benchCmd = &cobra.Command{
+		Use:   "bench",
+		Short: "Load-test a DNS resolver",
+		Long: `Send DNS queries at a fixed rate to a resolver (the local DNS proxy by default)
+and report throughput, latency percentiles, error rate and the share of responses
+fast enough to have come from a cache.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			opts := dns.BenchOptions{
+				Server:   benchServer,
+				QPS:      benchQPS,
+				Duration: benchDuration,
+			}
+			if opts.Server == "" {
+				cfg, err := config.LoadConfig()
+				if err != nil {
+					fmt.Println("Error loading config:", err)
+					return
+				}
+				opts.Server = net.JoinHostPort(cfg.DNS.ListenAddr, strconv.Itoa(cfg.DNS.ListenPort))
+			}
+			if benchNamesFile != "" {
+				names, err := dns.LoadBenchNames(benchNamesFile)
+				if err != nil {
+					fmt.Println("Error reading names file:", err)
+					return
+				}
+				opts.Names = names
+			}
+
+			if !benchJSON {
+				fmt.Printf("Benchmarking %s at %d qps for %v...\n", opts.Server, opts.QPS, opts.Duration)
+			}
+			result, err := dns.RunBenchmark(opts)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			if benchJSON {
+				data, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					fmt.Println("Error encoding results:", err)
+					return
+				}
+				fmt.Println(string(data))
+				return
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintf(w, "Server:\t%s\n", result.Server)
+			fmt.Fprintf(w, "Duration:\t%.1fs\n", result.Duration)
+			fmt.Fprintf(w, "Queries sent:\t%d\n", result.Sent)
+			fmt.Fprintf(w, "Succeeded:\t%d\n", result.Succeeded)
+			fmt.Fprintf(w, "Errors:\t%d (%.2f%%)\n", result.Errors, result.ErrorRate*100)
+			fmt.Fprintf(w, "Throughput:\t%.1f qps\n", result.Throughput)
+			fmt.Fprintf(w, "Latency p50:\t%.2fms\n", result.P50Ms)
+			fmt.Fprintf(w, "Latency p95:\t%.2fms\n", result.P95Ms)
+			fmt.Fprintf(w, "Latency p99:\t%.2fms\n", result.P99Ms)
+			fmt.Fprintf(w, "Cache hits (inferred):\t%.1f%%\n", result.CacheHitRatio*100)
+			w.Flush()
+		},
+	}
+	benchCmd.Flags().IntVar(&benchQPS, "qps", 100, "Queries per second to send")
+	benchCmd.Flags().DurationVar(&benchDuration, "duration", 10*time.Second, "How long to run the benchmark")
+	benchCmd.Flags().StringVar(&benchServer, "server", "", "Resolver address as host:port (default is the local DNS proxy)")
+	benchCmd.Flags().StringVar(&benchNamesFile, "names", "", "File with names to query, one per line (default is a built-in list)")
+	benchCmd.Flags().BoolVar(&benchJSON, "json", false, "Output results as JSON")
+	dnsCmd.AddCommand(benchCmd)
+	dnsCmd.AddCommand(dnsBenchmarkUpstreamsCmd())
+}
+
+// dnsBenchmarkUpstreamsCmd 返回分别测试各上游服务器的成功率与延迟并按结果排序的命令
+func dnsBenchmarkUpstreamsCmd() *cobra.Command {
+	opts := dns.UpstreamBenchOptions{}
+	var qtype string
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "benchmark-upstreams [server...]",
+		Short: "Rank upstream DNS servers by success rate and latency",
+		Long: `Send the same query to each upstream DNS server, the configured ones or those
+given as arguments, and rank them by success rate and then by median latency,
+e.g. to choose their order for the priority strategy. Each server is sent
+--warmup untimed queries so that the answer is in its cache, then --samples
+timed ones, without retries and without going through the DNS proxy.
+
+Servers whose answers change between queries or differ from the answer most
+servers agree on are flagged as inconsistent, which may mean the answers are
+tampered with. Names served by CDNs legitimately get different answers, so
+query a name with a fixed answer such as the default example.com.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			var err error
+			if opts.Type, err = dns.ParseType(qtype); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+			opts.Upstreams = args
+			if len(opts.Upstreams) == 0 {
+				cfg, err := config.LoadConfig()
+				if err != nil {
+					fmt.Println("Error loading config:", err)
+					return
+				}
+				opts.Upstreams = cfg.DNS.UpstreamDNS
+			}
+
+			// 每次查询的转发日志只在 --verbose 时输出，以免淹没结果
+			if !utils.Verbose() {
+				utils.SetLogLevel(utils.LevelQuiet)
+			}
+			if !jsonOutput {
+				fmt.Printf("Benchmarking %d upstream DNS servers with %d queries for %s %s each...\n",
+					len(opts.Upstreams), opts.Samples, opts.Name, dns.TypeString(opts.Type))
+			}
+			results, err := dns.BenchmarkUpstreams(opts)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			if jsonOutput {
+				data, err := json.MarshalIndent(results, "", "  ")
+				if err != nil {
+					fmt.Println("Error encoding results:", err)
+					return
+				}
+				fmt.Println(string(data))
+				return
+			}
+			printUpstreamBenchmark(results)
+		},
+	}
+	cmd.Flags().StringVar(&opts.Name, "name", dns.DefaultUpstreamBenchName, "Name to query")
+	cmd.Flags().StringVar(&qtype, "type", "A", "Record type to query")
+	cmd.Flags().IntVar(&opts.Samples, "samples", dns.DefaultUpstreamBenchSamples, "Timed queries to send to each server")
+	cmd.Flags().IntVar(&opts.Warmup, "warmup", dns.DefaultUpstreamBenchWarmup, "Untimed queries to send to each server first")
+	cmd.Flags().DurationVar(&opts.Timeout, "timeout", 2*time.Second, "Maximum time to wait for each response")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output results as JSON")
+	return cmd
+}
+
+// printUpstreamBenchmark 按排名输出各上游服务器的测试结果，并给出按该顺序设置上游服务器的命令
+func printUpstreamBenchmark(results []dns.UpstreamBenchResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "RANK\tUPSTREAM\tSUCCESS\tMIN\tP50\tP95\tMAX\tANSWER\tERROR")
+	var ranked []string
+	inconsistent := false
+	for i, r := range results {
+		minMs, p50, p95, maxMs := "-", "-", "-", "-"
+		if r.Succeeded > 0 {
+			minMs = fmt.Sprintf("%.1fms", r.MinMs)
+			p50 = fmt.Sprintf("%.1fms", r.P50Ms)
+			p95 = fmt.Sprintf("%.1fms", r.P95Ms)
+			maxMs = fmt.Sprintf("%.1fms", r.MaxMs)
+		}
+		ranked = append(ranked, r.Upstream)
+		answer := strings.Join(r.Answers, " | ")
+		if r.Inconsistent {
+			answer = "INCONSISTENT: " + answer
+			inconsistent = true
+		}
+		fmt.Fprintf(w, "%d\t%s\t%d/%d (%.0f%%)\t%s\t%s\t%s\t%s\t%s\t%s\n", i+1, r.Upstream, r.Succeeded, r.Sent, r.SuccessRate*100,
+			minMs, p50, p95, maxMs, valueOrDash(answer), valueOrDash(r.LastError))
+	}
+	w.Flush()
+
+	if inconsistent {
+		fmt.Println("\nWarning: some servers returned inconsistent answers; check them before relying on them.")
+	}
+	if len(ranked) > 1 {
+		fmt.Printf("\nTo use this order with the priority strategy:\n  gateshift dns set-upstreams %s\n  gateshift dns set-strategy priority\n", strings.Join(ranked, " "))
+	}
+}
+
+// dnsTestResult 是 dns test 的查询结果
+type dnsTestResult struct {
+	Server    string  `json:"server"`
+	Name      string  `json:"name"`
+	Type      string  `json:"type"`
+	Rcode     string  `json:"rcode"`
+	Truncated bool    `json:"truncated,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+	// Cached 表示应答是否来自代理缓存，无法确定时为空
+	Cached  *bool           `json:"cached,omitempty"`
+	Answers []dnsTestRecord `json:"answers"`
+}
+
+// dnsTestRecord 是应答中的一条记录
+type dnsTestRecord struct {
+	Name  string `json:"name"`
+	Type  string `json:"type"`
+	TTL   uint32 `json:"ttl"`
+	Value string `json:"value"`
+}
+
+// dnsTestCmd 返回向DNS代理（或 --server 指定的服务器）发送查询并显示应答的命令
+func dnsTestCmd() *cobra.Command {
+	var server string
+	var timeout time.Duration
+	var jsonOutput bool
+
+	cmd := &cobra.Command{
+		Use:   "test <domain> [type]",
+		Short: "Query the DNS proxy and show the answer",
+		Long: `Send a query for domain to the DNS proxy at the configured listen address,
+or to --server, and show the answer records with their TTLs, the response code
+and the latency. The type defaults to A, or PTR when domain is an IP address.
+
+When the local proxy is queried and its control API is enabled, the output also
+says whether the answer came from the cache.
+
+The command exits with status 1 on SERVFAIL, timeouts and other errors, so it
+can be used as a health check.`,
+		Args: cobra.RangeArgs(1, 2),
+		Run: func(cmd *cobra.Command, args []string) {
+			name, qtype := args[0], dns.TypeA
+			if ip := net.ParseIP(name); ip != nil {
+				name, qtype = dns.ReverseName(ip), dns.TypePTR
+			}
+			if len(args) == 2 {
+				var err error
+				if qtype, err = dns.ParseType(args[1]); err != nil {
+					fmt.Println("Error:", err)
+					os.Exit(1)
+				}
+			}
+
+			local := server == ""
+			if local {
+				cfg, err := config.LoadConfig()
+				if err != nil {
+					fmt.Println("Error loading config:", err)
+					os.Exit(1)
+				}
+				server = net.JoinHostPort(cfg.DNS.ListenAddr, strconv.Itoa(cfg.DNS.ListenPort))
+			} else if _, _, err := net.SplitHostPort(server); err != nil {
+				server = net.JoinHostPort(strings.Trim(server, "[]"), "53")
+			}
+
+			// 查询前检查缓存，判断应答是否来自缓存
+			fqdn := strings.TrimSuffix(name, ".") + "."
+			var cached *bool
+			if local {
+				cached = isCached(fqdn, qtype)
+			}
+
+			resp, latency, err := dns.Lookup(server, name, qtype, timeout)
+			if err != nil {
+				fmt.Printf("Error querying %s: %v\n", server, err)
+				os.Exit(1)
+			}
+
+			result := dnsTestResult{
+				Server:    server,
+				Name:      fqdn,
+				Type:      dns.TypeString(qtype),
+				Rcode:     dns.RcodeString(resp.Rcode),
+				Truncated: resp.Truncated,
+				LatencyMs: float64(latency.Microseconds()) / 1000,
+				Cached:    cached,
+				Answers:   []dnsTestRecord{},
+			}
+			for _, rr := range resp.Answers {
+				result.Answers = append(result.Answers, dnsTestRecord{
+					Name:  rr.Name,
+					Type:  dns.TypeString(rr.Type),
+					TTL:   rr.TTL,
+					Value: rr.Value,
+				})
+			}
+
+			if jsonOutput {
+				data, err := json.MarshalIndent(result, "", "  ")
+				if err != nil {
+					fmt.Println("Error encoding result:", err)
+					os.Exit(1)
+				}
+				fmt.Println(string(data))
+			} else {
+				printDNSTestResult(result)
+			}
+
+			if resp.Rcode == 2 {
+				os.Exit(1)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&server, "server", "", "DNS server as host[:port] (default is the local DNS proxy)")
+	cmd.Flags().DurationVar(&timeout, "timeout", 5*time.Second, "How long to wait for the answer")
+	cmd.Flags().BoolVar(&jsonOutput, "json", false, "Output as JSON")
+	return cmd
+}
+
+// isCached 通过控制API查看运行中的代理是否已缓存该查询；服务未运行或控制API未启用时无法确定，返回 nil
+func isCached(fqdn string, qtype uint16) *bool {
+	if !isServiceRunning() {
+		return nil
+	}
+	cfg, err := config.LoadConfig()
+	if err != nil || cfg.DNS.ControlAddr == "" {
+		return nil
+	}
+
+	entries, err := dns.NewControlClient(cfg.DNS.ControlAddr, controlTokenFile()).CacheEntries()
+	if err != nil {
+		return nil
+	}
+	cached := false
+	for _, entry := range entries {
+		// dns test 的查询不带 DO/CD 标志
+		if entry.Name == strings.ToLower(fqdn) && entry.Type == dns.TypeString(qtype) && entry.Flags == "" && entry.TTL > 0 {
+			cached = true
+			break
+		}
+	}
+	return &cached
+}
+
+// systemDNSReport 是 dns get-system 的输出
+type systemDNSReport struct {
+	Interfaces []dns.InterfaceDNS `json:"interfaces"`
+	// Resolved 是 systemd-resolved 实际转发到的服务器，仅在 resolv.conf 只指向其本地存根时列出
+	Resolved []dns.InterfaceDNS `json:"systemd_resolved,omitempty"`
+	// ResolvedError 是无法获取 systemd-resolved 服务器的原因
+	ResolvedError string `json:"systemd_resolved_error,omitempty"`
+}
+
+// systemDNSReportNow 获取系统当前的DNS服务器设置
+func systemDNSReportNow() (systemDNSReport, error) {
+	entries, err := dns.SystemDNSServers()
+	if err != nil {
+		return systemDNSReport{}, err
+	}
+	report := systemDNSReport{Interfaces: entries}
+
+	for _, entry := range entries {
+		if !entry.UsesResolvedStub() {
+			continue
+		}
+		resolved, err := dns.ResolvedDNSServers()
+		if err != nil {
+			report.ResolvedError = err.Error()
+		}
+		report.Resolved = resolved
+		break
+	}
+	return report, nil
+}
+
+// systemDNSSource 描述接口DNS服务器的来源：DHCP、手动设置或未设置
+func systemDNSSource(entry dns.InterfaceDNS) string {
+	switch {
+	case entry.Automatic:
+		return "DHCP"
+	case len(entry.Servers) == 0:
+		return "none set"
+	default:
+		return "manual"
+	}
+}
+
+// printSystemDNSReport 输出 dns get-system 的结果
+func printSystemDNSReport(report systemDNSReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "INTERFACE\tSOURCE\tSERVERS")
+	for _, entry := range report.Interfaces {
+		fmt.Fprintf(w, "%s\t%s\t%s\n", entry.Interface, systemDNSSource(entry), valueOrDash(strings.Join(entry.Servers, ", ")))
+	}
+	w.Flush()
+
+	if report.ResolvedError != "" {
+		fmt.Printf("\nWarning: %s\n", report.ResolvedError)
+	}
+	if len(report.Resolved) == 0 {
+		return
+	}
+	fmt.Println("\nsystemd-resolved forwards to:")
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "LINK\tSERVERS")
+	for _, entry := range report.Resolved {
+		fmt.Fprintf(w, "%s\t%s\n", entry.Interface, valueOrDash(strings.Join(entry.Servers, ", ")))
+	}
+	w.Flush()
+}
+
+// printDNSTestResult 输出 dns test 的查询结果
+func printDNSTestResult(result dnsTestResult) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "Query:\t%s %s\n", result.Name, result.Type)
+	fmt.Fprintf(w, "Server:\t%s\n", result.Server)
+	rcode := result.Rcode
+	if result.Truncated {
+		rcode += " (truncated)"
+	}
+	fmt.Fprintf(w, "Rcode:\t%s\n", rcode)
+	fmt.Fprintf(w, "Latency:\t%.2fms\n", result.LatencyMs)
+	if result.Cached != nil {
+		fmt.Fprintf(w, "From Cache:\t%t\n", *result.Cached)
+	}
+	w.Flush()
+
+	if len(result.Answers) == 0 {
+		fmt.Println("\nNo answer records")
+		return
+	}
+	fmt.Println()
+	w = tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "NAME\tTTL\tTYPE\tVALUE")
+	for _, rr := range result.Answers {
+		fmt.Fprintf(w, "%s\t%ds\t%s\t%s\n", rr.Name, rr.TTL, rr.Type, rr.Value)
+	}
+	w.Flush()
+}
+
+// dnsCacheCmd 返回用于查看和清空运行中DNS服务缓存的命令
+func dnsCacheCmd() *cobra.Command {
+	var cacheCmd = &cobra.Command{
+		Use:   "cache",
+		Short: "Inspect the DNS response cache",
+		Long:  `Inspect or clear the response cache of the running DNS service through its control API.`,
+	}
+
+	var jsonOutput bool
+	var showCmd = &cobra.Command{
+		Use:   "show",
+		Short: "List cached DNS responses",
+		Long:  `List the names, query types, remaining TTL and response size of cached DNS responses.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			client, err := dnsControlClient()
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			entries, err := client.CacheEntries()
+			if err != nil {
+				fmt.Println("Error reading DNS cache:", err)
+				return
+			}
+
+			if jsonOutput {
+				data, err := json.MarshalIndent(entries, "", "  ")
+				if err != nil {
+					fmt.Println("Error encoding DNS cache:", err)
+					return
+				}
+				fmt.Println(string(data))
+				return
+			}
+
+			if len(entries) == 0 {
+				fmt.Println("DNS cache is empty")
+				return
+			}
+
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tTYPE\tFLAGS\tTTL\tSIZE")
+			for _, entry := range entries {
+				flags := entry.Flags
+				if flags == "" {
+					flags = "-"
+				}
+				fmt.Fprintf(w, "%s\t%s\t%s\t%ds\t%d\n", entry.Name, entry.Type, flags, entry.TTL, entry.Size)
+			}
+			w.Flush()
+		},
+	}
+	showCmd.Flags().BoolVar(&jsonOutput, "json", false, "Output cache entries as JSON")
+	cacheCmd.AddCommand(showCmd)
+
+	var clearCmd = &cobra.Command{
+		Use:   "clear",
+		Short: "Clear the DNS response cache",
+		Long:  `Remove all cached responses from the running DNS service.`,
+		Run: func(cmd *cobra.Command, args []string) {
+			flushDNSCache()
+		},
+	}
+	cacheCmd.AddCommand(clearCmd)
+
+	return cacheCmd
+}
+
+func dnsFlushCmd() *cobra.Command {
+	return &cobra.Command{
+		Use:   "flush",
+		Short: "Clear the cache of the running DNS service",
+		Long: `Remove all cached responses from the running DNS service, so that changed
+records are resolved again without waiting for their TTL to expire. This is
+the same as "gateshift dns cache clear".`,
+		Args: cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			flushDNSCache()
+		},
+	}
+}
+
+// dnsBlocklistCmd 返回用于管理被屏蔽域名及其应答方式的命令
+func dnsBlocklistCmd() *cobra.Command {
+	var blocklistCmd = &cobra.Command{
+		Use:   "blocklist",
+		Short: "Manage blocked domains",
+		Long: `Manage the domains the DNS proxy answers itself instead of forwarding.
+A blocked domain also blocks all of its subdomains.`,
+	}
+
+	var listCmd = &cobra.Command{
+		Use:   "list",
+		Short: "List blocked domains",
+		Args:  cobra.NoArgs,
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			fmt.Printf("Blocklist response: %s\n", cfg.DNS.BlocklistResponse)
+			if len(cfg.DNS.Blocklist) == 0 {
+				fmt.Println("No domains are blocked")
+				return
+			}
+			fmt.Println("Blocked domains:")
+			for _, domain := range cfg.DNS.Blocklist {
+				fmt.Printf("- %s\n", domain)
+			}
+		},
+	}
+
+	var addCmd = &cobra.Command{
+		Use:   "add [domain...]",
+		Short: "Block domains and their subdomains",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			domains, err := normalizeBlocklist(args)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			var added []string
+			cfg.DNS.Blocklist, added = appendDomains(cfg.DNS.Blocklist, domains)
+			if len(added) == 0 {
+				fmt.Println("All domains are already blocked")
+				return
+			}
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			fmt.Printf("Blocked: %s\n", strings.Join(added, ", "))
+			applyDNSConfig(cfg)
+		},
+	}
+
+	var removeCmd = &cobra.Command{
+		Use:   "remove [domain...]",
+		Short: "Unblock domains",
+		Args:  cobra.MinimumNArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			domains, err := normalizeBlocklist(args)
+			if err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
+			cfg, err := config.LoadConfig()
+			if err != nil {
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			var removed []string
+			cfg.DNS.Blocklist, removed = removeDomains(cfg.DNS.Blocklist, domains)
+			if len(removed) == 0 {
+				fmt.Println("None of the domains are blocked")
+				return
+			}
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			fmt.Printf("Unblocked: %s\n", strings.Join(removed, ", "))
+			applyDNSConfig(cfg)
+		},
+	}
+
+	var modeCmd = &cobra.Command{
+		Use:   "mode [nxdomain|zeroip|refused]",
+		Short: "Set how queries for blocked domains are answered",
+		Long: `Set how the DNS proxy answers queries for blocked domains:
+
+  nxdomain  the domain does not exist (default)
+  zeroip    A queries get 0.0.0.0 and AAAA queries get ::, other types no records
+  refused   the query is refused
+
+Some applications retry NXDOMAIN answers forever, while others handle a
+0.0.0.0 answer poorly; choose whichever your clients cope with best.`,
+		Args: cobra.ExactArgs(1),
+		Run: func(cmd *cobra.Command, args []string) {
+			if err := dns.ValidateBlockResponse(args[0]); err != nil {
+				fmt.Println("Error:", err)
+				return
+			}
+
 			cfg, err := config.LoadConfig()
 			if err != nil {
-				fmt.Println("Error loading config:", err)
-				return
+				fmt.Println("Error loading config:", err)
+				return
+			}
+
+			cfg.DNS.BlocklistResponse = args[0]
+			if err := config.SaveConfig(cfg); err != nil {
+				fmt.Println("Error saving config:", err)
+				return
+			}
+
+			fmt.Printf("Blocklist response set to: %s\n", args[0])
+			applyDNSConfig(cfg)
+		},
+	}
+
+	var statsTop int
+	var statsJSON bool
+	var statsCmd = &cobra.Command{
+		Use:   "stats",
+		Short: "Show how many queries the running DNS service blocked",
+		Long: `Show the number of queries the running DNS service answered from the
+blocklist since it started, and the names blocked most often. Each blocked
+query is also logged; see them with: gateshift dns logs --filter blocked`,
+		Args: cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if statsTop < 0 {
+				return fmt.Errorf("--top must not be negative")
+			}
+
+			client, err := dnsControlClient()
+			if err != nil {
+				return err
+			}
+			stats, err := client.BlocklistStats(statsTop)
+			if err != nil {
+				return fmt.Errorf("reading blocklist statistics: %w", err)
+			}
+
+			if statsJSON {
+				data, err := json.MarshalIndent(stats, "", "  ")
+				if err != nil {
+					return err
+				}
+				fmt.Println(string(data))
+				return nil
+			}
+
+			fmt.Printf("Blocked queries: %d\n", stats.Total)
+			if len(stats.Top) == 0 {
+				return nil
 			}
+			fmt.Println()
+			w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+			fmt.Fprintln(w, "NAME\tBLOCKED")
+			for _, name := range stats.Top {
+				fmt.Fprintf(w, "%s\t%d\n", name.Name, name.Count)
+			}
+			return w.Flush()
+		},
+	}
+	statsCmd.Flags().IntVar(&statsTop, "top", 10, "Number of most blocked names to show (0 for all)")
+	statsCmd.Flags().BoolVar(&statsJSON, "json", false, "Output as JSON")
+
+	blocklistCmd.AddCommand(listCmd, addCmd, removeCmd, modeCmd, statsCmd)
+	return blocklistCmd
+}
+
+// normalizeBlocklist 规范化要屏蔽的域名（小写、去掉末尾的点）并去重
+func normalizeBlocklist(args []string) ([]string, error) {
+	domains := make([]string, 0, len(args))
+	for _, arg := range args {
+		domain, err := dns.NormalizeBlockedDomain(arg)
+		if err != nil {
+			return nil, err
+		}
+		domains, _ = appendDomains(domains, []string{domain})
+	}
+	return domains, nil
+}
+
+// appendDomains 将 existing 中尚未包含的域名追加到其后，返回新的列表及实际添加的域名
+func appendDomains(existing, domains []string) ([]string, []string) {
+	blocklist := append([]string(nil), existing...)
+	var added []string
+	for _, domain := range domains {
+		if indexDomain(blocklist, domain) < 0 {
+			blocklist = append(blocklist, domain)
+			added = append(added, domain)
+		}
+	}
+	return blocklist, added
+}
+
+// removeDomains 从 blocklist 中删除 domains，返回新的列表及实际删除的域名
+func removeDomains(blocklist, domains []string) ([]string, []string) {
+	kept := []string{}
+	var removed []string
+	for _, entry := range blocklist {
+		if indexDomain(domains, entry) >= 0 {
+			removed = append(removed, entry)
+			continue
+		}
+		kept = append(kept, entry)
+	}
+	return kept, removed
+}
+
+// indexDomain 返回 domain 在 domains 中的位置，忽略大小写与末尾的点；不存在时返回 -1
+func indexDomain(domains []string, domain string) int {
+	want, _ := dns.NormalizeBlockedDomain(domain)
+	for i, d := range domains {
+		if normalized, err := dns.NormalizeBlockedDomain(d); err == nil && normalized == want {
+			return i
+		}
+	}
+	return -1
+}
+
+// flushDNSCache 通过控制API清空运行中DNS服务的缓存，并报告清除的条目数
+func flushDNSCache() {
+	if !isServiceRunning() {
+		fmt.Println("No DNS service is running. Start it with: gateshift dns start")
+		return
+	}
+
+	client, err := dnsControlClient()
+	if err != nil {
+		fmt.Println("Error:", err)
+		return
+	}
+
+	cleared, err := client.ClearCache()
+	if err != nil {
+		fmt.Println("Error clearing DNS cache:", err)
+		return
+	}
+	fmt.Printf("Cleared %d cached entries\n", cleared)
+}
+
+// upstreamStatus 汇总一个上游DNS服务器的健康状态和查询统计
+type upstreamStatus struct {
+	dns.UpstreamHealth
+	Stats *dns.UpstreamStats `json:"stats,omitempty"`
+}
+
+// mergeUpstreamStatus 按上游服务器合并健康状态和查询统计
+func mergeUpstreamStatus(health []dns.UpstreamHealth, stats []dns.UpstreamStats) []upstreamStatus {
+	byUpstream := make(map[string]*dns.UpstreamStats, len(stats))
+	for i := range stats {
+		byUpstream[stats[i].Upstream] = &stats[i]
+	}
+
+	result := make([]upstreamStatus, 0, len(health))
+	for _, h := range health {
+		result = append(result, upstreamStatus{UpstreamHealth: h, Stats: byUpstream[h.Upstream]})
+	}
+	return result
+}
+
+// dnsControlClient 返回连接运行中DNS服务控制API的客户端
+func dnsControlClient() (*dns.ControlClient, error) {
+	if !isServiceRunning() {
+		return nil, fmt.Errorf("DNS service is not running")
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return nil, fmt.Errorf("loading config: %w", err)
+	}
+	if cfg.DNS.ControlAddr == "" {
+		return nil, fmt.Errorf("control API is disabled; set dns.control_addr (e.g. 127.0.0.1:5380) in the config and restart the DNS service")
+	}
+
+	return dns.NewControlClient(cfg.DNS.ControlAddr, controlTokenFile()), nil
+}
+
+// isServiceRunning 检查DNS服务是否在运行
+func isServiceRunning() bool {
+	// 已安装为系统服务时，由服务管理器报告状态
+	if service.IsInstalled() {
+		return service.IsRunning()
+	}
+
+	return getRunningPID() > 0
+}
+
+// getRunningPID 返回PID文件中仍在运行的DNS服务进程ID。
+// 如果进程已不存在或不是gateshift进程，则视为PID文件过期，清理后返回0。
+func getRunningPID() int {
+	pid := getPID(DNSPIDFile)
+	if pid <= 0 {
+		return 0
+	}
+
+	if isProcessAlive(pid) && isGateShiftProcess(pid) {
+		return pid
+	}
+
+	removePIDFile(DNSPIDFile)
+	return 0
+}
+
+// isProcessAlive 检查进程是否存在
+func isProcessAlive(pid int) bool {
+	process, err := os.FindProcess(pid)
+	if err != nil {
+		return false
+	}
+
+	// 在类Unix系统上，FindProcess总是成功的，我们需要发送一个信号0来检查进程是否真的存在。
+	// 进程属于root时会返回EPERM，但这同样说明进程存在。
+	if runtime.GOOS != "windows" {
+		err = process.Signal(syscall.Signal(0))
+		return err == nil || errors.Is(err, syscall.EPERM)
+	}
+
+	// Windows上，我们可以假设如果FindProcess成功，进程就存在
+	return true
+}
+
+// isGateShiftProcess 通过进程名确认PID属于gateshift，避免PID被其他进程复用
+func isGateShiftProcess(pid int) bool {
+	exe, err := os.Executable()
+	if err != nil {
+		return false
+	}
+	name := strings.TrimSuffix(filepath.Base(exe), ".exe")
+
+	var output []byte
+	if runtime.GOOS == "windows" {
+		output, err = exec.Command("tasklist", "/FI", fmt.Sprintf("PID eq %d", pid), "/NH").Output()
+	} else {
+		output, err = exec.Command("ps", "-p", strconv.Itoa(pid), "-o", "command=").Output()
+	}
+	if err != nil {
+		return false
+	}
+
+	return strings.Contains(string(output), name)
+}
+
+// removePIDFile 删除PID文件，必要时使用sudo
+func removePIDFile(pidFile string) {
+	if err := os.Remove(pidFile); err == nil || os.IsNotExist(err) {
+		return
+	}
+
+	sudoSession := utils.NewSudoSession(15 * time.Minute)
+	if err := sudoSession.RunWithPrivileges("rm", "-f", pidFile); err != nil {
+		fmt.Printf("Warning: could not remove PID file: %v\n", err)
+	}
+}
+
+// getPID 从PID文件中读取进程ID
+func getPID(pidFile string) int {
+	if _, err := os.Stat(pidFile); os.IsNotExist(err) {
+		return 0
+	}
+
+	// 尝试直接读取
+	data, err := os.ReadFile(pidFile)
+	if err == nil {
+		pid := 0
+		fmt.Sscanf(string(data), "%d", &pid)
+		return pid
+	}
+
+	// 如果直接读取失败，尝试使用sudo读取；--no-sudo 时说明无法判断服务状态
+	if utils.NoSudo() {
+		fmt.Printf("Note: %s is not readable without sudo (--no-sudo); the DNS service is reported as stopped\n", pidFile)
+		return 0
+	}
+	cmd := exec.Command("sudo", "cat", pidFile)
+	output, err := cmd.CombinedOutput()
+	if err != nil {
+		return 0
+	}
+
+	pid := 0
+	fmt.Sscanf(string(output), "%d", &pid)
+	return pid
+}
+
+// savePID 保存进程ID到PID文件
+func savePID(pidFile string, pid int) error {
+	return os.WriteFile(pidFile, []byte(fmt.Sprintf("%d", pid)), 0644)
+}
+
+// appendUpstreams 将 existing 中尚未包含的服务器追加到其后，返回新的列表及实际添加的服务器
+func appendUpstreams(existing, servers []string) ([]string, []string) {
+	upstreams := append([]string(nil), existing...)
+	var added []string
+	for _, server := range servers {
+		if !containsUpstream(upstreams, server) {
+			upstreams = append(upstreams, server)
+			added = append(added, server)
+		}
+	}
+	return upstreams, added
+}
+
+// removeUpstream 从 upstreams 中删除 server，返回新的列表及是否找到
+func removeUpstream(upstreams []string, server string) ([]string, bool) {
+	var kept []string
+	found := false
+	for _, s := range upstreams {
+		if sameUpstream(s, server) {
+			found = true
+			continue
+		}
+		kept = append(kept, s)
+	}
+	return kept, found
+}
+
+// containsUpstream 判断 upstreams 中是否已有 server
+func containsUpstream(upstreams []string, server string) bool {
+	for _, s := range upstreams {
+		if sameUpstream(s, server) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameUpstream 比较两个上游服务器地址，忽略写法差异（如省略的端口）
+func sameUpstream(a, b string) bool {
+	if normalized, err := dns.NormalizeUpstream(a); err == nil {
+		a = normalized
+	}
+	if normalized, err := dns.NormalizeUpstream(b); err == nil {
+		b = normalized
+	}
+	return a == b
+}
+
+// applyUpstreams 将配置中的上游DNS服务器应用到正在运行的DNS服务，无法应用时提示重启
+func applyUpstreams(cfg *config.Config) {
+	if !isServiceRunning() {
+		return
+	}
+
+	if cfg.DNS.ControlAddr != "" {
+		client := dns.NewControlClient(cfg.DNS.ControlAddr, controlTokenFile())
+		_, err := client.SetUpstreams(cfg.DNS.UpstreamDNS)
+		if err == nil {
+			fmt.Println("Changes applied to the running DNS service")
+			return
+		}
+		fmt.Printf("Warning: could not update the running DNS service: %v\n", err)
+	}
+
+	fmt.Println("Restart the DNS service to apply changes: gateshift dns restart")
+}
+
+// applyDNSConfig 通过控制API让运行中的DNS服务重新加载配置文件
+func applyDNSConfig(cfg *config.Config) {
+	if !isServiceRunning() {
+		return
+	}
+
+	if cfg.DNS.ControlAddr != "" {
+		client := dns.NewControlClient(cfg.DNS.ControlAddr, controlTokenFile())
+		err := client.Reload()
+		if err == nil {
+			fmt.Println("Changes applied to the running DNS service")
+			return
+		}
+		fmt.Printf("Warning: could not update the running DNS service: %v\n", err)
+	}
+
+	fmt.Println("Restart the DNS service to apply changes: gateshift dns restart")
+}
+
+// controlTokenFile 返回控制API令牌文件路径
+func controlTokenFile() string {
+	return filepath.Join(config.GetConfigDir(), "control.token")
+}
+
+// reloadDNSConfig 重新读取配置文件，并将上游服务器、选择策略、AAAA 过滤、备用服务器与屏蔽列表应用到运行中的代理；
+// 新配置无效时保持原配置不变
+func reloadDNSConfig(proxy *dns.DNSProxy) error {
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+	return proxy.ApplyConfig(dns.ReloadConfig{
+		Upstreams:         cfg.DNS.UpstreamDNS,
+		Strategy:          cfg.DNS.Strategy,
+		FilterAAAA:        cfg.DNS.FilterAAAA,
+		Fallback:          cfg.DNS.FallbackDNS,
+		Blocklist:         cfg.DNS.Blocklist,
+		BlockResponse:     cfg.DNS.BlocklistResponse,
+		Recursion:         cfg.DNS.Recursion,
+		HostsFile:         cfg.DNS.HostsFile,
+		IdleTimeout:       cfg.DNS.IdleTimeout,
+		Retries:           cfg.DNS.UpstreamRetries,
+		RetryBackoff:      cfg.DNS.RetryBackoff,
+		CacheMinTTL:       cfg.DNS.CacheMinTTL,
+		CacheMaxTTL:       cfg.DNS.CacheMaxTTL,
+		ServeStale:        cfg.DNS.ServeStaleMaxAge,
+		QNAMEMinimization: cfg.DNS.QNAMEMinimization,
+		MaxInflight:       cfg.DNS.MaxInflight,
+	})
+}
+
+// logFollowInterval 是实时查看日志时检查新内容的间隔
+const logFollowInterval = 500 * time.Millisecond
+
+// showLogs 输出日志文件最后 lines 行中匹配的行，follow 时继续输出新写入的行，
+// 直到收到中断信号；日志轮转后自动切换到新文件
+func showLogs(logFile string, lines int, follow bool, match utils.LineMatcher) error {
+	last, offset, err := utils.LastLines(logFile, lines)
+	if err != nil {
+		return err
+	}
+	for _, line := range last {
+		if match == nil || match(line) {
+			fmt.Println(line)
+		}
+	}
+
+	if !follow {
+		return nil
+	}
+
+	stop := make(chan struct{})
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, os.Interrupt, syscall.SIGTERM)
+	defer signal.Stop(sigChan)
+	go func() {
+		<-sigChan
+		close(stop)
+	}()
+
+	return utils.FollowFile(logFile, offset, os.Stdout, match, logFollowInterval, stop)
+}
+
+// dnsLogDir 返回DNS服务日志目录
+func dnsLogDir() (string, error) {
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return "", fmt.Errorf("failed to get home directory: %w", err)
+	}
+	return filepath.Join(homeDir, ".gateshift", "logs"), nil
+}
+
+// openQueryLog 打开按配置大小轮转的查询日志 queries.log
+func openQueryLog(cfg *config.Config) (*utils.RotatingFile, error) {
+	logDir, err := dnsLogDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	return utils.OpenRotatingFile(filepath.Join(logDir, "queries.log"),
+		int64(cfg.DNS.QueryLogMaxSize)<<20, cfg.DNS.QueryLogKeep)
+}
+
+// openServiceLog 以追加方式打开DNS服务日志 gateshift-dns.log
+func openServiceLog() (*os.File, error) {
+	logDir, err := dnsLogDir()
+	if err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return nil, fmt.Errorf("failed to create log directory: %w", err)
+	}
+	return os.OpenFile(filepath.Join(logDir, "gateshift-dns.log"), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+}
+
+// portOwnerHint 根据占用DNS端口的进程给出解决办法
+func portOwnerHint(owner *dns.PortOwner) string {
+	name := strings.ToLower(strings.TrimSuffix(owner.Name, ".exe"))
+	switch {
+	case strings.HasPrefix(name, "systemd-resolve"):
+		return "systemd-resolved's stub resolver is using the port. Run 'gateshift dns start --take-over' to turn it off\n" +
+			"while the DNS service runs, or change dns.listen_addr with 'gateshift config edit'."
+	case name == "dnsmasq":
+		return "dnsmasq is using the port. Stop it, or limit it to other addresses with listen-address and\n" +
+			"bind-interfaces in its configuration."
+	case name == "mdnsresponder":
+		return "mDNSResponder is using the port, usually because Internet Sharing is on. Turn it off,\n" +
+			"or change dns.listen_addr with 'gateshift config edit'."
+	case name == "gateshift":
+		return "Another GateShift DNS service is using the port. Stop it with 'gateshift dns stop'."
+	default:
+		return fmt.Sprintf("Stop %s, or change dns.listen_addr or dns.listen_port with 'gateshift config edit'.", owner)
+	}
+}
+
+// takeOverDNSPort 经用户确认后关闭占用DNS端口的系统解析器，记录的修改由 restoreResolver 恢复
+func takeOverDNSPort(cfg *config.Config, assumeYes bool) error {
+	owner, err := dns.FindPortOwner(cfg.DNS.ListenAddr, cfg.DNS.ListenPort)
+	if err != nil {
+		return fmt.Errorf("could not check port %d: %w", cfg.DNS.ListenPort, err)
+	}
+	if owner == nil {
+		fmt.Printf("Port %d is free, nothing to take over\n", cfg.DNS.ListenPort)
+		return nil
+	}
+	if err := dns.CanTakeOver(owner); err != nil {
+		return err
+	}
+
+	if !assumeYes && !utils.DryRun() {
+		fmt.Printf("%s is using port %d. Turn off its stub listener while the DNS service runs? [y/N] ", owner, cfg.DNS.ListenPort)
+		var response string
+		fmt.Scanln(&response)
+		if response != "y" && response != "Y" {
+			return errors.New("takeover cancelled")
+		}
+	}
+
+	sudoSession := utils.NewSudoSession(15 * time.Minute)
+	if err := dns.TakeOverResolver(sudoSession, owner, cfg.DNS.ListenAddr); err != nil {
+		// 部分完成的修改同样需要撤销
+		restoreResolver()
+		return err
+	}
+	fmt.Printf("%s no longer listens on port %d; it is restored when the DNS service stops\n", owner.Name, cfg.DNS.ListenPort)
+	return nil
+}
+
+// restoreResolver 恢复被 --take-over 关闭的系统解析器，没有记录时什么也不做
+func restoreResolver() {
+	restored, err := dns.RestoreResolver(utils.NewSudoSession(15 * time.Minute))
+	if err != nil {
+		fmt.Printf("Warning: Failed to restore the system resolver: %v\n", err)
+	} else if restored {
+		fmt.Println("System resolver restored.")
+	}
+}
+
+// startDNSForeground 在前台启动DNS服务。trace 为 true 时在终端逐行输出查询摘要，
+// 服务日志改写入 gateshift-dns.log
+func startDNSForeground(cfg *config.Config, trace bool) {
+	shutdown, err := startDNSService(cfg, trace)
+	if err != nil {
+		printDNSStartError(err)
+		return
+	}
+
+	// 等待中断信号或空闲超时；收到重载信号（SIGHUP）时重新读取配置，监听端口和缓存保持不变
+	fmt.Println("DNS service running. Press Ctrl+C to stop.")
+	if timeout := dnsProxy.IdleTimeout(); timeout > 0 {
+		fmt.Printf("The DNS service stops after %v without queries\n", timeout)
+	}
+	waitForStop(reloadDNSProxy, nil, nil)
+
+	// 正常退出时停止代理、恢复系统DNS并删除PID文件
+	utils.Infof("Shutting down DNS service...\n")
+	shutdown()
+}
+
+// startDNSService 按配置创建并启动DNS代理，将系统DNS指向它并写入PID文件。
+// trace 为 true 时在终端逐行输出查询摘要，服务日志改写入 gateshift-dns.log。
+// 返回的 shutdown 停止代理、恢复系统DNS与被接管的解析器并删除PID文件
+func startDNSService(cfg *config.Config, trace bool) (shutdown func(), err error) {
+	// 出错时按相反顺序关闭已打开的日志
+	var closers []func()
+	closeAll := func() {
+		for i := len(closers) - 1; i >= 0; i-- {
+			closers[i]()
+		}
+	}
+	defer func() {
+		if err != nil {
+			closeAll()
+		}
+	}()
+
+	// 创建DNS代理
+	dnsProxy, err = dns.NewDNSProxy(cfg.DNS.ListenAddr, cfg.DNS.ListenPort, cfg.DNS.UpstreamDNS)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create DNS proxy: %w", err)
+	}
+
+	if cfg.DNS.Strategy != "" {
+		if err := dnsProxy.SetStrategy(cfg.DNS.Strategy); err != nil {
+			return nil, fmt.Errorf("failed to set upstream strategy: %w", err)
+		}
+	}
 
-			if len(cfg.DNS.UpstreamDNS) == 0 {
-				fmt.Println("No upstream DNS servers configured")
-				return
-			}
+	dnsProxy.SetAAAAFilter(cfg.DNS.FilterAAAA)
+	dnsProxy.SetQNAMEMinimization(cfg.DNS.QNAMEMinimization)
+	if err := dnsProxy.SetFallback(cfg.DNS.FallbackDNS); err != nil {
+		return nil, fmt.Errorf("failed to set fallback DNS server: %w", err)
+	}
+	if err := dnsProxy.SetBlocklist(cfg.DNS.Blocklist); err != nil {
+		return nil, fmt.Errorf("failed to set blocklist: %w", err)
+	}
+	if cfg.DNS.BlocklistResponse != "" {
+		if err := dnsProxy.SetBlockResponse(cfg.DNS.BlocklistResponse); err != nil {
+			return nil, fmt.Errorf("failed to set blocklist response: %w", err)
+		}
+	}
 
-			fmt.Println("Upstream DNS servers:")
-			for _, server := range cfg.DNS.UpstreamDNS {
-				fmt.Printf("- %s\n", server)
-			}
-		},
+	if cfg.DNS.Recursion != "" {
+		if err := dnsProxy.SetRecursionMode(cfg.DNS.Recursion); err != nil {
+			return nil, fmt.Errorf("failed to set recursion mode: %w", err)
+		}
 	}
-	dnsCmd.AddCommand(listServersCmd)
-}
 
-// isServiceRunning 检查DNS服务是否在运行
-func isServiceRunning() bool {
-	// 从PID文件获取进程ID
-	pid := getPID(DNSPIDFile)
-	if pid <= 0 {
-		return false
+	if err := dnsProxy.SetHostsFile(cfg.DNS.HostsFile); err != nil {
+		return nil, fmt.Errorf("failed to set hosts file: %w", err)
 	}
 
-	// 检查进程是否存在
-	process, err := os.FindProcess(pid)
-	if err != nil {
-		return false
+	if err := dnsProxy.SetIdleTimeout(cfg.DNS.IdleTimeout); err != nil {
+		return nil, fmt.Errorf("failed to set idle timeout: %w", err)
 	}
 
-	// 在类Unix系统上，FindProcess总是成功的，我们需要发送一个信号0来检查进程是否真的存在
-	if runtime.GOOS != "windows" {
-		err = process.Signal(syscall.Signal(0))
-		return err == nil
+	if err := dnsProxy.SetRetries(cfg.DNS.UpstreamRetries, cfg.DNS.RetryBackoff); err != nil {
+		return nil, fmt.Errorf("failed to set upstream retries: %w", err)
 	}
 
-	// Windows上，我们可以假设如果FindProcess成功，进程就存在
-	return true
-}
+	if err := dnsProxy.SetCacheTTL(cfg.DNS.CacheMinTTL, cfg.DNS.CacheMaxTTL); err != nil {
+		return nil, fmt.Errorf("failed to set cache TTL bounds: %w", err)
+	}
 
-// getPID 从PID文件中读取进程ID
-func getPID(pidFile string) int {
-	if _, err := os.Stat(pidFile); os.IsNotExist(err) {
-		return 0
+	if err := dnsProxy.SetServeStale(cfg.DNS.ServeStaleMaxAge); err != nil {
+		return nil, fmt.Errorf("failed to set serve-stale: %w", err)
 	}
 
-	// 尝试直接读取
-	data, err := os.ReadFile(pidFile)
-	if err == nil {
-		pid := 0
-		fmt.Sscanf(string(data), "%d", &pid)
-		return pid
+	if err := dnsProxy.SetMaxInflight(cfg.DNS.MaxInflight); err != nil {
+		return nil, fmt.Errorf("failed to set the maximum of in-flight queries: %w", err)
 	}
 
-	// 如果直接读取失败，尝试使用sudo读取
-	cmd := exec.Command("sudo", "cat", pidFile)
-	output, err := cmd.CombinedOutput()
-	if err != nil {
-		return 0
+	if cfg.DNS.MetricsAddr != "" {
+		dnsProxy.EnableMetrics(cfg.DNS.MetricsAddr)
 	}
 
-	pid := 0
-	fmt.Sscanf(string(output), "%d", &pid)
-	return pid
-}
+	// 启用本地控制API，令牌写入配置目录
+	if cfg.DNS.ControlAddr != "" {
+		dnsProxy.EnableControlAPI(cfg.DNS.ControlAddr, controlTokenFile())
+	}
 
-// savePID 保存进程ID到PID文件
-func savePID(pidFile string, pid int) error {
-	return os.WriteFile(pidFile, []byte(fmt.Sprintf("%d", pid)), 0644)
-}
+	// 重新加载时从配置文件读取上游服务器、选择策略与 AAAA 过滤
+	proxy := dnsProxy
+	proxy.SetReloadFunc(func() error {
+		return reloadDNSConfig(proxy)
+	})
 
-// startDNSForeground 在前台启动DNS服务
-func startDNSForeground(cfg *config.Config) {
-	// 启动DNS代理
-	var err error
-	dnsProxy, err = dns.NewDNSProxy(cfg.DNS.ListenAddr, cfg.DNS.UpstreamDNS)
+	// 按配置的格式（text 或 json）将每条查询记录到单独的 queries.log，按大小轮转；
+	// 服务启停等日志仍写入原日志
+	queryLogFile, err := openQueryLog(cfg)
 	if err != nil {
-		fmt.Printf("Error creating DNS proxy: %v\n", err)
-		return
+		return nil, fmt.Errorf("failed to open query log: %w", err)
+	}
+	closers = append(closers, func() { queryLogFile.Close() })
+	queryLogger, err := dns.NewQueryLogger(cfg.DNS.LogFormat, queryLogFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to create query logger: %w", err)
+	}
+	if trace {
+		serviceLog, err := openServiceLog()
+		if err != nil {
+			return nil, fmt.Errorf("failed to open service log: %w", err)
+		}
+		log.SetOutput(serviceLog)
+		closers = append(closers, func() {
+			log.SetOutput(os.Stderr)
+			serviceLog.Close()
+		})
+		fmt.Printf("Tracing queries; the service log is written to %s\n", serviceLog.Name())
+
+		queryLogger = dns.MultiQueryLogger(queryLogger, dns.NewTraceLogger(os.Stdout))
 	}
+	dnsProxy.SetQueryLogger(queryLogger)
 
 	if err := dnsProxy.Start(); err != nil {
-		fmt.Printf("Error starting DNS proxy: %v\n", err)
-		return
+		restoreResolver()
+		return nil, fmt.Errorf("failed to start DNS proxy: %w", err)
+	}
+
+	// 系统解析器只会查询53端口
+	if cfg.DNS.ListenPort != 53 {
+		fmt.Printf("Warning: DNS proxy listens on port %d, but system resolvers only query port 53\n", cfg.DNS.ListenPort)
 	}
 
 	// 配置系统DNS
-	if err := dns.ConfigureSystemDNS(cfg.DNS.ListenAddr); err != nil {
+	if err := dns.ConfigureSystemDNS([]string{cfg.DNS.ListenAddr}, cfg.DNS.AllNetworkServices); err != nil {
 		fmt.Printf("Warning: Failed to configure system DNS: %v\n", err)
 	}
 
 	// 保存当前进程PID
-	savePID(DNSPIDFile, os.Getpid())
+	if err := savePID(DNSPIDFile, os.Getpid()); err != nil {
+		fmt.Printf("Warning: could not save PID file: %v\n", err)
+	}
 
-	// 等待中断信号
-	fmt.Println("DNS service running. Press Ctrl+C to stop.")
-	select {}
+	return func() {
+		if err := dnsProxy.Stop(); err != nil {
+			fmt.Printf("Warning: Failed to stop DNS proxy: %v\n", err)
+		}
+		if err := dns.RestoreSystemDNS([]string{cfg.DNS.ListenAddr}); err != nil {
+			fmt.Printf("Warning: Failed to restore system DNS: %v\n", err)
+		}
+		restoreResolver()
+		removePIDFile(DNSPIDFile)
+		closeAll()
+	}, nil
+}
+
+// printDNSDryRun 在预演模式下显示将要启动的DNS代理与将要修改的系统DNS设置
+func printDNSDryRun(cfg *config.Config) {
+	fmt.Printf("[dry-run] would start DNS proxy on %s forwarding to %v (strategy: %s)\n",
+		net.JoinHostPort(cfg.DNS.ListenAddr, strconv.Itoa(cfg.DNS.ListenPort)), cfg.DNS.UpstreamDNS, cfg.DNS.Strategy)
+	if err := dns.ConfigureSystemDNS([]string{cfg.DNS.ListenAddr}, cfg.DNS.AllNetworkServices); err != nil {
+		fmt.Println("Error:", err)
+	}
+}
+
+// printDNSStartError 输出DNS服务启动失败的原因；端口被占用时提示占用者
+func printDNSStartError(err error) {
+	fmt.Println("Error:", err)
+	var inUse *dns.PortInUseError
+	if errors.As(err, &inUse) {
+		fmt.Println(portOwnerHint(inUse.Owner))
+	}
+}
+
+// reloadDNSProxy 让运行中的DNS代理重新读取配置，失败时保留原配置
+func reloadDNSProxy(sig os.Signal) {
+	utils.Logf("Received %v, reloading configuration", sig)
+	if err := dnsProxy.Reload(); err != nil {
+		fmt.Printf("Error: reload failed, keeping previous configuration: %v\n", err)
+	}
+}
+
+// waitForStop 阻塞到收到中断或终止信号，或DNS代理空闲超时。收到重载信号时调用
+// reload；wake 每次触发时调用 onWake，wake 为 nil 时不会触发
+func waitForStop(reload func(os.Signal), wake <-chan struct{}, onWake func()) {
+	sigChan := make(chan os.Signal, 1)
+	signal.Notify(sigChan, append([]os.Signal{os.Interrupt, syscall.SIGTERM}, reloadSignals...)...)
+	defer signal.Stop(sigChan)
+	for {
+		select {
+		case sig := <-sigChan:
+			if !isReloadSignal(sig) {
+				return
+			}
+			reload(sig)
+		case <-wake:
+			onWake()
+		case <-dnsProxy.Idle():
+			// 与收到 SIGTERM 时相同：停止代理并恢复系统DNS
+			fmt.Println("Idle timeout reached, stopping the DNS service")
+			return
+		}
+	}
 }
 
 // startDNSBackground 在后台启动DNS服务
@@ -1248,79 +5051,52 @@ func startDNSBackground(cfg *config.Config) error {
 	// 使用预先获取的sudo会话
 	sudoSession := utils.NewSudoSession(15 * time.Minute)
 
-	// 构建命令行参数
-	args := []string{"dns", "start", "-f"}
+	// 构建命令行参数，显式传递PID文件路径（sudo可能会修改HOME）
+	args := []string{"dns", "start", "-f", "--pid-file", DNSPIDFile}
 
 	// 如果有配置文件路径，也传递给子进程
 	if cfgFile != "" {
 		args = append(args, "--config", cfgFile)
 	}
-
-	// 获取当前用户和组ID，用于后续修改文件权限
-	currentUser := fmt.Sprintf("%d", os.Getuid())
-	currentGroup := fmt.Sprintf("%d", os.Getgid())
-
-	// 用sudo运行完整命令
-	var cmd *exec.Cmd
-	switch runtime.GOOS {
-	case "darwin", "linux":
-		// 创建运行命令
-		fullCmd := append([]string{exe}, args...)
-
-		// 添加重定向
-		redirectCmd := append(fullCmd, ">", logFile, "2>&1", "&")
-
-		// 使用sudo和sh -c执行命令
-		err = sudoSession.RunWithPrivileges("sh", "-c", strings.Join(redirectCmd, " "))
-	case "windows":
-		// Windows上以管理员身份运行
-		cmd = exec.Command("powershell", "-Command", "Start-Process", "-Verb", "RunAs", exe, "-ArgumentList", strings.Join(args, " "))
-		err = cmd.Run()
-	default:
-		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+	if cfg.DNS.MetricsAddr != "" {
+		args = append(args, "--metrics-addr", cfg.DNS.MetricsAddr)
+	}
+	if cfg.DNS.Strategy != "" {
+		args = append(args, "--strategy", cfg.DNS.Strategy)
+	}
+	args = append(args,
+		"--query-log-max-size", strconv.Itoa(cfg.DNS.QueryLogMaxSize),
+		"--query-log-keep", strconv.Itoa(cfg.DNS.QueryLogKeep),
+		"--all-network-services="+strconv.FormatBool(cfg.DNS.AllNetworkServices))
+	switch {
+	case utils.Quiet():
+		args = append(args, "--quiet")
+	case utils.Verbose():
+		args = append(args, "--verbose")
 	}
 
-	if err != nil {
+	// 直接以参数列表启动子进程，不经过shell，路径中的空格等字符无需转义；
+	// 保留当前用户的HOME，使子进程使用同一配置目录
+	env := []string{"HOME=" + homeDir}
+	if err := sudoSession.StartWithPrivileges(logFile, env, exe, args...); err != nil {
 		return fmt.Errorf("failed to start DNS service: %w", err)
 	}
 
 	// 等待一小段时间确保进程已启动
 	time.Sleep(1 * time.Second)
 
-	// 修改日志文件权限，使当前用户可以访问
-	if runtime.GOOS != "windows" {
-		// 修改日志文件的所有者为当前用户
-		if err := sudoSession.RunWithPrivileges("chown", currentUser+":"+currentGroup, logFile); err != nil {
-			fmt.Printf("Warning: could not change log file ownership: %v\n", err)
-		}
+	// 等待子进程写入PID文件
+	for i := 0; i < 10 && getRunningPID() <= 0; i++ {
+		time.Sleep(200 * time.Millisecond)
 	}
-
-	// 尝试获取进程PID并保存
-	if runtime.GOOS != "windows" {
-		out, err := exec.Command("sudo", "pgrep", "-f", fmt.Sprintf("%s.*dns start -f", filepath.Base(exe))).Output()
-		if err == nil && len(out) > 0 {
-			pid := 0
-			fmt.Sscanf(string(out), "%d", &pid)
-			if pid > 0 {
-				// 需要使用sudo写入PID文件，确保权限正确
-				pidData := fmt.Sprintf("%d", pid)
-				err = sudoSession.RunWithPrivileges("sh", "-c", fmt.Sprintf("echo '%s' > %s", pidData, DNSPIDFile))
-				if err != nil {
-					fmt.Printf("Warning: could not save PID file: %v\n", err)
-				}
-
-				// 修改PID文件权限，使当前用户可以访问
-				if err := sudoSession.RunWithPrivileges("chown", currentUser+":"+currentGroup, DNSPIDFile); err != nil {
-					fmt.Printf("Warning: could not change PID file ownership: %v\n", err)
-				}
-			}
-		}
+	if getRunningPID() <= 0 {
+		fmt.Println("Warning: DNS service did not write its PID file")
 	}
 
 	// 检查进程是否成功启动
 	// 我们尝试连接DNS端口来验证
 	time.Sleep(500 * time.Millisecond) // 给进程多一点时间启动
-	conn, err := net.DialTimeout("udp", fmt.Sprintf("%s:53", cfg.DNS.ListenAddr), 500*time.Millisecond)
+	conn, err := net.DialTimeout("udp", net.JoinHostPort(cfg.DNS.ListenAddr, strconv.Itoa(cfg.DNS.ListenPort)), 500*time.Millisecond)
 	if err == nil {
 		conn.Close()
 		fmt.Println("DNS service started successfully and is responding to requests.")
@@ -1340,26 +5116,394 @@ func startDNSBackground(cfg *config.Config) error {
 	return nil
 }
 
+// dnsServiceOptions 构建由系统服务管理器启动DNS服务的参数
+func dnsServiceOptions() (service.Options, error) {
+	exe, err := os.Executable()
+	if err != nil {
+		return service.Options{}, fmt.Errorf("failed to get executable path: %w", err)
+	}
+
+	homeDir, err := os.UserHomeDir()
+	if err != nil {
+		return service.Options{}, fmt.Errorf("failed to get home directory: %w", err)
+	}
+
+	logDir := filepath.Join(homeDir, ".gateshift", "logs")
+	if err := os.MkdirAll(logDir, 0755); err != nil {
+		return service.Options{}, fmt.Errorf("failed to create log directory: %w", err)
+	}
+
+	args := []string{"dns", "start", "-f"}
+	if cfgFile != "" {
+		absCfg, err := filepath.Abs(cfgFile)
+		if err != nil {
+			return service.Options{}, fmt.Errorf("failed to resolve config path: %w", err)
+		}
+		args = append(args, "--config", absCfg)
+	}
+
+	return service.Options{
+		Executable: exe,
+		Args:       args,
+		LogFile:    filepath.Join(logDir, "gateshift-dns.log"),
+		// 服务以root运行，需要指向当前用户的配置目录
+		Environment: map[string]string{"HOME": homeDir},
+	}, nil
+}
+
+// restoreSystemDNS 使用配置中的代理监听地址恢复系统DNS设置
+func restoreSystemDNS() error {
+	var servers []string
+	if cfg, err := config.LoadConfig(); err == nil {
+		servers = []string{cfg.DNS.ListenAddr}
+	}
+	return dns.RestoreSystemDNS(servers)
+}
+
+// checkNoPassthrough 在系统DNS已由 dns set-system 直接指向其他服务器时返回错误，
+// 避免DNS服务覆盖并在停止时错误地恢复这些设置
+func checkNoPassthrough() error {
+	servers, err := dns.PassthroughDNS()
+	if err != nil {
+		return err
+	}
+	if len(servers) > 0 {
+		return fmt.Errorf("the system DNS is set to %s by 'gateshift dns set-system'; run 'gateshift dns restore-system' first", strings.Join(servers, ", "))
+	}
+	return nil
+}
+
+// leakTestTimeout 是泄露检测外部请求的超时时间
+const leakTestTimeout = 10 * time.Second
+
+// leakReport 是 dns leak-test 的检测结果
+type leakReport struct {
+	ProxyAddr      string                `json:"proxy_addr"`
+	ProxyRunning   bool                  `json:"proxy_running"`
+	Interfaces     []leakInterface       `json:"interfaces"`
+	SystemDNSError string                `json:"system_dns_error,omitempty"`
+	Resolver       *dns.ObservedResolver `json:"observed_resolver"`
+	ResolverError  string                `json:"observed_resolver_error,omitempty"`
+	Passed         bool                  `json:"passed"`
+}
+
+// leakInterface 是单个接口（或网络服务）的DNS设置及其是否经过代理
+type leakInterface struct {
+	dns.InterfaceDNS
+	Active    bool `json:"active"`
+	UsesProxy bool `json:"uses_proxy"`
+}
+
+// runLeakTest 检查系统DNS设置是否指向代理，并通过外部服务查看实际使用的解析器
+func runLeakTest(cfg *config.Config) *leakReport {
+	report := &leakReport{
+		ProxyAddr:    cfg.DNS.ListenAddr,
+		ProxyRunning: isServiceRunning(),
+	}
+
+	activeName := ""
+	if iface, err := gateway.GetActiveInterface(); err == nil {
+		activeName = iface.ServiceName
+	}
+
+	servers, err := dns.SystemDNSServers()
+	if err != nil {
+		report.SystemDNSError = err.Error()
+	}
+	for _, entry := range servers {
+		report.Interfaces = append(report.Interfaces, leakInterface{
+			InterfaceDNS: entry,
+			Active:       len(servers) == 1 || entry.Interface == activeName,
+			UsesProxy:    entry.UsesProxy(cfg.DNS.ListenAddr),
+		})
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), leakTestTimeout)
+	defer cancel()
+	if report.Resolver, err = dns.ProbeResolver(ctx); err != nil {
+		report.ResolverError = err.Error()
+	}
+
+	report.Passed = leakTestPassed(report)
+	return report
+}
+
+// leakTestPassed 判断检测是否通过：代理在运行，且活动接口（无法确定时为所有接口）只使用代理解析
+func leakTestPassed(report *leakReport) bool {
+	if !report.ProxyRunning || report.SystemDNSError != "" || len(report.Interfaces) == 0 {
+		return false
+	}
+
+	hasActive := false
+	for _, iface := range report.Interfaces {
+		hasActive = hasActive || iface.Active
+	}
+	for _, iface := range report.Interfaces {
+		if (iface.Active || !hasActive) && !iface.UsesProxy {
+			return false
+		}
+	}
+	return true
+}
+
+// printLeakReport 以文本格式输出泄露检测结果
+func printLeakReport(report *leakReport) {
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	if report.ProxyRunning {
+		fmt.Fprintf(w, "DNS Proxy:\t%s on %s\n", runningText(true), report.ProxyAddr)
+	} else {
+		fmt.Fprintf(w, "DNS Proxy:\t%s\n", runningText(false))
+	}
+
+	fmt.Fprintf(w, "\n%s\n", utils.Bold("System DNS:"))
+	if report.SystemDNSError != "" {
+		fmt.Fprintf(w, "  %s\n", utils.Red("Could not read system DNS settings: "+report.SystemDNSError))
+	}
+	for _, iface := range report.Interfaces {
+		name := iface.Interface
+		if iface.Active && len(report.Interfaces) > 1 {
+			name += " (active)"
+		}
+		servers := strings.Join(iface.Servers, ", ")
+		if iface.Automatic {
+			if servers == "" {
+				servers = "DHCP"
+			} else {
+				servers += " (DHCP)"
+			}
+		}
+		// 非活动接口不使用代理时只提示，切换到该接口后才会泄露
+		status := utils.Green("Uses the proxy")
+		switch {
+		case iface.UsesProxy:
+		case iface.Active:
+			status = utils.Red("Not using the proxy")
+		default:
+			status = utils.Yellow("Not using the proxy")
+		}
+		fmt.Fprintf(w, "  %s\t%s\t%s\n", name, servers, status)
+	}
+
+	fmt.Fprintln(w)
+	if report.Resolver != nil {
+		observed := report.Resolver.IP
+		if report.Resolver.Geo != "" {
+			observed += " (" + report.Resolver.Geo + ")"
+		}
+		fmt.Fprintf(w, "Observed Resolver:\t%s\n", observed)
+	} else {
+		fmt.Fprintf(w, "Observed Resolver:\t%s\n", utils.Yellow("Unknown: "+report.ResolverError))
+	}
+
+	if report.Passed {
+		fmt.Fprintf(w, "Result:\t%s\n", utils.Green("PASS - DNS queries go through the DNS proxy"))
+	} else {
+		fmt.Fprintf(w, "Result:\t%s\n", utils.Red("FAIL - DNS queries may bypass the DNS proxy"))
+	}
+	w.Flush()
+
+	if !report.ProxyRunning {
+		fmt.Println("\nStart the DNS proxy with: gateshift dns start")
+	}
+}
+
+// dnsReloadTimeout 是等待守护进程应用新配置的最长时间
+const dnsReloadTimeout = 3 * time.Second
+
+// reloadDNS 让运行中的DNS服务重新加载配置，并通过控制API确认和报告变更
+func reloadDNS() error {
+	if !isServiceRunning() {
+		fmt.Println("No DNS service is running. Start it with: gateshift dns start")
+		return nil
+	}
+
+	cfg, err := config.LoadConfig()
+	if err != nil {
+		return fmt.Errorf("loading config: %w", err)
+	}
+
+	// 重载前读取运行状态，用于确认守护进程已应用新配置
+	var client *dns.ControlClient
+	var before *dns.StatusResponse
+	if cfg.DNS.ControlAddr != "" {
+		client = dns.NewControlClient(cfg.DNS.ControlAddr, controlTokenFile())
+		if before, err = client.Status(); err != nil {
+			fmt.Printf("Warning: could not read DNS service status: %v\n", err)
+			client = nil
+		}
+	}
+
+	if err := requestReload(cfg); err != nil {
+		return err
+	}
+	if utils.DryRun() {
+		return nil
+	}
+	if client == nil {
+		fmt.Println("Reload requested. Check the result with: gateshift dns logs")
+		return nil
+	}
+
+	after, err := waitForReload(client, cfg, dnsReloadTimeout)
+	if err != nil {
+		return fmt.Errorf("%w; check the reason with: gateshift dns logs", err)
+	}
+
+	changes := reloadChanges(before, after)
+	if len(changes) == 0 {
+		fmt.Println("DNS service reloaded; configuration unchanged.")
+		return nil
+	}
+	fmt.Println("DNS service reloaded:")
+	for _, change := range changes {
+		fmt.Println("  " + change)
+	}
+	return nil
+}
+
+// waitForReload 轮询控制API，直到运行中的配置与 cfg 一致或超时
+func waitForReload(client *dns.ControlClient, cfg *config.Config, timeout time.Duration) (*dns.StatusResponse, error) {
+	deadline := time.Now().Add(timeout)
+	for {
+		status, err := client.Status()
+		if err != nil {
+			return nil, fmt.Errorf("could not read DNS service status: %w", err)
+		}
+		if statusMatchesConfig(status, cfg) {
+			return status, nil
+		}
+		if time.Now().After(deadline) {
+			return nil, fmt.Errorf("DNS service did not apply the new configuration and kept the previous one")
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+}
+
+// statusMatchesConfig 判断运行状态是否已与配置中的可重载项一致
+func statusMatchesConfig(status *dns.StatusResponse, cfg *config.Config) bool {
+	if strings.Join(status.Upstreams, ",") != strings.Join(cfg.DNS.UpstreamDNS, ",") {
+		return false
+	}
+	if cfg.DNS.Strategy != "" && status.Strategy != cfg.DNS.Strategy {
+		return false
+	}
+	if cfg.DNS.BlocklistResponse != "" && status.BlockResponse != cfg.DNS.BlocklistResponse {
+		return false
+	}
+	if cfg.DNS.Recursion != "" && status.Recursion != cfg.DNS.Recursion {
+		return false
+	}
+	if status.HostsFile != cfg.DNS.HostsFile {
+		return false
+	}
+	if blocklist, err := normalizeBlocklist(cfg.DNS.Blocklist); err != nil || status.BlockedDomains != len(blocklist) {
+		return false
+	}
+	if status.QNAMEMinimization != cfg.DNS.QNAMEMinimization {
+		return false
+	}
+	return status.FilterAAAA == cfg.DNS.FilterAAAA && status.Fallback == cfg.DNS.FallbackDNS
+}
+
+// reloadChanges 列出重载前后发生变化的配置项
+func reloadChanges(before, after *dns.StatusResponse) []string {
+	var changes []string
+	if strings.Join(before.Upstreams, ",") != strings.Join(after.Upstreams, ",") {
+		changes = append(changes, fmt.Sprintf("Upstream DNS: %s -> %s",
+			strings.Join(before.Upstreams, ", "), strings.Join(after.Upstreams, ", ")))
+	}
+	if before.Strategy != after.Strategy {
+		changes = append(changes, fmt.Sprintf("Strategy: %s -> %s", before.Strategy, after.Strategy))
+	}
+	if before.FilterAAAA != after.FilterAAAA {
+		changes = append(changes, fmt.Sprintf("AAAA Filter: %s -> %s",
+			enabledText(before.FilterAAAA), enabledText(after.FilterAAAA)))
+	}
+	if before.QNAMEMinimization != after.QNAMEMinimization {
+		changes = append(changes, fmt.Sprintf("QNAME Minimization: %s -> %s",
+			enabledText(before.QNAMEMinimization), enabledText(after.QNAMEMinimization)))
+	}
+	if before.Fallback != after.Fallback {
+		changes = append(changes, fmt.Sprintf("Fallback DNS: %s -> %s", valueOrDash(before.Fallback), valueOrDash(after.Fallback)))
+	}
+	if before.BlockedDomains != after.BlockedDomains {
+		changes = append(changes, fmt.Sprintf("Blocked Domains: %d -> %d", before.BlockedDomains, after.BlockedDomains))
+	}
+	if before.BlockResponse != after.BlockResponse {
+		changes = append(changes, fmt.Sprintf("Blocklist Response: %s -> %s", before.BlockResponse, after.BlockResponse))
+	}
+	if before.Recursion != after.Recursion {
+		changes = append(changes, fmt.Sprintf("Recursion: %s -> %s", before.Recursion, after.Recursion))
+	}
+	if before.HostsFile != after.HostsFile {
+		changes = append(changes, fmt.Sprintf("Hosts File: %s -> %s", valueOrDash(before.HostsFile), valueOrDash(after.HostsFile)))
+	}
+	return changes
+}
+
+// dnsStopTimeout 是等待守护进程自行清理退出的最长时间
+const dnsStopTimeout = 5 * time.Second
+
+// waitForCleanExit 等待守护进程退出并删除自己的PID文件。
+// 进程已退出但PID文件仍在，或超时，均视为未正常退出。
+func waitForCleanExit(pid int, timeout time.Duration) bool {
+	deadline := time.Now().Add(timeout)
+	for time.Now().Before(deadline) {
+		if getPID(DNSPIDFile) != pid {
+			return true
+		}
+		if !isProcessAlive(pid) {
+			// 给进程删除PID文件与退出之间留出最后一次检查
+			return getPID(DNSPIDFile) != pid
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	return false
+}
+
 // stopDNS 停止DNS服务
 func stopDNS() error {
 	// 获取sudo会话
 	sudoSession := utils.NewSudoSession(15 * time.Minute)
 
-	// 读取PID
-	pid := getPID(DNSPIDFile)
+	// 已安装为系统服务时，通过服务管理器停止
+	if service.IsInstalled() {
+		pid := getRunningPID()
+		if err := service.Stop(); err != nil {
+			return fmt.Errorf("failed to stop DNS service: %w", err)
+		}
+
+		// 守护进程正常退出时已自行恢复系统DNS，再次恢复会与其竞争
+		clean := pid > 0 && waitForCleanExit(pid, dnsStopTimeout)
+		removePIDFile(DNSPIDFile)
+		if !clean {
+			if err := restoreSystemDNS(); err != nil {
+				return fmt.Errorf("failed to restore system DNS: %w", err)
+			}
+		}
+		restoreResolver()
+
+		fmt.Println("DNS service stopped and system DNS settings restored.")
+		return nil
+	}
+
+	// 读取PID，过期的PID文件会被自动清理
+	pid := getRunningPID()
 	if pid <= 0 {
 		fmt.Println("No DNS service is running.")
-		// 如果没有运行的服务但PID文件存在，尝试删除
-		if _, err := os.Stat(DNSPIDFile); err == nil {
-			sudoSession.RunWithPrivileges("rm", DNSPIDFile)
-		}
+		// 服务崩溃后仍可能留有 --take-over 的修改
+		restoreResolver()
 		return nil
 	}
 
 	fmt.Printf("Stopping DNS service (PID: %d)...\n", pid)
 
-	// 使用sudo发送终止信号
-	if err := sudoSession.RunWithPrivileges("kill", fmt.Sprintf("%d", pid)); err != nil {
+	// 使用sudo发送终止信号，守护进程收到SIGTERM后会自行恢复系统DNS
+	clean := false
+	if err := sudoSession.RunWithPrivileges("kill", fmt.Sprintf("%d", pid)); err == nil {
+		clean = waitForCleanExit(pid, dnsStopTimeout)
+	}
+	if !clean && isProcessAlive(pid) {
 		// 如果普通终止失败，尝试强制终止
 		fmt.Println("Attempting force kill...")
 		if err := sudoSession.RunWithPrivileges("kill", "-9", fmt.Sprintf("%d", pid)); err != nil {
@@ -1367,15 +5511,16 @@ func stopDNS() error {
 		}
 	}
 
-	// 删除PID文件
-	if err := sudoSession.RunWithPrivileges("rm", "-f", DNSPIDFile); err != nil {
-		fmt.Printf("Warning: could not remove PID file: %v\n", err)
-	}
+	// 进程正常退出时会删除PID文件，这里确保其被清理
+	removePIDFile(DNSPIDFile)
 
-	// 恢复系统DNS设置
-	if err := dns.RestoreSystemDNS(); err != nil {
-		return fmt.Errorf("failed to restore system DNS: %w", err)
+	// 仅在守护进程未能正常退出时由这里恢复系统DNS
+	if !clean {
+		if err := restoreSystemDNS(); err != nil {
+			return fmt.Errorf("failed to restore system DNS: %w", err)
+		}
 	}
+	restoreResolver()
 
 	fmt.Println("DNS service stopped and system DNS settings restored.")
 	return nil
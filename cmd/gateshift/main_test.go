@@ -0,0 +1,486 @@
+package main
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ourines/GateShift/internal/dns"
+	"github.com/ourines/GateShift/internal/gateway"
+	"github.com/ourines/GateShift/pkg/config"
+)
+
+func TestVerifyChecksum(t *testing.T) {
+	binary := []byte("gateshift binary")
+	sum := sha256.Sum256(binary)
+	good := hex.EncodeToString(sum[:])
+	bad := strings.Repeat("0", 64)
+
+	filePath := filepath.Join(t.TempDir(), "gateshift")
+	if err := os.WriteFile(filePath, binary, 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	files := map[string]string{
+		"/match/checksums.txt":    fmt.Sprintf("%s  gateshift-darwin-amd64\n%s *gateshift-linux-amd64\n", bad, good),
+		"/mismatch/checksums.txt": fmt.Sprintf("%s  gateshift-linux-amd64\n", bad),
+		"/missing/checksums.txt":  fmt.Sprintf("%s  gateshift-darwin-amd64\n", good),
+		"/single/asset.sha256":    good + "\n",
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, ok := files[r.URL.Path]
+		if !ok {
+			http.NotFound(w, r)
+			return
+		}
+		fmt.Fprint(w, body)
+	}))
+	defer server.Close()
+
+	tests := []struct {
+		path    string
+		wantErr string
+	}{
+		{"/match/checksums.txt", ""},
+		{"/single/asset.sha256", ""},
+		{"/mismatch/checksums.txt", "mismatch"},
+		{"/missing/checksums.txt", "no checksum listed"},
+		{"/absent/checksums.txt", "status 404"},
+	}
+	for _, tt := range tests {
+		err := verifyChecksum(filePath, server.URL+tt.path, "gateshift-linux-amd64")
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.path, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: error = %v, want containing %q", tt.path, err, tt.wantErr)
+		}
+	}
+}
+
+func TestPlatformAssetName(t *testing.T) {
+	tests := []struct {
+		goos, goarch string
+		want         string
+		wantErr      bool
+	}{
+		{"linux", "amd64", "gateshift-linux-amd64", false},
+		{"linux", "arm64", "gateshift-linux-arm64", false},
+		{"linux", "arm", "gateshift-linux-arm", false},
+		{"darwin", "amd64", "gateshift-darwin-amd64", false},
+		{"darwin", "arm64", "gateshift-darwin-arm64", false},
+		{"windows", "amd64", "gateshift-windows-amd64.exe", false},
+		{"windows", "arm64", "gateshift-windows-arm64.exe", false},
+		{"linux", "386", "", true},
+		{"windows", "386", "", true},
+		{"windows", "arm", "", true},
+		{"freebsd", "amd64", "", true},
+	}
+	for _, tt := range tests {
+		got, err := platformAssetName(tt.goos, tt.goarch)
+		if (err != nil) != tt.wantErr || got != tt.want {
+			t.Errorf("platformAssetName(%s, %s) = %q, %v; want %q, error %v", tt.goos, tt.goarch, got, err, tt.want, tt.wantErr)
+		}
+	}
+}
+
+func TestSelectReleaseAsset(t *testing.T) {
+	assets := []releaseAsset{
+		{Name: "gateshift-linux-arm64.tar.gz", BrowserDownloadURL: "https://example.com/linux-arm64.tar.gz"},
+		{Name: "gateshift-windows-arm64.exe", BrowserDownloadURL: "https://example.com/windows-arm64.exe"},
+		{Name: "gateshift-windows-arm64.exe.sha256", BrowserDownloadURL: "https://example.com/windows-arm64.exe.sha256"},
+		{Name: "checksums.txt", BrowserDownloadURL: "https://example.com/checksums.txt"},
+	}
+
+	name, url, sumURL, err := selectReleaseAsset(assets, "windows", "arm64")
+	if err != nil || name != "gateshift-windows-arm64.exe" || url != "https://example.com/windows-arm64.exe" ||
+		sumURL != "https://example.com/windows-arm64.exe.sha256" {
+		t.Errorf("windows/arm64: got %s %s %s %v", name, url, sumURL, err)
+	}
+
+	name, _, sumURL, err = selectReleaseAsset(assets, "linux", "arm64")
+	if err != nil || name != "gateshift-linux-arm64.tar.gz" || sumURL != "https://example.com/checksums.txt" {
+		t.Errorf("linux/arm64: got %s %s %v", name, sumURL, err)
+	}
+
+	if _, _, _, err := selectReleaseAsset(assets, "darwin", "amd64"); err == nil || !strings.Contains(err.Error(), "available") {
+		t.Errorf("darwin/amd64: expected missing-asset error listing available assets, got %v", err)
+	}
+}
+
+// fakeEditor points $EDITOR at a script that overwrites the edited file
+// with content
+func fakeEditor(t *testing.T, content string) {
+	t.Helper()
+	if runtime.GOOS == "windows" {
+		t.Skip("editor script requires sh")
+	}
+
+	dir := t.TempDir()
+	src := filepath.Join(dir, "content.yaml")
+	if err := os.WriteFile(src, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	script := filepath.Join(dir, "editor.sh")
+	if err := os.WriteFile(script, []byte("#!/bin/sh\ncat '"+src+"' > \"$1\"\n"), 0755); err != nil {
+		t.Fatal(err)
+	}
+	t.Setenv("VISUAL", "")
+	t.Setenv("EDITOR", script)
+}
+
+func TestEditConfigFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte("proxy_gateway: 192.168.1.2\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	edited := "proxy_gateway: 192.168.1.3\ndefault_gateway: 192.168.1.1\n"
+	fakeEditor(t, edited)
+	if err := editConfigFile(path); err != nil {
+		t.Fatal(err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != edited {
+		t.Errorf("config = %q, want %q", data, edited)
+	}
+	if info, err := os.Stat(path); err != nil || info.Mode().Perm() != 0600 {
+		t.Errorf("config mode = %v, %v, want 0600", info.Mode().Perm(), err)
+	}
+	if matches, _ := filepath.Glob(filepath.Join(filepath.Dir(path), ".config-*")); len(matches) > 0 {
+		t.Errorf("temporary files left behind: %v", matches)
+	}
+}
+
+func TestEditConfigFileRejectsInvalid(t *testing.T) {
+	original := "proxy_gateway: 192.168.1.2\n"
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(original), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	fakeEditor(t, "proxy_gateway: router\n")
+
+	// Decline to reopen the editor
+	r, w, err := os.Pipe()
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.WriteString("n\n")
+	w.Close()
+	stdin := os.Stdin
+	os.Stdin = r
+	defer func() { os.Stdin = stdin }()
+
+	if err := editConfigFile(path); err == nil || !strings.Contains(err.Error(), "discarded") {
+		t.Fatalf("error = %v, want changes discarded", err)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(data) != original {
+		t.Errorf("config modified after failed validation: %q", data)
+	}
+}
+
+// statusServer serves the control API status endpoint, returning statuses in
+// turn and repeating the last one
+func statusServer(t *testing.T, statuses ...dns.StatusResponse) *dns.ControlClient {
+	t.Helper()
+	var calls int32
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		i := int(atomic.AddInt32(&calls, 1)) - 1
+		if i >= len(statuses) {
+			i = len(statuses) - 1
+		}
+		json.NewEncoder(w).Encode(statuses[i])
+	}))
+	t.Cleanup(server.Close)
+
+	tokenFile := filepath.Join(t.TempDir(), "control.token")
+	if err := os.WriteFile(tokenFile, []byte("token"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return dns.NewControlClient(strings.TrimPrefix(server.URL, "http://"), tokenFile)
+}
+
+func TestWaitForReload(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.DNS.UpstreamDNS = []string{"1.1.1.1:53"}
+	cfg.DNS.Strategy = dns.StrategyPriority
+	cfg.DNS.FilterAAAA = true
+
+	before := dns.StatusResponse{Upstreams: []string{"8.8.8.8:53"}, Strategy: dns.StrategyParallel}
+	reloaded := dns.StatusResponse{Upstreams: []string{"1.1.1.1:53"}, Strategy: dns.StrategyPriority, FilterAAAA: true}
+
+	after, err := waitForReload(statusServer(t, before, before, reloaded), cfg, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	changes := reloadChanges(&before, after)
+	if len(changes) != 3 {
+		t.Fatalf("reloadChanges() = %q, want 3 changes", changes)
+	}
+	if changes[0] != "Upstream DNS: 8.8.8.8:53 -> 1.1.1.1:53" {
+		t.Errorf("upstream change = %q", changes[0])
+	}
+
+	// The daemon rejected the new config and keeps reporting the old one
+	if _, err := waitForReload(statusServer(t, before), cfg, 300*time.Millisecond); err == nil {
+		t.Error("waitForReload succeeded although the daemon kept its previous configuration")
+	}
+}
+
+func TestLeakTestPassed(t *testing.T) {
+	proxied := leakInterface{InterfaceDNS: dns.InterfaceDNS{Interface: "Wi-Fi", Servers: []string{"127.0.0.1"}}, UsesProxy: true}
+	dhcp := leakInterface{InterfaceDNS: dns.InterfaceDNS{Interface: "Ethernet", Automatic: true}}
+
+	activeProxied := proxied
+	activeProxied.Active = true
+	activeDHCP := dhcp
+	activeDHCP.Active = true
+
+	tests := []struct {
+		name   string
+		report leakReport
+		want   bool
+	}{
+		{"active uses proxy", leakReport{ProxyRunning: true, Interfaces: []leakInterface{activeProxied, dhcp}}, true},
+		{"active bypasses proxy", leakReport{ProxyRunning: true, Interfaces: []leakInterface{proxied, activeDHCP}}, false},
+		{"proxy stopped", leakReport{ProxyRunning: false, Interfaces: []leakInterface{activeProxied}}, false},
+		{"no active interface known", leakReport{ProxyRunning: true, Interfaces: []leakInterface{proxied, dhcp}}, false},
+		{"system DNS unreadable", leakReport{ProxyRunning: true, SystemDNSError: "failed"}, false},
+	}
+	for _, tt := range tests {
+		if got := leakTestPassed(&tt.report); got != tt.want {
+			t.Errorf("%s: leakTestPassed() = %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestAppendUpstreams(t *testing.T) {
+	existing := []string{"8.8.8.8:53", "1.1.1.1:53"}
+
+	upstreams, added := appendUpstreams(existing, []string{"1.1.1.1:53", "9.9.9.9:53"})
+	if strings.Join(upstreams, " ") != "8.8.8.8:53 1.1.1.1:53 9.9.9.9:53" {
+		t.Errorf("upstreams = %v", upstreams)
+	}
+	if strings.Join(added, " ") != "9.9.9.9:53" {
+		t.Errorf("added = %v, want [9.9.9.9:53]", added)
+	}
+
+	// Appending only duplicates changes nothing
+	upstreams, added = appendUpstreams(existing, []string{"8.8.8.8:53"})
+	if len(added) != 0 || strings.Join(upstreams, " ") != strings.Join(existing, " ") {
+		t.Errorf("duplicate append = %v (added %v), want %v unchanged", upstreams, added, existing)
+	}
+	if existing[0] != "8.8.8.8:53" || len(existing) != 2 {
+		t.Errorf("appendUpstreams modified its input: %v", existing)
+	}
+}
+
+func TestRemoveUpstream(t *testing.T) {
+	upstreams, found := removeUpstream([]string{"8.8.8.8:53", "1.1.1.1:53"}, "8.8.8.8:53")
+	if !found || strings.Join(upstreams, " ") != "1.1.1.1:53" {
+		t.Errorf("removeUpstream = %v, %v", upstreams, found)
+	}
+	if _, found := removeUpstream([]string{"1.1.1.1:53"}, "9.9.9.9:53"); found {
+		t.Error("removeUpstream found a server that is not configured")
+	}
+}
+
+func TestBlocklistDomains(t *testing.T) {
+	domains, err := normalizeBlocklist([]string{"Ads.Example.com.", "tracker.net", "ads.example.com"})
+	if err != nil || strings.Join(domains, " ") != "ads.example.com tracker.net" {
+		t.Fatalf("normalizeBlocklist = %v, %v", domains, err)
+	}
+	if _, err := normalizeBlocklist([]string{"bad..name"}); err == nil {
+		t.Error("normalizeBlocklist accepted an invalid domain")
+	}
+
+	blocklist, added := appendDomains([]string{"ads.example.com"}, domains)
+	if strings.Join(blocklist, " ") != "ads.example.com tracker.net" || strings.Join(added, " ") != "tracker.net" {
+		t.Errorf("appendDomains = %v, added %v", blocklist, added)
+	}
+
+	blocklist, removed := removeDomains(blocklist, []string{"TRACKER.NET.", "other.org"})
+	if strings.Join(blocklist, " ") != "ads.example.com" || strings.Join(removed, " ") != "tracker.net" {
+		t.Errorf("removeDomains = %v, removed %v", blocklist, removed)
+	}
+}
+
+func TestVersionInfoJSON(t *testing.T) {
+	data, err := json.Marshal(currentVersionInfo())
+	if err != nil {
+		t.Fatal(err)
+	}
+	var fields map[string]string
+	if err := json.Unmarshal(data, &fields); err != nil {
+		t.Fatal(err)
+	}
+	for _, key := range []string{"version", "buildTime", "commit", "goVersion", "os", "arch"} {
+		if fields[key] == "" {
+			t.Errorf("version JSON has no %q: %s", key, data)
+		}
+	}
+	if fields["os"] != runtime.GOOS || fields["arch"] != runtime.GOARCH {
+		t.Errorf("platform = %s/%s, want %s/%s", fields["os"], fields["arch"], runtime.GOOS, runtime.GOARCH)
+	}
+}
+
+func TestCompareVersions(t *testing.T) {
+	tests := []struct {
+		a, b string
+		want int
+	}{
+		{"v1.2.3", "1.2.3", 0},
+		{"1.2.3+build.5", "1.2.3", 0},
+		{"1.2.4", "1.2.3", 1},
+		{"1.10.0", "1.9.9", 1},
+		{"2.0.0", "1.99.99", 1},
+		{"1.2.3", "1.3.0", -1},
+		{"1.2.3-rc.1", "1.2.3", -1},
+		{"1.2.3-rc.1", "1.2.2", 1},
+		{"1.2.3-alpha", "1.2.3-beta", -1},
+		{"1.2.3-rc.2", "1.2.3-rc.10", -1},
+		{"1.2.3-rc.1", "1.2.3-rc", 1},
+		{"1.2.3-1", "1.2.3-alpha", -1},
+		// git describe builds count as the tag they are based on
+		{"v1.2.3-4-gabcdef0-dirty", "1.2.3", 0},
+	}
+	for _, tt := range tests {
+		a, okA := parseVersion(tt.a)
+		b, okB := parseVersion(tt.b)
+		if !okA || !okB {
+			t.Errorf("parseVersion(%q) = %t, parseVersion(%q) = %t", tt.a, okA, tt.b, okB)
+			continue
+		}
+		if got := compareVersions(a, b); got != tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.a, tt.b, got, tt.want)
+		}
+		if got := compareVersions(b, a); got != -tt.want {
+			t.Errorf("compareVersions(%q, %q) = %d, want %d", tt.b, tt.a, got, -tt.want)
+		}
+	}
+
+	for _, s := range []string{"dev", "unknown", "e342441", "1.2", "1.2.3.4", "01.2.3", "1.2.3-", "1.2.3-rc..1"} {
+		if _, ok := parseVersion(s); ok {
+			t.Errorf("parseVersion(%q) accepted a non-release version", s)
+		}
+	}
+}
+
+func TestGetRelease(t *testing.T) {
+	assetName, err := platformAssetName(runtime.GOOS, runtime.GOARCH)
+	if err != nil {
+		t.Skip(err)
+	}
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		release := func(tag string) {
+			fmt.Fprintf(w, `{"tag_name": %q, "assets": [
+				{"name": %q, "browser_download_url": "https://example.com/%[1]s/bin"},
+				{"name": "checksums.txt", "browser_download_url": "https://example.com/%[1]s/checksums.txt"}]}`, tag, assetName)
+		}
+		switch r.URL.Path {
+		case "/releases/latest":
+			release("v1.3.0")
+		case "/releases/tags/v1.2.3":
+			release("v1.2.3")
+		case "/releases":
+			fmt.Fprint(w, `[{"tag_name": "v1.3.0"}, {"tag_name": "v1.2.3"}]`)
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer server.Close()
+	defer func(api string) { releasesAPI = api }(releasesAPI)
+	releasesAPI = server.URL + "/releases"
+
+	version, downloadURL, checksumURL, err := getRelease("")
+	if err != nil || version != "1.3.0" || downloadURL != "https://example.com/v1.3.0/bin" {
+		t.Errorf("latest release = %s %s %v", version, downloadURL, err)
+	}
+
+	tag, err := releaseTag("1.2.3")
+	if err != nil || tag != "v1.2.3" {
+		t.Fatalf("releaseTag(1.2.3) = %q, %v", tag, err)
+	}
+	version, _, checksumURL, err = getRelease(tag)
+	if err != nil || version != "1.2.3" || checksumURL != "https://example.com/v1.2.3/checksums.txt" {
+		t.Errorf("pinned release = %s %s %v", version, checksumURL, err)
+	}
+
+	_, _, _, err = getRelease("v9.9.9")
+	if err == nil || !strings.Contains(err.Error(), "available: v1.3.0, v1.2.3") {
+		t.Errorf("missing release error = %v, want the available tags", err)
+	}
+
+	if _, err := releaseTag("latest"); err == nil {
+		t.Error("releaseTag accepted a non-version")
+	}
+}
+
+func TestUsesProxyGateway(t *testing.T) {
+	gateways := []string{"192.168.31.100", "fd00::100"}
+	tests := []struct {
+		gateway string
+		want    bool
+	}{
+		{"192.168.31.100", true},
+		{"fd00:0::100", true},
+		{"192.168.31.1", false},
+		// DHCP renewal removed the default route
+		{"", false},
+	}
+	for _, tt := range tests {
+		iface := &gateway.NetworkInterface{Name: "eth0", Gateway: tt.gateway}
+		if got := usesProxyGateway(iface, gateways); got != tt.want {
+			t.Errorf("usesProxyGateway(%q) = %v, want %v", tt.gateway, got, tt.want)
+		}
+	}
+}
+
+func TestGatewaySupervisorReport(t *testing.T) {
+	s := &gatewaySupervisor{}
+	s.report(fmt.Errorf("unreachable"))
+	s.report(fmt.Errorf("unreachable"))
+	if s.lastErr != "unreachable" {
+		t.Errorf("lastErr = %q, want the failure", s.lastErr)
+	}
+	s.report(nil)
+	if s.lastErr != "" {
+		t.Errorf("lastErr = %q after recovering", s.lastErr)
+	}
+}
+
+func TestSystemDNSSource(t *testing.T) {
+	tests := []struct {
+		entry dns.InterfaceDNS
+		want  string
+	}{
+		{dns.InterfaceDNS{Interface: "Wi-Fi", Automatic: true}, "DHCP"},
+		{dns.InterfaceDNS{Interface: "Ethernet"}, "none set"},
+		{dns.InterfaceDNS{Interface: "Wi-Fi", Servers: []string{"1.1.1.1"}}, "manual"},
+	}
+	for _, tt := range tests {
+		if got := systemDNSSource(tt.entry); got != tt.want {
+			t.Errorf("systemDNSSource(%+v) = %q, want %q", tt.entry, got, tt.want)
+		}
+	}
+}
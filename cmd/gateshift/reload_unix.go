@@ -0,0 +1,37 @@
+//go:build !windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+	"strconv"
+	"syscall"
+	"time"
+
+	"github.com/ourines/GateShift/internal/utils"
+	"github.com/ourines/GateShift/pkg/config"
+)
+
+// reloadSignals 是让运行中的DNS服务重新读取配置的信号
+var reloadSignals = []os.Signal{syscall.SIGHUP}
+
+// isReloadSignal 判断 sig 是否为重载信号
+func isReloadSignal(sig os.Signal) bool {
+	return sig == syscall.SIGHUP
+}
+
+// requestReload 向PID文件中的DNS守护进程发送SIGHUP，守护进程以root运行，需使用sudo
+func requestReload(cfg *config.Config) error {
+	pid := getRunningPID()
+	if pid <= 0 {
+		return fmt.Errorf("could not find the DNS service process in %s", DNSPIDFile)
+	}
+
+	sudoSession := utils.NewSudoSession(15 * time.Minute)
+	if err := sudoSession.RunWithPrivileges("kill", "-HUP", strconv.Itoa(pid)); err != nil {
+		return fmt.Errorf("failed to send SIGHUP to DNS service (PID: %d): %w", pid, err)
+	}
+	utils.Infof("Sent SIGHUP to DNS service (PID: %d)\n", pid)
+	return nil
+}
@@ -0,0 +1,35 @@
+//go:build windows
+
+package main
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/ourines/GateShift/internal/dns"
+	"github.com/ourines/GateShift/internal/utils"
+	"github.com/ourines/GateShift/pkg/config"
+)
+
+// reloadSignals 为空：Windows 没有 SIGHUP，通过控制API重新加载配置
+var reloadSignals []os.Signal
+
+// isReloadSignal 判断 sig 是否为重载信号
+func isReloadSignal(sig os.Signal) bool {
+	return false
+}
+
+// requestReload 通过控制API让DNS服务重新加载配置
+func requestReload(cfg *config.Config) error {
+	if cfg.DNS.ControlAddr == "" {
+		return fmt.Errorf("reloading on Windows requires the control API; set dns.control_addr (e.g. 127.0.0.1:5380) in the config and restart the DNS service")
+	}
+	if utils.DryRun() {
+		fmt.Printf("[dry-run] would reload DNS service through the control API at %s\n", cfg.DNS.ControlAddr)
+		return nil
+	}
+	if err := dns.NewControlClient(cfg.DNS.ControlAddr, controlTokenFile()).Reload(); err != nil {
+		return fmt.Errorf("failed to reload DNS service: %w", err)
+	}
+	return nil
+}
@@ -1,7 +1,134 @@
 package main
 
+import (
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
 // Version information, set by the build process
 var (
 	Version   = "dev"
 	BuildTime = "unknown"
+	Commit    = "unknown"
 )
+
+// versionInfo 是 version --json 输出的版本信息
+type versionInfo struct {
+	Version   string `json:"version"`
+	BuildTime string `json:"buildTime"`
+	Commit    string `json:"commit"`
+	GoVersion string `json:"goVersion"`
+	OS        string `json:"os"`
+	Arch      string `json:"arch"`
+}
+
+// currentVersionInfo 返回当前二进制文件的版本信息
+func currentVersionInfo() versionInfo {
+	return versionInfo{
+		Version:   Version,
+		BuildTime: BuildTime,
+		Commit:    Commit,
+		GoVersion: runtime.Version(),
+		OS:        runtime.GOOS,
+		Arch:      runtime.GOARCH,
+	}
+}
+
+// semVersion 是解析后的语义化版本号
+type semVersion struct {
+	major, minor, patch int
+	// prerelease 是预发布标识，如 "rc.1" 拆分为 ["rc", "1"]；正式版为空
+	prerelease []string
+}
+
+// gitDescribeSuffix 匹配 git describe 在标签之后追加的提交数与提交哈希
+var gitDescribeSuffix = regexp.MustCompile(`-[0-9]+-g[0-9a-f]+$`)
+
+// parseVersion 解析 "v1.2.3"、"1.2.3-rc.1" 形式的版本号，忽略 "+" 之后的构建元数据。
+// 由 git describe 生成的本地构建版本（如 v1.2.3-4-gabcdef-dirty）按其所基于的标签解析。
+func parseVersion(s string) (semVersion, bool) {
+	s = strings.TrimPrefix(strings.TrimSpace(s), "v")
+	if i := strings.IndexByte(s, '+'); i >= 0 {
+		s = s[:i]
+	}
+	s = strings.TrimSuffix(s, "-dirty")
+	s = gitDescribeSuffix.ReplaceAllString(s, "")
+
+	var v semVersion
+	core := s
+	if i := strings.IndexByte(s, '-'); i >= 0 {
+		core = s[:i]
+		v.prerelease = strings.Split(s[i+1:], ".")
+		for _, id := range v.prerelease {
+			if id == "" {
+				return semVersion{}, false
+			}
+		}
+	}
+
+	parts := strings.Split(core, ".")
+	if len(parts) != 3 {
+		return semVersion{}, false
+	}
+	numbers := []*int{&v.major, &v.minor, &v.patch}
+	for i, part := range parts {
+		n, err := strconv.Atoi(part)
+		if err != nil || n < 0 || part != strconv.Itoa(n) {
+			return semVersion{}, false
+		}
+		*numbers[i] = n
+	}
+	return v, true
+}
+
+// compareVersions 按语义化版本的优先级比较 a 与 b，a 较旧时返回 -1，相同时返回 0，较新时返回 1。
+// 预发布版本旧于对应的正式版本。
+func compareVersions(a, b semVersion) int {
+	for _, pair := range [][2]int{{a.major, b.major}, {a.minor, b.minor}, {a.patch, b.patch}} {
+		if pair[0] != pair[1] {
+			return compareInts(pair[0], pair[1])
+		}
+	}
+
+	switch {
+	case len(a.prerelease) == 0 && len(b.prerelease) == 0:
+		return 0
+	case len(a.prerelease) == 0:
+		return 1
+	case len(b.prerelease) == 0:
+		return -1
+	}
+	for i := 0; i < len(a.prerelease) && i < len(b.prerelease); i++ {
+		if c := comparePrereleaseID(a.prerelease[i], b.prerelease[i]); c != 0 {
+			return c
+		}
+	}
+	return compareInts(len(a.prerelease), len(b.prerelease))
+}
+
+// comparePrereleaseID 比较单个预发布标识：数字按数值比较且旧于字母标识，字母标识按字典序比较
+func comparePrereleaseID(a, b string) int {
+	na, errA := strconv.Atoi(a)
+	nb, errB := strconv.Atoi(b)
+	switch {
+	case errA == nil && errB == nil:
+		return compareInts(na, nb)
+	case errA == nil:
+		return -1
+	case errB == nil:
+		return 1
+	}
+	return strings.Compare(a, b)
+}
+
+func compareInts(a, b int) int {
+	switch {
+	case a < b:
+		return -1
+	case a > b:
+		return 1
+	}
+	return 0
+}
@@ -7,8 +7,10 @@ ARCH=$(uname -m)
 # 将 x86_64 映射到 amd64
 if [ "$ARCH" = "x86_64" ]; then
     ARCH="amd64"
-elif [ "$ARCH" = "aarch64" ]; then
+elif [ "$ARCH" = "aarch64" ] || [ "$ARCH" = "arm64" ]; then
     ARCH="arm64"
+elif [ "${ARCH#armv}" != "$ARCH" ]; then
+    ARCH="arm"
 fi
 
 # 最新版本
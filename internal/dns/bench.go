@@ -0,0 +1,211 @@
+package dns
+
+import (
+	"bufio"
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+// benchCacheHitLatency is the latency below which a response is assumed to
+// have been answered from the resolver's cache
+const benchCacheHitLatency = 2 * time.Millisecond
+
+// benchNames are queried when no names file is supplied
+var benchNames = []string{
+	"google.com", "youtube.com", "facebook.com", "wikipedia.org", "amazon.com",
+	"github.com", "apple.com", "microsoft.com", "cloudflare.com", "netflix.com",
+	"baidu.com", "qq.com", "bilibili.com", "taobao.com", "openwrt.org",
+}
+
+// BenchOptions configures a DNS load test
+type BenchOptions struct {
+	Server   string
+	QPS      int
+	Duration time.Duration
+	Timeout  time.Duration
+	Names    []string
+}
+
+// BenchResult summarizes a DNS load test
+type BenchResult struct {
+	Server        string  `json:"server"`
+	Duration      float64 `json:"duration_seconds"`
+	Sent          int     `json:"sent"`
+	Succeeded     int     `json:"succeeded"`
+	Errors        int     `json:"errors"`
+	ErrorRate     float64 `json:"error_rate"`
+	Throughput    float64 `json:"throughput_qps"`
+	P50Ms         float64 `json:"p50_ms"`
+	P95Ms         float64 `json:"p95_ms"`
+	P99Ms         float64 `json:"p99_ms"`
+	CacheHitRatio float64 `json:"cache_hit_ratio"`
+}
+
+// LoadBenchNames reads query names from a file, one per line. Blank lines
+// and lines starting with # are ignored.
+func LoadBenchNames(path string) ([]string, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	var names []string
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		names = append(names, line)
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if len(names) == 0 {
+		return nil, fmt.Errorf("no names found in %s", path)
+	}
+	return names, nil
+}
+
+// RunBenchmark sends A queries to a resolver at a fixed rate for the given
+// duration and reports throughput, latency percentiles and error rate
+func RunBenchmark(opts BenchOptions) (*BenchResult, error) {
+	if opts.QPS <= 0 {
+		return nil, fmt.Errorf("qps must be positive")
+	}
+	if opts.Duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	names := opts.Names
+	if len(names) == 0 {
+		names = benchNames
+	}
+	if _, err := net.ResolveUDPAddr("udp", opts.Server); err != nil {
+		return nil, fmt.Errorf("invalid server address %s: %w", opts.Server, err)
+	}
+
+	var (
+		mu        sync.Mutex
+		latencies []time.Duration
+		failures  int
+		sent      int
+		wg        sync.WaitGroup
+	)
+
+	ticker := time.NewTicker(time.Second / time.Duration(opts.QPS))
+	defer ticker.Stop()
+	timer := time.NewTimer(opts.Duration)
+	defer timer.Stop()
+
+	start := time.Now()
+loop:
+	for {
+		select {
+		case <-timer.C:
+			break loop
+		case <-ticker.C:
+			name := names[sent%len(names)]
+			sent++
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				latency, err := benchQuery(opts.Server, name, opts.Timeout)
+				mu.Lock()
+				defer mu.Unlock()
+				if err != nil {
+					failures++
+					return
+				}
+				latencies = append(latencies, latency)
+			}()
+		}
+	}
+	wg.Wait()
+	elapsed := time.Since(start)
+
+	result := &BenchResult{
+		Server:     opts.Server,
+		Duration:   elapsed.Seconds(),
+		Sent:       sent,
+		Succeeded:  len(latencies),
+		Errors:     failures,
+		Throughput: float64(len(latencies)) / elapsed.Seconds(),
+	}
+	if sent > 0 {
+		result.ErrorRate = float64(failures) / float64(sent)
+	}
+
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		result.P50Ms = percentileMs(latencies, 0.50)
+		result.P95Ms = percentileMs(latencies, 0.95)
+		result.P99Ms = percentileMs(latencies, 0.99)
+
+		hits := sort.Search(len(latencies), func(i int) bool { return latencies[i] >= benchCacheHitLatency })
+		result.CacheHitRatio = float64(hits) / float64(len(latencies))
+	}
+
+	return result, nil
+}
+
+// percentileMs returns the p-th percentile of sorted latencies in milliseconds
+func percentileMs(sorted []time.Duration, p float64) float64 {
+	i := int(float64(len(sorted))*p+0.5) - 1
+	if i < 0 {
+		i = 0
+	}
+	if i >= len(sorted) {
+		i = len(sorted) - 1
+	}
+	return float64(sorted[i].Microseconds()) / 1000
+}
+
+// benchQuery sends one A query and returns its latency
+func benchQuery(server, name string, timeout time.Duration) (time.Duration, error) {
+	var idBytes [2]byte
+	rand.Read(idBytes[:])
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	query, err := BuildQuery(id, name, TypeA)
+	if err != nil {
+		return 0, err
+	}
+
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	start := time.Now()
+	if _, err := conn.Write(query); err != nil {
+		return 0, err
+	}
+
+	buf := make([]byte, 4096)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return 0, err
+	}
+	latency := time.Since(start)
+
+	if n < headerSize || binary.BigEndian.Uint16(buf[0:2]) != id {
+		return 0, fmt.Errorf("invalid response")
+	}
+	if rcode := int(buf[3] & 0x0f); rcode != 0 && rcode != 3 {
+		return 0, fmt.Errorf("server returned %s", rcodeString(rcode))
+	}
+	return latency, nil
+}
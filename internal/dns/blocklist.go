@@ -0,0 +1,238 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+)
+
+// Responses to queries for blocked domains
+const (
+	// BlockNXDomain answers that the domain does not exist
+	BlockNXDomain = "nxdomain"
+	// BlockZeroIP answers A queries with 0.0.0.0 and AAAA queries with ::,
+	// and other types without records
+	BlockZeroIP = "zeroip"
+	// BlockRefused refuses the query
+	BlockRefused = "refused"
+)
+
+// blockTTL is the TTL of the 0.0.0.0 and :: answers to blocked queries
+const blockTTL = 60
+
+// Response codes used for blocked queries
+const (
+	rcodeNXDomain = 3
+	rcodeRefused  = 5
+)
+
+// ValidateBlockResponse checks that mode is a supported blocklist response
+func ValidateBlockResponse(mode string) error {
+	switch mode {
+	case BlockNXDomain, BlockZeroIP, BlockRefused:
+		return nil
+	}
+	return fmt.Errorf("invalid blocklist response: %s (must be %s, %s or %s)", mode, BlockNXDomain, BlockZeroIP, BlockRefused)
+}
+
+// NormalizeBlockedDomain returns domain in the form the blocklist stores it:
+// lower case, without a trailing dot
+func NormalizeBlockedDomain(domain string) (string, error) {
+	name := strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+	if name == "" || len(name) > 253 {
+		return "", fmt.Errorf("invalid domain: %q", domain)
+	}
+	for _, label := range strings.Split(name, ".") {
+		if len(label) == 0 || len(label) > 63 || strings.ContainsAny(label, " \t/:") {
+			return "", fmt.Errorf("invalid domain: %q", domain)
+		}
+	}
+	return name, nil
+}
+
+// newBlocklist validates domains and returns them as a set
+func newBlocklist(domains []string) (map[string]bool, error) {
+	blocked := make(map[string]bool, len(domains))
+	for _, domain := range domains {
+		name, err := NormalizeBlockedDomain(domain)
+		if err != nil {
+			return nil, err
+		}
+		blocked[name] = true
+	}
+	return blocked, nil
+}
+
+// SetBlocklist replaces the blocked domains. Queries for a blocked domain or
+// any of its subdomains are answered by the proxy as set by SetBlockResponse
+// instead of being forwarded.
+func (p *DNSProxy) SetBlocklist(domains []string) error {
+	blocked, err := newBlocklist(domains)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blocklist = blocked
+	return nil
+}
+
+// Blocklist returns the blocked domains in sorted order
+func (p *DNSProxy) Blocklist() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return sortedDomains(p.blocklist)
+}
+
+func sortedDomains(blocked map[string]bool) []string {
+	domains := make([]string, 0, len(blocked))
+	for domain := range blocked {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// SetBlockResponse sets how queries for blocked domains are answered
+func (p *DNSProxy) SetBlockResponse(mode string) error {
+	if err := ValidateBlockResponse(mode); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.blockResponse = mode
+	return nil
+}
+
+// BlockResponse returns how queries for blocked domains are answered
+func (p *DNSProxy) BlockResponse() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.blockResponse
+}
+
+// blockedBy returns the blocklist response for name, or "" if neither name
+// nor any of its parent domains is blocked
+func (p *DNSProxy) blockedBy(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if len(p.blocklist) == 0 {
+		return ""
+	}
+	for {
+		if p.blocklist[name] {
+			return p.blockResponse
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return ""
+		}
+		name = name[i+1:]
+	}
+}
+
+// blockedResponse builds the answer to query, of type qtype, for a blocked
+// domain. It carries the transaction ID and question of the query.
+func blockedResponse(query []byte, qtype uint16, mode string) ([]byte, error) {
+	response, err := emptyResponse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	switch mode {
+	case BlockRefused:
+		response[3] |= rcodeRefused
+	case BlockZeroIP:
+		switch qtype {
+		case TypeA:
+			return addressResponse(query, qtype, []net.IP{net.IPv4zero}, blockTTL)
+		case TypeAAAA:
+			return addressResponse(query, qtype, []net.IP{net.IPv6zero}, blockTTL)
+		}
+		// No address records exist for other types: NOERROR without records
+	default:
+		response[3] |= rcodeNXDomain
+	}
+	return response, nil
+}
+
+// maxBlockedNames bounds the names counted individually, so a client
+// querying random subdomains of a blocked domain cannot grow the counts
+// without limit. Blocks of further names still count towards the total.
+const maxBlockedNames = 10000
+
+// BlockedName is the number of times queries for a name were blocked
+type BlockedName struct {
+	Name  string `json:"name"`
+	Count uint64 `json:"count"`
+}
+
+// BlocklistStats reports the queries blocked since the proxy started
+type BlocklistStats struct {
+	Total uint64        `json:"total"`
+	Top   []BlockedName `json:"top"`
+}
+
+// blockTracker counts blocked queries, in total and per query name
+type blockTracker struct {
+	// Accessed atomically; kept first for 64-bit alignment on 32-bit platforms
+	total uint64
+
+	mu     sync.Mutex
+	byName map[string]uint64
+}
+
+func newBlockTracker() *blockTracker {
+	return &blockTracker{byName: make(map[string]uint64)}
+}
+
+// blocked records a blocked query for name
+func (t *blockTracker) blocked(name string) {
+	atomic.AddUint64(&t.total, 1)
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if _, ok := t.byName[name]; ok || len(t.byName) < maxBlockedNames {
+		t.byName[name]++
+	}
+}
+
+// count returns the number of blocked queries
+func (t *blockTracker) count() uint64 {
+	return atomic.LoadUint64(&t.total)
+}
+
+// top returns the n most blocked names, most blocked first; all of them if
+// n is not positive
+func (t *blockTracker) top(n int) []BlockedName {
+	t.mu.Lock()
+	names := make([]BlockedName, 0, len(t.byName))
+	for name, count := range t.byName {
+		names = append(names, BlockedName{Name: name, Count: count})
+	}
+	t.mu.Unlock()
+
+	sort.Slice(names, func(i, j int) bool {
+		if names[i].Count != names[j].Count {
+			return names[i].Count > names[j].Count
+		}
+		return names[i].Name < names[j].Name
+	})
+	if n > 0 && len(names) > n {
+		names = names[:n]
+	}
+	return names
+}
+
+// BlocklistStats returns the number of blocked queries and the top most
+// blocked names; all of them if top is not positive
+func (p *DNSProxy) BlocklistStats(top int) BlocklistStats {
+	return BlocklistStats{Total: p.blocks.count(), Top: p.blocks.top(top)}
+}
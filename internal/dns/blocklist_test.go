@@ -0,0 +1,200 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+func TestBlockedResponseModes(t *testing.T) {
+	tests := []struct {
+		mode   string
+		qtype  uint16
+		rcode  int
+		answer string
+	}{
+		{BlockNXDomain, TypeA, 3, ""},
+		{BlockNXDomain, TypeAAAA, 3, ""},
+		{BlockRefused, TypeA, 5, ""},
+		{BlockZeroIP, TypeA, 0, "0.0.0.0"},
+		{BlockZeroIP, TypeAAAA, 0, "::"},
+		{BlockZeroIP, TypeNS, 0, ""},
+	}
+
+	for _, tt := range tests {
+		query, err := BuildQuery(0xbeef, "ads.example.com", tt.qtype)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := blockedResponse(query, tt.qtype, tt.mode)
+		if err != nil {
+			t.Fatalf("%s %s: %v", tt.mode, TypeString(tt.qtype), err)
+		}
+
+		m, err := ParseMessage(response)
+		if err != nil {
+			t.Fatalf("%s %s: response does not parse: %v", tt.mode, TypeString(tt.qtype), err)
+		}
+		if m.ID != 0xbeef || !m.Response || m.Rcode != tt.rcode {
+			t.Errorf("%s %s: ID %#x, response %v, rcode %d; want %#x, true, %d", tt.mode, TypeString(tt.qtype), m.ID, m.Response, m.Rcode, 0xbeef, tt.rcode)
+		}
+		if len(m.Questions) != 1 || m.Questions[0] != (Question{Name: "ads.example.com.", Type: tt.qtype}) {
+			t.Errorf("%s %s: questions %+v do not match the query", tt.mode, TypeString(tt.qtype), m.Questions)
+		}
+
+		if tt.answer == "" {
+			if len(m.Answers) != 0 {
+				t.Errorf("%s %s: answers %+v, want none", tt.mode, TypeString(tt.qtype), m.Answers)
+			}
+			continue
+		}
+		want := ResourceRecord{Name: "ads.example.com.", Type: tt.qtype, TTL: blockTTL, Value: tt.answer}
+		if len(m.Answers) != 1 || m.Answers[0] != want {
+			t.Errorf("%s %s: answers %+v, want %+v", tt.mode, TypeString(tt.qtype), m.Answers, want)
+		}
+	}
+}
+
+func TestBlocklistMatchesSubdomains(t *testing.T) {
+	p := newTestProxy(t, StrategyPriority)
+	if err := p.SetBlocklist([]string{"Example.COM.", "tracker.net"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for name, blocked := range map[string]bool{
+		"example.com.":        true,
+		"ads.EXAMPLE.com.":    true,
+		"a.b.tracker.net.":    true,
+		"notexample.com.":     false,
+		"example.com.evil.":   false,
+		"net.":                false,
+		"othertracker.net.":   false,
+		"tracker.net.example": false,
+	} {
+		if got := p.blockedBy(name) != ""; got != blocked {
+			t.Errorf("blockedBy(%q) blocked = %v, want %v", name, got, blocked)
+		}
+	}
+
+	if err := p.SetBlocklist([]string{"bad..name"}); err == nil {
+		t.Error("SetBlocklist accepted an invalid domain")
+	}
+	if err := p.SetBlockResponse("sinkhole"); err == nil {
+		t.Error("SetBlockResponse accepted an invalid mode")
+	}
+}
+
+func TestBlockedQueryIsNotForwarded(t *testing.T) {
+	upstream := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyPriority)
+	if err := p.SetUpstreams([]string{upstream.addr}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetBlocklist([]string{"example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetBlockResponse(BlockRefused); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p.conn = conn
+
+	var logged []QueryEvent
+	p.SetQueryLogger(queryLoggerFunc(func(e QueryEvent) { logged = append(logged, e) }))
+
+	response := exchange(t, p, testQuery(t, 0x4242))
+	if rcode, _ := extractRcode(response); rcode != rcodeRefused {
+		t.Errorf("rcode = %d, want REFUSED", rcode)
+	}
+	if n := atomic.LoadInt32(&upstream.queries); n != 0 {
+		t.Errorf("blocked query was forwarded %d times", n)
+	}
+	if len(logged) != 1 || !logged[0].Blocked || logged[0].Rcode != "REFUSED" {
+		t.Errorf("query events = %+v, want one blocked REFUSED event", logged)
+	}
+	if stats := p.CacheStats(); stats.Blocked != 1 {
+		t.Errorf("CacheStats().Blocked = %d, want 1", stats.Blocked)
+	}
+}
+
+// queryLoggerFunc adapts a function to the QueryLogger interface
+type queryLoggerFunc func(QueryEvent)
+
+func (f queryLoggerFunc) LogQuery(e QueryEvent) { f(e) }
+
+func TestBlockTrackerConcurrent(t *testing.T) {
+	tracker := newBlockTracker()
+
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			for j := 0; j <= i; j++ {
+				tracker.blocked(fmt.Sprintf("host%d.example.com", i))
+			}
+		}(i)
+	}
+	wg.Wait()
+
+	if n := tracker.count(); n != 36 {
+		t.Errorf("count = %d, want 36", n)
+	}
+	top := tracker.top(2)
+	want := []BlockedName{{"host7.example.com", 8}, {"host6.example.com", 7}}
+	if len(top) != 2 || top[0] != want[0] || top[1] != want[1] {
+		t.Errorf("top(2) = %+v, want %+v", top, want)
+	}
+	if all := tracker.top(0); len(all) != 8 {
+		t.Errorf("top(0) returned %d names, want 8", len(all))
+	}
+}
+
+func TestBlockTrackerBoundsNames(t *testing.T) {
+	tracker := newBlockTracker()
+	for i := 0; i < maxBlockedNames+10; i++ {
+		tracker.blocked(fmt.Sprintf("r%d.example.com", i))
+	}
+	tracker.blocked("r0.example.com")
+
+	if n := tracker.count(); n != maxBlockedNames+11 {
+		t.Errorf("count = %d, want %d", n, maxBlockedNames+11)
+	}
+	top := tracker.top(0)
+	if len(top) != maxBlockedNames || top[0] != (BlockedName{"r0.example.com", 2}) {
+		t.Errorf("tracked %d names, most blocked %+v", len(top), top[0])
+	}
+}
+
+func TestHandleBlocklistStats(t *testing.T) {
+	p := newTestProxy(t, StrategyPriority)
+	p.blocks.blocked("a.example.com")
+	p.blocks.blocked("a.example.com")
+	p.blocks.blocked("b.example.com")
+
+	rec := httptest.NewRecorder()
+	p.handleBlocklistStats(rec, httptest.NewRequest(http.MethodGet, "/blocklist/stats?top=1", nil))
+	var stats BlocklistStats
+	if err := json.Unmarshal(rec.Body.Bytes(), &stats); err != nil {
+		t.Fatalf("status %d: %v", rec.Code, err)
+	}
+	if stats.Total != 3 || len(stats.Top) != 1 || stats.Top[0] != (BlockedName{"a.example.com", 2}) {
+		t.Errorf("stats = %+v", stats)
+	}
+
+	rec = httptest.NewRecorder()
+	p.handleBlocklistStats(rec, httptest.NewRequest(http.MethodGet, "/blocklist/stats?top=x", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("invalid top: status %d, want 400", rec.Code)
+	}
+}
@@ -0,0 +1,294 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// maxCacheEntries bounds the number of cached responses
+	maxCacheEntries = 10000
+	// cacheCleanupInterval is how often expired entries are purged
+	cacheCleanupInterval = time.Minute
+)
+
+// CacheStats holds statistics about the response cache
+type CacheStats struct {
+	Entries int    `json:"entries"`
+	Hits    uint64 `json:"hits"`
+	Misses  uint64 `json:"misses"`
+	// Blocked counts queries answered from the blocklist
+	Blocked uint64 `json:"blocked"`
+}
+
+// CacheEntry describes a cached response without exposing its raw bytes
+type CacheEntry struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+	// Flags lists the DNSSEC query flags ("do", "cd") the response was cached for
+	Flags string `json:"flags,omitempty"`
+	TTL   int    `json:"ttl"`
+	Size  int    `json:"size"`
+}
+
+// cacheEntry is a cached upstream response
+type cacheEntry struct {
+	response []byte
+	stored   time.Time
+	expires  time.Time
+}
+
+// cacheKey identifies a cached response. The DO and CD bits of the query are
+// part of the key: a DNSSEC-validating client needs the signatures a DO query
+// returns, and a CD query may be answered with data a validating upstream
+// would reject, so neither may be served to a client that asked differently.
+type cacheKey struct {
+	name  string
+	qtype uint16
+	do    bool
+	cd    bool
+}
+
+// newCacheKey builds the cache key of the question name and type in query
+func newCacheKey(name string, qtype uint16, query []byte) cacheKey {
+	do, cd := dnssecFlags(query)
+	return cacheKey{name: strings.ToLower(name), qtype: qtype, do: do, cd: cd}
+}
+
+// flags returns the DNSSEC flags of the key as a comma-separated list
+func (k cacheKey) flags() string {
+	var flags []string
+	if k.do {
+		flags = append(flags, "do")
+	}
+	if k.cd {
+		flags = append(flags, "cd")
+	}
+	return strings.Join(flags, ",")
+}
+
+// dnsCache caches upstream responses keyed by question and DNSSEC flags
+type dnsCache struct {
+	// Accessed atomically; kept first for 64-bit alignment on 32-bit platforms
+	hits   uint64
+	misses uint64
+
+	mu      sync.RWMutex
+	entries map[cacheKey]*cacheEntry
+	// minTTL and maxTTL clamp how long responses are cached; zero leaves
+	// that side unbounded
+	minTTL time.Duration
+	maxTTL time.Duration
+	// staleAge is how long entries are kept after they expire, to be served
+	// when the upstreams fail; zero removes them once expired
+	staleAge time.Duration
+}
+
+func newDNSCache() *dnsCache {
+	return &dnsCache{entries: make(map[cacheKey]*cacheEntry)}
+}
+
+// get returns a copy of the cached response for key with its ID set to id and
+// its TTLs reduced by the time spent in the cache
+func (c *dnsCache) get(key cacheKey, id uint16) ([]byte, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	c.mu.RUnlock()
+
+	now := time.Now()
+	if !ok || now.After(entry.expires) {
+		atomic.AddUint64(&c.misses, 1)
+		return nil, false
+	}
+	atomic.AddUint64(&c.hits, 1)
+
+	response := make([]byte, len(entry.response))
+	copy(response, entry.response)
+	binary.BigEndian.PutUint16(response[0:2], id)
+
+	elapsed := uint32(now.Sub(entry.stored).Seconds())
+	walkRecords(response, func(rrType uint16, ttlOffset int) {
+		if rrType == typeOPT {
+			return
+		}
+		ttl := binary.BigEndian.Uint32(response[ttlOffset : ttlOffset+4])
+		if ttl > elapsed {
+			ttl -= elapsed
+		} else {
+			ttl = 0
+		}
+		binary.BigEndian.PutUint32(response[ttlOffset:ttlOffset+4], ttl)
+	})
+
+	return response, true
+}
+
+// set caches a response if it is cacheable, using its smallest record TTL
+// clamped to the cache TTL bounds
+func (c *dnsCache) set(key cacheKey, response []byte) {
+	if len(response) < headerSize {
+		return
+	}
+	// Do not cache truncated responses or errors other than NXDOMAIN
+	if response[2]&0x02 != 0 {
+		return
+	}
+	if rcode := int(response[3] & 0x0f); rcode != 0 && rcode != 3 {
+		return
+	}
+
+	ttl, ok := minTTL(response)
+	if !ok {
+		return
+	}
+
+	stored := make([]byte, len(response))
+	copy(stored, response)
+	now := time.Now()
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	lifetime := time.Duration(ttl) * time.Second
+	if lifetime < c.minTTL {
+		lifetime = c.minTTL
+	}
+	if c.maxTTL > 0 && lifetime > c.maxTTL {
+		lifetime = c.maxTTL
+		// Clients must not keep the records longer than the cache does
+		capTTLs(stored, uint32(c.maxTTL/time.Second))
+	}
+	if lifetime <= 0 {
+		return
+	}
+
+	if _, exists := c.entries[key]; !exists && len(c.entries) >= maxCacheEntries {
+		c.removeExpiredLocked(now)
+		if len(c.entries) >= maxCacheEntries {
+			return
+		}
+	}
+	c.entries[key] = &cacheEntry{
+		response: stored,
+		stored:   now,
+		expires:  now.Add(lifetime),
+	}
+}
+
+// setTTLBounds sets the bounds on how long responses are cached. Entries
+// already cached keep their expiration.
+func (c *dnsCache) setTTLBounds(min, max time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.minTTL, c.maxTTL = min, max
+}
+
+// ttlBounds returns the bounds on how long responses are cached
+func (c *dnsCache) ttlBounds() (min, max time.Duration) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.minTTL, c.maxTTL
+}
+
+// clear removes all entries and returns how many were removed
+func (c *dnsCache) clear() int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	n := len(c.entries)
+	c.entries = make(map[cacheKey]*cacheEntry)
+	return n
+}
+
+// removeExpired purges expired entries, keeping those that may still be
+// served stale
+func (c *dnsCache) removeExpired() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.removeExpiredLocked(time.Now())
+}
+
+func (c *dnsCache) removeExpiredLocked(now time.Time) {
+	for key, entry := range c.entries {
+		if now.After(entry.expires.Add(c.staleAge)) {
+			delete(c.entries, key)
+		}
+	}
+}
+
+// snapshot returns the unexpired entries sorted by name and type
+func (c *dnsCache) snapshot() []CacheEntry {
+	now := time.Now()
+
+	c.mu.RLock()
+	entries := make([]CacheEntry, 0, len(c.entries))
+	for key, entry := range c.entries {
+		if now.After(entry.expires) {
+			continue
+		}
+		entries = append(entries, CacheEntry{
+			Name:  key.name,
+			Type:  typeString(key.qtype),
+			Flags: key.flags(),
+			TTL:   int(entry.expires.Sub(now).Seconds()),
+			Size:  len(entry.response),
+		})
+	}
+	c.mu.RUnlock()
+
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].Name != entries[j].Name {
+			return entries[i].Name < entries[j].Name
+		}
+		if entries[i].Type != entries[j].Type {
+			return entries[i].Type < entries[j].Type
+		}
+		return entries[i].Flags < entries[j].Flags
+	})
+	return entries
+}
+
+// stats returns the current cache statistics
+func (c *dnsCache) stats() CacheStats {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return CacheStats{
+		Entries: len(c.entries),
+		Hits:    atomic.LoadUint64(&c.hits),
+		Misses:  atomic.LoadUint64(&c.misses),
+	}
+}
+
+// ValidateCacheTTL checks the bounds on how long responses are cached; zero
+// leaves that side unbounded
+func ValidateCacheTTL(min, max time.Duration) error {
+	if min < 0 {
+		return fmt.Errorf("invalid cache minimum TTL: %v", min)
+	}
+	if max < 0 || max > 0 && max < time.Second {
+		return fmt.Errorf("invalid cache maximum TTL: %v (must be at least 1s, or 0 for no maximum)", max)
+	}
+	if max > 0 && min > max {
+		return fmt.Errorf("cache minimum TTL %v is greater than the maximum %v", min, max)
+	}
+	return nil
+}
+
+// SetCacheTTL caches responses for at least min and at most max, whatever
+// their record TTLs; zero leaves that side unbounded. Records in responses
+// served from the cache never have TTLs above max.
+func (p *DNSProxy) SetCacheTTL(min, max time.Duration) error {
+	if err := ValidateCacheTTL(min, max); err != nil {
+		return err
+	}
+	p.cache.setTTLBounds(min, max)
+	return nil
+}
+
+// CacheTTL returns the bounds on how long responses are cached
+func (p *DNSProxy) CacheTTL() (min, max time.Duration) {
+	return p.cache.ttlBounds()
+}
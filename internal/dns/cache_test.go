@@ -0,0 +1,165 @@
+package dns
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// withDO returns query with an OPT record that has the DO bit set
+func withDO(t *testing.T, query []byte) []byte {
+	t.Helper()
+	query, added := addEDNS0(query)
+	if !added {
+		t.Fatal("query already has an OPT record")
+	}
+	// The extended flags are the third and fourth bytes of the OPT TTL,
+	// followed by the 2-byte RDLENGTH
+	query[len(query)-4] |= 0x80
+	return query
+}
+
+func TestDNSSECFlags(t *testing.T) {
+	plain := testQuery(t, 1)
+	if do, cd := dnssecFlags(plain); do || cd {
+		t.Errorf("plain query: do=%v cd=%v, want neither", do, cd)
+	}
+
+	noDO, _ := addEDNS0(plain)
+	if do, _ := dnssecFlags(noDO); do {
+		t.Error("EDNS0 query without DO reported DO")
+	}
+
+	if do, _ := dnssecFlags(withDO(t, plain)); !do {
+		t.Error("DO bit not reported")
+	}
+
+	checkingDisabled := testQuery(t, 1)
+	checkingDisabled[3] |= 0x10
+	if _, cd := dnssecFlags(checkingDisabled); !cd {
+		t.Error("CD bit not reported")
+	}
+}
+
+func TestCacheSeparatesDNSSECQueries(t *testing.T) {
+	upstream := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetUpstreams([]string{upstream.addr}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p.conn = conn
+
+	// Cache an answer for a query without DO
+	plain := testQuery(t, 1)
+	p.cache.set(newCacheKey("example.com.", TypeA, plain), compressedResponse(t))
+
+	exchange(t, p, testQuery(t, 2))
+	if n := atomic.LoadInt32(&upstream.queries); n != 0 {
+		t.Errorf("DO-clear query forwarded %d times, want it served from the cache", n)
+	}
+
+	// The same name with DO set must not get the non-DO answer
+	exchange(t, p, withDO(t, testQuery(t, 3)))
+	if n := atomic.LoadInt32(&upstream.queries); n != 1 {
+		t.Errorf("DO-set query forwarded %d times, want 1", n)
+	}
+
+	entries := p.CacheEntries()
+	if len(entries) != 1 || entries[0].Flags != "" {
+		t.Errorf("cache entries = %+v, want only the DO-clear answer", entries)
+	}
+
+	// An answer cached for DO is kept apart from the DO-clear one
+	p.cache.set(newCacheKey("example.com.", TypeA, withDO(t, plain)), compressedResponse(t))
+	entries = p.CacheEntries()
+	if len(entries) != 2 || entries[0].Flags != "" || entries[1].Flags != "do" {
+		t.Errorf("cache entries = %+v, want DO-clear and DO-set answers", entries)
+	}
+	exchange(t, p, withDO(t, testQuery(t, 4)))
+	if n := atomic.LoadInt32(&upstream.queries); n != 1 {
+		t.Errorf("cached DO-set query forwarded, upstream queries = %d, want 1", n)
+	}
+}
+
+func TestCacheTTLBounds(t *testing.T) {
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetCacheTTL(10*time.Second, time.Hour); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name string
+		ttl  uint32
+		// want is how long the response is cached, wantTTL the record TTL
+		// it is served with
+		want    time.Duration
+		wantTTL uint32
+	}{
+		{"zero.example.com.", 0, 10 * time.Second, 0},
+		{"short.example.com.", 3, 10 * time.Second, 3},
+		{"normal.example.com.", 300, 300 * time.Second, 300},
+		{"long.example.com.", 7 * 86400, time.Hour, 3600},
+	}
+	for _, tt := range tests {
+		query, err := BuildQuery(1, tt.name, TypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response, err := addressResponse(query, TypeA, []net.IP{net.IPv4(10, 0, 0, 1)}, tt.ttl)
+		if err != nil {
+			t.Fatal(err)
+		}
+		key := newCacheKey(tt.name, TypeA, query)
+		before := time.Now()
+		p.cache.set(key, response)
+
+		entry, ok := p.cache.entries[key]
+		if !ok {
+			t.Errorf("%s: TTL %d not cached", tt.name, tt.ttl)
+			continue
+		}
+		if lifetime := entry.expires.Sub(before); lifetime < tt.want || lifetime > tt.want+time.Second {
+			t.Errorf("%s: TTL %d cached for %v, want %v", tt.name, tt.ttl, lifetime, tt.want)
+		}
+		if ttl, _ := minTTL(entry.response); ttl != tt.wantTTL {
+			t.Errorf("%s: served with TTL %d, want %d", tt.name, ttl, tt.wantTTL)
+		}
+	}
+
+	// Without bounds the record TTL is used and zero TTLs are not cached
+	if err := p.SetCacheTTL(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	query, _ := BuildQuery(1, "zero.example.net.", TypeA)
+	response, _ := addressResponse(query, TypeA, []net.IP{net.IPv4(10, 0, 0, 1)}, 0)
+	p.cache.set(newCacheKey("zero.example.net.", TypeA, query), response)
+	if _, ok := p.cache.entries[newCacheKey("zero.example.net.", TypeA, query)]; ok {
+		t.Error("zero TTL cached without a minimum")
+	}
+}
+
+func TestValidateCacheTTL(t *testing.T) {
+	for _, tt := range []struct {
+		min, max time.Duration
+		ok       bool
+	}{
+		{0, 0, true},
+		{10 * time.Second, time.Hour, true},
+		{time.Minute, 0, true},
+		{time.Hour, time.Hour, true},
+		{time.Hour, time.Minute, false},
+		{-time.Second, 0, false},
+		{0, 500 * time.Millisecond, false},
+	} {
+		if err := ValidateCacheTTL(tt.min, tt.max); (err == nil) != tt.ok {
+			t.Errorf("ValidateCacheTTL(%v, %v) = %v", tt.min, tt.max, err)
+		}
+	}
+}
@@ -0,0 +1,104 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"sync"
+)
+
+const (
+	// upstreamPoolSize is how many idle connections are kept per upstream
+	upstreamPoolSize = 8
+	// upstreamConnMaxUses is how many queries a pooled connection sends
+	// before it is closed, so that the source port of upstream queries
+	// keeps changing
+	upstreamConnMaxUses = 64
+)
+
+// upstreamConn is a UDP socket connected to an upstream server
+type upstreamConn struct {
+	*net.UDPConn
+	uses int
+}
+
+// connPool keeps UDP sockets connected to upstream servers for reuse. A
+// connection is borrowed by one query at a time, as responses arriving on it
+// are not demultiplexed between queries.
+type connPool struct {
+	mu     sync.Mutex
+	idle   map[string][]*upstreamConn
+	closed bool
+}
+
+// newConnPool creates an empty connection pool
+func newConnPool() *connPool {
+	return &connPool{idle: make(map[string][]*upstreamConn)}
+}
+
+// get borrows a connection to server, dialing a new one when none is idle
+func (c *connPool) get(server string) (*upstreamConn, error) {
+	c.mu.Lock()
+	if conns := c.idle[server]; len(conns) > 0 {
+		conn := conns[len(conns)-1]
+		c.idle[server] = conns[:len(conns)-1]
+		c.mu.Unlock()
+		return conn, nil
+	}
+	c.mu.Unlock()
+
+	return dialUpstream(server)
+}
+
+// put returns a connection borrowed from get. A connection that failed, may
+// still receive a late response, has been used upstreamConnMaxUses times or
+// does not fit in the pool is closed instead, as are all once the pool is
+// closed.
+func (c *connPool) put(server string, conn *upstreamConn, healthy bool) {
+	conn.uses++
+
+	c.mu.Lock()
+	if healthy && !c.closed && conn.uses < upstreamConnMaxUses && len(c.idle[server]) < upstreamPoolSize {
+		c.idle[server] = append(c.idle[server], conn)
+		c.mu.Unlock()
+		return
+	}
+	c.mu.Unlock()
+	conn.Close()
+}
+
+// idleCount returns the number of idle connections to server
+func (c *connPool) idleCount(server string) int {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	return len(c.idle[server])
+}
+
+// close closes the idle connections. Connections borrowed at the time are
+// closed when they are returned; later queries dial connections of their own.
+func (c *connPool) close() {
+	c.mu.Lock()
+	idle := c.idle
+	c.idle = make(map[string][]*upstreamConn)
+	c.closed = true
+	c.mu.Unlock()
+
+	for _, conns := range idle {
+		for _, conn := range conns {
+			conn.Close()
+		}
+	}
+}
+
+// dialUpstream opens a UDP socket connected to server
+func dialUpstream(server string) (*upstreamConn, error) {
+	upstreamAddr, err := net.ResolveUDPAddr("udp", server)
+	if err != nil {
+		return nil, fmt.Errorf("failed to resolve %s: %w", server, err)
+	}
+
+	conn, err := net.DialUDP("udp", nil, upstreamAddr)
+	if err != nil {
+		return nil, fmt.Errorf("failed to connect to %s: %w", server, err)
+	}
+	return &upstreamConn{UDPConn: conn}, nil
+}
@@ -0,0 +1,198 @@
+package dns
+
+import (
+	"encoding/binary"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/ourines/GateShift/internal/utils"
+)
+
+func TestConnPoolReusesConnection(t *testing.T) {
+	upstream := startTestUpstream(t, true)
+	pool := newConnPool()
+	defer pool.close()
+
+	conn, err := pool.get(upstream.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := exchangeConn(conn.UDPConn, testQuery(t, 1), time.Now().Add(time.Second), nil); err != nil {
+		t.Fatal(err)
+	}
+	pool.put(upstream.addr, conn, true)
+
+	again, err := pool.get(upstream.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again != conn {
+		t.Error("idle connection was not reused")
+	}
+	pool.put(upstream.addr, again, true)
+}
+
+func TestConnPoolDiscardsConnections(t *testing.T) {
+	upstream := startTestUpstream(t, true)
+	pool := newConnPool()
+	defer pool.close()
+
+	tests := map[string]func(conn *upstreamConn) bool{
+		"broken": func(conn *upstreamConn) bool { return false },
+		"worn out": func(conn *upstreamConn) bool {
+			conn.uses = upstreamConnMaxUses - 1
+			return true
+		},
+	}
+	for name, prepare := range tests {
+		conn, err := pool.get(upstream.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		pool.put(upstream.addr, conn, prepare(conn))
+
+		if n := pool.idleCount(upstream.addr); n != 0 {
+			t.Errorf("%s: %d idle connections, want 0", name, n)
+		}
+		if _, err := conn.Write(testQuery(t, 1)); err == nil {
+			t.Errorf("%s: connection not closed", name)
+		}
+	}
+}
+
+func TestConnPoolLimitsIdleConnections(t *testing.T) {
+	upstream := startTestUpstream(t, true)
+	pool := newConnPool()
+	defer pool.close()
+
+	conns := make([]*upstreamConn, upstreamPoolSize+1)
+	for i := range conns {
+		conn, err := pool.get(upstream.addr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		conns[i] = conn
+	}
+	for _, conn := range conns {
+		pool.put(upstream.addr, conn, true)
+	}
+	if n := pool.idleCount(upstream.addr); n != upstreamPoolSize {
+		t.Errorf("%d idle connections, want %d", n, upstreamPoolSize)
+	}
+}
+
+func TestConnPoolClose(t *testing.T) {
+	upstream := startTestUpstream(t, true)
+	pool := newConnPool()
+
+	idle, err := pool.get(upstream.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	borrowed, err := pool.get(upstream.addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pool.put(upstream.addr, idle, true)
+
+	pool.close()
+	if _, err := idle.Write(testQuery(t, 1)); err == nil {
+		t.Error("idle connection not closed")
+	}
+
+	// A connection returned after close is closed rather than kept
+	pool.put(upstream.addr, borrowed, true)
+	if n := pool.idleCount(upstream.addr); n != 0 {
+		t.Errorf("%d idle connections after close, want 0", n)
+	}
+	if _, err := borrowed.Write(testQuery(t, 1)); err == nil {
+		t.Error("connection returned after close not closed")
+	}
+}
+
+func TestExchangeConnSkipsOtherIDs(t *testing.T) {
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Answer with a stale response to another query before the real one
+	go func() {
+		buf := make([]byte, 512)
+		n, addr, err := conn.ReadFromUDP(buf)
+		if err != nil {
+			return
+		}
+		response := append([]byte(nil), buf[:n]...)
+		response[2] |= 0x80 // QR
+		stale := append([]byte(nil), response...)
+		binary.BigEndian.PutUint16(stale[0:2], 0x9999)
+		conn.WriteToUDP(stale, addr)
+		conn.WriteToUDP(response, addr)
+	}()
+
+	response, err := exchangeUDP(conn.LocalAddr().String(), testQuery(t, 0x1234), time.Now().Add(time.Second), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := binary.BigEndian.Uint16(response[0:2]); id != 0x1234 {
+		t.Errorf("response ID = %#x, want %#x", id, 0x1234)
+	}
+}
+
+func TestStopClosesUpstreamConnections(t *testing.T) {
+	upstream := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyPriority)
+	if err := p.SetUpstreams([]string{upstream.addr}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := p.queryUpstreamServer(upstream.addr, testQuery(t, 1), time.Now().Add(time.Second), nil); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.upstreamConns.idleCount(upstream.addr); n != 1 {
+		t.Fatalf("%d idle upstream connections, want 1", n)
+	}
+
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if n := p.upstreamConns.idleCount(upstream.addr); n != 0 {
+		t.Errorf("%d idle upstream connections after Stop, want 0", n)
+	}
+}
+
+// BenchmarkUpstreamExchange compares dialing a socket for every upstream
+// query with borrowing one from the connection pool
+func BenchmarkUpstreamExchange(b *testing.B) {
+	defer utils.SetLogLevel(utils.LogLevel())
+	utils.SetLogLevel(utils.LevelQuiet)
+
+	upstream := startTestUpstream(b, true)
+	query := testQuery(b, 0x1234)
+
+	b.Run("dial", func(b *testing.B) {
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := exchangeUDP(upstream.addr, query, time.Now().Add(2*time.Second), nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+
+	b.Run("pooled", func(b *testing.B) {
+		p := newTestProxy(b, StrategyPriority)
+		defer p.upstreamConns.close()
+		b.ReportAllocs()
+		for i := 0; i < b.N; i++ {
+			if _, err := p.exchangePooled(upstream.addr, query, time.Now().Add(2*time.Second), nil); err != nil {
+				b.Fatal(err)
+			}
+		}
+	})
+}
@@ -0,0 +1,267 @@
+package dns
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/ourines/GateShift/internal/utils"
+)
+
+// StatusResponse is returned by the control API status endpoint
+type StatusResponse struct {
+	Running        bool             `json:"running"`
+	ListenAddr     string           `json:"listen_addr"`
+	ListenPort     int              `json:"listen_port"`
+	Upstreams      []string         `json:"upstreams"`
+	Strategy       string           `json:"strategy"`
+	FilterAAAA     bool             `json:"filter_aaaa"`
+	Fallback       string           `json:"fallback"`
+	BlockedDomains int              `json:"blocked_domains"`
+	BlockResponse  string           `json:"blocklist_response"`
+	Recursion      string           `json:"recursion"`
+	HostsFile      string           `json:"hosts_file"`
+	Health         []UpstreamHealth `json:"health"`
+	Cache          CacheStats       `json:"cache"`
+	// RecoveredPanics counts queries dropped because handling them panicked
+	RecoveredPanics uint64 `json:"recovered_panics"`
+	// QNAMEMinimization reports whether the parent of names is looked up
+	// before forwarding them
+	QNAMEMinimization bool `json:"qname_minimization"`
+}
+
+// UpstreamsRequest is the body accepted by the control API upstreams endpoint
+type UpstreamsRequest struct {
+	Upstreams []string `json:"upstreams"`
+}
+
+// ReloadResponse is returned by the control API reload endpoint
+type ReloadResponse struct {
+	Reloaded bool `json:"reloaded"`
+}
+
+// ClearCacheResponse is returned by the control API cache clear endpoint
+type ClearCacheResponse struct {
+	Cleared int `json:"cleared"`
+}
+
+// startControlServer serves the control API on addr, which must be a loopback
+// address. Requests must carry the bearer token written to tokenFile.
+func (p *DNSProxy) startControlServer(addr, tokenFile string) (*http.Server, error) {
+	host, _, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+		return nil, fmt.Errorf("control API must listen on a loopback address, got %s", addr)
+	}
+
+	token, err := writeControlToken(tokenFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to write control token: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		os.Remove(tokenFile)
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/status", p.handleStatus)
+	mux.HandleFunc("/cache", p.handleCache)
+	mux.HandleFunc("/cache/stats", p.handleCacheStats)
+	mux.HandleFunc("/cache/clear", p.handleCacheClear)
+	mux.HandleFunc("/upstreams", p.handleUpstreams)
+	mux.HandleFunc("/upstreams/health", p.handleUpstreamHealth)
+	mux.HandleFunc("/upstreams/stats", p.handleUpstreamStats)
+	mux.HandleFunc("/blocklist/stats", p.handleBlocklistStats)
+	mux.HandleFunc("/reload", p.handleReload)
+	server := &http.Server{Handler: requireToken(token, mux), ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Control API server error: %v", err)
+		}
+	}()
+
+	utils.Logf("Control API listening on %s", addr)
+	return server, nil
+}
+
+// stopControlServer shuts the control API down and removes its token file
+func stopControlServer(server *http.Server, tokenFile string) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error stopping control API server: %v", err)
+	}
+	os.Remove(tokenFile)
+}
+
+// writeControlToken generates a random token and writes it to path with 0600
+// permissions, owned by the owner of its directory so the CLI can read it
+// even though the daemon runs with elevated privileges
+func writeControlToken(path string) (string, error) {
+	buf := make([]byte, 32)
+	if _, err := rand.Read(buf); err != nil {
+		return "", err
+	}
+	token := hex.EncodeToString(buf)
+
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return "", err
+	}
+	if err := os.WriteFile(path, []byte(token), 0600); err != nil {
+		return "", err
+	}
+	if err := utils.ChownLike(path, filepath.Dir(path)); err != nil {
+		log.Printf("Warning: could not change control token ownership: %v", err)
+	}
+
+	return token, nil
+}
+
+// requireToken rejects requests that do not carry the bearer token
+func requireToken(token string, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided := strings.TrimPrefix(r.Header.Get("Authorization"), "Bearer ")
+		if subtle.ConstantTimeCompare([]byte(provided), []byte(token)) != 1 {
+			http.Error(w, "unauthorized", http.StatusUnauthorized)
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+func writeJSON(w http.ResponseWriter, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("Failed to encode control API response: %v", err)
+	}
+}
+
+func (p *DNSProxy) handleStatus(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, StatusResponse{
+		Running:           p.IsRunning(),
+		ListenAddr:        p.listenAddr,
+		ListenPort:        p.GetPort(),
+		Upstreams:         p.Upstreams(),
+		Strategy:          p.Strategy(),
+		FilterAAAA:        p.AAAAFilter(),
+		Fallback:          p.Fallback(),
+		BlockedDomains:    len(p.Blocklist()),
+		BlockResponse:     p.BlockResponse(),
+		Recursion:         p.RecursionMode(),
+		HostsFile:         p.HostsFile(),
+		Health:            p.UpstreamHealth(),
+		Cache:             p.CacheStats(),
+		RecoveredPanics:   p.RecoveredPanics(),
+		QNAMEMinimization: p.QNAMEMinimization(),
+	})
+}
+
+func (p *DNSProxy) handleCache(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, p.CacheEntries())
+}
+
+func (p *DNSProxy) handleCacheStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, p.CacheStats())
+}
+
+func (p *DNSProxy) handleCacheClear(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, ClearCacheResponse{Cleared: p.ClearCache()})
+}
+
+func (p *DNSProxy) handleUpstreamHealth(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, p.UpstreamHealth())
+}
+
+func (p *DNSProxy) handleUpstreamStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	writeJSON(w, p.UpstreamStats())
+}
+
+func (p *DNSProxy) handleBlocklistStats(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	top := 0
+	if value := r.URL.Query().Get("top"); value != "" {
+		n, err := strconv.Atoi(value)
+		if err != nil || n < 0 {
+			http.Error(w, fmt.Sprintf("invalid top: %s", value), http.StatusBadRequest)
+			return
+		}
+		top = n
+	}
+	writeJSON(w, p.BlocklistStats(top))
+}
+
+func (p *DNSProxy) handleUpstreams(w http.ResponseWriter, r *http.Request) {
+	switch r.Method {
+	case http.MethodGet:
+		writeJSON(w, UpstreamsRequest{Upstreams: p.Upstreams()})
+	case http.MethodPut:
+		var req UpstreamsRequest
+		if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+			http.Error(w, fmt.Sprintf("invalid request body: %v", err), http.StatusBadRequest)
+			return
+		}
+		if err := p.SetUpstreams(req.Upstreams); err != nil {
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+		writeJSON(w, UpstreamsRequest{Upstreams: p.Upstreams()})
+	default:
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+	}
+}
+
+func (p *DNSProxy) handleReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+	if err := p.Reload(); err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+	writeJSON(w, ReloadResponse{Reloaded: true})
+}
@@ -0,0 +1,142 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+)
+
+// ControlClient talks to the control API of a running DNS daemon
+type ControlClient struct {
+	addr      string
+	tokenFile string
+	client    *http.Client
+}
+
+// NewControlClient creates a client for the control API at addr, reading
+// the authentication token from tokenFile
+func NewControlClient(addr, tokenFile string) *ControlClient {
+	return &ControlClient{
+		addr:      addr,
+		tokenFile: tokenFile,
+		client:    &http.Client{Timeout: 5 * time.Second},
+	}
+}
+
+// Status returns the status of the running daemon
+func (c *ControlClient) Status() (*StatusResponse, error) {
+	var status StatusResponse
+	if err := c.do(http.MethodGet, "/status", nil, &status); err != nil {
+		return nil, err
+	}
+	return &status, nil
+}
+
+// CacheEntries returns the cached responses of the running daemon
+func (c *ControlClient) CacheEntries() ([]CacheEntry, error) {
+	var entries []CacheEntry
+	if err := c.do(http.MethodGet, "/cache", nil, &entries); err != nil {
+		return nil, err
+	}
+	return entries, nil
+}
+
+// ClearCache empties the response cache of the running daemon and returns
+// how many entries were removed
+func (c *ControlClient) ClearCache() (int, error) {
+	var resp ClearCacheResponse
+	if err := c.do(http.MethodPost, "/cache/clear", nil, &resp); err != nil {
+		return 0, err
+	}
+	return resp.Cleared, nil
+}
+
+// UpstreamHealth returns the health of each upstream of the running daemon
+func (c *ControlClient) UpstreamHealth() ([]UpstreamHealth, error) {
+	var health []UpstreamHealth
+	if err := c.do(http.MethodGet, "/upstreams/health", nil, &health); err != nil {
+		return nil, err
+	}
+	return health, nil
+}
+
+// UpstreamStats returns the query statistics of each upstream of the running daemon
+func (c *ControlClient) UpstreamStats() ([]UpstreamStats, error) {
+	var stats []UpstreamStats
+	if err := c.do(http.MethodGet, "/upstreams/stats", nil, &stats); err != nil {
+		return nil, err
+	}
+	return stats, nil
+}
+
+// BlocklistStats returns the number of queries the running daemon blocked
+// and its top most blocked names; all of them if top is 0
+func (c *ControlClient) BlocklistStats(top int) (*BlocklistStats, error) {
+	var stats BlocklistStats
+	if err := c.do(http.MethodGet, fmt.Sprintf("/blocklist/stats?top=%d", top), nil, &stats); err != nil {
+		return nil, err
+	}
+	return &stats, nil
+}
+
+// SetUpstreams replaces the upstream servers of the running daemon
+func (c *ControlClient) SetUpstreams(upstreams []string) ([]string, error) {
+	var resp UpstreamsRequest
+	if err := c.do(http.MethodPut, "/upstreams", UpstreamsRequest{Upstreams: upstreams}, &resp); err != nil {
+		return nil, err
+	}
+	return resp.Upstreams, nil
+}
+
+// Reload asks the running daemon to re-read its configuration
+func (c *ControlClient) Reload() error {
+	var resp ReloadResponse
+	return c.do(http.MethodPost, "/reload", nil, &resp)
+}
+
+// do sends an authenticated request and decodes the JSON response into out
+func (c *ControlClient) do(method, path string, in, out interface{}) error {
+	token, err := os.ReadFile(c.tokenFile)
+	if err != nil {
+		return fmt.Errorf("failed to read control token: %w", err)
+	}
+
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequest(method, "http://"+c.addr+path, body)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Authorization", "Bearer "+strings.TrimSpace(string(token)))
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to reach control API: %w", err)
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		msg, _ := io.ReadAll(resp.Body)
+		return fmt.Errorf("control API returned status %d: %s", resp.StatusCode, strings.TrimSpace(string(msg)))
+	}
+
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
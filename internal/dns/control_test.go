@@ -0,0 +1,72 @@
+package dns
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequireToken(t *testing.T) {
+	handler := requireToken("secret", http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {}))
+
+	for header, want := range map[string]int{
+		"":              http.StatusUnauthorized,
+		"Bearer wrong":  http.StatusUnauthorized,
+		"Bearer secret": http.StatusOK,
+	} {
+		req := httptest.NewRequest(http.MethodGet, "/status", nil)
+		if header != "" {
+			req.Header.Set("Authorization", header)
+		}
+		rec := httptest.NewRecorder()
+		handler.ServeHTTP(rec, req)
+		if rec.Code != want {
+			t.Errorf("Authorization %q: status %d, want %d", header, rec.Code, want)
+		}
+	}
+}
+
+func TestHandleReload(t *testing.T) {
+	p, _ := NewDNSProxy("127.0.0.1", 0, []string{"1.1.1.1:53"})
+
+	rec := httptest.NewRecorder()
+	p.handleReload(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	if rec.Code != http.StatusInternalServerError {
+		t.Errorf("reload without a reload function: status %d, want 500", rec.Code)
+	}
+
+	reloaded := 0
+	p.SetReloadFunc(func() error {
+		reloaded++
+		return p.SetUpstreams([]string{"9.9.9.9:53"})
+	})
+
+	rec = httptest.NewRecorder()
+	p.handleReload(rec, httptest.NewRequest(http.MethodGet, "/reload", nil))
+	if rec.Code != http.StatusMethodNotAllowed {
+		t.Errorf("GET /reload: status %d, want 405", rec.Code)
+	}
+
+	rec = httptest.NewRecorder()
+	p.handleReload(rec, httptest.NewRequest(http.MethodPost, "/reload", nil))
+	if rec.Code != http.StatusOK || reloaded != 1 {
+		t.Fatalf("POST /reload: status %d, reloaded %d times", rec.Code, reloaded)
+	}
+	if upstreams := p.Upstreams(); len(upstreams) != 1 || upstreams[0] != "9.9.9.9:53" {
+		t.Errorf("upstreams after reload = %v", upstreams)
+	}
+}
+
+func TestHandleUpstreamsRejectsInvalid(t *testing.T) {
+	p, _ := NewDNSProxy("127.0.0.1", 0, []string{"1.1.1.1:53"})
+
+	rec := httptest.NewRecorder()
+	p.handleUpstreams(rec, httptest.NewRequest(http.MethodPut, "/upstreams", strings.NewReader(`{"upstreams":[]}`)))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("empty upstream list: status %d, want 400", rec.Code)
+	}
+	if upstreams := p.Upstreams(); len(upstreams) != 1 || upstreams[0] != "1.1.1.1:53" {
+		t.Errorf("upstreams changed after rejected request: %v", upstreams)
+	}
+}
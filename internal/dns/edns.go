@@ -0,0 +1,161 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+)
+
+const (
+	// ednsUDPSize is the UDP payload size advertised to upstreams, as
+	// recommended by DNS Flag Day 2020 to avoid IP fragmentation
+	ednsUDPSize = 1232
+	// plainUDPSize is the largest UDP response a client without EDNS0 accepts
+	plainUDPSize = 512
+)
+
+// optRecord describes the EDNS0 OPT pseudo-record of a DNS message
+type optRecord struct {
+	start, end int
+	udpSize    int
+	dnssecOK   bool
+	last       bool
+}
+
+// findOPT locates the OPT record in the additional section of a DNS message
+func findOPT(msg []byte) (*optRecord, error) {
+	if len(msg) < headerSize {
+		return nil, fmt.Errorf("message too short")
+	}
+	anCount := int(binary.BigEndian.Uint16(msg[6:8]))
+	nsCount := int(binary.BigEndian.Uint16(msg[8:10]))
+	arCount := int(binary.BigEndian.Uint16(msg[10:12]))
+
+	offset, err := skipQuestions(msg)
+	if err != nil {
+		return nil, err
+	}
+
+	rrCount := anCount + nsCount + arCount
+	for i := 0; i < rrCount; i++ {
+		start := offset
+		end, err := skipName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if end+10 > len(msg) {
+			return nil, fmt.Errorf("record header out of bounds")
+		}
+		rrType := binary.BigEndian.Uint16(msg[end : end+2])
+		rdLength := int(binary.BigEndian.Uint16(msg[end+8 : end+10]))
+		offset = end + 10 + rdLength
+		if offset > len(msg) {
+			return nil, fmt.Errorf("record data out of bounds")
+		}
+
+		if rrType == typeOPT && i >= anCount+nsCount {
+			return &optRecord{
+				start:    start,
+				end:      offset,
+				udpSize:  int(binary.BigEndian.Uint16(msg[end+2 : end+4])),
+				dnssecOK: msg[end+6]&0x80 != 0,
+				last:     i == rrCount-1,
+			}, nil
+		}
+	}
+
+	return nil, nil
+}
+
+// addEDNS0 returns the query with an OPT record advertising ednsUDPSize
+// appended, and whether one was added. Queries that already carry an OPT
+// record or cannot be parsed are returned unchanged.
+func addEDNS0(query []byte) ([]byte, bool) {
+	opt, err := findOPT(query)
+	if err != nil || opt != nil {
+		return query, false
+	}
+	// Bytes after the last record are dropped; the upstream would otherwise
+	// read them as the start of the OPT record
+	end, err := walkRecords(query, func(uint16, int) {})
+	if err != nil {
+		return query, false
+	}
+
+	forwarded := make([]byte, end, end+11)
+	copy(forwarded, query[:end])
+	// Root name, type OPT, class = UDP payload size, TTL = extended flags, no data
+	forwarded = append(forwarded, 0, 0, typeOPT, byte(ednsUDPSize>>8), byte(ednsUDPSize&0xff), 0, 0, 0, 0, 0, 0)
+	binary.BigEndian.PutUint16(forwarded[10:12], binary.BigEndian.Uint16(forwarded[10:12])+1)
+
+	return forwarded, true
+}
+
+// clientUDPSize returns the largest UDP response the sender of query accepts
+func clientUDPSize(query []byte) (int, bool) {
+	opt, err := findOPT(query)
+	if err != nil || opt == nil {
+		return plainUDPSize, false
+	}
+	if opt.udpSize < plainUDPSize {
+		return plainUDPSize, true
+	}
+	return opt.udpSize, true
+}
+
+// dnssecFlags returns the DO (DNSSEC OK) bit from the OPT record of query and
+// the CD (checking disabled) bit from its header. A query that cannot be
+// parsed reports neither.
+func dnssecFlags(query []byte) (do, cd bool) {
+	if len(query) < headerSize {
+		return false, false
+	}
+	cd = query[3]&0x10 != 0
+	if opt, err := findOPT(query); err == nil && opt != nil {
+		do = opt.dnssecOK
+	}
+	return do, cd
+}
+
+// fitResponse adapts an upstream response to the client: the OPT record is
+// removed for clients that did not use EDNS0, and responses larger than the
+// client's UDP payload size are truncated to the question with TC set so the
+// client can retry over TCP
+func fitResponse(response []byte, clientEDNS bool, limit int) []byte {
+	if !clientEDNS {
+		// Only a trailing OPT record can be removed without invalidating
+		// compression pointers in later records
+		if opt, err := findOPT(response); err == nil && opt != nil && opt.last {
+			response = response[:opt.start]
+			binary.BigEndian.PutUint16(response[10:12], binary.BigEndian.Uint16(response[10:12])-1)
+		}
+	}
+
+	if len(response) <= limit {
+		return response
+	}
+
+	offset := headerSize
+	if binary.BigEndian.Uint16(response[4:6]) > 0 {
+		end, err := skipName(response, offset)
+		if err != nil || end+4 > len(response) {
+			return response
+		}
+		offset = end + 4
+	}
+
+	truncated := make([]byte, offset)
+	copy(truncated, response[:offset])
+	truncated[2] |= 0x02
+	binary.BigEndian.PutUint16(truncated[4:6], min16(binary.BigEndian.Uint16(truncated[4:6]), 1))
+	binary.BigEndian.PutUint16(truncated[6:8], 0)
+	binary.BigEndian.PutUint16(truncated[8:10], 0)
+	binary.BigEndian.PutUint16(truncated[10:12], 0)
+	return truncated
+}
+
+func min16(a, b uint16) uint16 {
+	if a < b {
+		return a
+	}
+	return b
+}
@@ -0,0 +1,71 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"time"
+)
+
+// fallbackMaxTTL caps the TTLs of answers from the fallback resolver, so
+// neither the cache nor clients keep them long once the upstreams recover
+const fallbackMaxTTL = 30
+
+// SetFallback sets the resolver queried as a last resort when every upstream
+// fails. An empty server disables the fallback.
+func (p *DNSProxy) SetFallback(server string) error {
+	if server != "" {
+		var err error
+		if server, err = NormalizeUpstream(server); err != nil {
+			return fmt.Errorf("invalid fallback DNS server: %w", err)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.fallbackDNS = server
+	return nil
+}
+
+// Fallback returns the fallback resolver, or "" if it is disabled
+func (p *DNSProxy) Fallback() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.fallbackDNS
+}
+
+// resolveFallback sends query to the fallback resolver after the upstreams
+// failed with upstreamErr. It is not used when the fallback is disabled or is
+// itself one of the upstreams, which were just tried.
+func (p *DNSProxy) resolveFallback(upstreams []string, query []byte, upstreamErr error) ([]byte, string, error) {
+	fallback := p.Fallback()
+	if fallback == "" {
+		return nil, "", upstreamErr
+	}
+	for _, upstream := range upstreams {
+		if upstream == fallback {
+			return nil, "", upstreamErr
+		}
+	}
+
+	response, err := p.queryUpstreamServer(fallback, query, time.Now().Add(attemptTimeout), nil)
+	if err != nil {
+		return nil, "", fmt.Errorf("%w; fallback %s: %v", upstreamErr, fallback, err)
+	}
+
+	log.Printf("Warning: all upstream DNS servers failed (%v), answered by fallback resolver %s", upstreamErr, fallback)
+	capTTLs(response, fallbackMaxTTL)
+	return response, fallback, nil
+}
+
+// capTTLs lowers every record TTL in msg above max to max
+func capTTLs(msg []byte, max uint32) {
+	walkRecords(msg, func(rrType uint16, ttlOffset int) {
+		if rrType == typeOPT {
+			return
+		}
+		if binary.BigEndian.Uint32(msg[ttlOffset:ttlOffset+4]) > max {
+			binary.BigEndian.PutUint32(msg[ttlOffset:ttlOffset+4], max)
+		}
+	})
+}
@@ -0,0 +1,94 @@
+package dns
+
+import (
+	"encoding/binary"
+	"errors"
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+// startRecordUpstream starts a test upstream that answers every query with
+// records, each a resource record in wire format whose name may point to the
+// question
+func startRecordUpstream(t *testing.T, records ...[]byte) *testUpstream {
+	t.Helper()
+
+	return startUpstream(t, func(n int32, query []byte) []byte {
+		response := echoResponse(query)
+		binary.BigEndian.PutUint16(response[6:8], uint16(len(records)))
+		for _, record := range records {
+			response = append(response, record...)
+		}
+		return response
+	})
+}
+
+// aRecord returns an A record for the question with address 192.0.2.1 and
+// the given TTL
+func aRecord(ttl uint32) []byte {
+	// Name pointer to the question, type A, class IN, TTL, 4-byte address
+	record := []byte{0xc0, headerSize, 0, 1, 0, 1, 0, 0, 0, 0, 0, 4, 192, 0, 2, 1}
+	binary.BigEndian.PutUint32(record[6:10], ttl)
+	return record
+}
+
+func TestFallbackAnswersWhenUpstreamsFail(t *testing.T) {
+	fallback := startRecordUpstream(t, aRecord(3600))
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetUpstreams([]string{closedUpstream(t), closedUpstream(t)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetFallback(fallback.addr); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p.conn = conn
+
+	response := exchange(t, p, testQuery(t, 0x1234))
+	if n := atomic.LoadInt32(&fallback.queries); n != 1 {
+		t.Fatalf("fallback received %d queries, want 1", n)
+	}
+	if ttl, ok := minTTL(response); !ok || ttl != fallbackMaxTTL {
+		t.Errorf("response TTL = %d (%v), want %d", ttl, ok, fallbackMaxTTL)
+	}
+
+	// The answer is cached, but only for the capped TTL
+	entries := p.CacheEntries()
+	if len(entries) != 1 || entries[0].TTL > fallbackMaxTTL {
+		t.Errorf("cache entries = %+v, want one with TTL <= %d", entries, fallbackMaxTTL)
+	}
+}
+
+func TestFallbackSkipped(t *testing.T) {
+	fallback := startRecordUpstream(t, aRecord(60))
+	p := newTestProxy(t, StrategyPriority)
+
+	// Disabled
+	if _, _, err := p.resolveFallback(nil, testQuery(t, 1), errTest); err != errTest {
+		t.Errorf("disabled fallback returned %v, want the upstream error", err)
+	}
+
+	// Already one of the upstreams that failed
+	if err := p.SetFallback(fallback.addr); err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := p.resolveFallback([]string{fallback.addr}, testQuery(t, 1), errTest); err != errTest {
+		t.Errorf("fallback among the upstreams returned %v, want the upstream error", err)
+	}
+	if n := atomic.LoadInt32(&fallback.queries); n != 0 {
+		t.Errorf("fallback received %d queries, want 0", n)
+	}
+
+	if err := p.SetFallback("dns.google"); err == nil {
+		t.Error("SetFallback accepted an invalid server")
+	}
+}
+
+// errTest stands in for the error of the failed upstreams
+var errTest = errors.New("test upstream failure")
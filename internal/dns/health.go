@@ -0,0 +1,200 @@
+package dns
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"log"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ourines/GateShift/internal/utils"
+)
+
+const (
+	// healthCheckInterval is how often each upstream is probed
+	healthCheckInterval = 30 * time.Second
+	// healthCheckTimeout bounds a single probe
+	healthCheckTimeout = 2 * time.Second
+	// healthFailureThreshold is the number of consecutive failed probes after
+	// which an upstream is marked down
+	healthFailureThreshold = 3
+	// healthCheckName is the name queried by the probes
+	healthCheckName = "example.com"
+)
+
+// UpstreamHealth reports the health of an upstream server
+type UpstreamHealth struct {
+	Upstream            string    `json:"upstream"`
+	Healthy             bool      `json:"healthy"`
+	LatencyMs           float64   `json:"latency_ms"`
+	LastCheck           time.Time `json:"last_check"`
+	ConsecutiveFailures int       `json:"consecutive_failures"`
+	LastError           string    `json:"last_error,omitempty"`
+}
+
+// healthTracker records the probe results of each upstream
+type healthTracker struct {
+	mu     sync.RWMutex
+	states map[string]*UpstreamHealth
+}
+
+func newHealthTracker() *healthTracker {
+	return &healthTracker{states: make(map[string]*UpstreamHealth)}
+}
+
+// record updates the state of an upstream with a probe result and reports
+// whether its health changed
+func (h *healthTracker) record(upstream string, latency time.Duration, err error) (UpstreamHealth, bool) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	state, ok := h.states[upstream]
+	if !ok {
+		state = &UpstreamHealth{Upstream: upstream, Healthy: true}
+		h.states[upstream] = state
+	}
+	wasHealthy := state.Healthy
+
+	state.LastCheck = time.Now()
+	if err != nil {
+		state.ConsecutiveFailures++
+		state.LastError = err.Error()
+		if state.ConsecutiveFailures >= healthFailureThreshold {
+			state.Healthy = false
+		}
+	} else {
+		state.ConsecutiveFailures = 0
+		state.LastError = ""
+		state.LatencyMs = float64(latency.Microseconds()) / 1000
+		state.Healthy = true
+	}
+
+	return *state, state.Healthy != wasHealthy
+}
+
+// healthy reports whether an upstream is usable. Upstreams that have not
+// been probed yet are assumed healthy.
+func (h *healthTracker) healthy(upstream string) bool {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+	state, ok := h.states[upstream]
+	return !ok || state.Healthy
+}
+
+// snapshot returns the state of the given upstreams in order
+func (h *healthTracker) snapshot(upstreams []string) []UpstreamHealth {
+	h.mu.RLock()
+	defer h.mu.RUnlock()
+
+	result := make([]UpstreamHealth, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		if state, ok := h.states[upstream]; ok {
+			result = append(result, *state)
+		} else {
+			result = append(result, UpstreamHealth{Upstream: upstream, Healthy: true})
+		}
+	}
+	return result
+}
+
+// UpstreamHealth returns the health of each configured upstream
+func (p *DNSProxy) UpstreamHealth() []UpstreamHealth {
+	return p.health.snapshot(p.Upstreams())
+}
+
+// healthyUpstreams filters out upstreams marked down. If every upstream is
+// down, all of them are returned so resolution is still attempted.
+func (p *DNSProxy) healthyUpstreams(upstreams []string) []string {
+	var healthy []string
+	for _, upstream := range upstreams {
+		if p.health.healthy(upstream) {
+			healthy = append(healthy, upstream)
+		}
+	}
+	if len(healthy) == 0 {
+		return upstreams
+	}
+	return healthy
+}
+
+// healthCheckTask periodically probes every upstream until the proxy stops
+func (p *DNSProxy) healthCheckTask() {
+	ticker := time.NewTicker(healthCheckInterval)
+	defer ticker.Stop()
+
+	p.checkUpstreams()
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			p.checkUpstreams()
+		}
+	}
+}
+
+// checkUpstreams probes all upstreams concurrently
+func (p *DNSProxy) checkUpstreams() {
+	var wg sync.WaitGroup
+	for _, upstream := range p.Upstreams() {
+		wg.Add(1)
+		go func(upstream string) {
+			defer wg.Done()
+
+			start := time.Now()
+			err := probeUpstream(upstream)
+			state, changed := p.health.record(upstream, time.Since(start), err)
+
+			if state.Healthy {
+				p.metrics.upstreamUp.WithLabelValues(upstream).Set(1)
+			} else {
+				p.metrics.upstreamUp.WithLabelValues(upstream).Set(0)
+			}
+			if changed {
+				if state.Healthy {
+					utils.Logf("Upstream DNS server %s recovered", upstream)
+				} else {
+					log.Printf("Upstream DNS server %s marked down after %d failed checks: %s",
+						upstream, state.ConsecutiveFailures, state.LastError)
+				}
+			}
+		}(upstream)
+	}
+	wg.Wait()
+}
+
+// probeUpstream sends a canary query to an upstream and checks it answers
+func probeUpstream(upstream string) error {
+	response, err := exchangeUDP(upstream, canaryQuery(), time.Now().Add(healthCheckTimeout), nil)
+	if err != nil {
+		return err
+	}
+
+	rcode, err := extractRcode(response)
+	if err != nil {
+		return err
+	}
+	// NXDOMAIN still proves the server is answering
+	if rcode != 0 && rcode != 3 {
+		return fmt.Errorf("check query returned %s", rcodeString(rcode))
+	}
+	return nil
+}
+
+// canaryQuery builds an A query for healthCheckName with a random ID
+func canaryQuery() []byte {
+	query := make([]byte, headerSize, headerSize+len(healthCheckName)+6)
+	var id [2]byte
+	rand.Read(id[:])
+	copy(query[0:2], id[:])
+	query[2] = 0x01 // RD
+	binary.BigEndian.PutUint16(query[4:6], 1)
+
+	for _, label := range strings.Split(healthCheckName, ".") {
+		query = append(query, byte(len(label)))
+		query = append(query, label...)
+	}
+	return append(query, 0, 0, 1, 0, 1)
+}
@@ -0,0 +1,190 @@
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"log"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// hostsTTL is the TTL of answers from the hosts file
+const hostsTTL = 60
+
+// hostsCheckInterval is how often the hosts file is checked for changes
+var hostsCheckInterval = 2 * time.Second
+
+// parseHosts reads hosts-format lines, "IP hostname [aliases...]" with #
+// comments, and returns the addresses of each name, lower case without a
+// trailing dot. Names listed on several lines get every address. Malformed
+// lines are logged and skipped.
+func parseHosts(r io.Reader, source string) (map[string][]net.IP, error) {
+	hosts := make(map[string][]net.IP)
+	scanner := bufio.NewScanner(r)
+	for lineNum := 1; scanner.Scan(); lineNum++ {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) == 0 {
+			continue
+		}
+
+		ip := net.ParseIP(fields[0])
+		if ip == nil || len(fields) < 2 {
+			log.Printf("Skipping malformed line %d of %s: %q", lineNum, source, scanner.Text())
+			continue
+		}
+		if ip4 := ip.To4(); ip4 != nil {
+			ip = ip4
+		}
+		for _, field := range fields[1:] {
+			name, err := NormalizeBlockedDomain(field)
+			if err != nil {
+				log.Printf("Skipping invalid name %q on line %d of %s", field, lineNum, source)
+				continue
+			}
+			if !containsIP(hosts[name], ip) {
+				hosts[name] = append(hosts[name], ip)
+			}
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	return hosts, nil
+}
+
+func containsIP(ips []net.IP, ip net.IP) bool {
+	for _, existing := range ips {
+		if existing.Equal(ip) {
+			return true
+		}
+	}
+	return false
+}
+
+// loadHostsFile parses the hosts file at path and returns its entries with
+// the file's modification time and size, which identify the version read
+func loadHostsFile(path string) (map[string][]net.IP, time.Time, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, time.Time{}, 0, fmt.Errorf("could not open hosts file: %w", err)
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, time.Time{}, 0, err
+	}
+	hosts, err := parseHosts(f, path)
+	if err != nil {
+		return nil, time.Time{}, 0, fmt.Errorf("could not read hosts file: %w", err)
+	}
+	return hosts, info.ModTime(), info.Size(), nil
+}
+
+// ReadHostsFile parses the hosts-format file at path and returns the
+// addresses of each name, as the proxy would answer them
+func ReadHostsFile(path string) (map[string][]net.IP, error) {
+	hosts, _, _, err := loadHostsFile(path)
+	return hosts, err
+}
+
+// SetHostsFile answers A and AAAA queries for the names in the hosts-format
+// file at path from the file instead of forwarding them. The file is read
+// now and again whenever it changes while the proxy runs. An empty path
+// disables the hosts file.
+func (p *DNSProxy) SetHostsFile(path string) error {
+	var hosts map[string][]net.IP
+	var modTime time.Time
+	var size int64
+	if path != "" {
+		var err error
+		if hosts, modTime, size, err = loadHostsFile(path); err != nil {
+			return err
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.hostsFile = path
+	p.hosts = hosts
+	p.hostsModTime = modTime
+	p.hostsSize = size
+	return nil
+}
+
+// HostsFile returns the path of the hosts file; empty when disabled
+func (p *DNSProxy) HostsFile() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.hostsFile
+}
+
+// lookupHosts returns the addresses of name in the hosts file and whether
+// the name is listed at all
+func (p *DNSProxy) lookupHosts(name string) ([]net.IP, bool) {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	ips, ok := p.hosts[name]
+	return ips, ok
+}
+
+// hostsWatchTask re-reads the hosts file when its modification time or size
+// changes, checking until the proxy stops. A file that cannot be read keeps
+// the entries last read from it.
+func (p *DNSProxy) hostsWatchTask() {
+	ticker := time.NewTicker(hostsCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			p.mu.Lock()
+			path, modTime, size := p.hostsFile, p.hostsModTime, p.hostsSize
+			p.mu.Unlock()
+			if path == "" {
+				continue
+			}
+
+			info, err := os.Stat(path)
+			if err != nil || info.ModTime().Equal(modTime) && info.Size() == size {
+				continue
+			}
+			hosts, newModTime, newSize, err := loadHostsFile(path)
+			if err != nil {
+				log.Printf("Warning: keeping the previous hosts file entries: %v", err)
+				continue
+			}
+
+			p.mu.Lock()
+			// The path may have changed through a reload in the meantime
+			if p.hostsFile == path {
+				p.hosts, p.hostsModTime, p.hostsSize = hosts, newModTime, newSize
+			}
+			p.mu.Unlock()
+			log.Printf("Reloaded %d names from hosts file %s", len(hosts), path)
+		}
+	}
+}
+
+// hostsResponse answers query for qtype with the addresses of the matching
+// family in ips, or without records when there are none
+func hostsResponse(query []byte, qtype uint16, ips []net.IP) ([]byte, error) {
+	var answers []net.IP
+	for _, ip := range ips {
+		if is4 := ip.To4() != nil; is4 == (qtype == TypeA) {
+			answers = append(answers, ip)
+		}
+	}
+	return addressResponse(query, qtype, answers, hostsTTL)
+}
@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestParseHosts(t *testing.T) {
+	input := `# comment
+127.0.0.1   localhost
+10.0.0.5    nas.lan nas   # trailing comment
+10.0.0.6    NAS.lan.
+fd00::5     nas.lan
+not-an-ip   broken.lan
+10.0.0.7
+10.0.0.8    bad/name.lan ok.lan
+`
+	hosts, err := parseHosts(strings.NewReader(input), "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, ip := range hosts["nas.lan"] {
+		got = append(got, ip.String())
+	}
+	if strings.Join(got, " ") != "10.0.0.5 10.0.0.6 fd00::5" {
+		t.Errorf("nas.lan = %v, want the addresses of every line", got)
+	}
+	if len(hosts["nas"]) != 1 || len(hosts["localhost"]) != 1 || len(hosts["ok.lan"]) != 1 {
+		t.Errorf("aliases missing: %v", hosts)
+	}
+	if _, ok := hosts["broken.lan"]; ok {
+		t.Error("malformed line was not skipped")
+	}
+	if len(hosts) != 4 {
+		t.Errorf("got %d names, want 4: %v", len(hosts), hosts)
+	}
+}
+
+// writeHostsFile writes content to a hosts file in a temporary directory
+// and returns its path
+func writeHostsFile(t *testing.T, content string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+// answerValues returns the values of the answers in response
+func answerValues(t *testing.T, response []byte) []string {
+	t.Helper()
+	msg, err := ParseMessage(response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var values []string
+	for _, rr := range msg.Answers {
+		values = append(values, rr.Value)
+	}
+	return values
+}
+
+func TestHostsFileAnswers(t *testing.T) {
+	upstream := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetUpstreams([]string{upstream.addr}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetHostsFile(writeHostsFile(t, "10.0.0.5 nas.lan\n10.0.0.6 nas.lan\nfd00::5 nas.lan\n10.0.0.9 printer.lan\n")); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p.conn = conn
+
+	tests := []struct {
+		name  string
+		qtype uint16
+		want  string
+	}{
+		{"nas.lan", TypeA, "10.0.0.5 10.0.0.6"},
+		{"NAS.lan", TypeAAAA, "fd00::5"},
+		// Listed without an IPv6 address: NOERROR without records
+		{"printer.lan", TypeAAAA, ""},
+	}
+	for _, tt := range tests {
+		query, err := BuildQuery(7, tt.name, tt.qtype)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := exchange(t, p, query)
+		if rcode, _ := extractRcode(response); rcode != 0 {
+			t.Errorf("%s %s: rcode = %s", tt.name, typeString(tt.qtype), rcodeString(rcode))
+		}
+		if got := strings.Join(answerValues(t, response), " "); got != tt.want {
+			t.Errorf("%s %s answered %q, want %q", tt.name, typeString(tt.qtype), got, tt.want)
+		}
+	}
+	if n := atomic.LoadInt32(&upstream.queries); n != 0 {
+		t.Errorf("hosts file names forwarded %d times", n)
+	}
+
+	// Names not in the file are forwarded
+	exchange(t, p, testQuery(t, 8))
+	if n := atomic.LoadInt32(&upstream.queries); n != 1 {
+		t.Errorf("upstream got %d queries, want 1", n)
+	}
+
+	if err := p.SetHostsFile(filepath.Join(t.TempDir(), "missing")); err == nil {
+		t.Error("SetHostsFile accepted a missing file")
+	}
+}
+
+func TestHostsFileWatch(t *testing.T) {
+	defer func(interval time.Duration) { hostsCheckInterval = interval }(hostsCheckInterval)
+	hostsCheckInterval = 10 * time.Millisecond
+
+	path := writeHostsFile(t, "10.0.0.5 nas.lan\n")
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetHostsFile(path); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	// Edits take effect without a reload
+	if err := os.WriteFile(path, []byte("10.0.0.50 nas.lan\n10.0.0.9 printer.lan\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		ips, _ := p.lookupHosts("nas.lan")
+		if len(ips) == 1 && ips[0].String() == "10.0.0.50" {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("hosts file change not picked up, nas.lan = %v", ips)
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if _, ok := p.lookupHosts("printer.lan"); !ok {
+		t.Error("added name not picked up")
+	}
+
+	// The watcher stops with the proxy
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte("10.0.0.99 nas.lan\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if ips, _ := p.lookupHosts("nas.lan"); len(ips) != 1 || ips[0].String() != "10.0.0.50" {
+		t.Errorf("hosts file read after Stop: nas.lan = %v", ips)
+	}
+}
@@ -0,0 +1,69 @@
+package dns
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+	"time"
+)
+
+// idleCheckInterval is how often the proxy checks whether it has gone
+// without queries for longer than its idle timeout
+var idleCheckInterval = 30 * time.Second
+
+// SetIdleTimeout sets how long the proxy may go without receiving a query
+// before the channel returned by Idle is closed. Zero disables the timeout.
+func (p *DNSProxy) SetIdleTimeout(timeout time.Duration) error {
+	if timeout < 0 {
+		return fmt.Errorf("invalid idle timeout: %v", timeout)
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.idleTimeout = timeout
+	return nil
+}
+
+// IdleTimeout returns the idle timeout; zero when disabled
+func (p *DNSProxy) IdleTimeout() time.Duration {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.idleTimeout
+}
+
+// Idle returns a channel that is closed once the running proxy has received
+// no query for its idle timeout. The proxy keeps serving; stopping it is
+// left to the caller.
+func (p *DNSProxy) Idle() <-chan struct{} {
+	return p.idle
+}
+
+// touch records that a query was received
+func (p *DNSProxy) touch() {
+	atomic.StoreInt64(&p.lastQuery, time.Now().UnixNano())
+}
+
+// idleWatchTask closes p.idle when no query has been received for the idle
+// timeout, checking until the proxy stops
+func (p *DNSProxy) idleWatchTask() {
+	ticker := time.NewTicker(idleCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			timeout := p.IdleTimeout()
+			if timeout <= 0 {
+				continue
+			}
+			idle := time.Since(time.Unix(0, atomic.LoadInt64(&p.lastQuery)))
+			if idle >= timeout {
+				log.Printf("No DNS queries received for %v (idle timeout %v)", idle.Round(time.Second), timeout)
+				close(p.idle)
+				return
+			}
+		}
+	}
+}
@@ -0,0 +1,81 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestIdleTimeout(t *testing.T) {
+	defer func(interval time.Duration) { idleCheckInterval = interval }(idleCheckInterval)
+	idleCheckInterval = 10 * time.Millisecond
+
+	upstream := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetUpstreams([]string{upstream.addr}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetIdleTimeout(-time.Second); err == nil {
+		t.Error("SetIdleTimeout accepted a negative timeout")
+	}
+	if err := p.SetIdleTimeout(200 * time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	client, err := net.DialUDP("udp", nil, p.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Queries keep the proxy from going idle for longer than the timeout
+	start := time.Now()
+	for time.Since(start) < 400*time.Millisecond {
+		client.Write(testQuery(t, 1))
+		select {
+		case <-p.Idle():
+			t.Fatal("proxy went idle while receiving queries")
+		case <-time.After(50 * time.Millisecond):
+		}
+	}
+
+	select {
+	case <-p.Idle():
+	case <-time.After(time.Second):
+		t.Fatal("proxy did not go idle without queries")
+	}
+	if !p.IsRunning() {
+		t.Error("proxy stopped itself; stopping is left to the caller")
+	}
+}
+
+func TestIdleTimeoutDisabled(t *testing.T) {
+	defer func(interval time.Duration) { idleCheckInterval = interval }(idleCheckInterval)
+	idleCheckInterval = 10 * time.Millisecond
+
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	select {
+	case <-p.Idle():
+		t.Fatal("proxy went idle without an idle timeout")
+	case <-time.After(100 * time.Millisecond):
+	}
+
+	// Enabling the timeout on a reload applies it to the running proxy
+	if err := p.ApplyConfig(ReloadConfig{Upstreams: []string{"8.8.8.8:53"}, IdleTimeout: 50 * time.Millisecond}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-p.Idle():
+	case <-time.After(time.Second):
+		t.Fatal("proxy did not go idle after the timeout was enabled")
+	}
+}
@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"fmt"
+	"log"
+	"net"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// DefaultMaxInflight is how many queries are processed at once by default
+	DefaultMaxInflight = 2048
+	// maxInflightLimit bounds the limit; each query in flight holds a buffer
+	// and up to one upstream socket per upstream server
+	maxInflightLimit = 65536
+	// droppedLogInterval is how often dropping queries is reported while it
+	// goes on
+	droppedLogInterval = 10 * time.Second
+)
+
+// ValidateMaxInflight checks the limit of queries processed at once
+func ValidateMaxInflight(n int) error {
+	if n < 1 || n > maxInflightLimit {
+		return fmt.Errorf("invalid maximum of in-flight queries: %d (must be 1 to %d)", n, maxInflightLimit)
+	}
+	return nil
+}
+
+// SetMaxInflight limits how many queries are processed at once. Queries
+// received while n are in flight are dropped without an answer, as when
+// they are lost, so that a flood of queries cannot exhaust memory or
+// sockets; clients send them again.
+func (p *DNSProxy) SetMaxInflight(n int) error {
+	if err := ValidateMaxInflight(n); err != nil {
+		return err
+	}
+	atomic.StoreInt64(&p.maxInflight, int64(n))
+	return nil
+}
+
+// MaxInflight returns how many queries are processed at once at most
+func (p *DNSProxy) MaxInflight() int {
+	return int(atomic.LoadInt64(&p.maxInflight))
+}
+
+// DroppedQueries returns how many queries were dropped because the limit of
+// queries in flight was reached
+func (p *DNSProxy) DroppedQueries() uint64 {
+	return atomic.LoadUint64(&p.dropped)
+}
+
+// acquireQuery takes one of the slots of queries in flight, reporting false
+// when all of them are taken
+func (p *DNSProxy) acquireQuery() bool {
+	if atomic.AddInt64(&p.active, 1) > atomic.LoadInt64(&p.maxInflight) {
+		atomic.AddInt64(&p.active, -1)
+		return false
+	}
+	return true
+}
+
+// releaseQuery frees the slot taken by acquireQuery
+func (p *DNSProxy) releaseQuery() {
+	atomic.AddInt64(&p.active, -1)
+}
+
+// dropQuery records a query dropped over the limit of queries in flight,
+// logging at most once every droppedLogInterval. It is only called by
+// handleRequests.
+func (p *DNSProxy) dropQuery(clientAddr *net.UDPAddr) {
+	dropped := atomic.AddUint64(&p.dropped, 1)
+	p.metrics.dropped.Inc()
+
+	if now := time.Now(); now.Sub(p.lastDropLog) >= droppedLogInterval {
+		p.lastDropLog = now
+		log.Printf("Warning: %d queries in flight, dropping new ones such as one from %s (%d dropped so far)",
+			p.MaxInflight(), clientAddr, dropped)
+	}
+}
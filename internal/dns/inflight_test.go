@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+	"testing"
+	"time"
+)
+
+func TestValidateMaxInflight(t *testing.T) {
+	for _, n := range []int{1, DefaultMaxInflight, maxInflightLimit} {
+		if err := ValidateMaxInflight(n); err != nil {
+			t.Errorf("ValidateMaxInflight(%d) = %v", n, err)
+		}
+	}
+	for _, n := range []int{-1, 0, maxInflightLimit + 1} {
+		if err := ValidateMaxInflight(n); err == nil {
+			t.Errorf("ValidateMaxInflight(%d) succeeded", n)
+		}
+	}
+}
+
+func TestMaxInflightDropsExcessQueries(t *testing.T) {
+	const limit, flood = 4, 20
+
+	upstream := startDelayedUpstream(t, 300*time.Millisecond)
+	p := newTestProxy(t, StrategyPriority)
+	if err := p.SetUpstreams([]string{upstream}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetMaxInflight(limit); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	client, err := net.DialUDP("udp", nil, p.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	// Distinct names keep the queries from being answered together
+	for i := 0; i < flood; i++ {
+		query, err := BuildQuery(uint16(i+1), fmt.Sprintf("flood%d.example.com", i), TypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if _, err := client.Write(query); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	answered := 0
+	buf := make([]byte, 512)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	for {
+		if _, err := client.Read(buf); err != nil {
+			break
+		}
+		answered++
+	}
+	if answered != limit {
+		t.Errorf("%d queries answered, want %d", answered, limit)
+	}
+	if dropped := p.DroppedQueries(); dropped != flood-limit {
+		t.Errorf("%d queries dropped, want %d", dropped, flood-limit)
+	}
+
+	// The slots are free again once the queries are answered
+	if _, err := client.Write(testQuery(t, 0x1234)); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("query after the flood was not answered: %v", err)
+	}
+	if id := binary.BigEndian.Uint16(buf[0:2]); n < 12 || id != 0x1234 {
+		t.Errorf("response ID = %#x, want %#x", id, 0x1234)
+	}
+}
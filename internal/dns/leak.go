@@ -0,0 +1,57 @@
+package dns
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+)
+
+// leakTestURL is the endpoint that reports which resolver looked up the host
+// name of the request. %s is replaced with a random label so the lookup
+// cannot be answered from a cache. A variable so tests can use a local server.
+var leakTestURL = "http://%s.edns.ip-api.com/json"
+
+// ObservedResolver is the resolver the outside world saw resolving a lookup
+// made through the system DNS settings
+type ObservedResolver struct {
+	IP  string `json:"ip"`
+	Geo string `json:"geo"`
+}
+
+// ProbeResolver resolves a unique host name through the system resolver and
+// asks the leak test endpoint which resolver performed the lookup
+func ProbeResolver(ctx context.Context) (*ObservedResolver, error) {
+	label := make([]byte, 8)
+	if _, err := rand.Read(label); err != nil {
+		return nil, err
+	}
+	url := fmt.Sprintf(leakTestURL, hex.EncodeToString(label))
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return nil, err
+	}
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("leak test request failed: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("leak test endpoint returned status %d", resp.StatusCode)
+	}
+
+	var result struct {
+		DNS ObservedResolver `json:"dns"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil {
+		return nil, fmt.Errorf("invalid leak test response: %w", err)
+	}
+	if strings.TrimSpace(result.DNS.IP) == "" {
+		return nil, fmt.Errorf("leak test endpoint did not report a resolver")
+	}
+	return &result.DNS, nil
+}
@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+const netshDNSServersOutput = `
+Configuration for interface "Ethernet"
+    Statically Configured DNS Servers:    127.0.0.1
+    Register with which suffix:           Primary only
+
+Configuration for interface "Wi-Fi"
+    DNS servers configured through DHCP:  192.168.1.1
+                                          fe80::1
+    Register with which suffix:           Primary only
+
+Configuration for interface "Loopback Pseudo-Interface 1"
+    Statically Configured DNS Servers:    None
+    Register with which suffix:           Primary only
+`
+
+func TestParseWindowsDNSServers(t *testing.T) {
+	got := parseWindowsDNSServers(netshDNSServersOutput)
+	if len(got) != 2 {
+		t.Fatalf("got %d interfaces, want 2 (loopback filtered): %+v", len(got), got)
+	}
+	if got[0].Interface != "Ethernet" || got[0].Automatic || strings.Join(got[0].Servers, ",") != "127.0.0.1" {
+		t.Errorf("unexpected Ethernet: %+v", got[0])
+	}
+	if got[1].Interface != "Wi-Fi" || !got[1].Automatic || strings.Join(got[1].Servers, ",") != "192.168.1.1,fe80::1" {
+		t.Errorf("unexpected Wi-Fi: %+v", got[1])
+	}
+	if !got[0].UsesProxy("127.0.0.1") || got[1].UsesProxy("127.0.0.1") {
+		t.Error("UsesProxy does not match the configured servers")
+	}
+}
+
+func TestParseResolvConfServers(t *testing.T) {
+	servers := parseResolvConfServers("# generated\nsearch home.arpa\nnameserver 127.0.0.1\nnameserver 1.1.1.1\n")
+	if strings.Join(servers, ",") != "127.0.0.1,1.1.1.1" {
+		t.Errorf("got %q", servers)
+	}
+	if (InterfaceDNS{Servers: servers}).UsesProxy("127.0.0.1") {
+		t.Error("a fallback nameserver bypasses the proxy")
+	}
+	if (InterfaceDNS{}).UsesProxy("127.0.0.1") {
+		t.Error("no nameservers cannot use the proxy")
+	}
+}
+
+func TestProbeResolver(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if len(r.URL.Query().Get("label")) != 16 {
+			t.Errorf("request without a random label: %s", r.URL)
+		}
+		w.Write([]byte(`{"dns":{"geo":"United States - Google","ip":"74.125.0.1"}}`))
+	}))
+	defer server.Close()
+
+	oldURL := leakTestURL
+	defer func() { leakTestURL = oldURL }()
+	leakTestURL = server.URL + "/json?label=%s"
+
+	resolver, err := ProbeResolver(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if resolver.IP != "74.125.0.1" || resolver.Geo != "United States - Google" {
+		t.Errorf("got %+v", resolver)
+	}
+}
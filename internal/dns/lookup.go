@@ -0,0 +1,68 @@
+package dns
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// ParseType returns the record type named by s: a mnemonic such as "MX",
+// case-insensitive, or a number, optionally written as "TYPE65"
+func ParseType(s string) (uint16, error) {
+	upper := strings.ToUpper(strings.TrimSpace(s))
+	for qtype, name := range typeNames {
+		if name == upper {
+			return qtype, nil
+		}
+	}
+	if n, err := strconv.ParseUint(strings.TrimPrefix(upper, "TYPE"), 10, 16); err == nil && n > 0 {
+		return uint16(n), nil
+	}
+	return 0, fmt.Errorf("unknown record type: %s", s)
+}
+
+// Lookup sends a single query for name and qtype over UDP to server, a
+// host:port address, and returns the decoded response and the round-trip
+// time. Responses whose ID does not match the query are rejected.
+func Lookup(server, name string, qtype uint16, timeout time.Duration) (*Message, time.Duration, error) {
+	var idBytes [2]byte
+	rand.Read(idBytes[:])
+	id := binary.BigEndian.Uint16(idBytes[:])
+
+	query, err := BuildQuery(id, name, qtype)
+	if err != nil {
+		return nil, 0, err
+	}
+
+	conn, err := net.Dial("udp", server)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer conn.Close()
+	conn.SetDeadline(time.Now().Add(timeout))
+
+	start := time.Now()
+	if _, err := conn.Write(query); err != nil {
+		return nil, 0, fmt.Errorf("sending query: %w", err)
+	}
+
+	buf := make([]byte, udpBufferSize)
+	n, err := conn.Read(buf)
+	if err != nil {
+		return nil, 0, fmt.Errorf("receiving response: %w", err)
+	}
+	latency := time.Since(start)
+
+	resp, err := ParseMessage(buf[:n])
+	if err != nil {
+		return nil, 0, fmt.Errorf("parsing response: %w", err)
+	}
+	if resp.ID != id {
+		return nil, 0, fmt.Errorf("response ID %d does not match query ID %d", resp.ID, id)
+	}
+	return resp, latency, nil
+}
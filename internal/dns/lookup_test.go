@@ -0,0 +1,74 @@
+package dns
+
+import (
+	"testing"
+	"time"
+)
+
+func TestParseType(t *testing.T) {
+	tests := []struct {
+		in   string
+		want uint16
+	}{
+		{"A", TypeA},
+		{"aaaa", TypeAAAA},
+		{"MX", TypeMX},
+		{"txt", TypeTXT},
+		{"TYPE65", 65},
+		{"99", 99},
+	}
+	for _, tt := range tests {
+		if got, err := ParseType(tt.in); err != nil || got != tt.want {
+			t.Errorf("ParseType(%q) = %d, %v, want %d", tt.in, got, err, tt.want)
+		}
+	}
+
+	for _, in := range []string{"", "FOO", "TYPE0", "70000"} {
+		if _, err := ParseType(in); err == nil {
+			t.Errorf("ParseType(%q) succeeded, want an error", in)
+		}
+	}
+}
+
+func TestLookup(t *testing.T) {
+	server := startRecordUpstream(t,
+		// MX 10 mail.<question>
+		[]byte{0xc0, headerSize, 0x00, 0x0f, 0x00, 0x01, 0x00, 0x00, 0x0e, 0x10, 0x00, 0x09,
+			0x00, 0x0a, 4, 'm', 'a', 'i', 'l', 0xc0, headerSize},
+		// TXT "v=spf1" "-all"
+		[]byte{0xc0, headerSize, 0x00, 0x10, 0x00, 0x01, 0x00, 0x00, 0x00, 0x3c, 0x00, 0x0c,
+			6, 'v', '=', 's', 'p', 'f', '1', 4, '-', 'a', 'l', 'l'},
+	).addr
+
+	resp, latency, err := Lookup(server, "example.com", TypeMX, time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if latency <= 0 {
+		t.Errorf("latency = %v, want positive", latency)
+	}
+	if len(resp.Answers) != 2 {
+		t.Fatalf("got %d answers, want 2", len(resp.Answers))
+	}
+
+	mx := resp.Answers[0]
+	if mx.Name != "example.com." || mx.Type != TypeMX || mx.TTL != 3600 || mx.Value != "10 mail.example.com." {
+		t.Errorf("MX record = %+v", mx)
+	}
+	txt := resp.Answers[1]
+	if txt.Type != TypeTXT || txt.TTL != 60 || txt.Value != `"v=spf1" "-all"` {
+		t.Errorf("TXT record = %+v", txt)
+	}
+}
+
+func TestLookupTimeout(t *testing.T) {
+	upstream := startTestUpstream(t, false)
+
+	start := time.Now()
+	if _, _, err := Lookup(upstream.addr, "example.com", TypeA, 100*time.Millisecond); err == nil {
+		t.Fatal("expected a timeout")
+	}
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("Lookup took %v, want about the timeout", elapsed)
+	}
+}
@@ -0,0 +1,253 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"net"
+)
+
+// DNS header size in bytes
+const headerSize = 12
+
+// Common DNS record types
+var typeNames = map[uint16]string{
+	1:   "A",
+	2:   "NS",
+	5:   "CNAME",
+	6:   "SOA",
+	12:  "PTR",
+	15:  "MX",
+	16:  "TXT",
+	28:  "AAAA",
+	33:  "SRV",
+	64:  "SVCB",
+	65:  "HTTPS",
+	255: "ANY",
+}
+
+// DNS response codes
+var rcodeNames = map[int]string{
+	0: "NOERROR",
+	1: "FORMERR",
+	2: "SERVFAIL",
+	3: "NXDOMAIN",
+	4: "NOTIMP",
+	5: "REFUSED",
+}
+
+// typeString returns the mnemonic of a record type
+func typeString(qtype uint16) string {
+	if name, ok := typeNames[qtype]; ok {
+		return name
+	}
+	return fmt.Sprintf("TYPE%d", qtype)
+}
+
+// rcodeString returns the mnemonic of a response code
+func rcodeString(rcode int) string {
+	if name, ok := rcodeNames[rcode]; ok {
+		return name
+	}
+	return fmt.Sprintf("RCODE%d", rcode)
+}
+
+// extractQueryName returns the name and type of the first question in a DNS message
+func extractQueryName(msg []byte) (string, uint16, error) {
+	if len(msg) < headerSize {
+		return "", 0, fmt.Errorf("message too short")
+	}
+	if binary.BigEndian.Uint16(msg[4:6]) == 0 {
+		return "", 0, fmt.Errorf("message has no question")
+	}
+
+	name, offset, err := readName(msg, headerSize)
+	if err != nil {
+		return "", 0, err
+	}
+
+	if offset+4 > len(msg) {
+		return "", 0, fmt.Errorf("question type out of bounds")
+	}
+	qtype := binary.BigEndian.Uint16(msg[offset : offset+2])
+
+	return name, qtype, nil
+}
+
+// emptyResponse builds a NOERROR response to query without any records. It
+// carries the transaction ID and first question of the query.
+func emptyResponse(query []byte) ([]byte, error) {
+	if len(query) < headerSize {
+		return nil, fmt.Errorf("message too short")
+	}
+	_, end, err := readName(query, headerSize)
+	if err != nil {
+		return nil, err
+	}
+	end += 4
+	if end > len(query) {
+		return nil, fmt.Errorf("question type out of bounds")
+	}
+
+	response := make([]byte, end)
+	copy(response, query[:end])
+	// QR set, opcode and RD copied from the query, RA set and rcode NOERROR
+	response[2] = 0x80 | query[2]&0x79
+	response[3] = 0x80
+	binary.BigEndian.PutUint16(response[4:6], 1)
+	for i := 6; i < headerSize; i++ {
+		response[i] = 0
+	}
+	return response, nil
+}
+
+// addressResponse builds a NOERROR response to query answering it with an
+// A or AAAA record, as qtype asks, for each of ips
+func addressResponse(query []byte, qtype uint16, ips []net.IP, ttl uint32) ([]byte, error) {
+	response, err := emptyResponse(query)
+	if err != nil {
+		return nil, err
+	}
+
+	for _, ip := range ips {
+		address := ip.To16()
+		if qtype == TypeA {
+			address = ip.To4()
+		}
+		if address == nil {
+			return nil, fmt.Errorf("%s is not an address for a %s record", ip, typeString(qtype))
+		}
+		// Name pointer to the question, type, class IN, TTL, address
+		record := make([]byte, 12, 12+len(address))
+		binary.BigEndian.PutUint16(record[0:2], 0xc000|headerSize)
+		binary.BigEndian.PutUint16(record[2:4], qtype)
+		binary.BigEndian.PutUint16(record[4:6], 1)
+		binary.BigEndian.PutUint32(record[6:10], ttl)
+		binary.BigEndian.PutUint16(record[10:12], uint16(len(address)))
+		response = append(append(response, record...), address...)
+	}
+	binary.BigEndian.PutUint16(response[6:8], uint16(len(ips)))
+	return response, nil
+}
+
+// formErrResponse builds a FORMERR response to a query that could not be
+// handled. The question section is echoed when it parses; otherwise only
+// the header is trusted and the response has no sections.
+func formErrResponse(query []byte) ([]byte, error) {
+	if len(query) < headerSize {
+		return nil, fmt.Errorf("message too short")
+	}
+
+	end, err := skipQuestions(query)
+	if err != nil {
+		end = headerSize
+	}
+	response := make([]byte, end)
+	copy(response, query[:end])
+	// QR set, opcode and RD copied from the query, RA set and rcode FORMERR
+	response[2] = 0x80 | query[2]&0x79
+	response[3] = 0x80 | 1
+	if err != nil {
+		response[4], response[5] = 0, 0
+	}
+	for i := 6; i < headerSize; i++ {
+		response[i] = 0
+	}
+	return response, nil
+}
+
+// extractRcode returns the response code of a DNS message
+func extractRcode(msg []byte) (int, error) {
+	if len(msg) < headerSize {
+		return 0, fmt.Errorf("message too short")
+	}
+	return int(msg[3] & 0x0f), nil
+}
+
+// Record type of the EDNS0 OPT pseudo-record, whose TTL field holds flags
+const typeOPT = 41
+
+// skipName returns the offset just past the (possibly compressed) name at offset
+func skipName(msg []byte, offset int) (int, error) {
+	_, end, err := readName(msg, offset)
+	return end, err
+}
+
+// skipQuestions returns the offset just past the question section of a DNS
+// message
+func skipQuestions(msg []byte) (int, error) {
+	if len(msg) < headerSize {
+		return 0, fmt.Errorf("message too short")
+	}
+	qdCount := int(binary.BigEndian.Uint16(msg[4:6]))
+
+	offset := headerSize
+	for i := 0; i < qdCount; i++ {
+		end, err := skipName(msg, offset)
+		if err != nil {
+			return 0, err
+		}
+		offset = end + 4
+		if offset > len(msg) {
+			return 0, fmt.Errorf("question out of bounds")
+		}
+	}
+	return offset, nil
+}
+
+// walkRecords calls fn with the type and TTL offset of every resource record
+// in the answer, authority and additional sections of a DNS message. It
+// returns the offset just past the last record.
+func walkRecords(msg []byte, fn func(rrType uint16, ttlOffset int)) (int, error) {
+	if len(msg) < headerSize {
+		return 0, fmt.Errorf("message too short")
+	}
+	rrCount := int(binary.BigEndian.Uint16(msg[6:8])) +
+		int(binary.BigEndian.Uint16(msg[8:10])) +
+		int(binary.BigEndian.Uint16(msg[10:12]))
+
+	offset, err := skipQuestions(msg)
+	if err != nil {
+		return 0, err
+	}
+
+	for i := 0; i < rrCount; i++ {
+		end, err := skipName(msg, offset)
+		if err != nil {
+			return 0, err
+		}
+		if end+10 > len(msg) {
+			return 0, fmt.Errorf("record header out of bounds")
+		}
+		rrType := binary.BigEndian.Uint16(msg[end : end+2])
+		rdLength := int(binary.BigEndian.Uint16(msg[end+8 : end+10]))
+		fn(rrType, end+4)
+
+		offset = end + 10 + rdLength
+		if offset > len(msg) {
+			return 0, fmt.Errorf("record data out of bounds")
+		}
+	}
+
+	return offset, nil
+}
+
+// minTTL returns the smallest TTL among the records of a DNS message and
+// whether the message contained any record with a TTL
+func minTTL(msg []byte) (uint32, bool) {
+	var ttl uint32
+	found := false
+	_, err := walkRecords(msg, func(rrType uint16, ttlOffset int) {
+		if rrType == typeOPT {
+			return
+		}
+		value := binary.BigEndian.Uint32(msg[ttlOffset : ttlOffset+4])
+		if !found || value < ttl {
+			ttl = value
+			found = true
+		}
+	})
+	if err != nil {
+		return 0, false
+	}
+	return ttl, found
+}
@@ -0,0 +1,235 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"math/rand"
+	"strings"
+	"testing"
+)
+
+// compressedResponse returns a response to "example.com. A" whose answer
+// name is a pointer to the question name
+func compressedResponse(t *testing.T) []byte {
+	t.Helper()
+	msg := testQuery(t, 1)
+	msg[2] |= 0x80
+	binary.BigEndian.PutUint16(msg[6:8], 1)
+	// name -> pointer to offset 12, type A, class IN, TTL 300, 4-byte address
+	return append(msg,
+		0xc0, 0x0c,
+		0x00, 0x01, 0x00, 0x01,
+		0x00, 0x00, 0x01, 0x2c,
+		0x00, 0x04, 192, 0, 2, 1)
+}
+
+func TestExtractQueryName(t *testing.T) {
+	name, qtype, err := extractQueryName(testQuery(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "example.com." || qtype != TypeA {
+		t.Errorf("got %s %s, want example.com. A", name, typeString(qtype))
+	}
+}
+
+func TestExtractQueryNameCompressed(t *testing.T) {
+	// Question name "a." followed by a pointer to "example.com." placed
+	// after the question
+	msg := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+	msg = append(msg, 1, 'a', 0xc0, 20, 0x00, 0x1c, 0x00, 0x01)
+	msg = append(msg, 7, 'e', 'x', 'a', 'm', 'p', 'l', 'e', 3, 'c', 'o', 'm', 0)
+
+	name, qtype, err := extractQueryName(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "a.example.com." || qtype != TypeAAAA {
+		t.Errorf("got %s %s, want a.example.com. AAAA", name, typeString(qtype))
+	}
+}
+
+func TestExtractQueryNamePointerLoop(t *testing.T) {
+	msg := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+	// The question name points at itself
+	msg = append(msg, 0xc0, headerSize, 0x00, 0x01, 0x00, 0x01)
+
+	if _, _, err := extractQueryName(msg); err == nil {
+		t.Error("expected an error for a compression pointer loop")
+	}
+}
+
+func TestExtractQueryNameRoot(t *testing.T) {
+	msg := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+	// The root name is a single zero byte; type NS, class IN
+	msg = append(msg, 0, 0x00, 0x02, 0x00, 0x01)
+
+	name, qtype, err := extractQueryName(msg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if name != "." || qtype != TypeNS {
+		t.Errorf("got %s %s, want . NS", name, typeString(qtype))
+	}
+
+	// Without the class the question is incomplete
+	if _, _, err := extractQueryName(msg[:len(msg)-2]); err == nil {
+		t.Error("expected an error for a question without class")
+	}
+}
+
+func TestExtractQueryNameMalformed(t *testing.T) {
+	query := testQuery(t, 1)
+
+	// Every truncation of a valid query is an error, never a panic
+	for n := 0; n < len(query); n++ {
+		if _, _, err := extractQueryName(query[:n]); err == nil {
+			t.Errorf("no error for the query truncated to %d bytes", n)
+		}
+	}
+
+	// Random bytes, with a question count so the name is parsed
+	rng := rand.New(rand.NewSource(1))
+	for i := 0; i < 10000; i++ {
+		msg := make([]byte, rng.Intn(64))
+		rng.Read(msg)
+		if len(msg) >= headerSize {
+			binary.BigEndian.PutUint16(msg[4:6], 1)
+		}
+		extractQueryName(msg)
+	}
+}
+
+func TestExtractQueryNameTooLong(t *testing.T) {
+	msg := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(msg[4:6], 1)
+	// Five 63-byte labels make a 321-byte name
+	for i := 0; i < 5; i++ {
+		msg = append(msg, 63)
+		msg = append(msg, bytes.Repeat([]byte{'a'}, 63)...)
+	}
+	msg = append(msg, 0, 0x00, 0x01, 0x00, 0x01)
+
+	if _, _, err := extractQueryName(msg); err == nil {
+		t.Error("expected an error for a name longer than 255 bytes")
+	}
+}
+
+// fuzzSeeds adds a normal A query, a query for the root and a truncated
+// header to the corpus of a fuzz target
+func fuzzSeeds(f *testing.F) {
+	query := testQuery(f, 1)
+	root := make([]byte, headerSize)
+	binary.BigEndian.PutUint16(root[4:6], 1)
+	root = append(root, 0, 0x00, 0x02, 0x00, 0x01)
+
+	f.Add(query)
+	f.Add(root)
+	f.Add(query[:headerSize-4])
+}
+
+func FuzzExtractQueryName(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, msg []byte) {
+		name, _, err := extractQueryName(msg)
+		if err != nil {
+			return
+		}
+		if !strings.HasSuffix(name, ".") {
+			t.Errorf("name %q is not fully qualified", name)
+		}
+		// Dotted names are at most the wire length minus one
+		if len(name) > maxNameLength {
+			t.Errorf("name is %d bytes, longer than %d", len(name), maxNameLength)
+		}
+	})
+}
+
+// FuzzQueryHandling runs what processQuery does with a query from a client
+// before it reaches an upstream
+func FuzzQueryHandling(f *testing.F) {
+	fuzzSeeds(f)
+	f.Fuzz(func(t *testing.T, query []byte) {
+		name, qtype, err := extractQueryName(query)
+		if err != nil {
+			if response, err := formErrResponse(query); err == nil && len(response) != headerSize {
+				t.Errorf("FORMERR response is %d bytes", len(response))
+			}
+			return
+		}
+
+		newCacheKey(name, qtype, query)
+		for _, mode := range []string{BlockNXDomain, BlockZeroIP, BlockRefused} {
+			blockedResponse(query, qtype, mode)
+		}
+		if response, err := emptyResponse(query); err == nil {
+			limit, clientEDNS := clientUDPSize(query)
+			fitResponse(response, clientEDNS, limit)
+		}
+		if forwarded, added := addEDNS0(query); added {
+			if opt, err := findOPT(forwarded); err != nil || opt == nil {
+				t.Errorf("no OPT record after addEDNS0: %v", err)
+			}
+		}
+	})
+}
+
+func TestAddEDNS0DropsTrailingData(t *testing.T) {
+	query := append(testQuery(t, 1), 0xff, 0xff)
+
+	forwarded, added := addEDNS0(query)
+	if !added {
+		t.Fatal("no OPT record added")
+	}
+	if opt, err := findOPT(forwarded); err != nil || opt == nil || !opt.last {
+		t.Errorf("findOPT = %+v, %v, want the last record", opt, err)
+	}
+
+	// A second question without type and class cannot be extended
+	truncated := testQuery(t, 1)
+	binary.BigEndian.PutUint16(truncated[4:6], 2)
+	truncated = append(truncated, 0)
+	if _, added := addEDNS0(truncated); added {
+		t.Error("OPT record added to a truncated question")
+	}
+}
+
+func TestSkipNameCompressed(t *testing.T) {
+	msg := compressedResponse(t)
+	answer := len(testQuery(t, 1))
+
+	end, err := skipName(msg, answer)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if end != answer+2 {
+		t.Errorf("skipName = %d, want %d", end, answer+2)
+	}
+
+	ttl, ok := minTTL(msg)
+	if !ok || ttl != 300 {
+		t.Errorf("minTTL = %d, %v, want 300, true", ttl, ok)
+	}
+}
+
+func TestSkipNameRejectsBadPointers(t *testing.T) {
+	msg := compressedResponse(t)
+	answer := len(testQuery(t, 1))
+
+	// A pointer to itself
+	loop := append([]byte(nil), msg...)
+	binary.BigEndian.PutUint16(loop[answer:], 0xc000|uint16(answer))
+	if _, err := skipName(loop, answer); err == nil {
+		t.Error("expected an error for a compression pointer loop")
+	}
+
+	// A pointer past the end of the message
+	outside := append([]byte(nil), msg...)
+	binary.BigEndian.PutUint16(outside[answer:], 0xc000|uint16(len(msg)+10))
+	if _, err := skipName(outside, answer); err == nil {
+		t.Error("expected an error for a pointer out of bounds")
+	}
+}
@@ -0,0 +1,150 @@
+package dns
+
+import (
+	"context"
+	"log"
+	"net"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+
+	"github.com/ourines/GateShift/internal/utils"
+)
+
+// proxyMetrics holds the Prometheus collectors of a DNS proxy
+type proxyMetrics struct {
+	registry        *prometheus.Registry
+	queries         prometheus.Counter
+	upstreamQueries *prometheus.CounterVec
+	upstreamErrors  *prometheus.CounterVec
+	upstreamLatency *prometheus.HistogramVec
+	upstreamUp      *prometheus.GaugeVec
+	cacheHits       prometheus.Counter
+	cacheMisses     prometheus.Counter
+	blocked         prometheus.Counter
+	panics          prometheus.Counter
+	dropped         prometheus.Counter
+}
+
+// newProxyMetrics creates and registers the DNS proxy collectors
+func newProxyMetrics(cache *dnsCache) *proxyMetrics {
+	m := &proxyMetrics{
+		registry: prometheus.NewRegistry(),
+		queries: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "gateshift",
+			Subsystem: "dns",
+			Name:      "queries_total",
+			Help:      "Total number of DNS queries received from clients.",
+		}),
+		upstreamQueries: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gateshift",
+			Subsystem: "dns",
+			Name:      "upstream_queries_total",
+			Help:      "Total number of DNS queries forwarded to each upstream server.",
+		}, []string{"upstream"}),
+		upstreamErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: "gateshift",
+			Subsystem: "dns",
+			Name:      "upstream_errors_total",
+			Help:      "Total number of failed queries to each upstream server.",
+		}, []string{"upstream"}),
+		upstreamLatency: prometheus.NewHistogramVec(prometheus.HistogramOpts{
+			Namespace: "gateshift",
+			Subsystem: "dns",
+			Name:      "upstream_latency_seconds",
+			Help:      "Latency of successful queries to each upstream server.",
+			Buckets:   prometheus.DefBuckets,
+		}, []string{"upstream"}),
+		upstreamUp: prometheus.NewGaugeVec(prometheus.GaugeOpts{
+			Namespace: "gateshift",
+			Subsystem: "dns",
+			Name:      "upstream_up",
+			Help:      "Whether each upstream server passes health checks (1) or is marked down (0).",
+		}, []string{"upstream"}),
+		cacheHits: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "gateshift",
+			Subsystem: "dns",
+			Name:      "cache_hits_total",
+			Help:      "Total number of queries answered from the cache.",
+		}),
+		cacheMisses: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "gateshift",
+			Subsystem: "dns",
+			Name:      "cache_misses_total",
+			Help:      "Total number of queries not found in the cache.",
+		}),
+		blocked: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "gateshift",
+			Subsystem: "dns",
+			Name:      "blocked_queries_total",
+			Help:      "Total number of queries answered from the blocklist.",
+		}),
+		panics: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "gateshift",
+			Subsystem: "dns",
+			Name:      "query_panics_total",
+			Help:      "Total number of queries dropped because handling them panicked.",
+		}),
+		dropped: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: "gateshift",
+			Subsystem: "dns",
+			Name:      "dropped_queries_total",
+			Help:      "Total number of queries dropped because the limit of queries in flight was reached.",
+		}),
+	}
+
+	cacheSize := prometheus.NewGaugeFunc(prometheus.GaugeOpts{
+		Namespace: "gateshift",
+		Subsystem: "dns",
+		Name:      "cache_entries",
+		Help:      "Current number of cached responses.",
+	}, func() float64 {
+		return float64(cache.stats().Entries)
+	})
+
+	m.registry.MustRegister(m.queries, m.upstreamQueries, m.upstreamErrors, m.upstreamLatency,
+		m.upstreamUp, m.cacheHits, m.cacheMisses, m.blocked, m.panics, m.dropped, cacheSize)
+	return m
+}
+
+// startMetricsServer serves /metrics on addr. A missing host binds to
+// 127.0.0.1 so the endpoint is not exposed to the network by default.
+func (m *proxyMetrics) startMetricsServer(addr string) (*http.Server, error) {
+	host, port, err := net.SplitHostPort(addr)
+	if err != nil {
+		return nil, err
+	}
+	if host == "" {
+		host = "127.0.0.1"
+	}
+	addr = net.JoinHostPort(host, port)
+
+	listener, err := net.Listen("tcp", addr)
+	if err != nil {
+		return nil, err
+	}
+
+	mux := http.NewServeMux()
+	mux.Handle("/metrics", promhttp.HandlerFor(m.registry, promhttp.HandlerOpts{}))
+	server := &http.Server{Handler: mux, ReadHeaderTimeout: 5 * time.Second}
+
+	go func() {
+		if err := server.Serve(listener); err != nil && err != http.ErrServerClosed {
+			log.Printf("Metrics server error: %v", err)
+		}
+	}()
+
+	utils.Logf("Metrics endpoint available at http://%s/metrics", addr)
+	return server, nil
+}
+
+// stopMetricsServer shuts the metrics server down
+func stopMetricsServer(server *http.Server) {
+	ctx, cancel := context.WithTimeout(context.Background(), 2*time.Second)
+	defer cancel()
+	if err := server.Shutdown(ctx); err != nil {
+		log.Printf("Error stopping metrics server: %v", err)
+	}
+}
@@ -0,0 +1,257 @@
+package dns
+
+import (
+	"encoding/binary"
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// Record types used by callers building queries
+const (
+	TypeA     uint16 = 1
+	TypeNS    uint16 = 2
+	TypeCNAME uint16 = 5
+	TypePTR   uint16 = 12
+	TypeMX    uint16 = 15
+	TypeTXT   uint16 = 16
+	TypeAAAA  uint16 = 28
+)
+
+// maxPointerJumps bounds compression pointer chains to reject loops
+const maxPointerJumps = 64
+
+// maxNameLength is the longest a name may be in wire format (RFC 1035),
+// which also bounds what a crafted pointer chain can make readName allocate
+const maxNameLength = 255
+
+// Question is a question of a DNS message
+type Question struct {
+	Name string
+	Type uint16
+}
+
+// ResourceRecord is a decoded resource record of a DNS message
+type ResourceRecord struct {
+	Name  string
+	Type  uint16
+	TTL   uint32
+	Value string
+}
+
+// Message is a decoded DNS message
+type Message struct {
+	ID         uint16
+	Response   bool
+	Truncated  bool
+	Rcode      int
+	Questions  []Question
+	Answers    []ResourceRecord
+	Authority  []ResourceRecord
+	Additional []ResourceRecord
+}
+
+// TypeString returns the mnemonic of a record type, e.g. "AAAA"
+func TypeString(qtype uint16) string {
+	return typeString(qtype)
+}
+
+// RcodeString returns the mnemonic of a response code, e.g. "NXDOMAIN"
+func RcodeString(rcode int) string {
+	return rcodeString(rcode)
+}
+
+// BuildQuery builds a recursive query for name and qtype
+func BuildQuery(id uint16, name string, qtype uint16) ([]byte, error) {
+	query := make([]byte, headerSize, 512)
+	binary.BigEndian.PutUint16(query[0:2], id)
+	query[2] = 0x01 // RD
+	binary.BigEndian.PutUint16(query[4:6], 1)
+
+	name = strings.TrimSuffix(name, ".")
+	if name != "" {
+		for _, label := range strings.Split(name, ".") {
+			if len(label) == 0 || len(label) > 63 {
+				return nil, fmt.Errorf("invalid label %q in %s", label, name)
+			}
+			query = append(query, byte(len(label)))
+			query = append(query, label...)
+		}
+	}
+	query = append(query, 0)
+	query = append(query, byte(qtype>>8), byte(qtype), 0, 1) // class IN
+
+	return query, nil
+}
+
+// ReverseName returns the PTR query name of an IP address
+func ReverseName(ip net.IP) string {
+	if ip4 := ip.To4(); ip4 != nil {
+		return fmt.Sprintf("%d.%d.%d.%d.in-addr.arpa.", ip4[3], ip4[2], ip4[1], ip4[0])
+	}
+
+	digits := hex.EncodeToString(ip.To16())
+	var b strings.Builder
+	for i := len(digits) - 1; i >= 0; i-- {
+		b.WriteByte(digits[i])
+		b.WriteByte('.')
+	}
+	b.WriteString("ip6.arpa.")
+	return b.String()
+}
+
+// ParseMessage decodes a DNS message, following name compression pointers
+func ParseMessage(msg []byte) (*Message, error) {
+	if len(msg) < headerSize {
+		return nil, fmt.Errorf("message too short")
+	}
+
+	m := &Message{
+		ID:        binary.BigEndian.Uint16(msg[0:2]),
+		Response:  msg[2]&0x80 != 0,
+		Truncated: msg[2]&0x02 != 0,
+		Rcode:     int(msg[3] & 0x0f),
+	}
+	qdCount := int(binary.BigEndian.Uint16(msg[4:6]))
+	counts := []int{
+		int(binary.BigEndian.Uint16(msg[6:8])),
+		int(binary.BigEndian.Uint16(msg[8:10])),
+		int(binary.BigEndian.Uint16(msg[10:12])),
+	}
+
+	offset := headerSize
+	for i := 0; i < qdCount; i++ {
+		name, next, err := readName(msg, offset)
+		if err != nil {
+			return nil, err
+		}
+		if next+4 > len(msg) {
+			return nil, fmt.Errorf("question out of bounds")
+		}
+		m.Questions = append(m.Questions, Question{Name: name, Type: binary.BigEndian.Uint16(msg[next : next+2])})
+		offset = next + 4
+	}
+
+	sections := []*[]ResourceRecord{&m.Answers, &m.Authority, &m.Additional}
+	for s, count := range counts {
+		for i := 0; i < count; i++ {
+			rr, next, err := readRecord(msg, offset)
+			if err != nil {
+				return nil, err
+			}
+			*sections[s] = append(*sections[s], rr)
+			offset = next
+		}
+	}
+
+	return m, nil
+}
+
+// readRecord decodes the resource record at offset and returns the offset after it
+func readRecord(msg []byte, offset int) (ResourceRecord, int, error) {
+	name, next, err := readName(msg, offset)
+	if err != nil {
+		return ResourceRecord{}, 0, err
+	}
+	if next+10 > len(msg) {
+		return ResourceRecord{}, 0, fmt.Errorf("record header out of bounds")
+	}
+
+	rr := ResourceRecord{
+		Name: name,
+		Type: binary.BigEndian.Uint16(msg[next : next+2]),
+		TTL:  binary.BigEndian.Uint32(msg[next+4 : next+8]),
+	}
+	rdLength := int(binary.BigEndian.Uint16(msg[next+8 : next+10]))
+	start := next + 10
+	end := start + rdLength
+	if end > len(msg) {
+		return ResourceRecord{}, 0, fmt.Errorf("record data out of bounds")
+	}
+	rdata := msg[start:end]
+
+	switch rr.Type {
+	case TypeA, TypeAAAA:
+		rr.Value = net.IP(rdata).String()
+	case TypeNS, TypeCNAME, TypePTR:
+		target, _, err := readName(msg, start)
+		if err != nil {
+			return ResourceRecord{}, 0, err
+		}
+		rr.Value = target
+	case TypeMX:
+		if rdLength < 3 {
+			return ResourceRecord{}, 0, fmt.Errorf("MX record too short")
+		}
+		exchange, _, err := readName(msg, start+2)
+		if err != nil {
+			return ResourceRecord{}, 0, err
+		}
+		rr.Value = fmt.Sprintf("%d %s", binary.BigEndian.Uint16(rdata[0:2]), exchange)
+	case TypeTXT:
+		// One or more length-prefixed character strings
+		var texts []string
+		for i := 0; i < len(rdata); {
+			length := int(rdata[i])
+			if i+1+length > len(rdata) {
+				return ResourceRecord{}, 0, fmt.Errorf("TXT string out of bounds")
+			}
+			texts = append(texts, strconv.Quote(string(rdata[i+1:i+1+length])))
+			i += 1 + length
+		}
+		rr.Value = strings.Join(texts, " ")
+	default:
+		rr.Value = fmt.Sprintf("\\# %d %s", rdLength, hex.EncodeToString(rdata))
+	}
+
+	return rr, end, nil
+}
+
+// readName decodes the possibly compressed name at offset and returns it
+// with the offset just past its encoding in the original position
+func readName(msg []byte, offset int) (string, int, error) {
+	var labels []string
+	next := -1
+	jumps := 0
+	// Wire length of the name, counting the terminating zero byte
+	length := 1
+
+	for {
+		if offset >= len(msg) {
+			return "", 0, fmt.Errorf("name out of bounds")
+		}
+		labelLength := int(msg[offset])
+
+		switch {
+		case labelLength == 0:
+			if next < 0 {
+				next = offset + 1
+			}
+			return strings.Join(labels, ".") + ".", next, nil
+		case labelLength&0xc0 == 0xc0:
+			if offset+2 > len(msg) {
+				return "", 0, fmt.Errorf("pointer out of bounds")
+			}
+			if jumps++; jumps > maxPointerJumps {
+				return "", 0, fmt.Errorf("too many compression pointers")
+			}
+			if next < 0 {
+				next = offset + 2
+			}
+			offset = int(binary.BigEndian.Uint16(msg[offset:offset+2]) & 0x3fff)
+		case labelLength > 63:
+			return "", 0, fmt.Errorf("unsupported label length %d", labelLength)
+		default:
+			if offset+1+labelLength > len(msg) {
+				return "", 0, fmt.Errorf("label out of bounds")
+			}
+			if length += labelLength + 1; length > maxNameLength {
+				return "", 0, fmt.Errorf("name longer than %d bytes", maxNameLength)
+			}
+			labels = append(labels, string(msg[offset+1:offset+1+labelLength]))
+			offset += labelLength + 1
+		}
+	}
+}
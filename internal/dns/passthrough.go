@@ -0,0 +1,111 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"path/filepath"
+
+	"github.com/ourines/GateShift/internal/utils"
+)
+
+// passthroughRecord records the servers SetPassthroughDNS pointed the
+// system at
+type passthroughRecord struct {
+	Servers []string `json:"servers"`
+}
+
+// passthroughPath returns where the pass-through DNS servers are recorded
+func passthroughPath() string {
+	return filepath.Join(utils.ConfigDir(), "passthrough-dns.json")
+}
+
+// ValidatePassthroughServer checks that server is an IP address; system
+// resolvers always query port 53, so no port can be given
+func ValidatePassthroughServer(server string) error {
+	if net.ParseIP(server) == nil {
+		return fmt.Errorf("invalid DNS server: %s (must be an IP address, system resolvers always use port 53)", server)
+	}
+	return nil
+}
+
+// ProbeDNSServer checks that server, an IP address, answers a DNS query on
+// UDP port 53, as system resolvers will send them
+func ProbeDNSServer(server string) error {
+	return probeUpstream(net.JoinHostPort(server, "53"))
+}
+
+// SetPassthroughDNS points the system DNS directly at servers, in order of
+// preference, without the DNS proxy. The original settings are backed up as
+// by ConfigureSystemDNS, keeping an earlier backup that was not restored
+// yet, and servers are recorded for RestorePassthroughDNS.
+func SetPassthroughDNS(servers []string, allServices bool) error {
+	if len(servers) == 0 {
+		return fmt.Errorf("no DNS servers given")
+	}
+	seen := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		if err := ValidatePassthroughServer(server); err != nil {
+			return err
+		}
+		if seen[server] {
+			return fmt.Errorf("duplicate DNS server: %s", server)
+		}
+		seen[server] = true
+	}
+	if err := ConfigureSystemDNS(servers, allServices); err != nil {
+		return err
+	}
+
+	if utils.DryRun() {
+		fmt.Printf("[dry-run] record pass-through DNS servers in %s\n", passthroughPath())
+		return nil
+	}
+	data, err := json.Marshal(passthroughRecord{Servers: servers})
+	if err != nil {
+		return err
+	}
+	if err := saveBackup(passthroughPath(), data); err != nil {
+		return fmt.Errorf("failed to record pass-through DNS servers: %w", err)
+	}
+	return nil
+}
+
+// PassthroughDNS returns the servers SetPassthroughDNS pointed the system
+// at, or nil when the system DNS was not set that way
+func PassthroughDNS() ([]string, error) {
+	data, err := os.ReadFile(passthroughPath())
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var record passthroughRecord
+	if err := json.Unmarshal(data, &record); err != nil {
+		return nil, fmt.Errorf("invalid pass-through DNS record %s: %w", passthroughPath(), err)
+	}
+	return record.Servers, nil
+}
+
+// RestorePassthroughDNS restores the system DNS settings backed up by
+// SetPassthroughDNS. Without a backup the settings are only reset when they
+// still point at the recorded servers.
+func RestorePassthroughDNS() error {
+	servers, err := PassthroughDNS()
+	if err != nil {
+		return err
+	}
+	if err := RestoreSystemDNS(servers); err != nil {
+		return err
+	}
+
+	if servers != nil {
+		if err := removeSystemFile(passthroughPath()); err != nil {
+			return fmt.Errorf("failed to remove pass-through DNS record: %w", err)
+		}
+	}
+	return nil
+}
@@ -0,0 +1,45 @@
+package dns
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestValidatePassthroughServer(t *testing.T) {
+	for _, server := range []string{"192.168.1.1", "2606:4700:4700::1111"} {
+		if err := ValidatePassthroughServer(server); err != nil {
+			t.Errorf("ValidatePassthroughServer(%q) = %v", server, err)
+		}
+	}
+	for _, server := range []string{"", "dns.google", "1.1.1.1:53", "tls://1.1.1.1"} {
+		if err := ValidatePassthroughServer(server); err == nil {
+			t.Errorf("ValidatePassthroughServer(%q) succeeded", server)
+		}
+	}
+}
+
+func TestPassthroughDNS(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	if servers, err := PassthroughDNS(); servers != nil || err != nil {
+		t.Fatalf("PassthroughDNS without a record = %q, %v", servers, err)
+	}
+
+	if err := os.MkdirAll(filepath.Dir(passthroughPath()), 0755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(passthroughPath(), []byte(`{"servers":["192.168.1.1"]}`), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if servers, err := PassthroughDNS(); err != nil || len(servers) != 1 || servers[0] != "192.168.1.1" {
+		t.Errorf("PassthroughDNS = %q, %v", servers, err)
+	}
+
+	if err := os.WriteFile(passthroughPath(), []byte("not json"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := PassthroughDNS(); err == nil {
+		t.Error("PassthroughDNS accepted a corrupt record")
+	}
+}
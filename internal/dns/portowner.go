@@ -0,0 +1,186 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"net"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// PortOwner is a process holding a UDP port
+type PortOwner struct {
+	PID  int
+	Name string
+	// Addr is the address the process is bound to, e.g. 127.0.0.53:53
+	Addr string
+}
+
+func (o *PortOwner) String() string {
+	if o.Name == "" {
+		return fmt.Sprintf("PID %d", o.PID)
+	}
+	return fmt.Sprintf("%s (PID %d)", o.Name, o.PID)
+}
+
+// PortInUseError is returned by Start when the listen address is held by
+// another process
+type PortInUseError struct {
+	Addr  string
+	Owner *PortOwner
+	Err   error
+}
+
+func (e *PortInUseError) Error() string {
+	return fmt.Sprintf("failed to listen on %s: %v (port held by %s)", e.Addr, e.Err, e.Owner)
+}
+
+func (e *PortInUseError) Unwrap() error {
+	return e.Err
+}
+
+// FindPortOwner returns the process bound to UDP port on an address that
+// conflicts with listenAddr, using lsof or ss on Unix and netstat and
+// tasklist on Windows. It returns nil without an error when no such process
+// is found; the process name may be empty when it cannot be determined.
+func FindPortOwner(listenAddr string, port int) (*PortOwner, error) {
+	if runtime.GOOS == "windows" {
+		return findWindowsPortOwner(listenAddr, port)
+	}
+
+	// lsof exits with 1 when no process matches, so only a missing lsof
+	// falls back to ss
+	output, err := execCommand("lsof", "-nP", fmt.Sprintf("-iUDP:%d", port), "-Fpcn").Output()
+	if !errors.Is(err, exec.ErrNotFound) {
+		return parseLsof(string(output), listenAddr, port), nil
+	}
+
+	output, err = execCommand("ss", "-H", "-lunp").Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not list UDP sockets: %w", err)
+	}
+	return parseSS(string(output), listenAddr, port), nil
+}
+
+// findWindowsPortOwner looks the port up with netstat and the process name
+// with tasklist
+func findWindowsPortOwner(listenAddr string, port int) (*PortOwner, error) {
+	output, err := execCommand("netstat", "-ano", "-p", "UDP").Output()
+	if err != nil {
+		return nil, fmt.Errorf("could not list UDP sockets: %w", err)
+	}
+	owner := parseNetstat(string(output), listenAddr, port)
+	if owner == nil {
+		return nil, nil
+	}
+
+	output, err = execCommand("tasklist", "/FI", fmt.Sprintf("PID eq %d", owner.PID), "/FO", "CSV", "/NH").Output()
+	if err == nil {
+		owner.Name = parseTasklist(string(output))
+	}
+	return owner, nil
+}
+
+// addrConflicts reports whether a socket bound to bound (host:port) keeps
+// the proxy from binding listenAddr on port
+func addrConflicts(bound, listenAddr string, port int) bool {
+	host, portStr, err := net.SplitHostPort(bound)
+	if err != nil || portStr != strconv.Itoa(port) {
+		return false
+	}
+	// Drop the interface of scoped addresses such as 127.0.0.53%lo
+	if i := strings.IndexByte(host, '%'); i >= 0 {
+		host = host[:i]
+	}
+	if host == "*" || host == listenAddr {
+		return true
+	}
+	boundIP, listenIP := net.ParseIP(host), net.ParseIP(listenAddr)
+	if boundIP == nil || listenIP == nil {
+		return false
+	}
+	return boundIP.IsUnspecified() || listenIP.IsUnspecified() || boundIP.Equal(listenIP)
+}
+
+// parseLsof parses the output of lsof -Fpcn: a p line per process followed
+// by its c (command) and n (address) lines
+func parseLsof(output, listenAddr string, port int) *PortOwner {
+	var current PortOwner
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		line := scanner.Text()
+		if line == "" {
+			continue
+		}
+		value := line[1:]
+		switch line[0] {
+		case 'p':
+			pid, _ := strconv.Atoi(value)
+			current = PortOwner{PID: pid}
+		case 'c':
+			current.Name = value
+		case 'n':
+			if current.PID > 0 && addrConflicts(value, listenAddr, port) {
+				owner := current
+				owner.Addr = value
+				return &owner
+			}
+		}
+	}
+	return nil
+}
+
+// ssProcess matches the first process of an ss -p users column
+var ssProcess = regexp.MustCompile(`users:\(\("([^"]*)",pid=(\d+)`)
+
+// parseSS parses the output of ss -H -lunp, whose fourth column is the
+// local address and last column lists the processes holding the socket
+func parseSS(output, listenAddr string, port int) *PortOwner {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) < 5 || !addrConflicts(fields[3], listenAddr, port) {
+			continue
+		}
+		match := ssProcess.FindStringSubmatch(scanner.Text())
+		if match == nil {
+			continue
+		}
+		pid, _ := strconv.Atoi(match[2])
+		return &PortOwner{PID: pid, Name: match[1], Addr: fields[3]}
+	}
+	return nil
+}
+
+// parseNetstat parses the output of netstat -ano -p UDP, whose lines read
+// "UDP <local address> <foreign address> <PID>"
+func parseNetstat(output, listenAddr string, port int) *PortOwner {
+	scanner := bufio.NewScanner(strings.NewReader(output))
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 4 || fields[0] != "UDP" || !addrConflicts(fields[1], listenAddr, port) {
+			continue
+		}
+		pid, err := strconv.Atoi(fields[3])
+		if err != nil || pid <= 0 {
+			continue
+		}
+		return &PortOwner{PID: pid, Addr: fields[1]}
+	}
+	return nil
+}
+
+// parseTasklist returns the image name from tasklist /FO CSV /NH output, or
+// an empty string when no task matched
+func parseTasklist(output string) string {
+	record, err := csv.NewReader(strings.NewReader(output)).Read()
+	if err != nil || len(record) < 2 {
+		return ""
+	}
+	return record[0]
+}
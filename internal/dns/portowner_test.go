@@ -0,0 +1,103 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"runtime"
+	"testing"
+)
+
+func TestAddrConflicts(t *testing.T) {
+	tests := []struct {
+		bound, listen string
+		want          bool
+	}{
+		{"127.0.0.1:53", "127.0.0.1", true},
+		{"*:53", "127.0.0.1", true},
+		{"0.0.0.0:53", "127.0.0.1", true},
+		{"[::]:53", "127.0.0.1", true},
+		{"127.0.0.53%lo:53", "0.0.0.0", true},
+		{"127.0.0.53%lo:53", "127.0.0.1", false},
+		{"127.0.0.1:5353", "127.0.0.1", false},
+		{"garbage", "127.0.0.1", false},
+	}
+	for _, tt := range tests {
+		if got := addrConflicts(tt.bound, tt.listen, 53); got != tt.want {
+			t.Errorf("addrConflicts(%q, %q) = %t, want %t", tt.bound, tt.listen, got, tt.want)
+		}
+	}
+}
+
+func TestParsePortOwner(t *testing.T) {
+	lsof := "p321\ncavahi-daemon\nf12\nn*:5353\np123\ncdnsmasq\nf4\nn127.0.0.1:53\n"
+	if owner := parseLsof(lsof, "127.0.0.1", 53); owner == nil || owner.PID != 123 || owner.Name != "dnsmasq" || owner.Addr != "127.0.0.1:53" {
+		t.Errorf("parseLsof = %+v", owner)
+	}
+
+	ss := `UNCONN 0 0 127.0.0.54:53 0.0.0.0:* users:(("systemd-resolve",pid=612,fd=16))
+UNCONN 0 0 127.0.0.53%lo:53 0.0.0.0:* users:(("systemd-resolve",pid=612,fd=14))
+UNCONN 0 0 0.0.0.0:68 0.0.0.0:* users:(("dhclient",pid=700,fd=6))
+`
+	if owner := parseSS(ss, "127.0.0.53", 53); owner == nil || owner.PID != 612 || owner.Name != "systemd-resolve" {
+		t.Errorf("parseSS = %+v", owner)
+	}
+	if owner := parseSS(ss, "127.0.0.1", 53); owner != nil {
+		t.Errorf("parseSS matched %+v for a free address", owner)
+	}
+
+	netstat := `
+Active Connections
+
+  Proto  Local Address          Foreign Address        State           PID
+  UDP    0.0.0.0:53             *:*                                    2480
+  UDP    0.0.0.0:5353           *:*                                    1884
+`
+	if owner := parseNetstat(netstat, "127.0.0.1", 53); owner == nil || owner.PID != 2480 {
+		t.Errorf("parseNetstat = %+v", owner)
+	}
+
+	if name := parseTasklist(`"svchost.exe","2480","Services","0","12,345 K"` + "\r\n"); name != "svchost.exe" {
+		t.Errorf("parseTasklist = %q", name)
+	}
+	if name := parseTasklist("INFO: No tasks are running which match the specified criteria.\r\n"); name != "" {
+		t.Errorf("parseTasklist = %q for no match", name)
+	}
+}
+
+func TestStartReportsPortOwner(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("the fake lsof output is only used on Unix")
+	}
+
+	held, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer held.Close()
+	port := held.LocalAddr().(*net.UDPAddr).Port
+
+	oldExec := execCommand
+	defer func() { execCommand = oldExec }()
+	var calls []string
+	execCommand = fakeExecCommand(map[string]string{
+		fmt.Sprintf("lsof -nP -iUDP:%d -Fpcn", port): fmt.Sprintf("p4242\ncdnsmasq\nf5\nn127.0.0.1:%d\n", port),
+	}, &calls)
+
+	p, err := NewDNSProxy("127.0.0.1", port, []string{"8.8.8.8:53"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = p.Start()
+	if err == nil {
+		p.Stop()
+		t.Fatal("Start succeeded on a port in use")
+	}
+	var inUse *PortInUseError
+	if !errors.As(err, &inUse) {
+		t.Fatalf("Start error = %v, want a PortInUseError", err)
+	}
+	if inUse.Owner.PID != 4242 || inUse.Owner.Name != "dnsmasq" {
+		t.Errorf("owner = %+v", inUse.Owner)
+	}
+}
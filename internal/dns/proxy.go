@@ -1,31 +1,216 @@
 package dns
 
 import (
+	"encoding/binary"
 	"fmt"
 	"log"
 	"net"
+	"net/http"
+	"net/url"
+	"runtime/debug"
+	"strconv"
+	"strings"
 	"sync"
+	"sync/atomic"
 	"time"
+
+	"github.com/ourines/GateShift/internal/utils"
 )
 
+// udpBufferSize is the size of the buffers DNS messages are read into
+const udpBufferSize = 4096
+
+// drainTimeout bounds how long Stop waits for in-flight queries to be
+// answered before closing the socket
+const drainTimeout = 2 * time.Second
+
+// bufferPool holds *[]byte buffers of udpBufferSize bytes, reused for
+// client queries and upstream responses
+var bufferPool = sync.Pool{
+	New: func() interface{} {
+		buffer := make([]byte, udpBufferSize)
+		return &buffer
+	},
+}
+
 // DNSProxy represents a DNS proxy server
 type DNSProxy struct {
+	// Accessed atomically; kept first for 64-bit alignment on 32-bit platforms
+	rrCounter uint64
+	panics    uint64
+	// lastQuery is when the last query was received, in Unix nanoseconds
+	lastQuery int64
+	// active is the number of queries in flight, at most maxInflight;
+	// dropped counts the queries received beyond it
+	active      int64
+	maxInflight int64
+	dropped     uint64
+
 	listenAddr  string
+	listenPort  int
 	upstreamDNS []string
-	conn        *net.UDPConn
-	running     bool
-	mu          sync.Mutex
-	stopChan    chan struct{}
+	fallbackDNS string
+	strategy    string
+	filterAAAA  bool
+	recursion   string // how the RD bit of client queries is handled
+	// qnameMinimization looks up the parent of names before forwarding them
+	qnameMinimization bool
+	// retries is how many times a query to an upstream that timed out is
+	// sent again, waiting retryBackoff before the first retry
+	retries      int
+	retryBackoff time.Duration
+	conn         *net.UDPConn
+	running      bool
+	mu           sync.Mutex
+	stopChan     chan struct{}
+	handlerDone  chan struct{}  // closed when handleRequests returns
+	inflight     sync.WaitGroup // queries being processed
+	lastDropLog  time.Time      // when dropping queries was last logged
+	idleTimeout  time.Duration
+	idle         chan struct{} // closed when idle for idleTimeout
+
+	// blocklist holds the blocked domains, lower case without trailing dot
+	blocklist     map[string]bool
+	blockResponse string
+	blocks        *blockTracker
+
+	// hosts holds the addresses of the names in hostsFile, lower case
+	// without trailing dot; hostsModTime and hostsSize identify the version
+	// read
+	hosts        map[string][]net.IP
+	hostsFile    string
+	hostsModTime time.Time
+	hostsSize    int64
+
+	// refreshing holds the cache keys of stale answers being refreshed
+	refreshing map[cacheKey]bool
+
+	cache         *dnsCache
+	upstreamConns *connPool
+	health        *healthTracker
+	stats         *statsTracker
+	metrics       *proxyMetrics
+	metricsAddr   string
+	metricsServer *http.Server
+	queryLogger   QueryLogger
+
+	controlAddr      string
+	controlTokenFile string
+	controlServer    *http.Server
+	reloadFunc       func() error
+}
+
+// NewDNSProxy creates a new DNS proxy. Upstream servers given without a port
+// use port 53.
+func NewDNSProxy(listenAddr string, listenPort int, upstreamDNS []string) (*DNSProxy, error) {
+	upstreamDNS, err := NormalizeUpstreams(upstreamDNS)
+	if err != nil {
+		return nil, err
+	}
+
+	p := &DNSProxy{
+		listenAddr:    listenAddr,
+		listenPort:    listenPort,
+		upstreamDNS:   upstreamDNS,
+		strategy:      StrategyParallel,
+		blockResponse: BlockNXDomain,
+		recursion:     RecursionForward,
+		retries:       DefaultUpstreamRetries,
+		retryBackoff:  DefaultRetryBackoff,
+		maxInflight:   DefaultMaxInflight,
+		running:       false,
+		stopChan:      make(chan struct{}),
+		idle:          make(chan struct{}),
+		cache:         newDNSCache(),
+		upstreamConns: newConnPool(),
+		health:        newHealthTracker(),
+		stats:         newStatsTracker(),
+		blocks:        newBlockTracker(),
+		refreshing:    make(map[cacheKey]bool),
+		queryLogger:   &textQueryLogger{logger: log.Default()},
+	}
+	p.metrics = newProxyMetrics(p.cache)
+	return p, nil
+}
+
+// EnableControlAPI serves the local control API on addr while the proxy is
+// running, authenticated with a token written to tokenFile. It must be called before Start.
+func (p *DNSProxy) EnableControlAPI(addr, tokenFile string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.controlAddr = addr
+	p.controlTokenFile = tokenFile
 }
 
-// NewDNSProxy creates a new DNS proxy
-func NewDNSProxy(listenAddr string, upstreamDNS []string) (*DNSProxy, error) {
-	return &DNSProxy{
-		listenAddr:  listenAddr,
-		upstreamDNS: upstreamDNS,
-		running:     false,
-		stopChan:    make(chan struct{}),
-	}, nil
+// SetReloadFunc sets the function that re-reads configuration such as the
+// upstream servers and hosts when a reload is requested
+func (p *DNSProxy) SetReloadFunc(fn func() error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.reloadFunc = fn
+}
+
+// Reload re-reads configuration with the function set by SetReloadFunc
+func (p *DNSProxy) Reload() error {
+	p.mu.Lock()
+	fn := p.reloadFunc
+	p.mu.Unlock()
+
+	if fn == nil {
+		return fmt.Errorf("reload is not supported by this DNS proxy")
+	}
+	if err := fn(); err != nil {
+		return err
+	}
+	utils.Logf("DNS proxy configuration reloaded")
+	return nil
+}
+
+// SetAAAAFilter enables or disables AAAA filtering. When enabled, AAAA
+// queries are answered with an empty NOERROR response so that clients on
+// networks with broken IPv6 fall back to IPv4 immediately.
+func (p *DNSProxy) SetAAAAFilter(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.filterAAAA = enabled
+}
+
+// AAAAFilter reports whether AAAA filtering is enabled
+func (p *DNSProxy) AAAAFilter() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.filterAAAA
+}
+
+// SetQueryLogger replaces the logger that records each client query.
+// It must be called before Start.
+func (p *DNSProxy) SetQueryLogger(logger QueryLogger) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.queryLogger = logger
+}
+
+// EnableMetrics serves Prometheus metrics on addr while the proxy is running.
+// It must be called before Start.
+func (p *DNSProxy) EnableMetrics(addr string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.metricsAddr = addr
+}
+
+// listenNetwork returns the UDP network to listen on addr with: udp4 for
+// IPv4 addresses and udp6 for IPv6 ones, so :: listens on all IPv6
+// interfaces only and 0.0.0.0 on all IPv4 interfaces
+func listenNetwork(addr string) string {
+	ip := net.ParseIP(addr)
+	switch {
+	case ip == nil:
+		return "udp"
+	case ip.To4() != nil:
+		return "udp4"
+	default:
+		return "udp6"
+	}
 }
 
 // Start starts the DNS proxy server
@@ -37,54 +222,131 @@ func (p *DNSProxy) Start() error {
 		return fmt.Errorf("DNS proxy is already running")
 	}
 
-	// 固定使用53端口
-	const port = 53
+	// Bind UDP port on the address family of the listen address
+	addr := net.JoinHostPort(p.listenAddr, strconv.Itoa(p.listenPort))
+	network := listenNetwork(p.listenAddr)
+	utils.Logf("Attempting to bind to %s (%s)", addr, network)
 
-	// Bind UDP port
-	addr := fmt.Sprintf("%s:%d", p.listenAddr, port)
-	log.Printf("Attempting to bind to %s", addr)
-
-	udpAddr, err := net.ResolveUDPAddr("udp", addr)
+	udpAddr, err := net.ResolveUDPAddr(network, addr)
 	if err != nil {
 		return fmt.Errorf("failed to resolve address: %w", err)
 	}
 
-	conn, err := net.ListenUDP("udp", udpAddr)
+	conn, err := net.ListenUDP(network, udpAddr)
 	if err != nil {
+		// Name the process holding the port, e.g. a system resolver
+		if owner, _ := FindPortOwner(p.listenAddr, p.listenPort); owner != nil {
+			return &PortInUseError{Addr: addr, Owner: owner, Err: err}
+		}
 		return fmt.Errorf("failed to listen on %s: %w", addr, err)
 	}
 	p.conn = conn
-	log.Printf("Successfully bound to %s", addr)
+	utils.Logf("Successfully bound to %s", addr)
+
+	// Start the metrics endpoint if enabled
+	if p.metricsAddr != "" {
+		server, err := p.metrics.startMetricsServer(p.metricsAddr)
+		if err != nil {
+			conn.Close()
+			p.conn = nil
+			return fmt.Errorf("failed to start metrics server on %s: %w", p.metricsAddr, err)
+		}
+		p.metricsServer = server
+	}
+
+	// Start the control API if enabled
+	if p.controlAddr != "" {
+		server, err := p.startControlServer(p.controlAddr, p.controlTokenFile)
+		if err != nil {
+			log.Printf("Warning: failed to start control API on %s: %v", p.controlAddr, err)
+		} else {
+			p.controlServer = server
+		}
+	}
 
 	// Handle DNS requests
-	go p.handleRequests()
+	p.handlerDone = make(chan struct{})
+	go p.handleRequests(conn, p.handlerDone)
+	go p.cacheCleanupTask()
+	go p.healthCheckTask()
+	p.touch()
+	go p.idleWatchTask()
+	go p.hostsWatchTask()
 
 	p.running = true
-	log.Printf("DNS proxy started on %s", addr)
-	log.Printf("Using upstream DNS servers: %v", p.upstreamDNS)
+	utils.Logf("DNS proxy started on %s", addr)
+	utils.Logf("Using upstream DNS servers: %v (strategy: %s)", p.upstreamDNS, p.strategy)
 	return nil
 }
 
 // Stop stops the DNS proxy server
 func (p *DNSProxy) Stop() error {
 	p.mu.Lock()
-	defer p.mu.Unlock()
-
 	if !p.running {
+		p.mu.Unlock()
 		return nil
 	}
 
+	// Stop accepting queries; the socket stays open until the queries
+	// already received are answered
 	close(p.stopChan)
-	if p.conn != nil {
-		p.conn.Close()
-		p.conn = nil
+	conn, handlerDone := p.conn, p.handlerDone
+	if conn != nil {
+		conn.SetReadDeadline(time.Now())
 	}
 
+	// HTTP servers are shut down without holding the lock, as their
+	// in-flight handlers may need it. So do in-flight queries.
+	metricsServer, controlServer := p.metricsServer, p.controlServer
+	p.metricsServer, p.controlServer = nil, nil
 	p.running = false
-	log.Printf("DNS proxy stopped")
+	p.mu.Unlock()
+
+	if conn != nil {
+		p.drain(handlerDone)
+		conn.Close()
+		p.mu.Lock()
+		p.conn = nil
+		p.mu.Unlock()
+	}
+	p.upstreamConns.close()
+
+	if metricsServer != nil {
+		stopMetricsServer(metricsServer)
+	}
+	if controlServer != nil {
+		stopControlServer(controlServer, p.controlTokenFile)
+	}
+
+	utils.Logf("DNS proxy stopped")
 	return nil
 }
 
+// drain waits until handleRequests has returned and the queries it started
+// are answered, or until drainTimeout passes
+func (p *DNSProxy) drain(handlerDone <-chan struct{}) {
+	timeout := time.NewTimer(drainTimeout)
+	defer timeout.Stop()
+
+	select {
+	case <-handlerDone:
+	case <-timeout.C:
+		log.Printf("Warning: DNS request handler did not stop within %v", drainTimeout)
+		return
+	}
+
+	drained := make(chan struct{})
+	go func() {
+		p.inflight.Wait()
+		close(drained)
+	}()
+	select {
+	case <-drained:
+	case <-timeout.C:
+		log.Printf("Warning: closing the DNS socket with queries still in flight after %v", drainTimeout)
+	}
+}
+
 // IsRunning returns true if the proxy is running
 func (p *DNSProxy) IsRunning() bool {
 	p.mu.Lock()
@@ -96,23 +358,164 @@ func (p *DNSProxy) IsRunning() bool {
 func (p *DNSProxy) GetPort() int {
 	p.mu.Lock()
 	defer p.mu.Unlock()
-	return 53
+	return p.listenPort
+}
+
+// Upstreams returns a copy of the upstream DNS servers in use
+func (p *DNSProxy) Upstreams() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return append([]string(nil), p.upstreamDNS...)
+}
+
+// SetUpstreams validates and atomically replaces the upstream DNS servers
+// while the proxy keeps running
+func (p *DNSProxy) SetUpstreams(upstreams []string) error {
+	if len(upstreams) == 0 {
+		return fmt.Errorf("at least one upstream DNS server is required")
+	}
+	upstreams, err := NormalizeUpstreams(upstreams)
+	if err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	previous := p.upstreamDNS
+	p.upstreamDNS = upstreams
+	p.mu.Unlock()
+
+	utils.Logf("Upstream DNS servers changed from %v to %v", previous, upstreams)
+	return nil
+}
+
+// ValidateUpstream checks that an upstream server is a plain DNS "ip:port"
+// address. DNS-over-HTTPS and DNS-over-TLS URLs are recognised but rejected,
+// as the proxy only forwards over UDP.
+func ValidateUpstream(upstream string) error {
+	if strings.Contains(upstream, "://") {
+		u, err := url.Parse(upstream)
+		if err != nil {
+			return fmt.Errorf("invalid upstream DNS server %s: %w", upstream, err)
+		}
+		switch u.Scheme {
+		case "https", "tls":
+			return fmt.Errorf("upstream DNS server %s uses %s, which is not supported (only plain DNS over UDP)", upstream, u.Scheme)
+		default:
+			return fmt.Errorf("invalid upstream DNS server scheme: %s", u.Scheme)
+		}
+	}
+
+	host, port, err := net.SplitHostPort(upstream)
+	if err != nil {
+		return fmt.Errorf("invalid upstream DNS server %s: %w", upstream, err)
+	}
+	if net.ParseIP(host) == nil {
+		return fmt.Errorf("invalid upstream DNS server IP address: %s", upstream)
+	}
+	if n, err := strconv.Atoi(port); err != nil || n < 1 || n > 65535 {
+		return fmt.Errorf("invalid upstream DNS server port: %s", upstream)
+	}
+	return nil
+}
+
+// NormalizeUpstream adds the default port 53 to an upstream DNS server given
+// without one and validates the result. IPv6 literals may be given bare
+// ("2606:4700:4700::1111"), bracketed ("[2606:4700:4700::1111]") or with a
+// port ("[2606:4700:4700::1111]:53").
+func NormalizeUpstream(server string) (string, error) {
+	server = strings.TrimSpace(server)
+	if !strings.Contains(server, "://") {
+		if _, _, err := net.SplitHostPort(server); err != nil {
+			// No port: a bare IPv6 literal contains colons, so it is wrapped
+			// in brackets by JoinHostPort rather than treated as host:port
+			host := strings.TrimSuffix(strings.TrimPrefix(server, "["), "]")
+			server = net.JoinHostPort(host, "53")
+		}
+	}
+
+	if err := ValidateUpstream(server); err != nil {
+		return "", err
+	}
+	if _, err := net.ResolveUDPAddr("udp", server); err != nil {
+		return "", fmt.Errorf("invalid upstream DNS server %s: %w", server, err)
+	}
+	return server, nil
+}
+
+// NormalizeUpstreams normalizes each server as NormalizeUpstream does and
+// drops the ones that are the same server written differently, such as
+// "8.8.8.8" and "8.8.8.8:53", keeping the first
+func NormalizeUpstreams(servers []string) ([]string, error) {
+	normalized := make([]string, 0, len(servers))
+	seen := make(map[string]bool, len(servers))
+	for _, server := range servers {
+		server, err := NormalizeUpstream(server)
+		if err != nil {
+			return nil, err
+		}
+		if !seen[server] {
+			seen[server] = true
+			normalized = append(normalized, server)
+		}
+	}
+	return normalized, nil
+}
+
+// CacheStats returns statistics about the response cache
+func (p *DNSProxy) CacheStats() CacheStats {
+	stats := p.cache.stats()
+	stats.Blocked = p.blocks.count()
+	return stats
+}
+
+// RecoveredPanics returns how many queries were dropped because handling
+// them panicked
+func (p *DNSProxy) RecoveredPanics() uint64 {
+	return atomic.LoadUint64(&p.panics)
+}
+
+// CacheEntries returns a snapshot of the cached responses
+func (p *DNSProxy) CacheEntries() []CacheEntry {
+	return p.cache.snapshot()
+}
+
+// ClearCache removes all cached responses and returns how many were removed
+func (p *DNSProxy) ClearCache() int {
+	return p.cache.clear()
+}
+
+// cacheCleanupTask periodically purges expired cache entries until the proxy stops
+func (p *DNSProxy) cacheCleanupTask() {
+	ticker := time.NewTicker(cacheCleanupInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-p.stopChan:
+			return
+		case <-ticker.C:
+			p.cache.removeExpired()
+		}
+	}
 }
 
-// handleRequests handles incoming DNS requests
-func (p *DNSProxy) handleRequests() {
-	buffer := make([]byte, 4096)
-	log.Printf("DNS request handler started")
+// handleRequests handles incoming DNS requests on conn until the proxy
+// stops, then closes done
+func (p *DNSProxy) handleRequests(conn *net.UDPConn, done chan<- struct{}) {
+	defer close(done)
+	utils.Logf("DNS request handler started")
 
 	for {
 		select {
 		case <-p.stopChan:
-			log.Printf("DNS request handler received stop signal")
+			utils.Logf("DNS request handler received stop signal")
 			return
 		default:
-			p.conn.SetReadDeadline(time.Now().Add(1 * time.Second))
-			n, addr, err := p.conn.ReadFromUDP(buffer)
+			conn.SetReadDeadline(time.Now().Add(1 * time.Second))
+			buffer := getBuffer()
+			n, addr, err := conn.ReadFromUDP(*buffer)
 			if err != nil {
+				putBuffer(buffer)
 				if netErr, ok := err.(net.Error); ok && netErr.Timeout() {
 					// Timeout, just continue
 					continue
@@ -121,65 +524,359 @@ func (p *DNSProxy) handleRequests() {
 				continue
 			}
 
-			log.Printf("Received DNS query from %s (%d bytes)", addr.String(), n)
-			// Process the query
-			go p.processQuery(buffer[:n], addr)
+			utils.Logf("Received DNS query from %s (%d bytes)", addr.String(), n)
+			if !p.acquireQuery() {
+				putBuffer(buffer)
+				p.dropQuery(addr)
+				continue
+			}
+			// Each query keeps its buffer until processQuery returns. Stop
+			// waits for the inflight queries before closing conn.
+			p.inflight.Add(1)
+			go func() {
+				defer p.inflight.Done()
+				defer p.releaseQuery()
+				defer putBuffer(buffer)
+				p.processQuery((*buffer)[:n], addr)
+			}()
 		}
 	}
 }
 
-// processQuery handles a single DNS query
+// processQuery handles a single DNS query. query may be a pooled buffer, so
+// nothing may hold on to it after processQuery returns.
 func (p *DNSProxy) processQuery(query []byte, clientAddr *net.UDPAddr) {
-	if len(p.upstreamDNS) == 0 {
+	startTime := time.Now()
+	event := QueryEvent{Time: startTime, ClientIP: clientAddr.IP.String()}
+	// A panic drops this query only, rather than the whole proxy
+	defer func() {
+		if r := recover(); r != nil {
+			atomic.AddUint64(&p.panics, 1)
+			p.metrics.panics.Inc()
+			log.Printf("Recovered from a panic handling a query for %q %s from %s (%d bytes): %v\n%s",
+				event.Name, event.Type, clientAddr, len(query), r, debug.Stack())
+		}
+	}()
+	p.touch()
+	name, qtype, parseErr := extractQueryName(query)
+	if parseErr == nil {
+		event.Name = name
+		event.Type = typeString(qtype)
+		// Only the first question would be looked at, so queries with
+		// several are not forwarded; hardly any resolver supports them
+		if qdCount := binary.BigEndian.Uint16(query[4:6]); qdCount != 1 {
+			parseErr = fmt.Errorf("query has %d questions", qdCount)
+		}
+	}
+	defer func() {
+		event.LatencyMs = float64(time.Since(startTime).Microseconds()) / 1000
+		if !utils.Quiet() {
+			p.queryLogger.LogQuery(event)
+		}
+	}()
+
+	upstreams := p.Upstreams()
+	if len(upstreams) == 0 {
 		log.Printf("No upstream DNS servers configured")
+		event.Error = "no upstream DNS servers configured"
 		return
 	}
 
-	log.Printf("Processing DNS query from %s", clientAddr.String())
-
-	// Use the first upstream DNS server for now
-	// In a more advanced implementation, we could try multiple servers or implement
-	// more sophisticated server selection
-	upstreamServer := p.upstreamDNS[0]
-	log.Printf("Forwarding query to upstream DNS server: %s", upstreamServer)
+	utils.Logf("Processing DNS query from %s", clientAddr.String())
+	p.metrics.queries.Inc()
 
-	// Connect to the upstream DNS server
-	upstreamAddr, err := net.ResolveUDPAddr("udp", upstreamServer)
-	if err != nil {
-		log.Printf("Failed to resolve upstream DNS server %s: %v", upstreamServer, err)
+	// Malformed and multi-question queries are answered with FORMERR
+	// rather than forwarded.
+	// Packets without a full header, and responses, which answering could
+	// turn into a loop, are dropped.
+	if parseErr != nil {
+		event.Error = parseErr.Error()
+		if len(query) < headerSize || query[2]&0x80 != 0 {
+			return
+		}
+		response, err := formErrResponse(query)
+		if err != nil {
+			return
+		}
+		event.Rcode = rcodeString(1)
+		p.reply(query, response, clientAddr, &event)
 		return
 	}
 
-	upstreamConn, err := net.DialUDP("udp", nil, upstreamAddr)
-	if err != nil {
-		log.Printf("Failed to connect to upstream DNS server %s: %v", upstreamServer, err)
+	// Only recursive clients are served when recursion is required
+	recursion := p.RecursionMode()
+	if recursion == RecursionRequire && !recursionDesired(query) {
+		response, err := refusedResponse(query)
+		if err != nil {
+			event.Error = err.Error()
+			return
+		}
+		event.Rcode = rcodeString(rcodeRefused)
+		p.reply(query, response, clientAddr, &event)
 		return
 	}
-	defer upstreamConn.Close()
 
-	// Send the query to upstream DNS
-	bytesWritten, err := upstreamConn.Write(query)
-	if err != nil {
-		log.Printf("Failed to send query to upstream DNS server: %v", err)
+	// Answer queries for blocked domains without forwarding them
+	if mode := p.blockedBy(name); mode != "" {
+		response, err := blockedResponse(query, qtype, mode)
+		if err != nil {
+			event.Error = err.Error()
+			return
+		}
+		event.Blocked = true
+		p.metrics.blocked.Inc()
+		p.blocks.blocked(strings.ToLower(strings.TrimSuffix(name, ".")))
+		if !utils.Quiet() {
+			log.Printf("Blocked %s %s from %s (%s)", name, event.Type, event.ClientIP, mode)
+		}
+		if rcode, err := extractRcode(response); err == nil {
+			event.Rcode = rcodeString(rcode)
+		}
+		p.reply(query, response, clientAddr, &event)
 		return
 	}
-	log.Printf("Query sent to upstream DNS server %s (%d bytes)", upstreamServer, bytesWritten)
 
-	// Receive the response
-	response := make([]byte, 4096)
-	upstreamConn.SetReadDeadline(time.Now().Add(5 * time.Second))
-	n, err := upstreamConn.Read(response)
-	if err != nil {
-		log.Printf("Failed to receive response from upstream DNS server: %v", err)
+	// Answer address queries for names in the hosts file from the file
+	if qtype == TypeA || qtype == TypeAAAA {
+		if ips, ok := p.lookupHosts(name); ok {
+			response, err := hostsResponse(query, qtype, ips)
+			if err != nil {
+				event.Error = err.Error()
+				return
+			}
+			event.Hosts = true
+			event.Rcode = rcodeString(0)
+			p.reply(query, response, clientAddr, &event)
+			return
+		}
+	}
+
+	// Answer AAAA queries without records when filtering is enabled
+	if qtype == TypeAAAA && p.AAAAFilter() {
+		response, err := emptyResponse(query)
+		if err != nil {
+			event.Error = err.Error()
+			return
+		}
+		event.Rcode = rcodeString(0)
+		p.reply(query, response, clientAddr, &event)
 		return
 	}
-	log.Printf("Received response from upstream DNS server (%d bytes)", n)
 
-	// Send the response back to the client
-	bytesWritten, err = p.conn.WriteToUDP(response[:n], clientAddr)
+	// Serve from the cache when possible. Queries with different DO/CD bits
+	// are cached separately.
+	key := newCacheKey(name, qtype, query)
+	response, cached := p.cache.get(key, binary.BigEndian.Uint16(query[0:2]))
+	if cached {
+		p.metrics.cacheHits.Inc()
+		event.CacheHit = true
+	} else {
+		p.metrics.cacheMisses.Inc()
+
+		// Forward to the upstreams according to the selection strategy,
+		// with the RD bit the recursion mode asks for, advertising a larger
+		// UDP payload size through EDNS0
+		base := withRecursion(query, recursion)
+		forwarded, addedEDNS := addEDNS0(base)
+		var upstream string
+		var err error
+		if nx, nxUpstream, ok := p.minimizedNXDomain(upstreams, name, qtype, base); ok {
+			// The parent of name does not exist, so name was never sent
+			response, upstream = nx, nxUpstream
+		} else {
+			response, upstream, err = p.resolve(upstreams, forwarded)
+			if err == nil && addedEDNS {
+				// Upstreams without EDNS0 support answer FORMERR; retry without it
+				if rcode, _ := extractRcode(response); rcode == 1 {
+					response, upstream, err = p.resolve(upstreams, base)
+				}
+			}
+			if err != nil {
+				// Last resort when every upstream failed
+				response, upstream, err = p.resolveFallback(upstreams, forwarded, err)
+			}
+		}
+		if err != nil {
+			// Rather than failing, answer from an expired cache entry if
+			// serving stale answers is enabled
+			stale, ok := p.cache.getStale(key, binary.BigEndian.Uint16(query[0:2]))
+			if !ok {
+				log.Printf("Query to upstream DNS servers failed: %v", err)
+				event.Error = err.Error()
+				return
+			}
+			log.Printf("Warning: query to upstream DNS servers failed (%v), serving a stale answer for %s", err, name)
+			p.refreshStale(key, upstreams, forwarded)
+			response = stale
+			event.Stale = true
+		} else {
+			// Minimized answers may come from a cached parent lookup
+			if upstream != "" {
+				event.Upstream = upstream
+				p.stats.answered(upstream)
+			}
+			p.cache.set(key, response)
+		}
+	}
+	if rcode, err := extractRcode(response); err == nil {
+		event.Rcode = rcodeString(rcode)
+	}
+
+	// The client sees the RD bit it sent, whatever was forwarded
+	matchRecursion(response, query)
+	p.reply(query, response, clientAddr, &event)
+}
+
+// reply sends response to the client that sent query
+func (p *DNSProxy) reply(query, response []byte, clientAddr *net.UDPAddr, event *QueryEvent) {
+	// Make sure the response fits what the client can receive over UDP
+	limit, clientEDNS := clientUDPSize(query)
+	response = fitResponse(response, clientEDNS, limit)
+
+	// Send the response back to the client. Queries still in flight when
+	// Stop gave up waiting find the socket gone.
+	p.mu.Lock()
+	conn := p.conn
+	p.mu.Unlock()
+	if conn == nil {
+		log.Printf("Failed to send response to client: DNS proxy stopped")
+		event.Error = "DNS proxy stopped"
+		return
+	}
+	bytesWritten, err := conn.WriteToUDP(response, clientAddr)
 	if err != nil {
 		log.Printf("Failed to send response to client: %v", err)
+		event.Error = err.Error()
 		return
 	}
-	log.Printf("Response sent back to client %s (%d bytes)", clientAddr.String(), bytesWritten)
+	utils.Logf("Response sent back to client %s (%d bytes)", clientAddr.String(), bytesWritten)
+}
+
+// queryUpstreamServer forwards a query to a single upstream server and returns
+// its response, sending it again if the upstream does not answer in time as
+// set by SetRetries. sent, if not nil, is called once query is no longer
+// needed.
+func (p *DNSProxy) queryUpstreamServer(upstreamServer string, query []byte, deadline time.Time, sent func()) ([]byte, error) {
+	utils.Logf("Forwarding query to upstream DNS server: %s", upstreamServer)
+	p.metrics.upstreamQueries.WithLabelValues(upstreamServer).Inc()
+	p.stats.sent(upstreamServer)
+	startTime := time.Now()
+
+	retries, backoff := p.Retries()
+	if retries > 0 && sent != nil {
+		// Retries send query again after the caller may have reused it
+		query = append([]byte(nil), query...)
+		sent()
+		sent = nil
+	}
+
+	var response []byte
+	var err error
+	for attempt := 0; ; attempt++ {
+		end, ok := attemptDeadline(deadline, attempt, retries, backoff)
+		if !ok {
+			retries = attempt
+		}
+		response, err = p.exchangePooled(upstreamServer, query, end, sent)
+		if err == nil || attempt == retries || !isTimeout(err) {
+			break
+		}
+		utils.Logf("Query to upstream DNS server %s timed out, retrying (%d/%d)", upstreamServer, attempt+1, retries)
+		time.Sleep(retryDelay(backoff, attempt+1))
+	}
+	if err != nil {
+		p.metrics.upstreamErrors.WithLabelValues(upstreamServer).Inc()
+		p.stats.failed(upstreamServer)
+		return nil, err
+	}
+
+	latency := time.Since(startTime)
+	p.metrics.upstreamLatency.WithLabelValues(upstreamServer).Observe(latency.Seconds())
+	p.stats.responded(upstreamServer, latency)
+	utils.Logf("Received response from upstream DNS server %s (%d bytes)", upstreamServer, len(response))
+	return response, nil
+}
+
+// exchangeUDP sends a query to a DNS server over a new UDP socket and waits
+// for the response until deadline. sent, if not nil, is called once the
+// query has been written.
+func exchangeUDP(server string, query []byte, deadline time.Time, sent func()) ([]byte, error) {
+	conn, err := dialUpstream(server)
+	if err != nil {
+		if sent != nil {
+			sent()
+		}
+		return nil, err
+	}
+	defer conn.Close()
+
+	return exchangeConn(conn.UDPConn, query, deadline, sent)
+}
+
+// exchangePooled is exchangeUDP over a socket borrowed from the proxy's
+// connection pool, which is discarded if the exchange fails
+func (p *DNSProxy) exchangePooled(server string, query []byte, deadline time.Time, sent func()) ([]byte, error) {
+	conn, err := p.upstreamConns.get(server)
+	if err != nil {
+		if sent != nil {
+			sent()
+		}
+		return nil, err
+	}
+
+	response, err := exchangeConn(conn.UDPConn, query, deadline, sent)
+	p.upstreamConns.put(server, conn, err == nil)
+	return response, err
+}
+
+// exchangeConn sends a query on a connected UDP socket and waits for the
+// response with the same ID until deadline. Other datagrams, such as a late
+// response to an earlier query on a reused socket, are skipped. sent, if
+// not nil, is called once the query has been written.
+func exchangeConn(conn *net.UDPConn, query []byte, deadline time.Time, sent func()) ([]byte, error) {
+	// Call sent exactly once, whether or not the query goes out
+	release := func() {
+		if sent != nil {
+			sent()
+			sent = nil
+		}
+	}
+	defer release()
+
+	if len(query) < headerSize {
+		return nil, fmt.Errorf("query too short")
+	}
+	id := binary.BigEndian.Uint16(query[0:2])
+
+	// Send the query to upstream DNS
+	if _, err := conn.Write(query); err != nil {
+		return nil, fmt.Errorf("failed to send query: %w", err)
+	}
+	release()
+
+	// Receive the response into a pooled buffer and return a copy of it
+	buffer := getBuffer()
+	defer putBuffer(buffer)
+	conn.SetReadDeadline(deadline)
+	for {
+		n, err := conn.Read(*buffer)
+		if err != nil {
+			return nil, fmt.Errorf("failed to receive response: %w", err)
+		}
+		if n >= 2 && binary.BigEndian.Uint16((*buffer)[0:2]) == id {
+			return append([]byte(nil), (*buffer)[:n]...), nil
+		}
+		utils.Logf("Ignoring response with unexpected ID from %s", conn.RemoteAddr())
+	}
+}
+
+// getBuffer returns a udpBufferSize buffer from bufferPool
+func getBuffer() *[]byte {
+	return bufferPool.Get().(*[]byte)
+}
+
+// putBuffer returns a buffer obtained from getBuffer to bufferPool. The
+// buffer must not be used afterwards.
+func putBuffer(buffer *[]byte) {
+	bufferPool.Put(buffer)
 }
@@ -0,0 +1,535 @@
+package dns
+
+import (
+	"bytes"
+	"encoding/binary"
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/ourines/GateShift/internal/utils"
+)
+
+func TestNormalizeUpstream(t *testing.T) {
+	tests := []struct {
+		server string
+		want   string
+	}{
+		{"8.8.8.8", "8.8.8.8:53"},
+		{"8.8.8.8:5353", "8.8.8.8:5353"},
+		{"2606:4700:4700::1111", "[2606:4700:4700::1111]:53"},
+		{"[2606:4700:4700::1111]", "[2606:4700:4700::1111]:53"},
+		{"[2606:4700:4700::1111]:5353", "[2606:4700:4700::1111]:5353"},
+		{" 1.1.1.1 ", "1.1.1.1:53"},
+	}
+
+	for _, tt := range tests {
+		got, err := NormalizeUpstream(tt.server)
+		if err != nil {
+			t.Errorf("NormalizeUpstream(%q) error = %v", tt.server, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("NormalizeUpstream(%q) = %q, want %q", tt.server, got, tt.want)
+		}
+	}
+}
+
+func TestNormalizeUpstreamRejectsInvalid(t *testing.T) {
+	for _, server := range []string{
+		"",
+		"dns.google",
+		"8.8.8.8:0",
+		"8.8.8.8:dns",
+		"[2606:4700:4700::1111]:99999",
+		"https://dns.google/dns-query",
+		"not an address",
+	} {
+		if got, err := NormalizeUpstream(server); err == nil {
+			t.Errorf("NormalizeUpstream(%q) = %q, want an error", server, got)
+		}
+	}
+}
+
+func TestNormalizeUpstreams(t *testing.T) {
+	servers, err := NormalizeUpstreams([]string{"1.1.1.1", "1.1.1.1:53", "2606:4700:4700::1111", "[2606:4700:4700::1111]:53", "9.9.9.9:5353"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"1.1.1.1:53", "[2606:4700:4700::1111]:53", "9.9.9.9:5353"}
+	if !reflect.DeepEqual(servers, want) {
+		t.Errorf("NormalizeUpstreams = %v, want %v", servers, want)
+	}
+
+	if _, err := NormalizeUpstreams([]string{"1.1.1.1", "dns.google"}); err == nil {
+		t.Error("expected an error for a host name")
+	}
+}
+
+func TestProxyNormalizesLegacyUpstreams(t *testing.T) {
+	p, err := NewDNSProxy("127.0.0.1", 0, []string{"8.8.8.8", "2001:4860:4860::8888"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"8.8.8.8:53", "[2001:4860:4860::8888]:53"}; !reflect.DeepEqual(p.Upstreams(), want) {
+		t.Errorf("upstreams = %v, want %v", p.Upstreams(), want)
+	}
+	if _, err := NewDNSProxy("127.0.0.1", 0, []string{"8.8.8.8", "dns.google"}); err == nil {
+		t.Error("NewDNSProxy accepted a host name")
+	}
+
+	if err := p.SetUpstreams([]string{" 1.1.1.1 ", "1.1.1.1:53"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"1.1.1.1:53"}; !reflect.DeepEqual(p.Upstreams(), want) {
+		t.Errorf("upstreams = %v, want %v", p.Upstreams(), want)
+	}
+
+	if err := p.ApplyConfig(ReloadConfig{Upstreams: []string{"[2606:4700:4700::1111]", "9.9.9.9:5353"}, Fallback: "149.112.112.112"}); err != nil {
+		t.Fatal(err)
+	}
+	if want := []string{"[2606:4700:4700::1111]:53", "9.9.9.9:5353"}; !reflect.DeepEqual(p.Upstreams(), want) {
+		t.Errorf("upstreams = %v, want %v", p.Upstreams(), want)
+	}
+	if p.Fallback() != "149.112.112.112:53" {
+		t.Errorf("fallback = %q, want %q", p.Fallback(), "149.112.112.112:53")
+	}
+
+	for name, rc := range map[string]ReloadConfig{
+		"upstream": {Upstreams: []string{"8.8.8.8:dns"}},
+		"fallback": {Upstreams: []string{"8.8.8.8"}, Fallback: "dns.quad9.net"},
+	} {
+		if err := p.ApplyConfig(rc); err == nil {
+			t.Errorf("%s: ApplyConfig accepted a malformed server", name)
+		}
+	}
+	if err := p.SetFallback("8.8.4.4"); err != nil || p.Fallback() != "8.8.4.4:53" {
+		t.Errorf("SetFallback(8.8.4.4) = %v, fallback %q", err, p.Fallback())
+	}
+}
+
+// exchange sends query through p.processQuery and returns the response the
+// client receives
+func exchange(t *testing.T, p *DNSProxy, query []byte) []byte {
+	t.Helper()
+
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	p.processQuery(query, client.LocalAddr().(*net.UDPAddr))
+
+	buf := make([]byte, 4096)
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	n, _, err := client.ReadFromUDP(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return buf[:n]
+}
+
+func TestAAAAFilter(t *testing.T) {
+	upstream := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetUpstreams([]string{upstream.addr}); err != nil {
+		t.Fatal(err)
+	}
+	p.SetAAAAFilter(true)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p.conn = conn
+
+	// AAAA is answered locally with no records
+	query, err := BuildQuery(0x1234, "example.com", TypeAAAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	response := exchange(t, p, query)
+
+	if id := binary.BigEndian.Uint16(response[0:2]); id != 0x1234 {
+		t.Errorf("response ID = %#x, want 0x1234", id)
+	}
+	if response[2]&0x80 == 0 {
+		t.Error("QR bit not set")
+	}
+	if rcode, _ := extractRcode(response); rcode != 0 {
+		t.Errorf("rcode = %s, want NOERROR", rcodeString(rcode))
+	}
+	if an := binary.BigEndian.Uint16(response[6:8]); an != 0 {
+		t.Errorf("answer count = %d, want 0", an)
+	}
+	if name, qtype, err := extractQueryName(response); err != nil || name != "example.com." || qtype != TypeAAAA {
+		t.Errorf("question = %s %s (%v), want example.com. AAAA", name, typeString(qtype), err)
+	}
+	if n := atomic.LoadInt32(&upstream.queries); n != 0 {
+		t.Errorf("filtered AAAA query forwarded %d times", n)
+	}
+
+	// A is still forwarded to the upstream
+	query = testQuery(t, 0x4321)
+	response = exchange(t, p, query)
+	if n := atomic.LoadInt32(&upstream.queries); n != 1 {
+		t.Errorf("A query forwarded %d times, want 1", n)
+	}
+	if !bytes.Equal(response[:2], query[:2]) {
+		t.Errorf("A response ID = %x, want %x", response[:2], query[:2])
+	}
+}
+
+func TestMalformedQuery(t *testing.T) {
+	upstream := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetUpstreams([]string{upstream.addr}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p.conn = conn
+
+	// A question cut off in the middle of the name is answered with FORMERR
+	query := testQuery(t, 0x1234)
+	query[2] |= 0x01 // RD
+	response := exchange(t, p, query[:headerSize+4])
+	if len(response) != headerSize {
+		t.Errorf("response is %d bytes, want only the header", len(response))
+	}
+	if id := binary.BigEndian.Uint16(response[0:2]); id != 0x1234 {
+		t.Errorf("response ID = %#x, want 0x1234", id)
+	}
+	if response[2]&0x81 != 0x81 {
+		t.Errorf("flags = %#x, want QR and RD set", response[2])
+	}
+	if rcode, _ := extractRcode(response); rcode != 1 {
+		t.Errorf("rcode = %s, want FORMERR", rcodeString(rcode))
+	}
+
+	// Truncated headers and malformed responses are dropped without an answer
+	client, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+	malformedResponse := append([]byte(nil), query[:headerSize+4]...)
+	malformedResponse[2] |= 0x80
+	for _, msg := range [][]byte{query[:5], malformedResponse} {
+		p.processQuery(msg, client.LocalAddr().(*net.UDPAddr))
+	}
+	client.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+	if n, _, err := client.ReadFromUDP(make([]byte, 512)); err == nil {
+		t.Errorf("got a %d byte answer to a dropped packet", n)
+	}
+
+	if n := atomic.LoadInt32(&upstream.queries); n != 0 {
+		t.Errorf("malformed queries forwarded %d times", n)
+	}
+}
+
+func TestMultiQuestionQuery(t *testing.T) {
+	upstream := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetUpstreams([]string{upstream.addr}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p.conn = conn
+
+	// A second question for example.org after the one for example.com
+	second, err := BuildQuery(0, "example.org", TypeAAAA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	query := append(testQuery(t, 0xbeef), second[headerSize:]...)
+	binary.BigEndian.PutUint16(query[4:6], 2)
+
+	response := exchange(t, p, query)
+	if id := binary.BigEndian.Uint16(response[0:2]); id != 0xbeef {
+		t.Errorf("response ID = %#x, want 0xbeef", id)
+	}
+	if rcode, _ := extractRcode(response); rcode != 1 {
+		t.Errorf("rcode = %s, want FORMERR", rcodeString(rcode))
+	}
+	if qd := binary.BigEndian.Uint16(response[4:6]); qd != 2 {
+		t.Errorf("question count = %d, want 2", qd)
+	}
+	if !bytes.Equal(response[headerSize:], query[headerSize:]) {
+		t.Errorf("question section = %x, want the query's %x", response[headerSize:], query[headerSize:])
+	}
+	if n := atomic.LoadInt32(&upstream.queries); n != 0 {
+		t.Errorf("multi-question query forwarded %d times", n)
+	}
+}
+
+func TestApplyConfig(t *testing.T) {
+	p, err := NewDNSProxy("127.0.0.1", 0, []string{"8.8.8.8:53"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := p.ApplyConfig(ReloadConfig{
+		Upstreams:  []string{"1.1.1.1:53", "9.9.9.9:53"},
+		Strategy:   StrategyRoundRobin,
+		FilterAAAA: true,
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if got := p.Upstreams(); len(got) != 2 || got[0] != "1.1.1.1:53" {
+		t.Errorf("Upstreams() = %v after reload", got)
+	}
+	if p.Strategy() != StrategyRoundRobin || !p.AAAAFilter() {
+		t.Errorf("strategy %q, AAAA filter %v after reload", p.Strategy(), p.AAAAFilter())
+	}
+
+	// A single invalid setting fails the whole reload and keeps the old config
+	for _, rc := range []ReloadConfig{
+		{Upstreams: []string{"8.8.4.4:53", "dns.google"}},
+		{Upstreams: []string{"8.8.4.4:53"}, Strategy: "random"},
+		{},
+	} {
+		if err := p.ApplyConfig(rc); err == nil {
+			t.Errorf("ApplyConfig(%+v) succeeded, want an error", rc)
+		}
+	}
+	if got := p.Upstreams(); len(got) != 2 || got[0] != "1.1.1.1:53" {
+		t.Errorf("Upstreams() = %v after a failed reload", got)
+	}
+	if p.Strategy() != StrategyRoundRobin || !p.AAAAFilter() {
+		t.Errorf("strategy %q, AAAA filter %v after a failed reload", p.Strategy(), p.AAAAFilter())
+	}
+}
+
+func TestStopDrainsInflightQueries(t *testing.T) {
+	upstream := startDelayedUpstream(t, 300*time.Millisecond)
+	p := newTestProxy(t, StrategyPriority)
+	if err := p.SetUpstreams([]string{upstream}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+
+	client, err := net.DialUDP("udp", nil, p.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	query := testQuery(t, 0x1234)
+	if _, err := client.Write(query); err != nil {
+		t.Fatal(err)
+	}
+	// Let the proxy forward the query, then stop while the upstream delays it
+	time.Sleep(100 * time.Millisecond)
+	start := time.Now()
+	if err := p.Stop(); err != nil {
+		t.Fatal(err)
+	}
+	if elapsed := time.Since(start); elapsed > drainTimeout {
+		t.Errorf("Stop took %v, want at most %v", elapsed, drainTimeout)
+	}
+
+	buf := make([]byte, 512)
+	client.SetReadDeadline(time.Now().Add(time.Second))
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatalf("pending query was not answered: %v", err)
+	}
+	if id := binary.BigEndian.Uint16(buf[0:2]); n < 12 || id != 0x1234 {
+		t.Errorf("response ID = %#x, want %#x", id, 0x1234)
+	}
+
+	// New queries are no longer answered
+	client.Write(query)
+	client.SetReadDeadline(time.Now().Add(200 * time.Millisecond))
+	if _, err := client.Read(buf); err == nil {
+		t.Error("query answered after Stop")
+	}
+}
+
+// panickingLogger panics when logging a query for name
+type panickingLogger struct {
+	name string
+}
+
+func (l panickingLogger) LogQuery(event QueryEvent) {
+	if event.Name == l.name {
+		panic("query logger failed")
+	}
+}
+
+func TestProcessQueryRecoversFromPanic(t *testing.T) {
+	upstream := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetUpstreams([]string{upstream.addr}); err != nil {
+		t.Fatal(err)
+	}
+	p.SetQueryLogger(panickingLogger{name: "panic.example.com."})
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	client, err := net.DialUDP("udp", nil, p.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	query, err := BuildQuery(0x1111, "panic.example.com", TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := client.Write(query); err != nil {
+		t.Fatal(err)
+	}
+	deadline := time.Now().Add(2 * time.Second)
+	for p.RecoveredPanics() == 0 && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := p.RecoveredPanics(); n != 1 {
+		t.Fatalf("RecoveredPanics() = %d, want 1", n)
+	}
+
+	// The proxy keeps answering other queries
+	buf := make([]byte, 512)
+	query = testQuery(t, 0x2222)
+	for {
+		if _, err := client.Write(query); err != nil {
+			t.Fatal(err)
+		}
+		client.SetReadDeadline(time.Now().Add(time.Second))
+		n, err := client.Read(buf)
+		if err != nil {
+			t.Fatalf("query after the panic was not answered: %v", err)
+		}
+		// Skip the answer to the query whose logging panicked
+		if id := binary.BigEndian.Uint16(buf[0:2]); n >= headerSize && id == 0x2222 {
+			break
+		}
+	}
+
+	families, err := p.metrics.registry.Gather()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, family := range families {
+		if family.GetName() == "gateshift_dns_query_panics_total" {
+			if v := family.GetMetric()[0].GetCounter().GetValue(); v != 1 {
+				t.Errorf("gateshift_dns_query_panics_total = %v, want 1", v)
+			}
+			return
+		}
+	}
+	t.Error("gateshift_dns_query_panics_total not registered")
+}
+
+// BenchmarkProxyQuery measures a full round trip through a running proxy,
+// forwarding every query to the upstream by clearing the cache
+func BenchmarkProxyQuery(b *testing.B) {
+	defer utils.SetLogLevel(utils.LogLevel())
+	utils.SetLogLevel(utils.LevelQuiet)
+
+	upstream := startTestUpstream(b, true)
+	p := newTestProxy(b, StrategyParallel)
+	if err := p.SetUpstreams([]string{upstream.addr}); err != nil {
+		b.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		b.Fatal(err)
+	}
+	defer p.Stop()
+
+	client, err := net.DialUDP("udp", nil, p.conn.LocalAddr().(*net.UDPAddr))
+	if err != nil {
+		b.Fatal(err)
+	}
+	defer client.Close()
+
+	query := testQuery(b, 0x1234)
+	buf := make([]byte, 4096)
+	b.ReportAllocs()
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		p.ClearCache()
+		if _, err := client.Write(query); err != nil {
+			b.Fatal(err)
+		}
+		client.SetReadDeadline(time.Now().Add(2 * time.Second))
+		if _, err := client.Read(buf); err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestListenNetwork(t *testing.T) {
+	tests := map[string]string{
+		"127.0.0.1": "udp4",
+		"0.0.0.0":   "udp4",
+		"::1":       "udp6",
+		"::":        "udp6",
+		"fd00::53":  "udp6",
+		"localhost": "udp",
+	}
+	for addr, want := range tests {
+		if got := listenNetwork(addr); got != want {
+			t.Errorf("listenNetwork(%q) = %s, want %s", addr, got, want)
+		}
+	}
+}
+
+func TestStartIPv6(t *testing.T) {
+	probe, err := net.ListenUDP("udp6", &net.UDPAddr{IP: net.IPv6loopback})
+	if err != nil {
+		t.Skipf("IPv6 loopback unavailable: %v", err)
+	}
+	probe.Close()
+
+	upstream := startTestUpstream(t, true)
+	p, err := NewDNSProxy("::1", 0, []string{upstream.addr})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.Start(); err != nil {
+		t.Fatal(err)
+	}
+	defer p.Stop()
+
+	addr := p.conn.LocalAddr().(*net.UDPAddr)
+	if !addr.IP.Equal(net.IPv6loopback) {
+		t.Fatalf("listening on %v, want [::1]", addr)
+	}
+	client, err := net.DialUDP("udp6", nil, addr)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	if _, err := client.Write(testQuery(t, 0x6666)); err != nil {
+		t.Fatal(err)
+	}
+	client.SetReadDeadline(time.Now().Add(2 * time.Second))
+	buf := make([]byte, 512)
+	n, err := client.Read(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if id := binary.BigEndian.Uint16(buf[0:2]); n < headerSize || id != 0x6666 {
+		t.Errorf("got a %d byte response with ID %#x, want ID 0x6666", n, id)
+	}
+}
@@ -0,0 +1,93 @@
+package dns
+
+import (
+	"encoding/binary"
+	"strings"
+)
+
+// typeDS is the delegation signer record type, which lives in the parent
+// zone and is never minimized
+const typeDS = 43
+
+// SetQNAMEMinimization sets whether the name one label up is looked up before
+// a query is forwarded (a conservative form of RFC 9156 QNAME minimization).
+// When the upstreams report that parent name does not exist, the query is
+// answered NXDOMAIN without the full name ever being sent. Every other
+// outcome forwards the query as usual, so this costs an extra NS query per
+// cache miss and only hides names under parents that do not exist.
+func (p *DNSProxy) SetQNAMEMinimization(enabled bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.qnameMinimization = enabled
+}
+
+// QNAMEMinimization reports whether QNAME minimization is enabled
+func (p *DNSProxy) QNAMEMinimization() bool {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.qnameMinimization
+}
+
+// parentName returns name without its first label, or "" when that would
+// leave a top-level domain or less, which reveals nothing worth hiding
+func parentName(name string) string {
+	name = strings.TrimSuffix(name, ".")
+	i := strings.IndexByte(name, '.')
+	if i < 0 || !strings.Contains(name[i+1:], ".") {
+		return ""
+	}
+	return name[i+1:] + "."
+}
+
+// minimizedNXDomain looks up the NS records of the parent of name when QNAME
+// minimization is enabled and, if the upstreams answer that the parent does
+// not exist, returns an NXDOMAIN response to query along with the upstream
+// that answered, empty when the answer came from the cache. ok is false
+// whenever query must be forwarded in full: minimization is off or does not
+// apply, or the parent lookup failed or was not a clear NXDOMAIN.
+func (p *DNSProxy) minimizedNXDomain(upstreams []string, name string, qtype uint16, query []byte) (response []byte, upstream string, ok bool) {
+	if !p.QNAMEMinimization() || qtype == typeDS {
+		return nil, "", false
+	}
+	// DNSSEC-aware clients need the proofs of the full answer
+	if do, cd := dnssecFlags(query); do || cd {
+		return nil, "", false
+	}
+	parent := parentName(name)
+	if parent == "" {
+		return nil, "", false
+	}
+
+	id := binary.BigEndian.Uint16(query[0:2])
+	probe, err := BuildQuery(id, parent, TypeNS)
+	if err != nil {
+		return nil, "", false
+	}
+	// Ask with the RD bit query is forwarded with
+	probe[2] = probe[2]&^flagRD | query[2]&flagRD
+
+	key := newCacheKey(parent, TypeNS, probe)
+	answer, cached := p.cache.get(key, id)
+	if !cached {
+		if answer, upstream, err = p.resolve(upstreams, probe); err != nil {
+			return nil, "", false
+		}
+		p.cache.set(key, answer)
+	}
+
+	// Anything but an untruncated NXDOMAIN for the parent is ambiguous
+	if rcode, err := extractRcode(answer); err != nil || rcode != rcodeNXDomain || answer[2]&0x02 != 0 {
+		return nil, "", false
+	}
+	msg, err := ParseMessage(answer)
+	if err != nil || len(msg.Questions) != 1 || !strings.EqualFold(msg.Questions[0].Name, parent) {
+		return nil, "", false
+	}
+
+	response, err = emptyResponse(query)
+	if err != nil {
+		return nil, "", false
+	}
+	response[3] |= rcodeNXDomain
+	return response, upstream, true
+}
@@ -0,0 +1,168 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+)
+
+// authUpstream answers like the servers of a zone holding names: a name
+// exists if it or a name under it is listed, names containing "broken"
+// fail with SERVFAIL, and every question asked is recorded
+type authUpstream struct {
+	addr string
+
+	mu        sync.Mutex
+	questions []string
+}
+
+func startAuthUpstream(t *testing.T, names ...string) *authUpstream {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	u := &authUpstream{addr: conn.LocalAddr().String()}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			n, client, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			query := append([]byte(nil), buf[:n]...)
+			msg, err := ParseMessage(query)
+			if err != nil || len(msg.Questions) != 1 {
+				continue
+			}
+			q := msg.Questions[0]
+			u.mu.Lock()
+			u.questions = append(u.questions, q.Name+" "+typeString(q.Type))
+			u.mu.Unlock()
+
+			var response []byte
+			switch {
+			case strings.Contains(q.Name, "broken"):
+				response, _ = emptyResponse(query)
+				response[3] |= 2 // SERVFAIL
+			case !zoneHas(names, q.Name):
+				response, _ = emptyResponse(query)
+				response[3] |= rcodeNXDomain
+			case q.Type == TypeA && listed(names, q.Name):
+				response, _ = addressResponse(query, TypeA, []net.IP{net.IPv4(10, 0, 0, 1)}, 60)
+			default:
+				response, _ = emptyResponse(query)
+			}
+			conn.WriteToUDP(response, client)
+		}
+	}()
+	return u
+}
+
+// zoneHas reports whether name or a name under it is in names
+func zoneHas(names []string, name string) bool {
+	for _, n := range names {
+		if n == name || strings.HasSuffix(n, "."+name) {
+			return true
+		}
+	}
+	return false
+}
+
+// listed reports whether name is in names
+func listed(names []string, name string) bool {
+	for _, n := range names {
+		if n == name {
+			return true
+		}
+	}
+	return false
+}
+
+// asked returns the questions the upstream received and forgets them
+func (u *authUpstream) asked() string {
+	u.mu.Lock()
+	defer u.mu.Unlock()
+	questions := strings.Join(u.questions, ", ")
+	u.questions = nil
+	return questions
+}
+
+func TestParentName(t *testing.T) {
+	tests := map[string]string{
+		"a.b.example.com.": "b.example.com.",
+		"www.example.com":  "example.com.",
+		"example.com.":     "",
+		"com.":             "",
+	}
+	for name, want := range tests {
+		if got := parentName(name); got != want {
+			t.Errorf("parentName(%q) = %q, want %q", name, got, want)
+		}
+	}
+}
+
+func TestQNAMEMinimization(t *testing.T) {
+	upstream := startAuthUpstream(t, "example.com.", "a.b.example.com.", "host.broken.example.com.")
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetUpstreams([]string{upstream.addr}); err != nil {
+		t.Fatal(err)
+	}
+	p.SetQNAMEMinimization(true)
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p.conn = conn
+
+	tests := []struct {
+		name  string
+		rcode int
+		asked string
+	}{
+		// The parent does not exist: the full name is never sent
+		{"secret.missing.example.com.", rcodeNXDomain, "missing.example.com. NS"},
+		// The parent exists: the full name follows
+		{"a.b.example.com.", 0, "b.example.com. NS, a.b.example.com. A"},
+		// The parent exists but the name does not
+		{"c.b.example.com.", rcodeNXDomain, "b.example.com. NS, c.b.example.com. A"},
+		// A failed parent lookup falls back to forwarding the full name
+		{"host.broken.example.com.", 2, "broken.example.com. NS, host.broken.example.com. A"},
+		// Names directly under a top-level domain are not minimized
+		{"example.com.", 0, "example.com. A"},
+	}
+	for _, tt := range tests {
+		query, err := BuildQuery(1, tt.name, TypeA)
+		if err != nil {
+			t.Fatal(err)
+		}
+		response := exchange(t, p, query)
+		if rcode, _ := extractRcode(response); rcode != tt.rcode {
+			t.Errorf("%s: rcode %s, want %s", tt.name, rcodeString(rcode), rcodeString(tt.rcode))
+		}
+		if asked := upstream.asked(); asked != tt.asked {
+			t.Errorf("%s: upstream asked %q, want %q", tt.name, asked, tt.asked)
+		}
+	}
+
+	// DNSSEC queries are forwarded in full
+	query, _ := BuildQuery(1, "other.missing.example.com.", TypeA)
+	exchange(t, p, withDO(t, query))
+	if asked := upstream.asked(); asked != "other.missing.example.com. A" {
+		t.Errorf("DO query: upstream asked %q, want only the full name", asked)
+	}
+
+	// Without minimization only the full name is sent
+	p.SetQNAMEMinimization(false)
+	query, _ = BuildQuery(1, "leaf.gone.example.com.", TypeA)
+	exchange(t, p, query)
+	if asked := upstream.asked(); asked != "leaf.gone.example.com. A" {
+		t.Errorf("minimization off: upstream asked %q", asked)
+	}
+}
@@ -0,0 +1,154 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"io"
+	"log"
+	"sync"
+	"time"
+)
+
+// Supported query log formats
+const (
+	LogFormatText = "text"
+	LogFormatJSON = "json"
+)
+
+// QueryEvent describes the outcome of a single client query
+type QueryEvent struct {
+	Time     time.Time `json:"timestamp"`
+	ClientIP string    `json:"client_ip"`
+	Name     string    `json:"name"`
+	Type     string    `json:"type"`
+	CacheHit bool      `json:"cache_hit"`
+	Blocked  bool      `json:"blocked,omitempty"`
+	Hosts    bool      `json:"hosts,omitempty"`
+	// Stale is set when an expired cache entry answered after the
+	// upstreams failed
+	Stale     bool    `json:"stale,omitempty"`
+	Upstream  string  `json:"upstream,omitempty"`
+	LatencyMs float64 `json:"latency_ms"`
+	Rcode     string  `json:"rcode,omitempty"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// QueryLogger records query events
+type QueryLogger interface {
+	LogQuery(event QueryEvent)
+}
+
+// NewQueryLogger returns a query logger for the given format writing to w
+func NewQueryLogger(format string, w io.Writer) (QueryLogger, error) {
+	switch format {
+	case "", LogFormatText:
+		return &textQueryLogger{logger: log.New(w, "", log.LstdFlags)}, nil
+	case LogFormatJSON:
+		return &jsonQueryLogger{w: w}, nil
+	default:
+		return nil, fmt.Errorf("unsupported log format: %s", format)
+	}
+}
+
+// textQueryLogger writes query events as human-readable log lines
+type textQueryLogger struct {
+	logger *log.Logger
+}
+
+func (l *textQueryLogger) LogQuery(e QueryEvent) {
+	if e.Error != "" {
+		l.logger.Printf("Query %s %s from %s failed via %s: %s (%.1fms)", e.Name, e.Type, e.ClientIP, e.Upstream, e.Error, e.LatencyMs)
+		return
+	}
+	if e.Blocked {
+		l.logger.Printf("Query %s %s from %s blocked: %s (%.1fms)", e.Name, e.Type, e.ClientIP, e.Rcode, e.LatencyMs)
+		return
+	}
+	if e.Hosts {
+		l.logger.Printf("Query %s %s from %s answered from the hosts file: %s (%.1fms)", e.Name, e.Type, e.ClientIP, e.Rcode, e.LatencyMs)
+		return
+	}
+	if e.Stale {
+		l.logger.Printf("Query %s %s from %s answered from a stale cache entry: %s (%.1fms)", e.Name, e.Type, e.ClientIP, e.Rcode, e.LatencyMs)
+		return
+	}
+	l.logger.Printf("Query %s %s from %s answered via %s: %s (%.1fms, cache hit: %v)", e.Name, e.Type, e.ClientIP, e.Upstream, e.Rcode, e.LatencyMs, e.CacheHit)
+}
+
+// jsonQueryLogger writes one JSON object per query event
+type jsonQueryLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *jsonQueryLogger) LogQuery(e QueryEvent) {
+	data, err := json.Marshal(e)
+	if err != nil {
+		log.Printf("Failed to encode query event: %v", err)
+		return
+	}
+
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.w.Write(append(data, '\n'))
+}
+
+// NewTraceLogger returns a query logger that writes one concise line per
+// query to w, for following queries live on a terminal
+func NewTraceLogger(w io.Writer) QueryLogger {
+	return &traceQueryLogger{w: w}
+}
+
+// traceQueryLogger writes each query event as a single summary line
+type traceQueryLogger struct {
+	mu sync.Mutex
+	w  io.Writer
+}
+
+func (l *traceQueryLogger) LogQuery(e QueryEvent) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	fmt.Fprintln(l.w, formatTrace(e))
+}
+
+// formatTrace renders a query event as
+// "15:04:05.000 client → name type → result latency"
+func formatTrace(e QueryEvent) string {
+	question := "?"
+	if e.Name != "" {
+		question = e.Name + " " + e.Type
+	}
+
+	var result string
+	switch {
+	case e.Error != "":
+		result = "error: " + e.Error
+	case e.Blocked:
+		result = e.Rcode + " (blocked)"
+	case e.Hosts:
+		result = e.Rcode + " (hosts)"
+	case e.Stale:
+		result = e.Rcode + " (stale)"
+	case e.CacheHit:
+		result = e.Rcode + " (cache)"
+	case e.Upstream != "":
+		result = e.Rcode + " via " + e.Upstream
+	default:
+		result = e.Rcode
+	}
+	return fmt.Sprintf("%s %s → %s → %s %.1fms", e.Time.Format("15:04:05.000"), e.ClientIP, question, result, e.LatencyMs)
+}
+
+// MultiQueryLogger returns a query logger that passes every event to each of
+// loggers in turn
+func MultiQueryLogger(loggers ...QueryLogger) QueryLogger {
+	return multiQueryLogger(loggers)
+}
+
+type multiQueryLogger []QueryLogger
+
+func (m multiQueryLogger) LogQuery(e QueryEvent) {
+	for _, logger := range m {
+		logger.LogQuery(e)
+	}
+}
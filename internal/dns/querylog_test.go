@@ -0,0 +1,56 @@
+package dns
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestFormatTrace(t *testing.T) {
+	at := time.Date(2024, 1, 2, 15, 4, 5, 123e6, time.Local)
+	tests := []struct {
+		event QueryEvent
+		want  string
+	}{
+		{
+			QueryEvent{Time: at, ClientIP: "127.0.0.1", Name: "example.com.", Type: "A", Upstream: "8.8.8.8:53", Rcode: "NOERROR", LatencyMs: 12.34},
+			"15:04:05.123 127.0.0.1 → example.com. A → NOERROR via 8.8.8.8:53 12.3ms",
+		},
+		{
+			QueryEvent{Time: at, ClientIP: "127.0.0.1", Name: "example.com.", Type: "AAAA", CacheHit: true, Rcode: "NXDOMAIN", LatencyMs: 0.05},
+			"15:04:05.123 127.0.0.1 → example.com. AAAA → NXDOMAIN (cache) 0.1ms",
+		},
+		{
+			QueryEvent{Time: at, ClientIP: "127.0.0.1", Name: "ads.example.com.", Type: "A", Blocked: true, Rcode: "NXDOMAIN", LatencyMs: 0.02},
+			"15:04:05.123 127.0.0.1 → ads.example.com. A → NXDOMAIN (blocked) 0.0ms",
+		},
+		{
+			QueryEvent{Time: at, ClientIP: "::1", Error: "query timed out", LatencyMs: 5000},
+			"15:04:05.123 ::1 → ? → error: query timed out 5000.0ms",
+		},
+	}
+
+	for _, tt := range tests {
+		if got := formatTrace(tt.event); got != tt.want {
+			t.Errorf("formatTrace = %q, want %q", got, tt.want)
+		}
+	}
+}
+
+func TestMultiQueryLogger(t *testing.T) {
+	var text, trace bytes.Buffer
+	textLogger, err := NewQueryLogger(LogFormatJSON, &text)
+	if err != nil {
+		t.Fatal(err)
+	}
+	logger := MultiQueryLogger(textLogger, NewTraceLogger(&trace))
+
+	logger.LogQuery(QueryEvent{Time: time.Now(), ClientIP: "127.0.0.1", Name: "example.com.", Type: "A", Rcode: "NOERROR"})
+	if !strings.Contains(text.String(), `"name":"example.com."`) {
+		t.Errorf("JSON log = %q", text.String())
+	}
+	if !strings.Contains(trace.String(), "example.com. A → NOERROR") || strings.Count(trace.String(), "\n") != 1 {
+		t.Errorf("trace output = %q", trace.String())
+	}
+}
@@ -0,0 +1,95 @@
+package dns
+
+import "fmt"
+
+// How the recursion desired (RD) bit of client queries is handled
+const (
+	// RecursionForward forwards queries with the RD bit the client sent
+	RecursionForward = "forward"
+	// RecursionForce sets the RD bit on forwarded queries, for upstreams
+	// that do not recurse otherwise
+	RecursionForce = "force"
+	// RecursionStrip clears the RD bit on forwarded queries
+	RecursionStrip = "strip"
+	// RecursionRequire refuses queries without the RD bit and forwards the
+	// others unchanged, serving only recursive clients
+	RecursionRequire = "require"
+)
+
+// flagRD is the recursion desired bit in the third header byte
+const flagRD = 0x01
+
+// ValidateRecursionMode checks that mode is a supported RD bit handling
+func ValidateRecursionMode(mode string) error {
+	switch mode {
+	case RecursionForward, RecursionForce, RecursionStrip, RecursionRequire:
+		return nil
+	}
+	return fmt.Errorf("invalid recursion mode: %s (must be %s, %s, %s or %s)",
+		mode, RecursionForward, RecursionForce, RecursionStrip, RecursionRequire)
+}
+
+// SetRecursionMode sets how the RD bit of client queries is handled
+func (p *DNSProxy) SetRecursionMode(mode string) error {
+	if err := ValidateRecursionMode(mode); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.recursion = mode
+	return nil
+}
+
+// RecursionMode returns how the RD bit of client queries is handled
+func (p *DNSProxy) RecursionMode() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.recursion
+}
+
+// recursionDesired reports whether the RD bit of msg is set
+func recursionDesired(msg []byte) bool {
+	return len(msg) > 2 && msg[2]&flagRD != 0
+}
+
+// withRecursion returns query with its RD bit set or cleared as mode
+// requires. query itself is not modified.
+func withRecursion(query []byte, mode string) []byte {
+	var rd bool
+	switch mode {
+	case RecursionForce:
+		rd = true
+	case RecursionStrip:
+		rd = false
+	default:
+		return query
+	}
+	if len(query) < headerSize || recursionDesired(query) == rd {
+		return query
+	}
+
+	forwarded := make([]byte, len(query))
+	copy(forwarded, query)
+	forwarded[2] ^= flagRD
+	return forwarded
+}
+
+// matchRecursion copies the RD bit of query into response, which may answer
+// a forwarded query whose RD bit was changed
+func matchRecursion(response, query []byte) {
+	if len(response) < headerSize || len(query) < headerSize {
+		return
+	}
+	response[2] = response[2]&^flagRD | query[2]&flagRD
+}
+
+// refusedResponse builds a REFUSED response to query without any records
+func refusedResponse(query []byte) ([]byte, error) {
+	response, err := emptyResponse(query)
+	if err != nil {
+		return nil, err
+	}
+	response[3] |= rcodeRefused
+	return response, nil
+}
@@ -0,0 +1,90 @@
+package dns
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+)
+
+func TestValidateRecursionMode(t *testing.T) {
+	for _, mode := range []string{RecursionForward, RecursionForce, RecursionStrip, RecursionRequire} {
+		if err := ValidateRecursionMode(mode); err != nil {
+			t.Errorf("ValidateRecursionMode(%q) = %v", mode, err)
+		}
+	}
+	if err := ValidateRecursionMode("always"); err == nil {
+		t.Error("ValidateRecursionMode accepted an unknown mode")
+	}
+}
+
+func TestRecursionMode(t *testing.T) {
+	tests := []struct {
+		mode     string
+		clientRD bool
+		// upstreamRD is the RD bit forwarded upstream; refused queries are
+		// not forwarded
+		upstreamRD bool
+		refused    bool
+	}{
+		{RecursionForward, true, true, false},
+		{RecursionForward, false, false, false},
+		{RecursionForce, true, true, false},
+		{RecursionForce, false, true, false},
+		{RecursionStrip, true, false, false},
+		{RecursionStrip, false, false, false},
+		{RecursionRequire, true, true, false},
+		{RecursionRequire, false, false, true},
+	}
+	for _, tt := range tests {
+		// The upstream records the RD bit of the last query it received
+		var lastRD int32
+		upstream := startUpstream(t, func(n int32, query []byte) []byte {
+			atomic.StoreInt32(&lastRD, int32(query[2]&flagRD))
+			return echoResponse(query)
+		})
+		p := newTestProxy(t, StrategyParallel)
+		if err := p.SetUpstreams([]string{upstream.addr}); err != nil {
+			t.Fatal(err)
+		}
+		if err := p.SetRecursionMode(tt.mode); err != nil {
+			t.Fatal(err)
+		}
+		conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+		if err != nil {
+			t.Fatal(err)
+		}
+		p.conn = conn
+
+		query := testQuery(t, 0x4242)
+		if !tt.clientRD {
+			query[2] &^= flagRD
+		}
+		response := exchange(t, p, query)
+		conn.Close()
+
+		rcode, _ := extractRcode(response)
+		forwarded := atomic.LoadInt32(&upstream.queries) > 0
+		switch {
+		case tt.refused:
+			if rcode != rcodeRefused || forwarded {
+				t.Errorf("%s, RD %t: rcode %s, forwarded %t; want REFUSED without forwarding", tt.mode, tt.clientRD, rcodeString(rcode), forwarded)
+			}
+		case !forwarded:
+			t.Errorf("%s, RD %t: query not forwarded", tt.mode, tt.clientRD)
+		case (atomic.LoadInt32(&lastRD) != 0) != tt.upstreamRD:
+			t.Errorf("%s, RD %t: upstream RD = %t, want %t", tt.mode, tt.clientRD, !tt.upstreamRD, tt.upstreamRD)
+		}
+		if recursionDesired(response) != tt.clientRD {
+			t.Errorf("%s, RD %t: response RD = %t, want the client's", tt.mode, tt.clientRD, recursionDesired(response))
+		}
+	}
+}
+
+func TestWithRecursionLeavesQueryUnchanged(t *testing.T) {
+	query := testQuery(t, 1)
+	stripped := withRecursion(query, RecursionStrip)
+	if recursionDesired(stripped) || !recursionDesired(query) {
+		t.Errorf("withRecursion changed the query or did not strip RD: query RD %t, forwarded RD %t",
+			recursionDesired(query), recursionDesired(stripped))
+	}
+}
@@ -0,0 +1,198 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"reflect"
+	"time"
+
+	"github.com/ourines/GateShift/internal/utils"
+)
+
+// ReloadConfig holds the settings that can be changed while the proxy is
+// running. The listener, cache and upstream health are left untouched.
+type ReloadConfig struct {
+	Upstreams []string
+	// Strategy is the upstream selection strategy; empty keeps the current one
+	Strategy   string
+	FilterAAAA bool
+	// Fallback is the last-resort resolver; empty disables it
+	Fallback  string
+	Blocklist []string
+	// BlockResponse is how blocked queries are answered; empty keeps the
+	// current one
+	BlockResponse string
+	// Recursion is how the RD bit of client queries is handled; empty keeps
+	// the current mode
+	Recursion string
+	// HostsFile is the hosts-format file answering address queries, read
+	// again on every reload; empty disables it
+	HostsFile string
+	// IdleTimeout is how long the proxy may go without queries before Idle
+	// is closed; zero disables it
+	IdleTimeout time.Duration
+	// Retries is how many times a query to an upstream that timed out is
+	// sent again, waiting RetryBackoff before the first retry
+	Retries      int
+	RetryBackoff time.Duration
+	// CacheMinTTL and CacheMaxTTL clamp how long responses are cached; zero
+	// leaves that side unbounded. Entries already cached are unaffected.
+	CacheMinTTL time.Duration
+	CacheMaxTTL time.Duration
+	// ServeStale is how long after expiring cache entries may answer
+	// queries the upstreams fail; zero disables serving stale answers
+	ServeStale time.Duration
+	// QNAMEMinimization looks up the parent of names before forwarding them
+	QNAMEMinimization bool
+	// MaxInflight is how many queries are processed at once; zero keeps the
+	// current limit
+	MaxInflight int
+}
+
+// ApplyConfig validates every setting in rc before changing any of them, then
+// swaps them in under a single lock. If validation fails the running
+// configuration is left exactly as it was.
+func (p *DNSProxy) ApplyConfig(rc ReloadConfig) error {
+	if len(rc.Upstreams) == 0 {
+		return fmt.Errorf("at least one upstream DNS server is required")
+	}
+	upstreams, err := NormalizeUpstreams(rc.Upstreams)
+	if err != nil {
+		return err
+	}
+	if rc.Strategy != "" {
+		if err := ValidateStrategy(rc.Strategy); err != nil {
+			return err
+		}
+	}
+	fallback := rc.Fallback
+	if fallback != "" {
+		if fallback, err = NormalizeUpstream(fallback); err != nil {
+			return fmt.Errorf("invalid fallback DNS server: %w", err)
+		}
+	}
+
+	blocklist, err := newBlocklist(rc.Blocklist)
+	if err != nil {
+		return err
+	}
+	if rc.BlockResponse != "" {
+		if err := ValidateBlockResponse(rc.BlockResponse); err != nil {
+			return err
+		}
+	}
+	if rc.Recursion != "" {
+		if err := ValidateRecursionMode(rc.Recursion); err != nil {
+			return err
+		}
+	}
+	if rc.IdleTimeout < 0 {
+		return fmt.Errorf("invalid idle timeout: %v", rc.IdleTimeout)
+	}
+	if err := ValidateRetries(rc.Retries, rc.RetryBackoff); err != nil {
+		return err
+	}
+	if err := ValidateCacheTTL(rc.CacheMinTTL, rc.CacheMaxTTL); err != nil {
+		return err
+	}
+	if err := ValidateServeStale(rc.ServeStale); err != nil {
+		return err
+	}
+	if rc.MaxInflight != 0 {
+		if err := ValidateMaxInflight(rc.MaxInflight); err != nil {
+			return err
+		}
+	}
+	var hosts map[string][]net.IP
+	var hostsModTime time.Time
+	var hostsSize int64
+	if rc.HostsFile != "" {
+		if hosts, hostsModTime, hostsSize, err = loadHostsFile(rc.HostsFile); err != nil {
+			return err
+		}
+	}
+
+	previousMinTTL, previousMaxTTL := p.cache.ttlBounds()
+	previousServeStale := p.cache.maxStaleAge()
+	p.cache.setTTLBounds(rc.CacheMinTTL, rc.CacheMaxTTL)
+	p.cache.setStaleAge(rc.ServeStale)
+
+	previousMaxInflight := p.MaxInflight()
+	if rc.MaxInflight != 0 {
+		p.SetMaxInflight(rc.MaxInflight)
+	}
+
+	p.mu.Lock()
+	previous := ReloadConfig{Upstreams: p.upstreamDNS, Strategy: p.strategy, FilterAAAA: p.filterAAAA, Fallback: p.fallbackDNS,
+		Blocklist: sortedDomains(p.blocklist), BlockResponse: p.blockResponse, Recursion: p.recursion, HostsFile: p.hostsFile, IdleTimeout: p.idleTimeout,
+		Retries: p.retries, RetryBackoff: p.retryBackoff, CacheMinTTL: previousMinTTL, CacheMaxTTL: previousMaxTTL,
+		ServeStale: previousServeStale, QNAMEMinimization: p.qnameMinimization}
+	p.upstreamDNS = upstreams
+	if rc.Strategy != "" {
+		p.strategy = rc.Strategy
+	}
+	p.filterAAAA = rc.FilterAAAA
+	p.qnameMinimization = rc.QNAMEMinimization
+	p.fallbackDNS = fallback
+	p.blocklist = blocklist
+	if rc.BlockResponse != "" {
+		p.blockResponse = rc.BlockResponse
+	}
+	if rc.Recursion != "" {
+		p.recursion = rc.Recursion
+	}
+	p.hostsFile, p.hosts, p.hostsModTime, p.hostsSize = rc.HostsFile, hosts, hostsModTime, hostsSize
+	p.idleTimeout = rc.IdleTimeout
+	p.retries, p.retryBackoff = rc.Retries, rc.RetryBackoff
+	current := ReloadConfig{Upstreams: p.upstreamDNS, Strategy: p.strategy, FilterAAAA: p.filterAAAA, Fallback: p.fallbackDNS,
+		Blocklist: sortedDomains(p.blocklist), BlockResponse: p.blockResponse, Recursion: p.recursion, HostsFile: p.hostsFile, IdleTimeout: p.idleTimeout,
+		Retries: p.retries, RetryBackoff: p.retryBackoff, CacheMinTTL: rc.CacheMinTTL, CacheMaxTTL: rc.CacheMaxTTL,
+		ServeStale: rc.ServeStale, QNAMEMinimization: p.qnameMinimization}
+	p.mu.Unlock()
+
+	if !reflect.DeepEqual(previous.Upstreams, current.Upstreams) {
+		utils.Logf("Upstream DNS servers changed from %v to %v", previous.Upstreams, current.Upstreams)
+	}
+	if previous.Strategy != current.Strategy {
+		utils.Logf("Upstream strategy changed from %s to %s", previous.Strategy, current.Strategy)
+	}
+	if previous.FilterAAAA != current.FilterAAAA {
+		utils.Logf("AAAA filter changed from %v to %v", previous.FilterAAAA, current.FilterAAAA)
+	}
+	if previous.Fallback != current.Fallback {
+		utils.Logf("Fallback DNS server changed from %q to %q", previous.Fallback, current.Fallback)
+	}
+	if !reflect.DeepEqual(previous.Blocklist, current.Blocklist) {
+		utils.Logf("Blocklist changed from %d to %d domains", len(previous.Blocklist), len(current.Blocklist))
+	}
+	if previous.BlockResponse != current.BlockResponse {
+		utils.Logf("Blocklist response changed from %s to %s", previous.BlockResponse, current.BlockResponse)
+	}
+	if previous.Recursion != current.Recursion {
+		utils.Logf("Recursion mode changed from %s to %s", previous.Recursion, current.Recursion)
+	}
+	if previous.HostsFile != current.HostsFile {
+		utils.Logf("Hosts file changed from %q to %q", previous.HostsFile, current.HostsFile)
+	}
+	if previous.IdleTimeout != current.IdleTimeout {
+		utils.Logf("Idle timeout changed from %v to %v", previous.IdleTimeout, current.IdleTimeout)
+	}
+	if previous.Retries != current.Retries || previous.RetryBackoff != current.RetryBackoff {
+		utils.Logf("Upstream retries changed from %d (backoff %v) to %d (backoff %v)",
+			previous.Retries, previous.RetryBackoff, current.Retries, current.RetryBackoff)
+	}
+	if previous.CacheMinTTL != current.CacheMinTTL || previous.CacheMaxTTL != current.CacheMaxTTL {
+		utils.Logf("Cache TTL bounds changed from %v-%v to %v-%v",
+			previous.CacheMinTTL, previous.CacheMaxTTL, current.CacheMinTTL, current.CacheMaxTTL)
+	}
+	if previous.QNAMEMinimization != current.QNAMEMinimization {
+		utils.Logf("QNAME minimization changed from %v to %v", previous.QNAMEMinimization, current.QNAMEMinimization)
+	}
+	if previous.ServeStale != current.ServeStale {
+		utils.Logf("Serve-stale maximum age changed from %v to %v", previous.ServeStale, current.ServeStale)
+	}
+	if current := p.MaxInflight(); previousMaxInflight != current {
+		utils.Logf("Maximum of in-flight queries changed from %d to %d", previousMaxInflight, current)
+	}
+	return nil
+}
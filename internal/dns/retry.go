@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"time"
+)
+
+const (
+	// DefaultUpstreamRetries is how many times a query to an upstream that
+	// timed out is sent again by default
+	DefaultUpstreamRetries = 1
+	// DefaultRetryBackoff is the default wait before the first retry
+	DefaultRetryBackoff = 100 * time.Millisecond
+
+	// maxUpstreamRetries bounds the retries, each of which shortens the
+	// time every attempt may take
+	maxUpstreamRetries = 5
+	// maxRetryBackoff bounds the wait before the first retry
+	maxRetryBackoff = time.Second
+)
+
+// ValidateRetries checks the number of retries and the backoff before the
+// first of them
+func ValidateRetries(retries int, backoff time.Duration) error {
+	if retries < 0 || retries > maxUpstreamRetries {
+		return fmt.Errorf("invalid number of upstream retries: %d (must be 0 to %d)", retries, maxUpstreamRetries)
+	}
+	if backoff < 0 || backoff > maxRetryBackoff {
+		return fmt.Errorf("invalid upstream retry backoff: %v (must be 0 to %v)", backoff, maxRetryBackoff)
+	}
+	return nil
+}
+
+// SetRetries sets how many times a query to an upstream that timed out is
+// sent again, waiting backoff before the first retry and twice as long
+// before each further one. The attempts share the time the query may take.
+func (p *DNSProxy) SetRetries(retries int, backoff time.Duration) error {
+	if err := ValidateRetries(retries, backoff); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.retries = retries
+	p.retryBackoff = backoff
+	return nil
+}
+
+// Retries returns the number of retries of queries to an upstream that timed
+// out and the backoff before the first of them
+func (p *DNSProxy) Retries() (int, time.Duration) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.retries, p.retryBackoff
+}
+
+// retryDelay returns the wait before retry number n, counting from 1
+func retryDelay(backoff time.Duration, n int) time.Duration {
+	return backoff << (n - 1)
+}
+
+// attemptDeadline splits the time left until deadline evenly between attempt
+// number attempt, counting from 0, and the retries after it, leaving out
+// their backoff. ok is false when no time would be left for the retries.
+func attemptDeadline(deadline time.Time, attempt, retries int, backoff time.Duration) (end time.Time, ok bool) {
+	left := retries - attempt
+	if left <= 0 {
+		return deadline, true
+	}
+
+	remaining := time.Until(deadline)
+	for n := attempt + 1; n <= retries; n++ {
+		remaining -= retryDelay(backoff, n)
+	}
+	slice := remaining / time.Duration(left+1)
+	if slice <= 0 {
+		return deadline, false
+	}
+	return time.Now().Add(slice), true
+}
+
+// isTimeout reports whether err is a network timeout, as opposed to an
+// error response or a failure to send
+func isTimeout(err error) bool {
+	var netErr net.Error
+	return errors.As(err, &netErr) && netErr.Timeout()
+}
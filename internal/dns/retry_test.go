@@ -0,0 +1,91 @@
+package dns
+
+import (
+	"encoding/binary"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// startLossyUpstream answers every query except the first drop ones
+func startLossyUpstream(t *testing.T, drop int32) *testUpstream {
+	t.Helper()
+
+	return startUpstream(t, func(n int32, query []byte) []byte {
+		if n <= drop {
+			return nil
+		}
+		return echoResponse(query)
+	})
+}
+
+func TestValidateRetries(t *testing.T) {
+	if err := ValidateRetries(DefaultUpstreamRetries, DefaultRetryBackoff); err != nil {
+		t.Errorf("defaults rejected: %v", err)
+	}
+	for _, tt := range []struct {
+		retries int
+		backoff time.Duration
+	}{{-1, 0}, {maxUpstreamRetries + 1, 0}, {1, -time.Millisecond}, {1, 2 * maxRetryBackoff}} {
+		if err := ValidateRetries(tt.retries, tt.backoff); err == nil {
+			t.Errorf("ValidateRetries(%d, %v) succeeded", tt.retries, tt.backoff)
+		}
+	}
+}
+
+func TestRetryAfterTimeout(t *testing.T) {
+	upstream := startLossyUpstream(t, 1)
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetRetries(1, 10*time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	start := time.Now()
+	deadline := start.Add(400 * time.Millisecond)
+	response, err := p.queryUpstreamServer(upstream.addr, testQuery(t, 1), deadline, nil)
+	if err != nil {
+		t.Fatalf("query failed despite a retry: %v", err)
+	}
+	if id := binary.BigEndian.Uint16(response); id != 1 {
+		t.Errorf("response ID = %d, want 1", id)
+	}
+	if n := atomic.LoadInt32(&upstream.queries); n != 2 {
+		t.Errorf("upstream received %d queries, want 2", n)
+	}
+	if time.Now().After(deadline) {
+		t.Errorf("retry answered after the deadline, in %v", time.Since(start))
+	}
+
+	// Without retries the dropped query fails
+	upstream = startLossyUpstream(t, 1)
+	if err := p.SetRetries(0, 0); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := p.queryUpstreamServer(upstream.addr, testQuery(t, 2), time.Now().Add(100*time.Millisecond), nil); err == nil {
+		t.Error("dropped query answered without retries")
+	}
+}
+
+func TestRetryReleasesQuery(t *testing.T) {
+	upstream := startLossyUpstream(t, 1)
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetRetries(2, time.Millisecond); err != nil {
+		t.Fatal(err)
+	}
+
+	// The caller may reuse the query once sent is called, before the retry
+	query := testQuery(t, 3)
+	sent := make(chan struct{})
+	done := make(chan error, 1)
+	go func() {
+		_, err := p.queryUpstreamServer(upstream.addr, query, time.Now().Add(600*time.Millisecond), func() { close(sent) })
+		done <- err
+	}()
+	<-sent
+	for i := range query {
+		query[i] = 0
+	}
+	if err := <-done; err != nil {
+		t.Fatalf("retry of a released query failed: %v", err)
+	}
+}
@@ -0,0 +1,107 @@
+package dns
+
+import (
+	"encoding/binary"
+	"fmt"
+	"log"
+	"time"
+)
+
+// staleTTL is the TTL of records served from expired cache entries, as
+// RFC 8767 recommends
+const staleTTL = 30
+
+// ValidateServeStale checks how long after expiring cache entries may be
+// served; zero disables serving them
+func ValidateServeStale(maxAge time.Duration) error {
+	if maxAge < 0 {
+		return fmt.Errorf("invalid serve-stale maximum age: %v", maxAge)
+	}
+	return nil
+}
+
+// SetServeStale answers queries from cache entries that expired up to maxAge
+// ago when every upstream and the fallback resolver fail, refreshing them in
+// the background. Zero disables serving stale answers.
+func (p *DNSProxy) SetServeStale(maxAge time.Duration) error {
+	if err := ValidateServeStale(maxAge); err != nil {
+		return err
+	}
+	p.cache.setStaleAge(maxAge)
+	return nil
+}
+
+// ServeStale returns how long after expiring cache entries may be served;
+// zero when serving stale answers is disabled
+func (p *DNSProxy) ServeStale() time.Duration {
+	return p.cache.maxStaleAge()
+}
+
+// setStaleAge sets how long entries are kept after they expire
+func (c *dnsCache) setStaleAge(maxAge time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.staleAge = maxAge
+}
+
+// maxStaleAge returns how long entries are kept after they expire
+func (c *dnsCache) maxStaleAge() time.Duration {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.staleAge
+}
+
+// getStale returns a copy of the cached response for key with its ID set to
+// id if it expired no longer than the stale age ago, with every record TTL
+// set to staleTTL. Unexpired entries are left to get.
+func (c *dnsCache) getStale(key cacheKey, id uint16) ([]byte, bool) {
+	c.mu.RLock()
+	entry, ok := c.entries[key]
+	staleAge := c.staleAge
+	c.mu.RUnlock()
+
+	now := time.Now()
+	if !ok || staleAge == 0 || !now.After(entry.expires) || now.After(entry.expires.Add(staleAge)) {
+		return nil, false
+	}
+
+	response := make([]byte, len(entry.response))
+	copy(response, entry.response)
+	binary.BigEndian.PutUint16(response[0:2], id)
+	walkRecords(response, func(rrType uint16, ttlOffset int) {
+		if rrType != typeOPT {
+			binary.BigEndian.PutUint32(response[ttlOffset:ttlOffset+4], staleTTL)
+		}
+	})
+	return response, true
+}
+
+// refreshStale resolves query in the background after a stale answer was
+// served for key, caching the response if the upstreams answer. Only one
+// refresh per key runs at a time.
+func (p *DNSProxy) refreshStale(key cacheKey, upstreams []string, query []byte) {
+	p.mu.Lock()
+	if p.refreshing[key] {
+		p.mu.Unlock()
+		return
+	}
+	p.refreshing[key] = true
+	p.mu.Unlock()
+
+	query = append([]byte(nil), query...)
+	go func() {
+		defer func() {
+			p.mu.Lock()
+			delete(p.refreshing, key)
+			p.mu.Unlock()
+		}()
+
+		response, upstream, err := p.resolve(upstreams, query)
+		if err != nil {
+			return
+		}
+		p.stats.answered(upstream)
+		p.cache.set(key, response)
+		log.Printf("Refreshed stale cache entry for %s from %s", key.name, upstream)
+	}()
+}
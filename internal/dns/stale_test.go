@@ -0,0 +1,114 @@
+package dns
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// cacheExpired caches an answer for example.com A that expired age ago
+func cacheExpired(t *testing.T, p *DNSProxy, age time.Duration) cacheKey {
+	t.Helper()
+	query := testQuery(t, 1)
+	response, err := addressResponse(query, TypeA, []net.IP{net.IPv4(10, 0, 0, 1)}, 300)
+	if err != nil {
+		t.Fatal(err)
+	}
+	key := newCacheKey("example.com.", TypeA, query)
+	p.cache.set(key, response)
+	p.cache.entries[key].expires = time.Now().Add(-age)
+	return key
+}
+
+func TestServeStaleWhenUpstreamsDown(t *testing.T) {
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetUpstreams([]string{closedUpstream(t), closedUpstream(t)}); err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetServeStale(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	var stale atomic.Value
+	p.SetQueryLogger(queryLoggerFunc(func(e QueryEvent) { stale.Store(e.Stale) }))
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p.conn = conn
+
+	key := cacheExpired(t, p, 10*time.Minute)
+	response := exchange(t, p, testQuery(t, 2))
+	if rcode, _ := extractRcode(response); rcode != 0 {
+		t.Errorf("stale answer rcode = %s", rcodeString(rcode))
+	}
+	if got := answerValues(t, response); len(got) != 1 || got[0] != "10.0.0.1" {
+		t.Errorf("stale answer = %v, want the cached address", got)
+	}
+	if ttl, _ := minTTL(response); ttl != staleTTL {
+		t.Errorf("stale answer TTL = %d, want %d", ttl, staleTTL)
+	}
+	if v, _ := stale.Load().(bool); !v {
+		t.Error("stale answer not marked in the query log")
+	}
+
+	// The expired entry is kept until the maximum stale age has passed
+	p.cache.removeExpired()
+	if _, ok := p.cache.entries[key]; !ok {
+		t.Error("entry within the stale age was purged")
+	}
+	cacheExpired(t, p, 2*time.Hour)
+	if _, ok := p.cache.getStale(key, 1); ok {
+		t.Error("entry older than the stale age was served")
+	}
+	p.cache.removeExpired()
+	if _, ok := p.cache.entries[key]; ok {
+		t.Error("entry older than the stale age was kept")
+	}
+}
+
+func TestServeStaleDisabled(t *testing.T) {
+	p := newTestProxy(t, StrategyParallel)
+	key := cacheExpired(t, p, time.Minute)
+	if _, ok := p.cache.getStale(key, 1); ok {
+		t.Error("stale entry served with serve-stale disabled")
+	}
+	if err := p.SetServeStale(-time.Second); err == nil {
+		t.Error("negative stale age accepted")
+	}
+}
+
+func TestRefreshStale(t *testing.T) {
+	upstream := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetServeStale(time.Hour); err != nil {
+		t.Fatal(err)
+	}
+	key := cacheExpired(t, p, time.Minute)
+
+	// No second refresh of an entry is started while one runs
+	p.refreshing[key] = true
+	p.refreshStale(key, []string{upstream.addr}, compressedResponse(t))
+	time.Sleep(50 * time.Millisecond)
+	if n := atomic.LoadInt32(&upstream.queries); n != 0 {
+		t.Fatalf("upstream got %d queries during a running refresh", n)
+	}
+	delete(p.refreshing, key)
+
+	p.refreshStale(key, []string{upstream.addr}, compressedResponse(t))
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		if _, ok := p.cache.get(key, 1); ok {
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatal("stale entry not refreshed")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if n := atomic.LoadInt32(&upstream.queries); n != 1 {
+		t.Errorf("upstream got %d refresh queries, want 1", n)
+	}
+}
@@ -0,0 +1,100 @@
+package dns
+
+import (
+	"sync"
+	"time"
+)
+
+// statsLatencyWeight is the weight of the newest sample in the rolling
+// average latency of an upstream
+const statsLatencyWeight = 0.2
+
+// UpstreamStats reports the queries forwarded to an upstream server since
+// the proxy started
+type UpstreamStats struct {
+	Upstream     string  `json:"upstream"`
+	Queries      uint64  `json:"queries"`
+	Responses    uint64  `json:"responses"`
+	Errors       uint64  `json:"errors"`
+	Answered     uint64  `json:"answered"`
+	AvgLatencyMs float64 `json:"avg_latency_ms"`
+}
+
+// statsTracker records per-upstream query statistics
+type statsTracker struct {
+	mu    sync.Mutex
+	stats map[string]*UpstreamStats
+}
+
+func newStatsTracker() *statsTracker {
+	return &statsTracker{stats: make(map[string]*UpstreamStats)}
+}
+
+// get returns the statistics of an upstream, creating them if needed. The
+// caller must hold t.mu.
+func (t *statsTracker) get(upstream string) *UpstreamStats {
+	s, ok := t.stats[upstream]
+	if !ok {
+		s = &UpstreamStats{Upstream: upstream}
+		t.stats[upstream] = s
+	}
+	return s
+}
+
+// sent records a query forwarded to an upstream
+func (t *statsTracker) sent(upstream string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.get(upstream).Queries++
+}
+
+// responded records a response from an upstream and its latency
+func (t *statsTracker) responded(upstream string, latency time.Duration) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	s := t.get(upstream)
+	ms := float64(latency.Microseconds()) / 1000
+	if s.Responses == 0 {
+		s.AvgLatencyMs = ms
+	} else {
+		s.AvgLatencyMs += (ms - s.AvgLatencyMs) * statsLatencyWeight
+	}
+	s.Responses++
+}
+
+// failed records a query to an upstream that got no response
+func (t *statsTracker) failed(upstream string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.get(upstream).Errors++
+}
+
+// answered records that the response of an upstream was sent to the client.
+// With the parallel strategy this is the upstream that answered first.
+func (t *statsTracker) answered(upstream string) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.get(upstream).Answered++
+}
+
+// snapshot returns the statistics of the given upstreams in order
+func (t *statsTracker) snapshot(upstreams []string) []UpstreamStats {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	result := make([]UpstreamStats, 0, len(upstreams))
+	for _, upstream := range upstreams {
+		if s, ok := t.stats[upstream]; ok {
+			result = append(result, *s)
+		} else {
+			result = append(result, UpstreamStats{Upstream: upstream})
+		}
+	}
+	return result
+}
+
+// UpstreamStats returns the query statistics of each configured upstream
+func (p *DNSProxy) UpstreamStats() []UpstreamStats {
+	return p.stats.snapshot(p.Upstreams())
+}
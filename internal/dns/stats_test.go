@@ -0,0 +1,110 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+// startDelayedUpstream starts a test upstream that answers every query after
+// delay
+func startDelayedUpstream(t *testing.T, delay time.Duration) string {
+	t.Helper()
+
+	return startUpstream(t, func(n int32, query []byte) []byte {
+		time.Sleep(delay)
+		return echoResponse(query)
+	}).addr
+}
+
+// statsFor returns the statistics of upstream from p
+func statsFor(t *testing.T, p *DNSProxy, upstream string) UpstreamStats {
+	t.Helper()
+
+	for _, s := range p.UpstreamStats() {
+		if s.Upstream == upstream {
+			return s
+		}
+	}
+	t.Fatalf("no statistics for %s", upstream)
+	return UpstreamStats{}
+}
+
+func TestUpstreamStatsParallel(t *testing.T) {
+	fast := startDelayedUpstream(t, 0)
+	slow := startDelayedUpstream(t, 100*time.Millisecond)
+	p := newTestProxy(t, StrategyParallel)
+	if err := p.SetUpstreams([]string{fast, slow}); err != nil {
+		t.Fatal(err)
+	}
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	p.conn = conn
+
+	const queries = 5
+	for i := 0; i < queries; i++ {
+		p.ClearCache()
+		exchange(t, p, testQuery(t, uint16(i)))
+	}
+
+	// The slow upstream's responses arrive after the client was answered
+	deadline := time.Now().Add(2 * time.Second)
+	for statsFor(t, p, slow).Responses < queries && time.Now().Before(deadline) {
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	f, s := statsFor(t, p, fast), statsFor(t, p, slow)
+	if f.Queries != queries || s.Queries != queries {
+		t.Errorf("queries = %d fast, %d slow, want %d each", f.Queries, s.Queries, queries)
+	}
+	if f.Responses != queries || s.Responses != queries {
+		t.Errorf("responses = %d fast, %d slow, want %d each", f.Responses, s.Responses, queries)
+	}
+	if f.Answered != queries || s.Answered != 0 {
+		t.Errorf("answered = %d fast, %d slow, want %d and 0", f.Answered, s.Answered, queries)
+	}
+	if s.AvgLatencyMs < 100 || f.AvgLatencyMs >= s.AvgLatencyMs {
+		t.Errorf("average latency = %.1fms fast, %.1fms slow", f.AvgLatencyMs, s.AvgLatencyMs)
+	}
+}
+
+func TestUpstreamStatsCountsErrors(t *testing.T) {
+	closed := closedUpstream(t)
+	good := startDelayedUpstream(t, 0)
+	p := newTestProxy(t, StrategyPriority)
+	if err := p.SetUpstreams([]string{closed, good}); err != nil {
+		t.Fatal(err)
+	}
+
+	for i := 0; i < 3; i++ {
+		if _, _, err := p.resolve(p.Upstreams(), testQuery(t, uint16(i))); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	c := statsFor(t, p, closed)
+	if c.Queries != 3 || c.Errors != 3 || c.Responses != 0 {
+		t.Errorf("closed upstream stats = %+v, want 3 queries and 3 errors", c)
+	}
+	if g := statsFor(t, p, good); g.Queries != 3 || g.Responses != 3 || g.Errors != 0 {
+		t.Errorf("good upstream stats = %+v, want 3 queries and 3 responses", g)
+	}
+}
+
+func TestStatsTrackerRollingLatency(t *testing.T) {
+	tracker := newStatsTracker()
+	tracker.responded("a", 10*time.Millisecond)
+	tracker.responded("a", 20*time.Millisecond)
+
+	s := tracker.snapshot([]string{"a", "b"})
+	if want := 10 + (20-10)*statsLatencyWeight; s[0].AvgLatencyMs != want {
+		t.Errorf("average latency = %v, want %v", s[0].AvgLatencyMs, want)
+	}
+	if s[1] != (UpstreamStats{Upstream: "b"}) {
+		t.Errorf("unused upstream stats = %+v, want zero", s[1])
+	}
+}
@@ -0,0 +1,132 @@
+package dns
+
+import (
+	"fmt"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+// Upstream selection strategies
+const (
+	// StrategyParallel queries all upstreams at once and uses the first answer
+	StrategyParallel = "parallel"
+	// StrategyRoundRobin rotates the first upstream tried between queries
+	StrategyRoundRobin = "round-robin"
+	// StrategyPriority tries upstreams in the configured order
+	StrategyPriority = "priority"
+)
+
+const (
+	// queryTimeout bounds the total time spent resolving a query upstream
+	queryTimeout = 5 * time.Second
+	// attemptTimeout bounds a single upstream attempt in sequential strategies
+	attemptTimeout = 2 * time.Second
+)
+
+// ValidateStrategy checks that s names a supported upstream selection strategy
+func ValidateStrategy(s string) error {
+	switch s {
+	case StrategyParallel, StrategyRoundRobin, StrategyPriority:
+		return nil
+	default:
+		return fmt.Errorf("unsupported upstream strategy: %s (must be %s, %s or %s)",
+			s, StrategyParallel, StrategyRoundRobin, StrategyPriority)
+	}
+}
+
+// SetStrategy sets the upstream selection strategy
+func (p *DNSProxy) SetStrategy(strategy string) error {
+	if err := ValidateStrategy(strategy); err != nil {
+		return err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.strategy = strategy
+	return nil
+}
+
+// Strategy returns the upstream selection strategy in use
+func (p *DNSProxy) Strategy() string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	return p.strategy
+}
+
+// resolve forwards a query to the upstreams according to the selection
+// strategy and returns the response along with the upstream that answered
+func (p *DNSProxy) resolve(upstreams []string, query []byte) ([]byte, string, error) {
+	deadline := time.Now().Add(queryTimeout)
+	upstreams = p.healthyUpstreams(upstreams)
+
+	switch p.Strategy() {
+	case StrategyParallel:
+		return p.resolveParallel(upstreams, query, deadline)
+	case StrategyRoundRobin:
+		start := int((atomic.AddUint64(&p.rrCounter, 1) - 1) % uint64(len(upstreams)))
+		rotated := append(append([]string(nil), upstreams[start:]...), upstreams[:start]...)
+		return p.resolveSequential(rotated, query, deadline)
+	default:
+		return p.resolveSequential(upstreams, query, deadline)
+	}
+}
+
+// resolveSequential tries each upstream in order until one answers
+func (p *DNSProxy) resolveSequential(upstreams []string, query []byte, deadline time.Time) ([]byte, string, error) {
+	var lastErr error
+	for _, upstream := range upstreams {
+		if !time.Now().Before(deadline) {
+			break
+		}
+
+		attemptDeadline := time.Now().Add(attemptTimeout)
+		if attemptDeadline.After(deadline) {
+			attemptDeadline = deadline
+		}
+
+		response, err := p.queryUpstreamServer(upstream, query, attemptDeadline, nil)
+		if err == nil {
+			return response, upstream, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", upstream, err)
+	}
+
+	if lastErr == nil {
+		lastErr = fmt.Errorf("query timed out")
+	}
+	return nil, "", lastErr
+}
+
+// resolveParallel queries all upstreams concurrently and returns the first
+// answer once every upstream has been sent the query, as query may be
+// returned to bufferPool as soon as this function returns
+func (p *DNSProxy) resolveParallel(upstreams []string, query []byte, deadline time.Time) ([]byte, string, error) {
+	type result struct {
+		response []byte
+		upstream string
+		err      error
+	}
+
+	var sent sync.WaitGroup
+	defer sent.Wait()
+
+	results := make(chan result, len(upstreams))
+	for _, upstream := range upstreams {
+		sent.Add(1)
+		go func(upstream string) {
+			response, err := p.queryUpstreamServer(upstream, query, deadline, sent.Done)
+			results <- result{response: response, upstream: upstream, err: err}
+		}(upstream)
+	}
+
+	var lastErr error
+	for range upstreams {
+		r := <-results
+		if r.err == nil {
+			return r.response, r.upstream, nil
+		}
+		lastErr = fmt.Errorf("%s: %w", r.upstream, r.err)
+	}
+	return nil, "", lastErr
+}
@@ -0,0 +1,221 @@
+package dns
+
+import (
+	"net"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// testUpstream is a local UDP DNS server started by startUpstream
+type testUpstream struct {
+	addr    string
+	queries int32
+}
+
+// startUpstream starts a test upstream that counts the queries it receives
+// and answers each with the response handle returns for it, or not at all
+// when that is nil. n counts the queries from 1. handle runs in a goroutine
+// of its own for each query, so it may take its time.
+func startUpstream(t testing.TB, handle func(n int32, query []byte) []byte) *testUpstream {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { conn.Close() })
+
+	u := &testUpstream{addr: conn.LocalAddr().String()}
+	go func() {
+		buf := make([]byte, 512)
+		for {
+			size, addr, err := conn.ReadFromUDP(buf)
+			if err != nil {
+				return
+			}
+			n := atomic.AddInt32(&u.queries, 1)
+			query := append([]byte(nil), buf[:size]...)
+			go func() {
+				if response := handle(n, query); response != nil {
+					conn.WriteToUDP(response, addr)
+				}
+			}()
+		}
+	}()
+	return u
+}
+
+// echoResponse returns query as a response without records
+func echoResponse(query []byte) []byte {
+	response := append([]byte(nil), query...)
+	response[2] |= 0x80 // QR
+	return response
+}
+
+// startTestUpstream starts a test upstream that either echoes queries back
+// as responses or silently drops them
+func startTestUpstream(t testing.TB, answer bool) *testUpstream {
+	t.Helper()
+
+	return startUpstream(t, func(n int32, query []byte) []byte {
+		if !answer {
+			return nil
+		}
+		return echoResponse(query)
+	})
+}
+
+// closedUpstream returns the address of a UDP port with no listener
+func closedUpstream(t *testing.T) string {
+	t.Helper()
+
+	conn, err := net.ListenUDP("udp", &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := conn.LocalAddr().String()
+	conn.Close()
+	return addr
+}
+
+func testQuery(t testing.TB, id uint16) []byte {
+	t.Helper()
+
+	query, err := BuildQuery(id, "example.com", TypeA)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return query
+}
+
+func newTestProxy(t testing.TB, strategy string) *DNSProxy {
+	t.Helper()
+
+	p, err := NewDNSProxy("127.0.0.1", 0, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := p.SetStrategy(strategy); err != nil {
+		t.Fatal(err)
+	}
+	return p
+}
+
+func TestDefaultStrategyIsParallel(t *testing.T) {
+	p, _ := NewDNSProxy("127.0.0.1", 0, nil)
+	if p.Strategy() != StrategyParallel {
+		t.Errorf("default strategy = %s, want %s", p.Strategy(), StrategyParallel)
+	}
+}
+
+func TestValidateStrategy(t *testing.T) {
+	for _, s := range []string{StrategyParallel, StrategyRoundRobin, StrategyPriority} {
+		if err := ValidateStrategy(s); err != nil {
+			t.Errorf("ValidateStrategy(%q) = %v", s, err)
+		}
+	}
+	if err := ValidateStrategy("random"); err == nil {
+		t.Error("expected error for unknown strategy")
+	}
+}
+
+func TestPriorityFallsThroughOnFailure(t *testing.T) {
+	good := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyPriority)
+
+	_, upstream, err := p.resolve([]string{closedUpstream(t), good.addr}, testQuery(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upstream != good.addr {
+		t.Errorf("answered by %s, want %s", upstream, good.addr)
+	}
+}
+
+func TestPriorityFallsThroughOnTimeout(t *testing.T) {
+	silent := startTestUpstream(t, false)
+	good := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyPriority)
+
+	start := time.Now()
+	_, upstream, err := p.resolve([]string{silent.addr, good.addr}, testQuery(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upstream != good.addr {
+		t.Errorf("answered by %s, want %s", upstream, good.addr)
+	}
+	if elapsed := time.Since(start); elapsed < attemptTimeout {
+		t.Errorf("second upstream tried after %v, before the %v attempt timeout", elapsed, attemptTimeout)
+	}
+	// The timed out query is retried within the attempt timeout
+	if n := atomic.LoadInt32(&silent.queries); n != 1+DefaultUpstreamRetries {
+		t.Errorf("first upstream received %d queries, want %d", n, 1+DefaultUpstreamRetries)
+	}
+}
+
+func TestPriorityPrefersFirstUpstream(t *testing.T) {
+	first := startTestUpstream(t, true)
+	second := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyPriority)
+
+	for i := 0; i < 3; i++ {
+		if _, upstream, err := p.resolve([]string{first.addr, second.addr}, testQuery(t, uint16(i))); err != nil || upstream != first.addr {
+			t.Fatalf("query %d answered by %s (%v), want %s", i, upstream, err, first.addr)
+		}
+	}
+	if atomic.LoadInt32(&second.queries) != 0 {
+		t.Errorf("second upstream received %d queries, want 0", second.queries)
+	}
+}
+
+func TestRoundRobinRotates(t *testing.T) {
+	a := startTestUpstream(t, true)
+	b := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyRoundRobin)
+
+	want := []string{a.addr, b.addr, a.addr, b.addr}
+	for i, w := range want {
+		_, upstream, err := p.resolve([]string{a.addr, b.addr}, testQuery(t, uint16(i)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if upstream != w {
+			t.Errorf("query %d answered by %s, want %s", i, upstream, w)
+		}
+	}
+}
+
+func TestParallelUsesFirstAnswer(t *testing.T) {
+	silent := startTestUpstream(t, false)
+	good := startTestUpstream(t, true)
+	p := newTestProxy(t, StrategyParallel)
+
+	start := time.Now()
+	_, upstream, err := p.resolve([]string{silent.addr, good.addr}, testQuery(t, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if upstream != good.addr {
+		t.Errorf("answered by %s, want %s", upstream, good.addr)
+	}
+	if elapsed := time.Since(start); elapsed >= attemptTimeout {
+		t.Errorf("parallel answer took %v, should not wait for the silent upstream", elapsed)
+	}
+
+	// Both upstreams are queried at once
+	time.Sleep(50 * time.Millisecond)
+	if atomic.LoadInt32(&silent.queries) != 1 {
+		t.Errorf("silent upstream received %d queries, want 1", silent.queries)
+	}
+}
+
+func TestAllUpstreamsFail(t *testing.T) {
+	for _, strategy := range []string{StrategyParallel, StrategyRoundRobin, StrategyPriority} {
+		p := newTestProxy(t, strategy)
+		if _, _, err := p.resolve([]string{closedUpstream(t), closedUpstream(t)}, testQuery(t, 1)); err == nil {
+			t.Errorf("%s: expected error when all upstreams fail", strategy)
+		}
+	}
+}
@@ -1,96 +1,378 @@
 package dns
 
 import (
+	"encoding/json"
 	"fmt"
 	"log"
+	"net"
+	"os"
 	"os/exec"
+	"path/filepath"
 	"runtime"
+	"strings"
 
 	"github.com/ourines/GateShift/internal/gateway"
+	"github.com/ourines/GateShift/internal/utils"
 )
 
-// ConfigureSystemDNS configures the system to use the DNS proxy
-func ConfigureSystemDNS(proxyIP string) error {
+// ConfigureSystemDNS configures the system to use servers, in order of
+// preference; the DNS proxy passes its listen address alone. On macOS,
+// allServices points every enabled network service at the servers instead
+// of only the active one, so DNS keeps going through them when another
+// interface becomes active; it is ignored on other systems.
+func ConfigureSystemDNS(servers []string, allServices bool) error {
+	if len(servers) == 0 {
+		return fmt.Errorf("no DNS servers to configure")
+	}
+
 	switch runtime.GOOS {
 	case "darwin":
-		return configureDarwinDNS(proxyIP)
+		return configureDarwinDNS(servers, allServices)
 	case "windows":
-		return configureWindowsDNS(proxyIP)
+		return configureWindowsDNS(servers)
 	case "linux":
-		return configureLinuxDNS(proxyIP)
+		return configureLinuxDNS(servers)
 	default:
 		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
 	}
 }
 
-// RestoreSystemDNS restores the system's original DNS settings
-func RestoreSystemDNS() error {
+// RestoreSystemDNS restores the system's original DNS settings. servers are
+// the ones ConfigureSystemDNS pointed the system at; without a backup the
+// settings are only reset when they still point there.
+func RestoreSystemDNS(servers []string) error {
 	switch runtime.GOOS {
 	case "darwin":
-		return restoreDarwinDNS()
+		return restoreDarwinDNS(servers)
 	case "windows":
 		return restoreWindowsDNS()
 	case "linux":
-		return restoreLinuxDNS()
+		return restoreLinuxDNS(servers)
 	default:
 		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
 	}
 }
 
+// sameServers reports whether a and b list the same servers in the same order
+func sameServers(a, b []string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
+
+// Mock command executor for testing
+var execCommand = exec.Command
+
+// runSystemCommand runs a command that changes system settings. In dry-run
+// mode the command is only printed.
+func runSystemCommand(name string, args ...string) ([]byte, error) {
+	if utils.DryRun() {
+		utils.PrintDryRun(name, args...)
+		return nil, nil
+	}
+	return execCommand(name, args...).CombinedOutput()
+}
+
+// writeSystemFile replaces the contents of a system file. In dry-run mode
+// the change is only printed.
+func writeSystemFile(path string, data []byte) error {
+	if utils.DryRun() {
+		fmt.Printf("[dry-run] write %s:\n%s", path, data)
+		return nil
+	}
+	return os.WriteFile(path, data, 0644)
+}
+
+// removeSystemFile removes a file. In dry-run mode the removal is only printed.
+func removeSystemFile(path string) error {
+	if utils.DryRun() {
+		utils.PrintDryRun("rm", path)
+		return nil
+	}
+	return os.Remove(path)
+}
+
+// saveBackup writes a backup of original system settings to the config
+// directory, owned by the directory owner so the user can inspect it
+func saveBackup(path string, data []byte) error {
+	if utils.DryRun() {
+		fmt.Printf("[dry-run] back up original settings to %s\n", path)
+		return nil
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	if err := utils.ChownLike(path, filepath.Dir(path)); err != nil {
+		log.Printf("Warning: could not change backup ownership: %v", err)
+	}
+	return nil
+}
+
 // macOS specific functions
-func configureDarwinDNS(dnsServer string) error {
-	iface, err := gateway.GetActiveInterface()
+
+// darwinDNSBackup records the DNS servers and search domains of a macOS
+// network service before they were changed. Empty lists mean none were set
+// manually, i.e. the service used the ones from DHCP. The backup file holds
+// one entry per service that was changed.
+type darwinDNSBackup struct {
+	Service       string   `json:"service"`
+	Servers       []string `json:"servers"`
+	SearchDomains []string `json:"search_domains"`
+}
+
+// darwinDNSBackupPath returns where the original macOS DNS settings are saved
+func darwinDNSBackupPath() string {
+	return filepath.Join(utils.ConfigDir(), "macos-dns.json")
+}
+
+// networksetupList runs a networksetup -get* command and returns the values
+// it lists, one per line. "There aren't any ... set" means an empty list.
+func networksetupList(args ...string) ([]string, error) {
+	output, err := execCommand("networksetup", args...).CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("failed to get active interface: %w", err)
+		return nil, fmt.Errorf("networksetup %s failed: %w, output: %s", strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return parseNetworksetupList(string(output))
+}
+
+// parseNetworksetupList parses the output of networksetup -getdnsservers or
+// -getsearchdomains
+func parseNetworksetupList(output string) ([]string, error) {
+	output = strings.TrimSpace(output)
+	if strings.HasPrefix(output, "There aren't any") {
+		return nil, nil
+	}
+	// networksetup reports errors such as an unknown service on stdout
+	if strings.HasPrefix(output, "**") || strings.Contains(output, "is not a recognized network service") {
+		return nil, fmt.Errorf("networksetup: %s", output)
 	}
 
-	// 注意: macOS的networksetup命令使用标准53端口
-	cmd := exec.Command("networksetup", "-setdnsservers", iface.ServiceName, dnsServer)
-	output, err := cmd.CombinedOutput()
+	var values []string
+	for _, line := range strings.Split(output, "\n") {
+		if line = strings.TrimSpace(line); line != "" {
+			values = append(values, line)
+		}
+	}
+	return values, nil
+}
+
+// darwinNetworkServices lists the enabled network services. networksetup
+// prints an explanatory first line and marks disabled services with "*".
+func darwinNetworkServices() ([]string, error) {
+	output, err := execCommand("networksetup", "-listallnetworkservices").CombinedOutput()
 	if err != nil {
-		return fmt.Errorf("failed to set DNS servers: %w, output: %s", err, string(output))
+		return nil, fmt.Errorf("failed to list network services: %w, output: %s", err, strings.TrimSpace(string(output)))
 	}
+	return parseNetworkServices(string(output)), nil
+}
 
-	log.Printf("DNS服务器IP已设置为 %s 在网络接口 %s", dnsServer, iface.ServiceName)
-	log.Printf("DNS已配置为使用 %s 在网络接口 %s", dnsServer, iface.ServiceName)
+// parseNetworkServices parses the output of networksetup -listallnetworkservices
+func parseNetworkServices(output string) []string {
+	var services []string
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "*") || strings.HasPrefix(line, "An asterisk") {
+			continue
+		}
+		services = append(services, line)
+	}
+	return services
+}
 
-	return nil
+// networksetupArgs returns values as networksetup -set* arguments, where
+// "empty" clears the manual setting
+func networksetupArgs(values []string) []string {
+	if len(values) == 0 {
+		return []string{"empty"}
+	}
+	return values
 }
 
-func restoreDarwinDNS() error {
-	iface, err := gateway.GetActiveInterface()
+// backupDarwinDNS saves the DNS servers and search domains of services for
+// restoreDarwinDNS, unless an earlier backup has not been restored yet
+func backupDarwinDNS(services []string, dnsServers []string) error {
+	backupPath := darwinDNSBackupPath()
+	if _, err := os.Stat(backupPath); err == nil {
+		return nil
+	}
+
+	backups := make([]darwinDNSBackup, 0, len(services))
+	for _, service := range services {
+		servers, err := networksetupList("-getdnsservers", service)
+		if err != nil {
+			return err
+		}
+		domains, err := networksetupList("-getsearchdomains", service)
+		if err != nil {
+			return err
+		}
+		// Left over from a run that did not restore its settings
+		if sameServers(servers, dnsServers) {
+			servers = nil
+		}
+		backups = append(backups, darwinDNSBackup{Service: service, Servers: servers, SearchDomains: domains})
+	}
+
+	data, err := json.Marshal(backups)
 	if err != nil {
-		return fmt.Errorf("failed to get active interface: %w", err)
+		return err
+	}
+	if err := saveBackup(backupPath, data); err != nil {
+		return fmt.Errorf("failed to back up DNS settings: %w", err)
 	}
+	return nil
+}
 
-	// Restore DHCP DNS or use empty string to clear custom DNS
-	cmd := exec.Command("networksetup", "-setdnsservers", iface.ServiceName, "empty")
-	output, err := cmd.CombinedOutput()
+func configureDarwinDNS(dnsServers []string, allServices bool) error {
+	var services []string
+	if allServices {
+		var err error
+		if services, err = darwinNetworkServices(); err != nil {
+			return err
+		}
+		if len(services) == 0 {
+			return fmt.Errorf("no enabled network services found")
+		}
+	} else {
+		iface, err := gateway.GetActiveInterface()
+		if err != nil {
+			return fmt.Errorf("failed to get active interface: %w", err)
+		}
+		services = []string{iface.ServiceName}
+	}
+
+	if err := backupDarwinDNS(services, dnsServers); err != nil {
+		return err
+	}
+
+	// 注意: macOS的networksetup命令使用标准53端口
+	for _, service := range services {
+		args := append([]string{"-setdnsservers", service}, dnsServers...)
+		output, err := runSystemCommand("networksetup", args...)
+		if err != nil {
+			return fmt.Errorf("failed to set DNS servers of %s: %w, output: %s", service, err, string(output))
+		}
+		utils.Logf("DNS已配置为使用 %s 在网络服务 %s", strings.Join(dnsServers, ", "), service)
+	}
+
+	return nil
+}
+
+// restoreDarwinDNS restores the DNS servers and search domains saved by
+// configureDarwinDNS. Without a backup the servers of the active service are
+// cleared only if they still are dnsServers.
+func restoreDarwinDNS(dnsServers []string) error {
+	backupPath := darwinDNSBackupPath()
+	data, err := os.ReadFile(backupPath)
+	if os.IsNotExist(err) {
+		return resetDarwinDNS(dnsServers)
+	}
 	if err != nil {
+		return fmt.Errorf("failed to read DNS backup: %w", err)
+	}
+
+	var backups []darwinDNSBackup
+	if err := json.Unmarshal(data, &backups); err != nil {
+		return fmt.Errorf("invalid DNS backup: %w", err)
+	}
+
+	// Restore every service before reporting failures, and keep the backup
+	// if any of them failed so the restore can be retried
+	var failed []string
+	for _, backup := range backups {
+		if err := restoreDarwinService(backup); err != nil {
+			log.Printf("Failed to restore DNS settings of %s: %v", backup.Service, err)
+			failed = append(failed, backup.Service)
+			continue
+		}
+		utils.Logf("DNS servers and search domains restored on %s", backup.Service)
+	}
+	if len(failed) > 0 {
+		return fmt.Errorf("failed to restore DNS settings of %s", strings.Join(failed, ", "))
+	}
+
+	if err := removeSystemFile(backupPath); err != nil {
+		log.Printf("Warning: could not remove DNS backup: %v", err)
+	}
+	return nil
+}
+
+// restoreDarwinService sets the DNS servers and search domains of a network
+// service back to the values in backup
+func restoreDarwinService(backup darwinDNSBackup) error {
+	args := append([]string{"-setdnsservers", backup.Service}, networksetupArgs(backup.Servers)...)
+	if output, err := runSystemCommand("networksetup", args...); err != nil {
 		return fmt.Errorf("failed to restore DNS servers: %w, output: %s", err, string(output))
 	}
+	args = append([]string{"-setsearchdomains", backup.Service}, networksetupArgs(backup.SearchDomains)...)
+	if output, err := runSystemCommand("networksetup", args...); err != nil {
+		return fmt.Errorf("failed to restore search domains: %w, output: %s", err, string(output))
+	}
+	return nil
+}
+
+// resetDarwinDNS clears the DNS servers of every enabled service that points
+// at dnsServers, so settings the user changed since are left alone
+func resetDarwinDNS(dnsServers []string) error {
+	if len(dnsServers) == 0 {
+		return nil
+	}
+	services, err := darwinNetworkServices()
+	if err != nil {
+		return err
+	}
+
+	for _, service := range services {
+		servers, err := networksetupList("-getdnsservers", service)
+		if err != nil {
+			return err
+		}
+		if !sameServers(servers, dnsServers) {
+			continue
+		}
 
-	log.Printf("DNS settings restored to default on %s", iface.ServiceName)
+		output, err := runSystemCommand("networksetup", "-setdnsservers", service, "empty")
+		if err != nil {
+			return fmt.Errorf("failed to restore DNS servers of %s: %w, output: %s", service, err, string(output))
+		}
+		utils.Logf("DNS settings restored to default on %s", service)
+	}
 	return nil
 }
 
 // Windows specific functions
-func configureWindowsDNS(dnsServer string) error {
+func configureWindowsDNS(dnsServers []string) error {
 	// Get the name of the active interface
 	iface, err := gateway.GetActiveInterface()
 	if err != nil {
 		return fmt.Errorf("failed to get active interface: %w", err)
 	}
+	name := fmt.Sprintf("name=\"%s\"", iface.Name)
 
-	// 注意: Windows的netsh命令使用标准53端口
-	cmd := exec.Command("netsh", "interface", "ip", "set", "dns", fmt.Sprintf("name=\"%s\"", iface.Name), "static", dnsServer)
-	output, err := cmd.CombinedOutput()
+	// 注意: Windows的netsh命令使用标准53端口；首选服务器替换原有列表，其余依次添加
+	output, err := runSystemCommand("netsh", "interface", "ip", "set", "dns", name, "static", dnsServers[0])
 	if err != nil {
 		return fmt.Errorf("failed to set DNS servers: %w, output: %s", err, string(output))
 	}
+	for i, server := range dnsServers[1:] {
+		output, err := runSystemCommand("netsh", "interface", "ip", "add", "dns", name, server, fmt.Sprintf("index=%d", i+2))
+		if err != nil {
+			return fmt.Errorf("failed to add DNS server %s: %w, output: %s", server, err, string(output))
+		}
+	}
 
-	log.Printf("DNS服务器IP已设置为 %s 在网络接口 %s", dnsServer, iface.Name)
-	log.Printf("DNS已配置为使用 %s 在网络接口 %s", dnsServer, iface.Name)
+	servers := strings.Join(dnsServers, ", ")
+	utils.Logf("DNS服务器IP已设置为 %s 在网络接口 %s", servers, iface.Name)
+	utils.Logf("DNS已配置为使用 %s 在网络接口 %s", servers, iface.Name)
 
 	return nil
 }
@@ -103,40 +385,424 @@ func restoreWindowsDNS() error {
 	}
 
 	// Use netsh to set DNS servers back to DHCP
-	cmd := exec.Command("netsh", "interface", "ip", "set", "dns", fmt.Sprintf("name=\"%s\"", iface.Name), "dhcp")
-	output, err := cmd.CombinedOutput()
+	output, err := runSystemCommand("netsh", "interface", "ip", "set", "dns", fmt.Sprintf("name=\"%s\"", iface.Name), "dhcp")
 	if err != nil {
 		return fmt.Errorf("failed to restore DNS servers: %w, output: %s", err, string(output))
 	}
 
-	log.Printf("DNS settings restored to DHCP on %s", iface.Name)
+	utils.Logf("DNS settings restored to DHCP on %s", iface.Name)
 	return nil
 }
 
 // Linux specific functions
-func configureLinuxDNS(dnsServer string) error {
-	// 注意: Linux的resolv.conf使用标准53端口
-	cmd := exec.Command("sh", "-c", fmt.Sprintf("echo 'nameserver %s' > /etc/resolv.conf", dnsServer))
-	output, err := cmd.CombinedOutput()
+
+const resolvConfPath = "/etc/resolv.conf"
+
+// resolvConfBackupPath returns where the original resolv.conf is saved
+func resolvConfBackupPath() string {
+	return filepath.Join(utils.ConfigDir(), "resolv.conf.bak")
+}
+
+// checkResolvConfSymlink returns an error if resolv.conf is a symlink, which
+// usually means it is managed by systemd-resolved or another resolver manager
+func checkResolvConfSymlink() error {
+	info, err := os.Lstat(resolvConfPath)
 	if err != nil {
-		return fmt.Errorf("failed to set DNS servers: %w, output: %s", err, string(output))
+		return nil
+	}
+	if info.Mode()&os.ModeSymlink == 0 {
+		return nil
+	}
+
+	target, _ := os.Readlink(resolvConfPath)
+	return fmt.Errorf("%s is a symlink to %s (likely managed by systemd-resolved), not modifying it; configure the resolver manager to use the DNS proxy instead", resolvConfPath, target)
+}
+
+// networkManagerDNSBackup records the DNS settings of a NetworkManager
+// connection before they were changed
+type networkManagerDNSBackup struct {
+	Connection    string `json:"connection"`
+	DNS           string `json:"dns"`
+	IgnoreAutoDNS string `json:"ignore_auto_dns"`
+}
+
+// networkManagerBackupPath returns where the original NetworkManager DNS settings are saved
+func networkManagerBackupPath() string {
+	return filepath.Join(utils.ConfigDir(), "nm-dns.json")
+}
+
+// configureNetworkManagerDNS points a NetworkManager connection at dnsServers
+// and reactivates it, saving the previous settings for restoreNetworkManagerDNS
+func configureNetworkManagerDNS(conn string, dnsServers []string) error {
+	backupPath := networkManagerBackupPath()
+	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+		dnsServers, err := gateway.NetworkManagerSetting(conn, "ipv4.dns")
+		if err != nil {
+			return err
+		}
+		ignoreAuto, err := gateway.NetworkManagerSetting(conn, "ipv4.ignore-auto-dns")
+		if err != nil {
+			return err
+		}
+
+		data, err := json.Marshal(networkManagerDNSBackup{Connection: conn, DNS: dnsServers, IgnoreAutoDNS: ignoreAuto})
+		if err != nil {
+			return err
+		}
+		if err := saveBackup(backupPath, data); err != nil {
+			return fmt.Errorf("failed to back up NetworkManager DNS settings: %w", err)
+		}
 	}
 
-	log.Printf("DNS服务器IP已设置为 %s 在/etc/resolv.conf", dnsServer)
-	log.Printf("DNS已配置为使用 %s 在/etc/resolv.conf", dnsServer)
+	// nmcli 以逗号分隔的形式列出并接受多个服务器，与备份的格式相同
+	if err := modifyNetworkManagerDNS(conn, strings.Join(dnsServers, ","), "yes"); err != nil {
+		return err
+	}
 
+	utils.Logf("DNS已配置为使用 %s 在NetworkManager连接 %s", strings.Join(dnsServers, ", "), conn)
 	return nil
 }
 
-func restoreLinuxDNS() error {
-	// This is a simplified implementation that restores a basic resolv.conf
-	// A more robust solution would backup and restore the original file
-	cmd := exec.Command("sh", "-c", "echo 'nameserver 8.8.8.8\nnameserver 8.8.4.4' > /etc/resolv.conf")
-	output, err := cmd.CombinedOutput()
+// restoreNetworkManagerDNS restores the DNS settings saved by
+// configureNetworkManagerDNS. It reports false if there was nothing to restore.
+func restoreNetworkManagerDNS() (bool, error) {
+	backupPath := networkManagerBackupPath()
+	data, err := os.ReadFile(backupPath)
+	if os.IsNotExist(err) {
+		return false, nil
+	}
 	if err != nil {
-		return fmt.Errorf("failed to restore DNS servers: %w, output: %s", err, string(output))
+		return true, fmt.Errorf("failed to read NetworkManager DNS backup: %w", err)
+	}
+
+	var backup networkManagerDNSBackup
+	if err := json.Unmarshal(data, &backup); err != nil {
+		return true, fmt.Errorf("invalid NetworkManager DNS backup: %w", err)
+	}
+
+	if err := modifyNetworkManagerDNS(backup.Connection, backup.DNS, backup.IgnoreAutoDNS); err != nil {
+		return true, err
+	}
+	if err := removeSystemFile(backupPath); err != nil {
+		log.Printf("Warning: could not remove NetworkManager DNS backup: %v", err)
+	}
+
+	utils.Logf("DNS settings of NetworkManager connection %s restored", backup.Connection)
+	return true, nil
+}
+
+// modifyNetworkManagerDNS sets the DNS settings of a connection and reactivates it
+func modifyNetworkManagerDNS(conn, dnsServers, ignoreAutoDNS string) error {
+	if ignoreAutoDNS == "" {
+		ignoreAutoDNS = "no"
+	}
+
+	if output, err := runSystemCommand("nmcli", "connection", "modify", conn, "ipv4.dns", dnsServers, "ipv4.ignore-auto-dns", ignoreAutoDNS); err != nil {
+		return fmt.Errorf("failed to set DNS of connection %s: %w, output: %s", conn, err, string(output))
+	}
+
+	if output, err := runSystemCommand("nmcli", "connection", "up", conn); err != nil {
+		return fmt.Errorf("failed to reactivate connection %s: %w, output: %s", conn, err, string(output))
+	}
+	return nil
+}
+
+func configureLinuxDNS(dnsServers []string) error {
+	// NetworkManager 会在下次连接事件时覆盖 resolv.conf，存在时通过它修改
+	if iface, err := gateway.GetActiveInterface(); err == nil {
+		if conn := gateway.NetworkManagerConnection(iface.Name); conn != "" {
+			return configureNetworkManagerDNS(conn, dnsServers)
+		}
+	}
+
+	if err := checkResolvConfSymlink(); err != nil {
+		return err
+	}
+
+	// 仅在没有备份时保存原始resolv.conf，避免覆盖为我们自己写入的内容
+	backupPath := resolvConfBackupPath()
+	if _, err := os.Stat(backupPath); os.IsNotExist(err) {
+		original, err := os.ReadFile(resolvConfPath)
+		if err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to read %s: %w", resolvConfPath, err)
+		}
+		if err == nil {
+			if err := saveBackup(backupPath, original); err != nil {
+				return fmt.Errorf("failed to back up %s: %w", resolvConfPath, err)
+			}
+			utils.Logf("Original %s backed up to %s", resolvConfPath, backupPath)
+		}
+	}
+
+	// 注意: Linux的resolv.conf使用标准53端口
+	if err := writeSystemFile(resolvConfPath, resolvConfData(dnsServers)); err != nil {
+		return fmt.Errorf("failed to set DNS servers: %w", err)
+	}
+	if len(dnsServers) > maxResolvConfServers {
+		utils.Logf("Warning: the system resolver only queries the first %d nameservers in %s", maxResolvConfServers, resolvConfPath)
+	}
+
+	servers := strings.Join(dnsServers, ", ")
+	utils.Logf("DNS服务器IP已设置为 %s 在/etc/resolv.conf", servers)
+	utils.Logf("DNS已配置为使用 %s 在/etc/resolv.conf", servers)
+
+	return nil
+}
+
+// maxResolvConfServers is how many nameservers of resolv.conf the glibc
+// resolver queries (MAXNS)
+const maxResolvConfServers = 3
+
+// resolvConfData returns a resolv.conf listing dnsServers in order
+func resolvConfData(dnsServers []string) []byte {
+	var b strings.Builder
+	for _, server := range dnsServers {
+		fmt.Fprintf(&b, "nameserver %s\n", server)
+	}
+	return []byte(b.String())
+}
+
+// resolvConfPointsAt checks whether resolv.conf lists every one of addrs as
+// a nameserver
+func resolvConfPointsAt(addrs []string) bool {
+	if len(addrs) == 0 {
+		return false
+	}
+	data, err := os.ReadFile(resolvConfPath)
+	if err != nil {
+		return false
+	}
+
+	listed := parseResolvConfServers(string(data))
+	for _, addr := range addrs {
+		found := false
+		for _, server := range listed {
+			if server == addr {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+func restoreLinuxDNS(dnsServers []string) error {
+	if restored, err := restoreNetworkManagerDNS(); restored || err != nil {
+		return err
+	}
+
+	// 符号链接由其他程序管理，configureLinuxDNS 不会修改它
+	if err := checkResolvConfSymlink(); err != nil {
+		utils.Logf("Skipping DNS restore: %v", err)
+		return nil
 	}
 
-	log.Printf("DNS settings restored to default")
+	backupPath := resolvConfBackupPath()
+	original, err := os.ReadFile(backupPath)
+	if os.IsNotExist(err) {
+		// 没有备份说明已恢复过或从未修改；只有resolv.conf仍指向代理时才退回到公共DNS服务器
+		if !resolvConfPointsAt(dnsServers) {
+			utils.Logf("No resolv.conf backup found and %s does not point at %s, leaving it unchanged", resolvConfPath, strings.Join(dnsServers, ", "))
+			return nil
+		}
+		if err := writeSystemFile(resolvConfPath, []byte("nameserver 8.8.8.8\nnameserver 8.8.4.4\n")); err != nil {
+			return fmt.Errorf("failed to restore DNS servers: %w", err)
+		}
+		utils.Logf("No resolv.conf backup found, DNS settings restored to public resolvers")
+		return nil
+	}
+	if err != nil {
+		return fmt.Errorf("failed to read resolv.conf backup: %w", err)
+	}
+
+	if err := writeSystemFile(resolvConfPath, original); err != nil {
+		return fmt.Errorf("failed to restore DNS servers: %w", err)
+	}
+	if err := removeSystemFile(backupPath); err != nil {
+		log.Printf("Warning: could not remove resolv.conf backup: %v", err)
+	}
+
+	utils.Logf("DNS settings restored from %s", backupPath)
 	return nil
 }
+
+// InterfaceDNS lists the DNS servers a network interface or service resolves with
+type InterfaceDNS struct {
+	Interface string   `json:"interface"`
+	Servers   []string `json:"servers"`
+	// Automatic is set when no servers are configured manually and the ones
+	// from DHCP are used; Servers then lists them where the system reports them
+	Automatic bool `json:"automatic"`
+}
+
+// UsesProxy reports whether the interface resolves only through proxyIP
+func (d InterfaceDNS) UsesProxy(proxyIP string) bool {
+	if len(d.Servers) == 0 {
+		return false
+	}
+	for _, server := range d.Servers {
+		if server != proxyIP {
+			return false
+		}
+	}
+	return true
+}
+
+// resolvedStubAddrs are the addresses the systemd-resolved stub listener
+// answers on
+var resolvedStubAddrs = []string{"127.0.0.53", "127.0.0.54"}
+
+// UsesResolvedStub reports whether the interface resolves only through the
+// systemd-resolved stub listener, which forwards to the servers listed by
+// ResolvedDNSServers
+func (d InterfaceDNS) UsesResolvedStub() bool {
+	if len(d.Servers) == 0 {
+		return false
+	}
+	for _, server := range d.Servers {
+		stub := false
+		for _, addr := range resolvedStubAddrs {
+			stub = stub || server == addr
+		}
+		if !stub {
+			return false
+		}
+	}
+	return true
+}
+
+// ResolvedDNSServers returns the DNS servers systemd-resolved forwards to,
+// the global ones first and then those of each link, as listed by
+// "resolvectl dns"
+func ResolvedDNSServers() ([]InterfaceDNS, error) {
+	output, err := execCommand("resolvectl", "dns").CombinedOutput()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get systemd-resolved DNS servers: %w, output: %s", err, strings.TrimSpace(string(output)))
+	}
+	return parseResolvectlDNS(string(output)), nil
+}
+
+// parseResolvectlDNS parses the output of "resolvectl dns", with lines such
+// as "Global: 1.1.1.1" and "Link 2 (eth0): 192.168.1.1 fe80::1%2". Servers
+// reached over DNS-over-TLS carry a "#name" suffix, which is dropped.
+func parseResolvectlDNS(output string) []InterfaceDNS {
+	var result []InterfaceDNS
+	for _, line := range strings.Split(output, "\n") {
+		name, value, found := strings.Cut(strings.TrimSpace(line), ":")
+		if !found {
+			continue
+		}
+		if open, close := strings.IndexByte(name, '('), strings.LastIndexByte(name, ')'); open >= 0 && close > open {
+			name = name[open+1 : close]
+		}
+
+		entry := InterfaceDNS{Interface: name}
+		for _, server := range strings.Fields(value) {
+			server, _, _ = strings.Cut(server, "#")
+			entry.Servers = append(entry.Servers, server)
+		}
+		result = append(result, entry)
+	}
+	return result
+}
+
+// SystemDNSServers returns the DNS servers currently configured on the system,
+// per network service on macOS, per interface on Windows and from
+// /etc/resolv.conf on Linux
+func SystemDNSServers() ([]InterfaceDNS, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return darwinDNSServers()
+	case "windows":
+		output, err := execCommand("netsh", "interface", "ip", "show", "dnsservers").CombinedOutput()
+		if err != nil {
+			return nil, fmt.Errorf("failed to get DNS servers: %w, output: %s", err, strings.TrimSpace(string(output)))
+		}
+		return parseWindowsDNSServers(string(output)), nil
+	case "linux":
+		data, err := os.ReadFile(resolvConfPath)
+		if err != nil {
+			return nil, fmt.Errorf("failed to read %s: %w", resolvConfPath, err)
+		}
+		return []InterfaceDNS{{Interface: resolvConfPath, Servers: parseResolvConfServers(string(data))}}, nil
+	default:
+		return nil, fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+	}
+}
+
+// darwinDNSServers returns the manually configured DNS servers of every
+// enabled network service
+func darwinDNSServers() ([]InterfaceDNS, error) {
+	services, err := darwinNetworkServices()
+	if err != nil {
+		return nil, err
+	}
+
+	result := make([]InterfaceDNS, 0, len(services))
+	for _, service := range services {
+		servers, err := networksetupList("-getdnsservers", service)
+		if err != nil {
+			return nil, err
+		}
+		result = append(result, InterfaceDNS{Interface: service, Servers: servers, Automatic: len(servers) == 0})
+	}
+	return result, nil
+}
+
+// parseResolvConfServers returns the nameserver addresses of a resolv.conf
+func parseResolvConfServers(data string) []string {
+	var servers []string
+	for _, line := range strings.Split(data, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers
+}
+
+// parseWindowsDNSServers parses the output of
+// "netsh interface ip show dnsservers", where further servers of an
+// interface follow on lines of their own
+func parseWindowsDNSServers(output string) []InterfaceDNS {
+	var result []InterfaceDNS
+	var current *InterfaceDNS
+	inServers := false
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.Contains(line, "Configuration for interface") {
+			inServers = false
+			parts := strings.Split(line, "\"")
+			if len(parts) < 2 || strings.HasPrefix(parts[1], "Loopback Pseudo-Interface") {
+				current = nil
+				continue
+			}
+			result = append(result, InterfaceDNS{Interface: parts[1]})
+			current = &result[len(result)-1]
+			continue
+		}
+		if current == nil || line == "" {
+			continue
+		}
+
+		name, value, found := strings.Cut(line, ":")
+		switch {
+		case found && strings.Contains(name, "DNS"):
+			inServers = true
+			current.Automatic = strings.Contains(name, "DHCP")
+			value = strings.TrimSpace(value)
+			if value != "" && value != "None" {
+				current.Servers = append(current.Servers, value)
+			}
+		case inServers && net.ParseIP(line) != nil:
+			current.Servers = append(current.Servers, line)
+		default:
+			inServers = false
+		}
+	}
+	return result
+}
@@ -0,0 +1,278 @@
+package dns
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// fakeExecCommand returns an execCommand replacement that records each
+// command line and re-runs the test binary as TestHelperProcess, printing
+// outputs[command line] on stdout
+func fakeExecCommand(outputs map[string]string, calls *[]string) func(string, ...string) *exec.Cmd {
+	return func(name string, args ...string) *exec.Cmd {
+		line := strings.Join(append([]string{name}, args...), " ")
+		*calls = append(*calls, line)
+		cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+		cmd.Env = append(os.Environ(), "GATESHIFT_HELPER_PROCESS=1", "GATESHIFT_HELPER_OUTPUT="+outputs[line])
+		return cmd
+	}
+}
+
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GATESHIFT_HELPER_PROCESS") != "1" {
+		return
+	}
+	fmt.Print(os.Getenv("GATESHIFT_HELPER_OUTPUT"))
+	os.Exit(0)
+}
+
+func TestParseNetworksetupList(t *testing.T) {
+	values, err := parseNetworksetupList("192.168.1.1\n2001:db8::1\n")
+	if err != nil || len(values) != 2 || values[0] != "192.168.1.1" || values[1] != "2001:db8::1" {
+		t.Errorf("got %q, %v", values, err)
+	}
+
+	for _, output := range []string{
+		"There aren't any DNS Servers set on Wi-Fi.\n",
+		"There aren't any Search Domains set on Wi-Fi.\n",
+	} {
+		if values, err := parseNetworksetupList(output); err != nil || len(values) != 0 {
+			t.Errorf("parseNetworksetupList(%q) = %q, %v, want an empty list", output, values, err)
+		}
+	}
+
+	if _, err := parseNetworksetupList("** Error: The parameters were not valid.\n"); err == nil {
+		t.Error("expected an error for networksetup error output")
+	}
+}
+
+func TestDarwinDNSBackupRestore(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	oldExec := execCommand
+	defer func() { execCommand = oldExec }()
+
+	var calls []string
+	execCommand = fakeExecCommand(map[string]string{
+		"networksetup -getdnsservers Wi-Fi":    "9.9.9.9\n149.112.112.112\n",
+		"networksetup -getsearchdomains Wi-Fi": "There aren't any Search Domains set on Wi-Fi.\n",
+	}, &calls)
+
+	if err := backupDarwinDNS([]string{"Wi-Fi"}, []string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(darwinDNSBackupPath()); err != nil {
+		t.Fatalf("backup not written: %v", err)
+	}
+
+	// A second configure before restoring keeps the original snapshot
+	execCommand = fakeExecCommand(map[string]string{
+		"networksetup -getdnsservers Wi-Fi": "127.0.0.1\n",
+	}, &calls)
+	if err := backupDarwinDNS([]string{"Wi-Fi"}, []string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls = nil
+	if err := restoreDarwinDNS([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"networksetup -setdnsservers Wi-Fi 9.9.9.9 149.112.112.112",
+		"networksetup -setsearchdomains Wi-Fi empty",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("restore ran:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+	if _, err := os.Stat(darwinDNSBackupPath()); !os.IsNotExist(err) {
+		t.Error("backup not removed after restore")
+	}
+}
+
+func TestDarwinDNSBackupIgnoresLeftoverProxy(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	oldExec := execCommand
+	defer func() { execCommand = oldExec }()
+
+	var calls []string
+	execCommand = fakeExecCommand(map[string]string{
+		"networksetup -getdnsservers Ethernet":    "127.0.0.1\n",
+		"networksetup -getsearchdomains Ethernet": "corp.example.com\n",
+	}, &calls)
+	if err := backupDarwinDNS([]string{"Ethernet"}, []string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls = nil
+	if err := restoreDarwinDNS([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"networksetup -setdnsservers Ethernet empty",
+		"networksetup -setsearchdomains Ethernet corp.example.com",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("restore ran:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestParseNetworkServices(t *testing.T) {
+	output := `An asterisk (*) denotes that a network service is disabled.
+Wi-Fi
+USB 10/100/1000 LAN
+*Thunderbolt Bridge
+Tailscale
+`
+	services := parseNetworkServices(output)
+	want := []string{"Wi-Fi", "USB 10/100/1000 LAN", "Tailscale"}
+	if strings.Join(services, "|") != strings.Join(want, "|") {
+		t.Errorf("got %q, want %q", services, want)
+	}
+}
+
+func TestDarwinDNSAllServicesRestore(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	oldExec := execCommand
+	defer func() { execCommand = oldExec }()
+
+	var calls []string
+	execCommand = fakeExecCommand(map[string]string{
+		"networksetup -getdnsservers Wi-Fi":       "There aren't any DNS Servers set on Wi-Fi.\n",
+		"networksetup -getsearchdomains Wi-Fi":    "home.arpa\n",
+		"networksetup -getdnsservers Ethernet":    "10.0.0.53\n",
+		"networksetup -getsearchdomains Ethernet": "There aren't any Search Domains set on Ethernet.\n",
+	}, &calls)
+
+	if err := backupDarwinDNS([]string{"Wi-Fi", "Ethernet"}, []string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+
+	calls = nil
+	if err := restoreDarwinDNS([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"networksetup -setdnsservers Wi-Fi empty",
+		"networksetup -setsearchdomains Wi-Fi home.arpa",
+		"networksetup -setdnsservers Ethernet 10.0.0.53",
+		"networksetup -setsearchdomains Ethernet empty",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("restore ran:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestResetDarwinDNSWithoutBackup(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	oldExec := execCommand
+	defer func() { execCommand = oldExec }()
+
+	var calls []string
+	execCommand = fakeExecCommand(map[string]string{
+		"networksetup -listallnetworkservices": "An asterisk (*) denotes that a network service is disabled.\nWi-Fi\nEthernet\n",
+		"networksetup -getdnsservers Wi-Fi":    "127.0.0.1\n",
+		"networksetup -getdnsservers Ethernet": "1.1.1.1\n",
+	}, &calls)
+
+	if err := restoreDarwinDNS([]string{"127.0.0.1"}); err != nil {
+		t.Fatal(err)
+	}
+	// Only the service still pointing at the proxy is cleared
+	want := []string{
+		"networksetup -listallnetworkservices",
+		"networksetup -getdnsservers Wi-Fi",
+		"networksetup -setdnsservers Wi-Fi empty",
+		"networksetup -getdnsservers Ethernet",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("restore ran:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestConfigureDarwinDNSMultipleServers(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	oldExec := execCommand
+	defer func() { execCommand = oldExec }()
+
+	var calls []string
+	execCommand = fakeExecCommand(map[string]string{
+		"networksetup -listallnetworkservices": "An asterisk (*) denotes that a network service is disabled.\nWi-Fi\n",
+		"networksetup -getdnsservers Wi-Fi":    "There aren't any DNS Servers set on Wi-Fi.\n",
+		"networksetup -getsearchdomains Wi-Fi": "There aren't any Search Domains set on Wi-Fi.\n",
+	}, &calls)
+
+	servers := []string{"192.168.1.1", "2606:4700:4700::1111"}
+	if err := configureDarwinDNS(servers, true); err != nil {
+		t.Fatal(err)
+	}
+	if last := calls[len(calls)-1]; last != "networksetup -setdnsservers Wi-Fi 192.168.1.1 2606:4700:4700::1111" {
+		t.Errorf("last command = %q, want both servers set in order", last)
+	}
+
+	// Without a backup only services still using exactly these servers are cleared
+	if err := os.Remove(darwinDNSBackupPath()); err != nil {
+		t.Fatal(err)
+	}
+	execCommand = fakeExecCommand(map[string]string{
+		"networksetup -listallnetworkservices": "Wi-Fi\nEthernet\n",
+		"networksetup -getdnsservers Wi-Fi":    "192.168.1.1\n2606:4700:4700::1111\n",
+		"networksetup -getdnsservers Ethernet": "192.168.1.1\n",
+	}, &calls)
+	calls = nil
+	if err := restoreDarwinDNS(servers); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Count(strings.Join(calls, "\n"), "-setdnsservers") != 1 || !strings.Contains(strings.Join(calls, "\n"), "-setdnsservers Wi-Fi empty") {
+		t.Errorf("restore ran %q, want only Wi-Fi cleared", calls)
+	}
+}
+
+func TestResolvConfData(t *testing.T) {
+	got := string(resolvConfData([]string{"192.168.1.1", "1.1.1.1"}))
+	if want := "nameserver 192.168.1.1\nnameserver 1.1.1.1\n"; got != want {
+		t.Errorf("resolvConfData = %q, want %q", got, want)
+	}
+	if servers := parseResolvConfServers(got); !sameServers(servers, []string{"192.168.1.1", "1.1.1.1"}) {
+		t.Errorf("round trip = %q", servers)
+	}
+}
+
+func TestParseResolvectlDNS(t *testing.T) {
+	output := `Global: 1.1.1.1#cloudflare-dns.com 9.9.9.9
+Link 2 (eth0): 192.168.1.1 fe80::1%2
+Link 3 (docker0):
+`
+	entries := parseResolvectlDNS(output)
+	want := []InterfaceDNS{
+		{Interface: "Global", Servers: []string{"1.1.1.1", "9.9.9.9"}},
+		{Interface: "eth0", Servers: []string{"192.168.1.1", "fe80::1%2"}},
+		{Interface: "docker0"},
+	}
+	if len(entries) != len(want) {
+		t.Fatalf("got %+v, want %+v", entries, want)
+	}
+	for i := range want {
+		if entries[i].Interface != want[i].Interface || !sameServers(entries[i].Servers, want[i].Servers) {
+			t.Errorf("entry %d = %+v, want %+v", i, entries[i], want[i])
+		}
+	}
+}
+
+func TestUsesResolvedStub(t *testing.T) {
+	tests := []struct {
+		servers []string
+		want    bool
+	}{
+		{[]string{"127.0.0.53"}, true},
+		{[]string{"127.0.0.53", "127.0.0.54"}, true},
+		{[]string{"127.0.0.53", "1.1.1.1"}, false},
+		{nil, false},
+	}
+	for _, tt := range tests {
+		if got := (InterfaceDNS{Servers: tt.servers}).UsesResolvedStub(); got != tt.want {
+			t.Errorf("UsesResolvedStub(%q) = %v, want %v", tt.servers, got, tt.want)
+		}
+	}
+}
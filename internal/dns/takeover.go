@@ -0,0 +1,165 @@
+package dns
+
+import (
+	"encoding/json"
+	"fmt"
+	"log"
+	"net"
+	"os"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"time"
+
+	"github.com/ourines/GateShift/internal/utils"
+)
+
+// resolvedDropIn turns off the systemd-resolved stub listener. Files under
+// /run are gone after a reboot, which restores the resolver even when
+// GateShift never gets to.
+const resolvedDropIn = "/run/systemd/resolved.conf.d/gateshift-takeover.conf"
+
+// ResolverTakeover records a system resolver moved off the DNS port by
+// TakeOverResolver
+type ResolverTakeover struct {
+	// Service is the system service that was reconfigured
+	Service string `json:"service"`
+	// Files were created to reconfigure it and are removed on restore
+	Files []string  `json:"files"`
+	Time  time.Time `json:"time"`
+}
+
+// takeoverPath returns where the resolver takeover is recorded
+func takeoverPath() string {
+	return filepath.Join(utils.ConfigDir(), "resolver-takeover.json")
+}
+
+// runPrivileged runs a command through the sudo session; replaced in tests
+var runPrivileged = func(session *utils.SudoSession, name string, args ...string) error {
+	return session.RunWithPrivileges(name, args...)
+}
+
+// CanTakeOver returns why TakeOverResolver cannot free the DNS port from
+// owner, or nil when it can
+func CanTakeOver(owner *PortOwner) error {
+	switch {
+	case runtime.GOOS == "linux" && strings.HasPrefix(owner.Name, "systemd-resolve"):
+		return nil
+	case strings.EqualFold(owner.Name, "mDNSResponder"):
+		return fmt.Errorf("mDNSResponder is protected by System Integrity Protection and cannot be stopped; turn off Internet Sharing instead")
+	default:
+		return fmt.Errorf("taking over the DNS port from %s is not supported; stop it manually", owner)
+	}
+}
+
+// TakeOverResolver frees the DNS port held by owner, a system stub resolver,
+// and points the resolver at the proxy on proxyIP so lookups made through it
+// still work. The change is recorded before it is made, so RestoreResolver
+// undoes it even after a crash.
+func TakeOverResolver(session *utils.SudoSession, owner *PortOwner, proxyIP string) error {
+	if err := CanTakeOver(owner); err != nil {
+		return err
+	}
+	if ip := net.ParseIP(proxyIP); ip == nil || ip.IsUnspecified() {
+		proxyIP = "127.0.0.1"
+	}
+
+	takeover := ResolverTakeover{
+		Service: "systemd-resolved",
+		Files:   []string{resolvedDropIn},
+		Time:    time.Now(),
+	}
+	if err := saveTakeover(&takeover); err != nil {
+		return fmt.Errorf("failed to record resolver takeover: %w", err)
+	}
+
+	dropIn := fmt.Sprintf("[Resolve]\nDNS=%s\nDomains=~.\nDNSStubListener=no\n", proxyIP)
+	if err := writePrivilegedFile(session, resolvedDropIn, []byte(dropIn)); err != nil {
+		return fmt.Errorf("failed to write %s: %w", resolvedDropIn, err)
+	}
+	if err := runPrivileged(session, "systemctl", "restart", takeover.Service); err != nil {
+		return fmt.Errorf("failed to restart %s: %w", takeover.Service, err)
+	}
+	utils.Logf("Turned off the %s stub listener", takeover.Service)
+	return nil
+}
+
+// RestoreResolver undoes TakeOverResolver. It returns false without an error
+// when no resolver was taken over.
+func RestoreResolver(session *utils.SudoSession) (bool, error) {
+	data, err := os.ReadFile(takeoverPath())
+	if os.IsNotExist(err) {
+		return false, nil
+	}
+	if err != nil {
+		return false, err
+	}
+	var takeover ResolverTakeover
+	if err := json.Unmarshal(data, &takeover); err != nil {
+		return false, fmt.Errorf("invalid resolver takeover record %s: %w", takeoverPath(), err)
+	}
+
+	for _, file := range takeover.Files {
+		if err := runPrivileged(session, "rm", "-f", file); err != nil {
+			return false, fmt.Errorf("failed to remove %s: %w", file, err)
+		}
+	}
+	if err := runPrivileged(session, "systemctl", "restart", takeover.Service); err != nil {
+		return false, fmt.Errorf("failed to restart %s: %w", takeover.Service, err)
+	}
+
+	if utils.DryRun() {
+		utils.PrintDryRun("rm", takeoverPath())
+	} else if err := os.Remove(takeoverPath()); err != nil {
+		return true, err
+	}
+	utils.Logf("Restored %s", takeover.Service)
+	return true, nil
+}
+
+// saveTakeover writes the takeover record to the config directory, owned by
+// the directory owner like the DNS backups
+func saveTakeover(takeover *ResolverTakeover) error {
+	path := takeoverPath()
+	if utils.DryRun() {
+		fmt.Printf("[dry-run] record resolver takeover in %s\n", path)
+		return nil
+	}
+	data, err := json.MarshalIndent(takeover, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	if err := os.WriteFile(path, data, 0644); err != nil {
+		return err
+	}
+	if err := utils.ChownLike(path, filepath.Dir(path)); err != nil {
+		log.Printf("Warning: could not change takeover record ownership: %v", err)
+	}
+	return nil
+}
+
+// writePrivilegedFile writes a world-readable system file through the sudo
+// session. In dry-run mode the change is only printed.
+func writePrivilegedFile(session *utils.SudoSession, path string, data []byte) error {
+	if utils.DryRun() {
+		fmt.Printf("[dry-run] write %s:\n%s", path, data)
+		return nil
+	}
+
+	tmp, err := os.CreateTemp("", "gateshift-*"+filepath.Ext(path))
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+	return runPrivileged(session, "install", "-D", "-m", "0644", tmp.Name(), path)
+}
@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"os"
+	"runtime"
+	"strings"
+	"testing"
+
+	"github.com/ourines/GateShift/internal/utils"
+)
+
+// fakePrivileged replaces runPrivileged with one that records each command
+// line, keeping the contents of installed files in files
+func fakePrivileged(t *testing.T, calls *[]string, files map[string]string) {
+	t.Helper()
+	old := runPrivileged
+	t.Cleanup(func() { runPrivileged = old })
+	runPrivileged = func(session *utils.SudoSession, name string, args ...string) error {
+		*calls = append(*calls, strings.Join(append([]string{name}, args...), " "))
+		if name == "install" {
+			data, err := os.ReadFile(args[len(args)-2])
+			if err != nil {
+				return err
+			}
+			files[args[len(args)-1]] = string(data)
+		}
+		return nil
+	}
+}
+
+func TestCanTakeOver(t *testing.T) {
+	resolved := &PortOwner{PID: 612, Name: "systemd-resolve"}
+	if err := CanTakeOver(resolved); (err == nil) != (runtime.GOOS == "linux") {
+		t.Errorf("CanTakeOver(systemd-resolved) = %v on %s", err, runtime.GOOS)
+	}
+	for _, owner := range []*PortOwner{{PID: 1, Name: "mDNSResponder"}, {PID: 2, Name: "dnsmasq"}, {PID: 3}} {
+		if err := CanTakeOver(owner); err == nil {
+			t.Errorf("CanTakeOver(%s) succeeded", owner)
+		}
+	}
+}
+
+func TestTakeOverAndRestoreResolver(t *testing.T) {
+	if runtime.GOOS != "linux" {
+		t.Skip("only systemd-resolved can be taken over")
+	}
+	t.Setenv("HOME", t.TempDir())
+	var calls []string
+	files := map[string]string{}
+	fakePrivileged(t, &calls, files)
+	session := utils.NewIndependentSudoSession(0)
+
+	if restored, err := RestoreResolver(session); restored || err != nil {
+		t.Fatalf("RestoreResolver without a takeover = %t, %v", restored, err)
+	}
+
+	owner := &PortOwner{PID: 612, Name: "systemd-resolve"}
+	if err := TakeOverResolver(session, owner, "0.0.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if dropIn := files[resolvedDropIn]; !strings.Contains(dropIn, "DNSStubListener=no") || !strings.Contains(dropIn, "DNS=127.0.0.1\n") {
+		t.Errorf("drop-in = %q", dropIn)
+	}
+	if calls[len(calls)-1] != "systemctl restart systemd-resolved" {
+		t.Errorf("last command = %q, want a resolver restart", calls[len(calls)-1])
+	}
+	if _, err := os.Stat(takeoverPath()); err != nil {
+		t.Fatalf("takeover not recorded: %v", err)
+	}
+
+	calls = nil
+	restored, err := RestoreResolver(session)
+	if !restored || err != nil {
+		t.Fatalf("RestoreResolver = %t, %v", restored, err)
+	}
+	want := []string{"rm -f " + resolvedDropIn, "systemctl restart systemd-resolved"}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("restore ran %q, want %q", calls, want)
+	}
+	if _, err := os.Stat(takeoverPath()); !os.IsNotExist(err) {
+		t.Errorf("takeover record still exists: %v", err)
+	}
+}
@@ -0,0 +1,225 @@
+package dns
+
+import (
+	"crypto/rand"
+	"encoding/binary"
+	"fmt"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	// DefaultUpstreamBenchName is queried when no name is given; its answer
+	// does not vary between resolvers, unlike names served by CDNs
+	DefaultUpstreamBenchName = "example.com"
+	// DefaultUpstreamBenchSamples is how many timed queries each upstream is
+	// sent by default
+	DefaultUpstreamBenchSamples = 20
+	// DefaultUpstreamBenchWarmup is how many untimed queries each upstream is
+	// sent first by default, so that its cache holds the answer
+	DefaultUpstreamBenchWarmup = 2
+)
+
+// UpstreamBenchOptions configures BenchmarkUpstreams
+type UpstreamBenchOptions struct {
+	Upstreams []string
+	Name      string
+	Type      uint16
+	Samples   int
+	Warmup    int
+	Timeout   time.Duration
+}
+
+// UpstreamBenchResult summarizes the queries sent to one upstream server by
+// BenchmarkUpstreams
+type UpstreamBenchResult struct {
+	Upstream    string  `json:"upstream"`
+	Sent        int     `json:"sent"`
+	Succeeded   int     `json:"succeeded"`
+	SuccessRate float64 `json:"success_rate"`
+	MinMs       float64 `json:"min_ms"`
+	P50Ms       float64 `json:"p50_ms"`
+	P95Ms       float64 `json:"p95_ms"`
+	MaxMs       float64 `json:"max_ms"`
+	// Answers are the distinct answers received, each as the response code
+	// followed by the sorted record values, e.g. "NOERROR 192.0.2.1"
+	Answers []string `json:"answers"`
+	// Inconsistent is set when the upstream's answers changed between
+	// queries or differ from the answer most upstreams returned, which may
+	// mean the answers are tampered with
+	Inconsistent bool   `json:"inconsistent"`
+	LastError    string `json:"last_error,omitempty"`
+}
+
+// BenchmarkUpstreams sends the same query to each upstream server in turn,
+// Warmup times untimed and then Samples times, each server independently of
+// the others and without retries. The results are ranked by success rate,
+// then by median latency.
+func BenchmarkUpstreams(opts UpstreamBenchOptions) ([]UpstreamBenchResult, error) {
+	if opts.Samples <= 0 {
+		return nil, fmt.Errorf("samples must be positive")
+	}
+	if opts.Warmup < 0 {
+		return nil, fmt.Errorf("warmup must not be negative")
+	}
+	if opts.Timeout <= 0 {
+		opts.Timeout = 2 * time.Second
+	}
+	if opts.Name == "" {
+		opts.Name = DefaultUpstreamBenchName
+	}
+	if opts.Type == 0 {
+		opts.Type = TypeA
+	}
+	if len(opts.Upstreams) == 0 {
+		return nil, fmt.Errorf("no upstream DNS servers to benchmark")
+	}
+	if _, err := BuildQuery(0, opts.Name, opts.Type); err != nil {
+		return nil, err
+	}
+
+	// The queries go through a proxy that is never started, so they take
+	// the same path as forwarded ones, over pooled connections
+	p, err := NewDNSProxy("127.0.0.1", 0, opts.Upstreams)
+	if err != nil {
+		return nil, err
+	}
+	defer p.upstreamConns.close()
+	if err := p.SetRetries(0, DefaultRetryBackoff); err != nil {
+		return nil, err
+	}
+
+	upstreams := p.Upstreams()
+	results := make([]UpstreamBenchResult, len(upstreams))
+	var wg sync.WaitGroup
+	for i, upstream := range upstreams {
+		wg.Add(1)
+		go func(i int, upstream string) {
+			defer wg.Done()
+			results[i] = p.benchmarkUpstream(upstream, opts)
+		}(i, upstream)
+	}
+	wg.Wait()
+
+	markInconsistentAnswers(results)
+	sort.SliceStable(results, func(i, j int) bool {
+		if results[i].SuccessRate != results[j].SuccessRate {
+			return results[i].SuccessRate > results[j].SuccessRate
+		}
+		return results[i].P50Ms < results[j].P50Ms
+	})
+	return results, nil
+}
+
+// benchmarkUpstream sends the queries of BenchmarkUpstreams to upstream
+func (p *DNSProxy) benchmarkUpstream(upstream string, opts UpstreamBenchOptions) UpstreamBenchResult {
+	for i := 0; i < opts.Warmup; i++ {
+		p.benchQueryUpstream(upstream, opts)
+	}
+
+	result := UpstreamBenchResult{Upstream: upstream, Sent: opts.Samples, Answers: []string{}}
+	var latencies []time.Duration
+	for i := 0; i < opts.Samples; i++ {
+		answer, latency, err := p.benchQueryUpstream(upstream, opts)
+		if err != nil {
+			result.LastError = err.Error()
+			continue
+		}
+		latencies = append(latencies, latency)
+		if !containsString(result.Answers, answer) {
+			result.Answers = append(result.Answers, answer)
+		}
+	}
+
+	result.Succeeded = len(latencies)
+	result.SuccessRate = float64(result.Succeeded) / float64(result.Sent)
+	result.Inconsistent = len(result.Answers) > 1
+	if len(latencies) > 0 {
+		sort.Slice(latencies, func(i, j int) bool { return latencies[i] < latencies[j] })
+		result.MinMs = percentileMs(latencies, 0)
+		result.P50Ms = percentileMs(latencies, 0.50)
+		result.P95Ms = percentileMs(latencies, 0.95)
+		result.MaxMs = percentileMs(latencies, 1)
+	}
+	return result
+}
+
+// benchQueryUpstream sends one query to upstream with queryUpstreamServer
+// and returns its answer as listed in UpstreamBenchResult.Answers
+func (p *DNSProxy) benchQueryUpstream(upstream string, opts UpstreamBenchOptions) (string, time.Duration, error) {
+	var idBytes [2]byte
+	rand.Read(idBytes[:])
+	query, err := BuildQuery(binary.BigEndian.Uint16(idBytes[:]), opts.Name, opts.Type)
+	if err != nil {
+		return "", 0, err
+	}
+
+	start := time.Now()
+	response, err := p.queryUpstreamServer(upstream, query, start.Add(opts.Timeout), nil)
+	if err != nil {
+		return "", 0, err
+	}
+	latency := time.Since(start)
+
+	msg, err := ParseMessage(response)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid response: %w", err)
+	}
+	if msg.Rcode == 2 {
+		return "", 0, fmt.Errorf("server returned %s", rcodeString(msg.Rcode))
+	}
+	return answerSignature(msg), latency, nil
+}
+
+// answerSignature describes the answer of msg independently of the record
+// order and TTLs
+func answerSignature(msg *Message) string {
+	values := make([]string, 0, len(msg.Answers))
+	for _, rr := range msg.Answers {
+		values = append(values, typeString(rr.Type)+" "+rr.Value)
+	}
+	sort.Strings(values)
+	return strings.Join(append([]string{rcodeString(msg.Rcode)}, values...), " ")
+}
+
+// markInconsistentAnswers flags the upstreams whose answers differ from the
+// one most upstreams agree on. Without such a majority, as with two
+// upstreams that disagree, no answer can be told to be wrong and each is
+// flagged.
+func markInconsistentAnswers(results []UpstreamBenchResult) {
+	votes := make(map[string]int)
+	answered := 0
+	for _, r := range results {
+		if len(r.Answers) == 1 {
+			votes[r.Answers[0]]++
+			answered++
+		}
+	}
+	if answered < 2 {
+		return
+	}
+
+	majority := ""
+	for answer, n := range votes {
+		if n*2 > answered {
+			majority = answer
+		}
+	}
+	for i := range results {
+		if len(results[i].Answers) == 1 && results[i].Answers[0] != majority {
+			results[i].Inconsistent = true
+		}
+	}
+}
+
+// containsString reports whether s is one of values
+func containsString(values []string, s string) bool {
+	for _, v := range values {
+		if v == s {
+			return true
+		}
+	}
+	return false
+}
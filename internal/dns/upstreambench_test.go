@@ -0,0 +1,100 @@
+package dns
+
+import (
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// answerA returns a startUpstream handler answering A queries with the
+// address returned by ip for the n-th query
+func answerA(ip func(n int32) net.IP) func(int32, []byte) []byte {
+	return func(n int32, query []byte) []byte {
+		response, err := hostsResponse(query, TypeA, []net.IP{ip(n)})
+		if err != nil {
+			return nil
+		}
+		return response
+	}
+}
+
+func TestBenchmarkUpstreams(t *testing.T) {
+	good := startRecordUpstream(t, aRecord(3600))
+	other := startRecordUpstream(t, aRecord(60))
+	hijacking := startUpstream(t, answerA(func(int32) net.IP { return net.IPv4(198, 51, 100, 7) }))
+	down := closedUpstream(t)
+
+	results, err := BenchmarkUpstreams(UpstreamBenchOptions{
+		Upstreams: []string{down, hijacking.addr, good.addr, other.addr},
+		Samples:   5,
+		Warmup:    1,
+		Timeout:   500 * time.Millisecond,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(results) != 4 {
+		t.Fatalf("%d results, want 4", len(results))
+	}
+
+	// Answering upstreams rank before the one that is down
+	last := results[3]
+	if last.Upstream != down || last.Succeeded != 0 || last.SuccessRate != 0 || last.LastError == "" {
+		t.Errorf("last result = %+v, want the upstream that is down", last)
+	}
+	if q := atomic.LoadInt32(&good.queries); q != 6 {
+		t.Errorf("upstream sent %d queries, want 6 with the warm-up", q)
+	}
+
+	for _, r := range results[:3] {
+		if r.Sent != 5 || r.Succeeded != 5 || r.SuccessRate != 1 {
+			t.Errorf("%s: %d of %d succeeded, want 5 of 5", r.Upstream, r.Succeeded, r.Sent)
+		}
+		if r.MinMs > r.P50Ms || r.P50Ms > r.P95Ms || r.P95Ms > r.MaxMs {
+			t.Errorf("%s: latencies out of order: %+v", r.Upstream, r)
+		}
+		wantAnswer, wantInconsistent := "NOERROR A 192.0.2.1", false
+		if r.Upstream == hijacking.addr {
+			wantAnswer, wantInconsistent = "NOERROR A 198.51.100.7", true
+		}
+		if !reflect.DeepEqual(r.Answers, []string{wantAnswer}) {
+			t.Errorf("%s: answers = %q, want %q", r.Upstream, r.Answers, wantAnswer)
+		}
+		if r.Inconsistent != wantInconsistent {
+			t.Errorf("%s: inconsistent = %v, want %v", r.Upstream, r.Inconsistent, wantInconsistent)
+		}
+	}
+}
+
+func TestBenchmarkUpstreamsFlagsChangingAnswers(t *testing.T) {
+	flapping := startUpstream(t, answerA(func(n int32) net.IP {
+		if n%2 == 0 {
+			return net.IPv4(198, 51, 100, 7)
+		}
+		return net.IPv4(192, 0, 2, 1)
+	}))
+
+	results, err := BenchmarkUpstreams(UpstreamBenchOptions{Upstreams: []string{flapping.addr}, Samples: 4})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r := results[0]; !r.Inconsistent || len(r.Answers) != 2 {
+		t.Errorf("result = %+v, want two answers flagged as inconsistent", r)
+	}
+}
+
+func TestBenchmarkUpstreamsRejectsInvalidOptions(t *testing.T) {
+	upstream := startRecordUpstream(t, aRecord(60))
+	for name, opts := range map[string]UpstreamBenchOptions{
+		"no upstreams":     {Samples: 1},
+		"no samples":       {Upstreams: []string{upstream.addr}},
+		"negative warm-up": {Upstreams: []string{upstream.addr}, Samples: 1, Warmup: -1},
+		"host name":        {Upstreams: []string{"dns.google"}, Samples: 1},
+	} {
+		if _, err := BenchmarkUpstreams(opts); err == nil {
+			t.Errorf("%s: expected an error", name)
+		}
+	}
+}
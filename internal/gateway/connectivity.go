@@ -0,0 +1,161 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Targets used by CheckInternetConnectivity; variables so they can be
+// pointed at local listeners in tests
+var (
+	connectivityTargets = []string{"1.1.1.1:443", "8.8.8.8:53"}
+	connectivityURL     = "http://connectivitycheck.gstatic.com/generate_204"
+	connectivityTimeout = 2 * time.Second
+)
+
+// defaultCheckPort is used for check targets given without a port
+const defaultCheckPort = "443"
+
+// CheckTarget is an endpoint to probe: a TCP address, or an HTTP(S) URL that
+// is probed with a HEAD request
+type CheckTarget struct {
+	Protocol string
+	Address  string
+}
+
+// CheckResult is the outcome of probing a single target
+type CheckResult struct {
+	Target    string  `json:"target"`
+	Protocol  string  `json:"protocol"`
+	Reachable bool    `json:"reachable"`
+	LatencyMs float64 `json:"latency_ms"`
+	Error     string  `json:"error,omitempty"`
+}
+
+// ParseCheckTarget parses "host", "host:port", "[ipv6]:port", a bare IPv6
+// address, "tcp://host:port" or an http:// or https:// URL. Targets without a
+// port are probed on port 443.
+func ParseCheckTarget(target string) (CheckTarget, error) {
+	target = strings.TrimSpace(target)
+	if target == "" {
+		return CheckTarget{}, fmt.Errorf("empty check target")
+	}
+
+	if strings.Contains(target, "://") {
+		u, err := url.Parse(target)
+		if err != nil {
+			return CheckTarget{}, fmt.Errorf("invalid check target %q: %w", target, err)
+		}
+		switch u.Scheme {
+		case "http", "https":
+			if u.Host == "" {
+				return CheckTarget{}, fmt.Errorf("invalid check target %q: missing host", target)
+			}
+			return CheckTarget{Protocol: u.Scheme, Address: target}, nil
+		case "tcp":
+			target = u.Host
+		default:
+			return CheckTarget{}, fmt.Errorf("invalid check target %q: unsupported protocol %q (use tcp, http or https)", target, u.Scheme)
+		}
+	}
+
+	// Bare IPv6 addresses and hosts without a port use the default port
+	host, port, err := net.SplitHostPort(target)
+	if err != nil {
+		host = strings.TrimSuffix(strings.TrimPrefix(target, "["), "]")
+		port = defaultCheckPort
+	}
+	if host == "" || strings.ContainsAny(host, "/ ") {
+		return CheckTarget{}, fmt.Errorf("invalid check target %q", target)
+	}
+	if _, err := net.LookupPort("tcp", port); err != nil {
+		return CheckTarget{}, fmt.Errorf("invalid check target %q: bad port %q", target, port)
+	}
+	return CheckTarget{Protocol: "tcp", Address: net.JoinHostPort(host, port)}, nil
+}
+
+// CheckConnectivity probes target within timeout and returns how long it
+// took to connect, or to get an HTTP response
+func CheckConnectivity(target CheckTarget, timeout time.Duration) (time.Duration, error) {
+	start := time.Now()
+	switch target.Protocol {
+	case "tcp":
+		conn, err := net.DialTimeout("tcp", target.Address, timeout)
+		if err != nil {
+			return 0, err
+		}
+		conn.Close()
+	case "http", "https":
+		client := &http.Client{Timeout: timeout}
+		resp, err := client.Head(target.Address)
+		if err != nil {
+			return 0, err
+		}
+		resp.Body.Close()
+	default:
+		return 0, fmt.Errorf("unsupported protocol %q", target.Protocol)
+	}
+	return time.Since(start), nil
+}
+
+// CheckTargets probes every target concurrently and returns the results in
+// the order of targets
+func CheckTargets(targets []CheckTarget) []CheckResult {
+	results := make([]CheckResult, len(targets))
+	var wg sync.WaitGroup
+	for i, target := range targets {
+		wg.Add(1)
+		go func(i int, target CheckTarget) {
+			defer wg.Done()
+			result := CheckResult{Target: target.Address, Protocol: target.Protocol}
+			latency, err := CheckConnectivity(target, connectivityTimeout)
+			if err != nil {
+				result.Error = err.Error()
+			} else {
+				result.Reachable = true
+				result.LatencyMs = float64(latency.Microseconds()) / 1000
+			}
+			results[i] = result
+		}(i, target)
+	}
+	wg.Wait()
+	return results
+}
+
+// hasConnectivityFrom reports whether one of the connectivity targets can be
+// reached over TCP from the local address localIP, i.e. through the
+// interface that owns it
+func hasConnectivityFrom(localIP string) bool {
+	ip := net.ParseIP(localIP)
+	if ip == nil {
+		return false
+	}
+	dialer := net.Dialer{LocalAddr: &net.TCPAddr{IP: ip}, Timeout: connectivityTimeout}
+	for _, address := range connectivityTargets {
+		if conn, err := dialer.Dial("tcp", address); err == nil {
+			conn.Close()
+			return true
+		}
+	}
+	return false
+}
+
+// CheckInternetConnectivity verifies if there's internet connectivity. It
+// dials well-known TCP endpoints rather than relying on ping, whose flags
+// differ between operating systems and whose ICMP traffic is often blocked,
+// and falls back to an HTTP HEAD request.
+func CheckInternetConnectivity() bool {
+	for _, address := range connectivityTargets {
+		if _, err := CheckConnectivity(CheckTarget{Protocol: "tcp", Address: address}, connectivityTimeout); err == nil {
+			return true
+		}
+	}
+
+	_, err := CheckConnectivity(CheckTarget{Protocol: "http", Address: connectivityURL}, connectivityTimeout)
+	return err == nil
+}
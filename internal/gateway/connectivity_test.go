@@ -0,0 +1,123 @@
+package gateway
+
+import (
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+// closedAddr returns a local TCP address with no listener
+func closedAddr(t *testing.T) string {
+	t.Helper()
+
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := listener.Addr().String()
+	listener.Close()
+	return addr
+}
+
+func withConnectivityTargets(t *testing.T, targets []string, url string) {
+	t.Helper()
+
+	oldTargets, oldURL := connectivityTargets, connectivityURL
+	t.Cleanup(func() { connectivityTargets, connectivityURL = oldTargets, oldURL })
+	connectivityTargets, connectivityURL = targets, url
+}
+
+func TestCheckInternetConnectivityTCP(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	withConnectivityTargets(t, []string{closedAddr(t), listener.Addr().String()}, "http://"+closedAddr(t))
+	if !CheckInternetConnectivity() {
+		t.Error("expected connectivity through the second TCP target")
+	}
+}
+
+func TestCheckInternetConnectivityHTTPFallback(t *testing.T) {
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	withConnectivityTargets(t, []string{closedAddr(t)}, server.URL)
+	if !CheckInternetConnectivity() {
+		t.Error("expected connectivity through the HTTP fallback")
+	}
+}
+
+func TestCheckInternetConnectivityOffline(t *testing.T) {
+	withConnectivityTargets(t, []string{closedAddr(t)}, "http://"+closedAddr(t))
+	if CheckInternetConnectivity() {
+		t.Error("expected no connectivity when every target is unreachable")
+	}
+}
+
+func TestParseCheckTarget(t *testing.T) {
+	tests := []struct {
+		target   string
+		protocol string
+		address  string
+	}{
+		{"1.1.1.1", "tcp", "1.1.1.1:443"},
+		{"example.com:80", "tcp", "example.com:80"},
+		{"2606:4700:4700::1111", "tcp", "[2606:4700:4700::1111]:443"},
+		{"[2606:4700:4700::1111]:53", "tcp", "[2606:4700:4700::1111]:53"},
+		{"tcp://8.8.8.8:53", "tcp", "8.8.8.8:53"},
+		{"https://example.com/health", "https", "https://example.com/health"},
+	}
+	for _, tt := range tests {
+		got, err := ParseCheckTarget(tt.target)
+		if err != nil {
+			t.Errorf("ParseCheckTarget(%q) error = %v", tt.target, err)
+			continue
+		}
+		if got.Protocol != tt.protocol || got.Address != tt.address {
+			t.Errorf("ParseCheckTarget(%q) = %+v, want %s %s", tt.target, got, tt.protocol, tt.address)
+		}
+	}
+
+	for _, target := range []string{"", "example.com:99999", "udp://1.1.1.1:53", "https://", "not a host"} {
+		if got, err := ParseCheckTarget(target); err == nil {
+			t.Errorf("ParseCheckTarget(%q) = %+v, want an error", target, got)
+		}
+	}
+}
+
+func TestCheckTargets(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusNoContent)
+	}))
+	defer server.Close()
+
+	results := CheckTargets([]CheckTarget{
+		{Protocol: "tcp", Address: listener.Addr().String()},
+		{Protocol: "tcp", Address: closedAddr(t)},
+		{Protocol: "http", Address: server.URL},
+	})
+	if len(results) != 3 {
+		t.Fatalf("got %d results, want 3", len(results))
+	}
+	if !results[0].Reachable || results[0].Error != "" {
+		t.Errorf("open TCP target: %+v", results[0])
+	}
+	if results[1].Reachable || results[1].Error == "" {
+		t.Errorf("closed TCP target: %+v", results[1])
+	}
+	if !results[2].Reachable || results[2].Target != server.URL {
+		t.Errorf("HTTP target: %+v", results[2])
+	}
+}
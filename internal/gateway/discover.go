@@ -0,0 +1,216 @@
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"runtime"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+)
+
+// DefaultDiscoverPorts are the ports probed when looking for proxy gateways
+var DefaultDiscoverPorts = []int{80, 443, 1080, 8080}
+
+// maxDiscoverHosts bounds how many addresses are probed; larger subnets are
+// narrowed to the /22 around the interface address
+const maxDiscoverHosts = 1024
+
+// discoverConcurrency bounds the number of simultaneous connection attempts
+const discoverConcurrency = 64
+
+// GatewayCandidate is a host on the local subnet that may be a proxy gateway
+type GatewayCandidate struct {
+	IP    string `json:"ip"`
+	Ports []int  `json:"ports"`
+	ARP   bool   `json:"arp"`
+}
+
+// DiscoverGateways scans the subnet of iface for hosts accepting TCP
+// connections on any of ports or present in the ARP cache. The interface's
+// own address and its current gateway are skipped. The scan stops when
+// timeout elapses.
+func DiscoverGateways(iface *NetworkInterface, ports []int, timeout time.Duration) ([]GatewayCandidate, error) {
+	hosts, err := subnetHosts(iface.IP, iface.Subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	var (
+		mu         sync.Mutex
+		wg         sync.WaitGroup
+		candidates = make(map[string]*GatewayCandidate)
+		sem        = make(chan struct{}, discoverConcurrency)
+		dialer     = net.Dialer{Timeout: 500 * time.Millisecond}
+	)
+
+scan:
+	for _, host := range hosts {
+		if host == iface.IP || host == iface.Gateway {
+			continue
+		}
+		for _, port := range ports {
+			select {
+			case sem <- struct{}{}:
+			case <-ctx.Done():
+				break scan
+			}
+
+			wg.Add(1)
+			go func(host string, port int) {
+				defer wg.Done()
+				defer func() { <-sem }()
+
+				conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+				if err != nil {
+					return
+				}
+				conn.Close()
+
+				mu.Lock()
+				defer mu.Unlock()
+				c, ok := candidates[host]
+				if !ok {
+					c = &GatewayCandidate{IP: host}
+					candidates[host] = c
+				}
+				c.Ports = append(c.Ports, port)
+			}(host, port)
+		}
+	}
+	wg.Wait()
+
+	// Hosts that answered ARP during the sweep show up in the neighbour cache
+	inSubnet := make(map[string]bool, len(hosts))
+	for _, host := range hosts {
+		inSubnet[host] = true
+	}
+	for _, ip := range arpNeighbours() {
+		if !inSubnet[ip] || ip == iface.IP || ip == iface.Gateway {
+			continue
+		}
+		c, ok := candidates[ip]
+		if !ok {
+			c = &GatewayCandidate{IP: ip}
+			candidates[ip] = c
+		}
+		c.ARP = true
+	}
+
+	result := make([]GatewayCandidate, 0, len(candidates))
+	for _, c := range candidates {
+		sort.Ints(c.Ports)
+		result = append(result, *c)
+	}
+	sort.Slice(result, func(i, j int) bool {
+		return binary.BigEndian.Uint32(net.ParseIP(result[i].IP).To4()) < binary.BigEndian.Uint32(net.ParseIP(result[j].IP).To4())
+	})
+
+	return result, nil
+}
+
+// IsReachable reports whether host answers a TCP connection on any of ports
+// within timeout. A refused connection counts too, since the host had to be
+// up to refuse it.
+func IsReachable(host string, ports []int, timeout time.Duration) bool {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	results := make(chan bool, len(ports))
+	var dialer net.Dialer
+	for _, port := range ports {
+		go func(port int) {
+			conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, strconv.Itoa(port)))
+			if err == nil {
+				conn.Close()
+			}
+			results <- err == nil || errors.Is(err, syscall.ECONNREFUSED)
+		}(port)
+	}
+
+	for range ports {
+		if <-results {
+			return true
+		}
+	}
+	return false
+}
+
+// subnetHosts lists the host addresses of the IPv4 network containing ip
+func subnetHosts(ip, subnet string) ([]string, error) {
+	addr := net.ParseIP(ip).To4()
+	if addr == nil {
+		return nil, fmt.Errorf("interface has no IPv4 address")
+	}
+	mask, err := ParseSubnetMask(subnet)
+	if err != nil {
+		return nil, err
+	}
+
+	ones, _ := mask.Size()
+	if ones < 22 {
+		mask = net.CIDRMask(22, 32)
+		ones = 22
+	}
+	if ones >= 31 {
+		return nil, fmt.Errorf("subnet /%d has no other hosts to scan", ones)
+	}
+
+	network := binary.BigEndian.Uint32(addr.Mask(mask))
+	size := uint32(1) << (32 - ones)
+
+	hosts := make([]string, 0, size-2)
+	for i := uint32(1); i < size-1 && len(hosts) < maxDiscoverHosts; i++ {
+		b := make(net.IP, 4)
+		binary.BigEndian.PutUint32(b, network+i)
+		hosts = append(hosts, b.String())
+	}
+	return hosts, nil
+}
+
+// arpNeighbours returns the IPv4 addresses in the system ARP cache
+func arpNeighbours() []string {
+	var ips []string
+
+	if runtime.GOOS == "linux" {
+		f, err := os.Open("/proc/net/arp")
+		if err != nil {
+			return nil
+		}
+		defer f.Close()
+
+		scanner := bufio.NewScanner(f)
+		scanner.Scan() // header
+		for scanner.Scan() {
+			fields := strings.Fields(scanner.Text())
+			// Flags 0x0 marks an incomplete entry
+			if len(fields) >= 3 && fields[2] != "0x0" {
+				ips = append(ips, fields[0])
+			}
+		}
+		return ips
+	}
+
+	output, err := execCommand("arp", "-a").Output()
+	if err != nil {
+		return nil
+	}
+	for _, field := range strings.Fields(string(output)) {
+		field = strings.Trim(field, "()")
+		if ip := net.ParseIP(field); ip != nil && ip.To4() != nil {
+			ips = append(ips, field)
+		}
+	}
+	return ips
+}
@@ -0,0 +1,124 @@
+package gateway
+
+import (
+	"net"
+	"testing"
+	"time"
+)
+
+func TestParseSubnetMask(t *testing.T) {
+	tests := []struct {
+		subnet string
+		ones   int
+		ok     bool
+	}{
+		{"255.255.255.0", 24, true},
+		{"/24", 24, true},
+		{"16", 16, true},
+		{"192.168.1.0/24", 24, true},
+		{"0xffffff00", 24, true},
+		{"255.0.255.0", 0, false},
+		{"/33", 0, false},
+		{"", 0, false},
+		{"0xzz", 0, false},
+	}
+
+	for _, tt := range tests {
+		mask, err := ParseSubnetMask(tt.subnet)
+		if (err == nil) != tt.ok {
+			t.Errorf("ParseSubnetMask(%q) error = %v, want ok=%v", tt.subnet, err, tt.ok)
+			continue
+		}
+		if !tt.ok {
+			continue
+		}
+		if ones, _ := mask.Size(); ones != tt.ones {
+			t.Errorf("ParseSubnetMask(%q) = /%d, want /%d", tt.subnet, ones, tt.ones)
+		}
+	}
+}
+
+func TestSubnetHosts(t *testing.T) {
+	hosts, err := subnetHosts("192.168.1.10", "255.255.255.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 254 || hosts[0] != "192.168.1.1" || hosts[253] != "192.168.1.254" {
+		t.Errorf("unexpected /24 hosts: %d, first %s", len(hosts), hosts[0])
+	}
+
+	// Subnets wider than /22 are narrowed around the interface address
+	hosts, err = subnetHosts("10.1.2.3", "255.0.0.0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(hosts) != 1022 || hosts[0] != "10.1.0.1" {
+		t.Errorf("unexpected narrowed hosts: %d, first %s", len(hosts), hosts[0])
+	}
+
+	if _, err := subnetHosts("10.0.0.1", "/32"); err == nil {
+		t.Error("expected error for /32")
+	}
+}
+
+func TestDiscoverGateways(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	go func() {
+		for {
+			conn, err := listener.Accept()
+			if err != nil {
+				return
+			}
+			conn.Close()
+		}
+	}()
+	port := listener.Addr().(*net.TCPAddr).Port
+
+	// 127.0.0.0/30 leaves 127.0.0.1 (listening) and 127.0.0.2 (own address)
+	iface := &NetworkInterface{IP: "127.0.0.2", Subnet: "255.255.255.252"}
+	candidates, err := DiscoverGateways(iface, []int{port}, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 1 || candidates[0].IP != "127.0.0.1" || len(candidates[0].Ports) != 1 || candidates[0].Ports[0] != port {
+		t.Errorf("unexpected candidates: %+v", candidates)
+	}
+
+	// The current gateway is never reported
+	iface.Gateway = "127.0.0.1"
+	candidates, err = DiscoverGateways(iface, []int{port}, 2*time.Second)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(candidates) != 0 {
+		t.Errorf("gateway should be skipped, got %+v", candidates)
+	}
+}
+
+func TestIsReachable(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+	open := ln.Addr().(*net.TCPAddr).Port
+
+	if !IsReachable("127.0.0.1", []int{open}, time.Second) {
+		t.Error("host with an open port reported unreachable")
+	}
+
+	// A refused connection still proves the host is up
+	closed, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	port := closed.Addr().(*net.TCPAddr).Port
+	closed.Close()
+	if !IsReachable("127.0.0.1", []int{port}, time.Second) {
+		t.Error("host refusing connections reported unreachable")
+	}
+}
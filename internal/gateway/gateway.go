@@ -1,11 +1,12 @@
 package gateway
 
 import (
+	"context"
+	"errors"
 	"fmt"
 	"net"
 	"os/exec"
 	"runtime"
-	"strconv"
 	"strings"
 	"time"
 
@@ -17,76 +18,212 @@ var execCommand = exec.Command
 
 // NetworkInterface represents information about a network interface
 type NetworkInterface struct {
-	Name        string
-	ServiceName string
-	IP          string
-	Subnet      string
-	Gateway     string
+	Name        string `json:"name"`
+	ServiceName string `json:"service_name"`
+	IP          string `json:"ip"`
+	Subnet      string `json:"subnet"`
+	PrefixLen   int    `json:"prefix_len"`
+	Gateway     string `json:"gateway"`
+	IsDefault   bool   `json:"is_default"`
 }
 
 // Initialize sudo session with 15-minute timeout
 var sudoSession = utils.NewSudoSession(15 * time.Minute)
 
-// GetActiveInterface returns the currently active network interface
+// Privileged command runner, replaced in tests alongside execCommand
+var runPrivileged = func(ctx context.Context, name string, args ...string) error {
+	return sudoSession.RunWithPrivilegesContext(ctx, name, args...)
+}
+
+// rollbackTimeout bounds restoring the previous route after a failed switch,
+// which must still run when the switch itself ran out of time
+const rollbackTimeout = 30 * time.Second
+
+// Errors returned by the gateway package, wrapped with details; check them
+// with errors.Is
+var (
+	// ErrNoActiveInterface is returned by GetActiveInterface when no network
+	// interface has an IPv4 address
+	ErrNoActiveInterface = errors.New("no active network interface found")
+	// ErrNoDefaultRoute is returned when no default route, and so no gateway,
+	// is found for an interface; GetActiveInterface returns it as a
+	// *NoDefaultRouteError
+	ErrNoDefaultRoute = errors.New("no default route found")
+	// ErrUnsupportedOS is returned on operating systems other than macOS,
+	// Linux and Windows
+	ErrUnsupportedOS = errors.New("unsupported operating system")
+	// ErrGatewayUnreachable is returned when a gateway cannot be used by an
+	// interface, such as one outside its subnet
+	ErrGatewayUnreachable = errors.New("gateway unreachable")
+)
+
+// NoDefaultRouteError is returned by GetActiveInterface when interfaces have
+// an IPv4 address but none carries a default route, such as while the
+// network is being set up. It matches ErrNoDefaultRoute.
+type NoDefaultRouteError struct {
+	// Interfaces lists the interfaces with an IPv4 address
+	Interfaces []*NetworkInterface
+}
+
+func (e *NoDefaultRouteError) Error() string {
+	names := make([]string, len(e.Interfaces))
+	for i, iface := range e.Interfaces {
+		names[i] = iface.Name
+	}
+	return fmt.Sprintf("no network interface has a default route (interfaces with an address: %s)", strings.Join(names, ", "))
+}
+
+func (e *NoDefaultRouteError) Unwrap() error {
+	return ErrNoDefaultRoute
+}
+
+// unsupportedOSError builds the error returned on unsupported systems
+func unsupportedOSError() error {
+	return fmt.Errorf("%w: %s", ErrUnsupportedOS, runtime.GOOS)
+}
+
+// GetActiveInterface returns the currently active network interface. When
+// there is none it returns ErrNoActiveInterface, or a *NoDefaultRouteError
+// when interfaces have an address but no default route.
 func GetActiveInterface() (*NetworkInterface, error) {
+	return GetInterface("")
+}
+
+// GetInterface returns the network interface with the given name. If name is
+// empty, the currently active interface is detected automatically, failing
+// as GetActiveInterface does.
+func GetInterface(name string) (*NetworkInterface, error) {
+	if name != "" && !interfaceExists(name) {
+		return nil, fmt.Errorf("interface %s not found (available: %s)", name, strings.Join(availableInterfaceNames(), ", "))
+	}
+
+	var iface *NetworkInterface
+	var err error
 	switch runtime.GOOS {
 	case "darwin":
-		return getActiveMacInterface()
+		iface, err = getMacInterface(name)
 	case "linux":
-		return getActiveLinuxInterface()
+		iface, err = getLinuxInterface(name)
 	case "windows":
-		return getActiveWindowsInterface()
+		iface, err = getWindowsInterface(name)
 	default:
-		return nil, fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+		return nil, unsupportedOSError()
+	}
+
+	if name == "" && errors.Is(err, ErrNoDefaultRoute) {
+		return nil, noActiveInterfaceError(ListInterfaces())
 	}
+	return iface, err
 }
 
-// SwitchGateway changes the gateway for the active network interface
-func SwitchGateway(iface *NetworkInterface, newGateway string) error {
-	switch runtime.GOOS {
-	case "darwin":
-		return switchMacGateway(iface, newGateway)
-	case "linux":
-		return switchLinuxGateway(iface, newGateway)
-	case "windows":
-		return switchWindowsGateway(iface, newGateway)
-	default:
-		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+// noActiveInterfaceError explains why no active interface was found, given
+// the interface listing: a NoDefaultRouteError when some interfaces have an
+// IPv4 address, ErrNoActiveInterface otherwise
+func noActiveInterfaceError(ifaces []*NetworkInterface, err error) error {
+	if err != nil {
+		return fmt.Errorf("%w: %v", ErrNoActiveInterface, err)
 	}
+
+	var addressed []*NetworkInterface
+	for _, iface := range ifaces {
+		if iface.IP != "" {
+			addressed = append(addressed, iface)
+		}
+	}
+	if len(addressed) == 0 {
+		return ErrNoActiveInterface
+	}
+	return &NoDefaultRouteError{Interfaces: addressed}
 }
 
-// macOS specific implementations
-func getActiveMacInterface() (*NetworkInterface, error) {
-	// Get active interface name
-	cmd := exec.Command("route", "-n", "get", "default")
-	output, err := cmd.Output()
+// availableInterfaceNames returns the names of all non-loopback interfaces
+func availableInterfaceNames() []string {
+	interfaces, err := net.Interfaces()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get default route: %w", err)
+		return nil
 	}
 
-	outputStr := string(output)
-	interfaceLine := ""
-	for _, line := range strings.Split(outputStr, "\n") {
-		if strings.Contains(line, "interface:") {
-			interfaceLine = line
-			break
+	var names []string
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback == 0 {
+			names = append(names, iface.Name)
 		}
 	}
+	return names
+}
+
+// interfaceExists reports whether a non-loopback interface with the given name exists
+func interfaceExists(name string) bool {
+	for _, n := range availableInterfaceNames() {
+		if sameInterfaceName(n, name) {
+			return true
+		}
+	}
+	return false
+}
+
+// sameInterfaceName compares interface names; Windows interface aliases such
+// as "Wi-Fi" are case-insensitive
+func sameInterfaceName(a, b string) bool {
+	if runtime.GOOS == "windows" {
+		return strings.EqualFold(a, b)
+	}
+	return a == b
+}
 
-	if interfaceLine == "" {
-		return nil, fmt.Errorf("could not find interface line in route output")
+// noGatewayError builds the error returned when a named interface has no gateway
+func noGatewayError(name string) error {
+	return fmt.Errorf("%w: could not find gateway for interface %s (available: %s)", ErrNoDefaultRoute, name, strings.Join(availableInterfaceNames(), ", "))
+}
+
+// CheckGateway returns an error wrapping ErrGatewayUnreachable when gw is
+// not on the subnet of the interface, so a route through it cannot work. A
+// subnet that cannot be determined is not held against gw.
+func (n *NetworkInterface) CheckGateway(gw string) error {
+	if ok, err := n.InSubnet(gw); err == nil && !ok {
+		return fmt.Errorf("%w: %s is not on the subnet of %s (%s/%d)", ErrGatewayUnreachable, gw, n.Name, n.IP, n.PrefixLen)
 	}
+	return nil
+}
 
-	ifaceName := strings.TrimSpace(strings.Split(interfaceLine, ":")[1])
+// SwitchGateway changes the gateway for the active network interface. The
+// commands it runs are killed if ctx is done before they finish.
+func SwitchGateway(ctx context.Context, iface *NetworkInterface, newGateway string) error {
+	if err := iface.CheckGateway(newGateway); err != nil {
+		return err
+	}
+
+	switch runtime.GOOS {
+	case "darwin":
+		return switchMacGateway(ctx, iface, newGateway)
+	case "linux":
+		return switchLinuxGateway(ctx, iface, newGateway)
+	case "windows":
+		return switchWindowsGateway(ctx, iface, newGateway)
+	default:
+		return unsupportedOSError()
+	}
+}
+
+// macOS specific implementations
+func getMacInterface(ifaceName string) (*NetworkInterface, error) {
+	if ifaceName == "" {
+		name, err := getMacDefaultInterfaceName()
+		if err != nil {
+			// "route get default" fails when there is no default route
+			return nil, ErrNoDefaultRoute
+		}
+		ifaceName = name
+	}
 
 	// Get service name
-	cmd = exec.Command("networksetup", "-listallhardwareports")
-	output, err = cmd.Output()
+	cmd := exec.Command("networksetup", "-listallhardwareports")
+	output, err := cmd.Output()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list hardware ports: %w", err)
 	}
 
-	outputStr = string(output)
+	outputStr := string(output)
 	var serviceName string
 	lines := strings.Split(outputStr, "\n")
 	for i, line := range lines {
@@ -117,15 +254,8 @@ func getActiveMacInterface() (*NetworkInterface, error) {
 			parts := strings.Fields(line)
 			if len(parts) >= 4 {
 				ip = parts[1]
-				// Convert netmask from hex to decimal
-				netmaskHex := strings.TrimPrefix(parts[3], "0x")
-				if len(netmaskHex) == 8 {
-					a, _ := strconv.ParseInt(netmaskHex[0:2], 16, 64)
-					b, _ := strconv.ParseInt(netmaskHex[2:4], 16, 64)
-					c, _ := strconv.ParseInt(netmaskHex[4:6], 16, 64)
-					d, _ := strconv.ParseInt(netmaskHex[6:8], 16, 64)
-					subnet = fmt.Sprintf("%d.%d.%d.%d", a, b, c, d)
-				}
+				// ifconfig reports the netmask in hex, e.g. 0xffffff00
+				subnet = parts[3]
 				break
 			}
 		}
@@ -155,25 +285,45 @@ func getActiveMacInterface() (*NetworkInterface, error) {
 	}
 
 	if gateway == "" {
-		return nil, fmt.Errorf("could not find gateway for interface %s", ifaceName)
+		return nil, noGatewayError(ifaceName)
 	}
 
-	return &NetworkInterface{
+	iface := &NetworkInterface{
 		Name:        ifaceName,
 		ServiceName: serviceName,
 		IP:          ip,
-		Subnet:      subnet,
 		Gateway:     gateway,
-	}, nil
+	}
+	if err := iface.setSubnet(subnet); err != nil {
+		return nil, fmt.Errorf("invalid subnet for interface %s: %w", ifaceName, err)
+	}
+	return iface, nil
+}
+
+// getMacDefaultInterfaceName returns the interface carrying the default route
+func getMacDefaultInterfaceName() (string, error) {
+	cmd := exec.Command("route", "-n", "get", "default")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to get default route: %w", err)
+	}
+
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.Contains(line, "interface:") {
+			return strings.TrimSpace(strings.Split(line, ":")[1]), nil
+		}
+	}
+
+	return "", fmt.Errorf("could not find interface line in route output")
 }
 
-func switchMacGateway(iface *NetworkInterface, newGateway string) error {
+func switchMacGateway(ctx context.Context, iface *NetworkInterface, newGateway string) error {
 	// Use networksetup to change the gateway with sudo privileges
-	return sudoSession.RunWithPrivileges("networksetup", "-setmanual", iface.ServiceName, iface.IP, iface.Subnet, newGateway)
+	return runPrivileged(ctx, "networksetup", "-setmanual", iface.ServiceName, iface.IP, iface.Subnet, newGateway)
 }
 
 // Linux specific implementations
-func getActiveLinuxInterface() (*NetworkInterface, error) {
+func getLinuxInterface(name string) (*NetworkInterface, error) {
 	// Get active interface name
 	interfaces, err := net.Interfaces()
 	if err != nil {
@@ -181,6 +331,10 @@ func getActiveLinuxInterface() (*NetworkInterface, error) {
 	}
 
 	for _, iface := range interfaces {
+		if name != "" && iface.Name != name {
+			continue
+		}
+
 		if iface.Flags&net.FlagUp != 0 && iface.Flags&net.FlagLoopback == 0 {
 			addrs, err := iface.Addrs()
 			if err != nil {
@@ -213,11 +367,13 @@ func getActiveLinuxInterface() (*NetworkInterface, error) {
 						continue // Try next interface if no gateway found
 					}
 
+					ones, _ := ipnet.Mask.Size()
 					return &NetworkInterface{
 						Name:        iface.Name,
 						ServiceName: iface.Name, // Linux doesn't have separate service names
 						IP:          ipnet.IP.String(),
-						Subnet:      net.IP(ipnet.Mask).String(),
+						Subnet:      net.IP(net.CIDRMask(ones, 32)).String(),
+						PrefixLen:   ones,
 						Gateway:     gateway,
 					}, nil
 				}
@@ -225,92 +381,114 @@ func getActiveLinuxInterface() (*NetworkInterface, error) {
 		}
 	}
 
-	return nil, fmt.Errorf("no active network interface found")
+	if name != "" {
+		return nil, noGatewayError(name)
+	}
+	return nil, ErrNoDefaultRoute
 }
 
-func switchLinuxGateway(iface *NetworkInterface, newGateway string) error {
+func switchLinuxGateway(ctx context.Context, iface *NetworkInterface, newGateway string) error {
+	// NetworkManager reverts raw route changes on static profiles at the next
+	// connection event, so make the change through it for those
+	if conn := NetworkManagerConnection(iface.Name); conn != "" && usesManualIPv4(conn) {
+		return switchNetworkManagerGateway(ctx, iface.Name, newGateway)
+	}
+
+	// Capture the current default route so it can be restored if the add fails
+	oldRoute, err := getLinuxDefaultRoute()
+	if err != nil {
+		return err
+	}
+
 	// First delete the existing default route with sudo
-	if err := sudoSession.RunWithPrivileges("ip", "route", "del", "default"); err != nil {
+	if err := runPrivileged(ctx, "ip", "route", "del", "default"); err != nil {
 		return fmt.Errorf("failed to delete default route: %w", err)
 	}
 
 	// Add the new default route with sudo
-	return sudoSession.RunWithPrivileges("ip", "route", "add", "default", "via", newGateway, "dev", iface.Name)
+	addErr := runPrivileged(ctx, "ip", "route", "add", "default", "via", newGateway, "dev", iface.Name)
+	if addErr == nil {
+		return nil
+	}
+
+	if len(oldRoute) == 0 {
+		return fmt.Errorf("deleted default route but failed to add route via %s: %w (no previous route to restore)", newGateway, addErr)
+	}
+
+	// Put the previous default route back so the machine is not left offline,
+	// even if ctx has already expired
+	rollbackCtx, cancel := context.WithTimeout(context.Background(), rollbackTimeout)
+	defer cancel()
+	restoreArgs := append([]string{"route", "add"}, oldRoute...)
+	if restoreErr := runPrivileged(rollbackCtx, "ip", restoreArgs...); restoreErr != nil {
+		return fmt.Errorf("deleted default route but failed to add route via %s: %v; restoring previous route (%s) also failed: %w",
+			newGateway, addErr, strings.Join(oldRoute, " "), restoreErr)
+	}
+
+	return fmt.Errorf("deleted default route but failed to add route via %s: %w; previous route (%s) restored",
+		newGateway, addErr, strings.Join(oldRoute, " "))
 }
 
-// Windows specific implementations
-func getActiveWindowsInterface() (*NetworkInterface, error) {
-	// Get interface information
-	cmd := exec.Command("netsh", "interface", "ip", "show", "config")
-	output, err := cmd.Output()
+// getLinuxDefaultRoute returns the fields of the first default route, which is
+// the one removed by "ip route del default". It returns nil if there is none.
+func getLinuxDefaultRoute() ([]string, error) {
+	output, err := execCommand("ip", "route", "show", "default").Output()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get interface config: %w", err)
+		return nil, fmt.Errorf("failed to get default route: %w", err)
 	}
 
-	outputStr := string(output)
-	var (
-		currentInterface    string
-		ip, subnet, gateway string
-	)
-
-	lines := strings.Split(outputStr, "\n")
-	for _, line := range lines {
-		line = strings.TrimSpace(line)
-		if strings.Contains(line, "Configuration for interface") {
-			currentInterface = strings.Trim(strings.Split(line, "\"")[1], "\"")
+	for _, line := range strings.Split(string(output), "\n") {
+		if !strings.HasPrefix(line, "default") {
+			continue
 		}
 
-		if currentInterface != "" {
-			if strings.Contains(line, "IP Address:") {
-				ip = strings.TrimSpace(strings.TrimPrefix(line, "IP Address:"))
-			} else if strings.Contains(line, "Subnet Prefix:") {
-				subnet = strings.TrimSpace(strings.Split(line, "(")[0])
-				subnet = strings.TrimSpace(strings.TrimPrefix(subnet, "Subnet Prefix:"))
-			} else if strings.Contains(line, "Default Gateway:") {
-				gateway = strings.TrimSpace(strings.TrimPrefix(line, "Default Gateway:"))
+		var fields []string
+		for _, field := range strings.Fields(line) {
+			// Route flags reported by "ip route show" are not accepted by "ip route add"
+			if field == "linkdown" || field == "dead" {
+				continue
 			}
+			fields = append(fields, field)
+		}
+		return fields, nil
+	}
 
-			// If we have all the information, check if the interface is active
-			if ip != "" && subnet != "" && gateway != "" {
-				// Ping test to verify the interface is active
-				pingCmd := exec.Command("ping", "-n", "1", "-w", "1000", "8.8.8.8")
-				if pingCmd.Run() == nil {
-					return &NetworkInterface{
-						Name:        currentInterface,
-						ServiceName: currentInterface,
-						IP:          ip,
-						Subnet:      subnet,
-						Gateway:     gateway,
-					}, nil
-				}
+	return nil, nil
+}
 
-				// Reset for next interface
-				currentInterface = ""
-				ip, subnet, gateway = "", "", ""
+// Windows specific implementations
+func getWindowsInterface(name string) (*NetworkInterface, error) {
+	ifaces, best, err := loadWindowsInterfaces()
+	if err != nil {
+		return nil, err
+	}
+
+	// A named interface (its alias, e.g. "Wi-Fi") is used as-is
+	if name != "" {
+		for _, iface := range ifaces {
+			if sameInterfaceName(iface.Name, name) && usableWindowsInterface(iface) {
+				return iface, nil
 			}
 		}
+		return nil, noGatewayError(name)
 	}
 
-	return nil, fmt.Errorf("no active network interface found")
+	if best == nil {
+		return nil, ErrNoDefaultRoute
+	}
+	return best, nil
 }
 
-func switchWindowsGateway(iface *NetworkInterface, newGateway string) error {
+func switchWindowsGateway(ctx context.Context, iface *NetworkInterface, newGateway string) error {
 	// Windows requires administrative privileges to change the gateway
-	return sudoSession.RunWithPrivileges("netsh", "interface", "ip", "set", "address",
+	return runPrivileged(ctx, "netsh", "interface", "ip", "set", "address",
 		fmt.Sprintf("name=\"%s\"", iface.Name), "gateway="+newGateway)
 }
 
-// CheckInternetConnectivity verifies if there's internet connectivity
-func CheckInternetConnectivity() bool {
-	// Ping Google's DNS to check internet connectivity
-	cmd := exec.Command("ping", "-c", "1", "-W", "1", "8.8.8.8")
-	return cmd.Run() == nil
-}
-
 // String returns a string representation of the NetworkInterface
 func (n *NetworkInterface) String() string {
-	return fmt.Sprintf("Interface: %s (%s)\nIP: %s\nSubnet: %s\nGateway: %s",
-		n.Name, n.ServiceName, n.IP, n.Subnet, n.Gateway)
+	return fmt.Sprintf("Interface: %s (%s)\nIP: %s\nSubnet: %s (/%d)\nGateway: %s",
+		n.Name, n.ServiceName, n.IP, n.Subnet, n.PrefixLen, n.Gateway)
 }
 
 // IsPrivateIP checks if an IP address is private
@@ -0,0 +1,185 @@
+package gateway
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+)
+
+// fakeExecCommand returns an execCommand replacement that re-runs the test
+// binary as TestHelperProcess, printing outputs[command line] on stdout
+func fakeExecCommand(outputs map[string]string) func(string, ...string) *exec.Cmd {
+	return func(name string, args ...string) *exec.Cmd {
+		line := strings.Join(append([]string{name}, args...), " ")
+		cmd := exec.Command(os.Args[0], "-test.run=TestHelperProcess")
+		cmd.Env = append(os.Environ(), "GATESHIFT_HELPER_PROCESS=1", "GATESHIFT_HELPER_OUTPUT="+outputs[line])
+		return cmd
+	}
+}
+
+func TestHelperProcess(t *testing.T) {
+	if os.Getenv("GATESHIFT_HELPER_PROCESS") != "1" {
+		return
+	}
+	fmt.Print(os.Getenv("GATESHIFT_HELPER_OUTPUT"))
+	os.Exit(0)
+}
+
+func TestSwitchLinuxGatewayRestoresRouteOnAddFailure(t *testing.T) {
+	oldExec, oldRun := execCommand, runPrivileged
+	defer func() { execCommand, runPrivileged = oldExec, oldRun }()
+
+	execCommand = fakeExecCommand(map[string]string{
+		"ip route show default": "default via 192.168.1.1 dev eth0 proto dhcp metric 100 linkdown\n",
+	})
+
+	var calls []string
+	runPrivileged = func(ctx context.Context, name string, args ...string) error {
+		line := strings.Join(append([]string{name}, args...), " ")
+		calls = append(calls, line)
+		if strings.HasPrefix(line, "ip route add default via 192.168.1.2") {
+			return errors.New("RTNETLINK answers: Network is unreachable")
+		}
+		return nil
+	}
+
+	err := switchLinuxGateway(context.Background(), &NetworkInterface{Name: "eth0"}, "192.168.1.2")
+	if err == nil || !strings.Contains(err.Error(), "previous route") || !strings.Contains(err.Error(), "restored") {
+		t.Fatalf("expected restored-route error, got %v", err)
+	}
+
+	want := []string{
+		"ip route del default",
+		"ip route add default via 192.168.1.2 dev eth0",
+		"ip route add default via 192.168.1.1 dev eth0 proto dhcp metric 100",
+	}
+	if strings.Join(calls, "\n") != strings.Join(want, "\n") {
+		t.Errorf("unexpected privileged commands:\n%s\nwant:\n%s", strings.Join(calls, "\n"), strings.Join(want, "\n"))
+	}
+}
+
+func TestSwitchLinuxGatewayRestoresRouteAfterTimeout(t *testing.T) {
+	oldExec, oldRun := execCommand, runPrivileged
+	defer func() { execCommand, runPrivileged = oldExec, oldRun }()
+
+	execCommand = fakeExecCommand(map[string]string{
+		"ip route show default": "default via 192.168.1.1 dev eth0\n",
+	})
+
+	// The deadline passes right after the old route is deleted
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	var restored bool
+	runPrivileged = func(ctx context.Context, name string, args ...string) error {
+		if err := ctx.Err(); err != nil {
+			return err
+		}
+		switch strings.Join(args, " ") {
+		case "route del default":
+			cancel()
+		case "route add default via 192.168.1.1 dev eth0":
+			restored = true
+		}
+		return nil
+	}
+
+	err := switchLinuxGateway(ctx, &NetworkInterface{Name: "eth0"}, "192.168.1.2")
+	if !errors.Is(err, context.Canceled) || !strings.Contains(err.Error(), "restored") {
+		t.Fatalf("expected canceled, restored-route error, got %v", err)
+	}
+	if !restored {
+		t.Error("previous route not restored after the switch ran out of time")
+	}
+}
+
+func TestSwitchLinuxGatewayNoPreviousRoute(t *testing.T) {
+	oldExec, oldRun := execCommand, runPrivileged
+	defer func() { execCommand, runPrivileged = oldExec, oldRun }()
+
+	execCommand = fakeExecCommand(map[string]string{})
+
+	var calls int
+	runPrivileged = func(ctx context.Context, name string, args ...string) error {
+		calls++
+		if len(args) > 1 && args[1] == "add" {
+			return errors.New("add failed")
+		}
+		return nil
+	}
+
+	err := switchLinuxGateway(context.Background(), &NetworkInterface{Name: "eth0"}, "192.168.1.2")
+	if err == nil || !strings.Contains(err.Error(), "no previous route") {
+		t.Fatalf("expected no-previous-route error, got %v", err)
+	}
+	if calls != 2 {
+		t.Errorf("expected 2 privileged commands, got %d", calls)
+	}
+}
+
+func TestNoActiveInterfaceError(t *testing.T) {
+	ifaces := []*NetworkInterface{
+		{Name: "eth0", IP: "192.168.1.20", PrefixLen: 24},
+		{Name: "wlan0"},
+	}
+	err := noActiveInterfaceError(ifaces, nil)
+	var noRoute *NoDefaultRouteError
+	if !errors.As(err, &noRoute) || !errors.Is(err, ErrNoDefaultRoute) {
+		t.Fatalf("expected NoDefaultRouteError, got %v", err)
+	}
+	if len(noRoute.Interfaces) != 1 || noRoute.Interfaces[0].Name != "eth0" {
+		t.Errorf("expected only eth0 to be listed, got %+v", noRoute.Interfaces)
+	}
+
+	if err := noActiveInterfaceError([]*NetworkInterface{{Name: "wlan0"}}, nil); !errors.Is(err, ErrNoActiveInterface) {
+		t.Errorf("expected ErrNoActiveInterface without addresses, got %v", err)
+	}
+	if err := noActiveInterfaceError(nil, errors.New("listing failed")); !errors.Is(err, ErrNoActiveInterface) {
+		t.Errorf("expected ErrNoActiveInterface when listing fails, got %v", err)
+	}
+}
+
+func TestGatewayErrors(t *testing.T) {
+	if err := noGatewayError("eth0"); !errors.Is(err, ErrNoDefaultRoute) {
+		t.Errorf("noGatewayError = %v, want ErrNoDefaultRoute", err)
+	}
+	if err := unsupportedOSError(); !errors.Is(err, ErrUnsupportedOS) {
+		t.Errorf("unsupportedOSError = %v, want ErrUnsupportedOS", err)
+	}
+}
+
+func TestCheckGateway(t *testing.T) {
+	iface := &NetworkInterface{Name: "eth0", IP: "192.168.1.20", Subnet: "255.255.255.0", PrefixLen: 24}
+	if err := iface.CheckGateway("192.168.1.2"); err != nil {
+		t.Errorf("gateway on the subnet rejected: %v", err)
+	}
+	if err := iface.CheckGateway("10.0.0.1"); !errors.Is(err, ErrGatewayUnreachable) {
+		t.Errorf("gateway off the subnet: got %v, want ErrGatewayUnreachable", err)
+	}
+	if err := (&NetworkInterface{Name: "eth0"}).CheckGateway("10.0.0.1"); err != nil {
+		t.Errorf("gateway rejected with an unknown subnet: %v", err)
+	}
+}
+
+func TestSwitchGatewayRejectsUnreachableGateway(t *testing.T) {
+	oldRun := runPrivileged
+	defer func() { runPrivileged = oldRun }()
+
+	var calls int
+	runPrivileged = func(ctx context.Context, name string, args ...string) error {
+		calls++
+		return nil
+	}
+
+	iface := &NetworkInterface{Name: "eth0", ServiceName: "eth0", IP: "192.168.1.20", Subnet: "255.255.255.0", PrefixLen: 24}
+	err := SwitchGateway(context.Background(), iface, "10.0.0.1")
+	if !errors.Is(err, ErrGatewayUnreachable) {
+		t.Fatalf("expected ErrGatewayUnreachable, got %v", err)
+	}
+	if calls != 0 {
+		t.Errorf("expected no privileged commands, got %d", calls)
+	}
+}
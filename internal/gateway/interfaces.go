@@ -0,0 +1,358 @@
+package gateway
+
+import (
+	"fmt"
+	"net"
+	"os/exec"
+	"runtime"
+	"strconv"
+	"strings"
+)
+
+// ListInterfaces returns every non-loopback network interface along with its
+// gateway, marking the one that currently carries the default route
+func ListInterfaces() ([]*NetworkInterface, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return listMacInterfaces()
+	case "linux":
+		return listLinuxInterfaces()
+	case "windows":
+		return listWindowsInterfaces()
+	default:
+		return nil, unsupportedOSError()
+	}
+}
+
+// interfaceIPv4 returns the first IPv4 address and dotted subnet mask of an interface
+func interfaceIPv4(iface net.Interface) (string, string) {
+	addrs, err := iface.Addrs()
+	if err != nil {
+		return "", ""
+	}
+
+	for _, addr := range addrs {
+		ipnet, ok := addr.(*net.IPNet)
+		if !ok || ipnet.IP.To4() == nil {
+			continue
+		}
+		mask := ipnet.Mask
+		if len(mask) == net.IPv6len {
+			mask = mask[12:]
+		}
+		return ipnet.IP.String(), net.IP(mask).String()
+	}
+
+	return "", ""
+}
+
+// newInterface builds a NetworkInterface for a listing, normalizing subnet
+// where it is known
+func newInterface(name, serviceName, ip, subnet, gw string, isDefault bool) *NetworkInterface {
+	iface := &NetworkInterface{
+		Name:        name,
+		ServiceName: serviceName,
+		IP:          ip,
+		Gateway:     gw,
+		IsDefault:   isDefault,
+	}
+	if subnet != "" {
+		// An unparsable subnet is kept as reported, with no prefix length
+		_ = iface.setSubnet(subnet)
+	}
+	return iface
+}
+
+// macOS specific implementations
+func listMacInterfaces() ([]*NetworkInterface, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+
+	// Map devices to their hardware port (service) names
+	output, err := exec.Command("networksetup", "-listallhardwareports").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list hardware ports: %w", err)
+	}
+
+	services := make(map[string]string)
+	var port string
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.HasPrefix(line, "Hardware Port:") {
+			port = strings.TrimSpace(strings.TrimPrefix(line, "Hardware Port:"))
+		} else if strings.HasPrefix(line, "Device:") && port != "" {
+			services[strings.TrimSpace(strings.TrimPrefix(line, "Device:"))] = port
+			port = ""
+		}
+	}
+
+	// Collect the IPv4 default gateway of each interface
+	output, err = exec.Command("netstat", "-nr", "-f", "inet").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get routing table: %w", err)
+	}
+
+	gateways := make(map[string]string)
+	for _, line := range strings.Split(string(output), "\n") {
+		parts := strings.Fields(line)
+		if len(parts) >= 4 && parts[0] == "default" {
+			if _, ok := gateways[parts[3]]; !ok {
+				gateways[parts[3]] = parts[1]
+			}
+		}
+	}
+
+	defaultName, _ := getMacDefaultInterfaceName()
+
+	var result []*NetworkInterface
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		ip, subnet := interfaceIPv4(iface)
+		result = append(result, newInterface(iface.Name, services[iface.Name], ip, subnet,
+			gateways[iface.Name], iface.Name == defaultName))
+	}
+
+	return result, nil
+}
+
+// Linux specific implementations
+func listLinuxInterfaces() ([]*NetworkInterface, error) {
+	interfaces, err := net.Interfaces()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list interfaces: %w", err)
+	}
+
+	output, err := execCommand("ip", "route", "show", "default").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to get default route: %w", err)
+	}
+
+	// The first default route listed is the one in use
+	gateways := make(map[string]string)
+	var defaultName string
+	for _, line := range strings.Split(string(output), "\n") {
+		parts := strings.Fields(line)
+		if len(parts) < 5 || parts[0] != "default" || parts[1] != "via" {
+			continue
+		}
+		for i := 3; i < len(parts)-1; i++ {
+			if parts[i] != "dev" {
+				continue
+			}
+			dev := parts[i+1]
+			if _, ok := gateways[dev]; !ok {
+				gateways[dev] = parts[2]
+			}
+			if defaultName == "" {
+				defaultName = dev
+			}
+			break
+		}
+	}
+
+	var result []*NetworkInterface
+	for _, iface := range interfaces {
+		if iface.Flags&net.FlagLoopback != 0 {
+			continue
+		}
+
+		ip, subnet := interfaceIPv4(iface)
+		// Linux doesn't have separate service names
+		result = append(result, newInterface(iface.Name, iface.Name, ip, subnet,
+			gateways[iface.Name], iface.Name == defaultName))
+	}
+
+	return result, nil
+}
+
+// Windows specific implementations
+func listWindowsInterfaces() ([]*NetworkInterface, error) {
+	result, _, err := loadWindowsInterfaces()
+	return result, err
+}
+
+// windowsRoute is an active IPv4 default route reported by "route print"
+type windowsRoute struct {
+	gateway     string
+	interfaceIP string
+	metric      int
+}
+
+// loadWindowsInterfaces enumerates every interface with its configuration
+// and returns them along with the one carrying the default route, which is
+// marked IsDefault
+func loadWindowsInterfaces() ([]*NetworkInterface, *NetworkInterface, error) {
+	output, err := execCommand("netsh", "interface", "ip", "show", "config").Output()
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to get interface config: %w", err)
+	}
+	ifaces, metrics := parseWindowsConfig(string(output))
+
+	// Without the routing table the interface metrics still give an answer
+	var routes []windowsRoute
+	if output, err := execCommand("route", "print", "-4", "0.0.0.0").Output(); err == nil {
+		routes = parseWindowsDefaultRoutes(string(output))
+	}
+
+	best := selectWindowsDefault(ifaces, metrics, routes, hasConnectivityFrom)
+	if best != nil {
+		best.IsDefault = true
+	}
+	return ifaces, best, nil
+}
+
+// usableWindowsInterface reports whether iface has the address, subnet and
+// gateway needed to switch gateways
+func usableWindowsInterface(iface *NetworkInterface) bool {
+	return iface.IP != "" && iface.Subnet != "" && iface.Gateway != ""
+}
+
+// selectWindowsDefault picks the interface that owns the default route with
+// the lowest metric. When no interface owns a default route, the interface
+// metric decides instead. Interfaces that tie are told apart by whether the
+// connectivity check succeeds from their address.
+func selectWindowsDefault(ifaces []*NetworkInterface, metrics map[string]int, routes []windowsRoute, connected func(localIP string) bool) *NetworkInterface {
+	routeMetric := func(iface *NetworkInterface) (int, bool) {
+		metric, found := 0, false
+		for _, route := range routes {
+			if route.interfaceIP == iface.IP && (!found || route.metric < metric) {
+				metric, found = route.metric, true
+			}
+		}
+		return metric, found
+	}
+
+	useRoutes := false
+	for _, iface := range ifaces {
+		if _, ok := routeMetric(iface); ok && usableWindowsInterface(iface) {
+			useRoutes = true
+			break
+		}
+	}
+
+	var candidates []*NetworkInterface
+	bestMetric := 0
+	for _, iface := range ifaces {
+		if !usableWindowsInterface(iface) {
+			continue
+		}
+		metric := metrics[iface.Name]
+		if useRoutes {
+			var ok bool
+			if metric, ok = routeMetric(iface); !ok {
+				continue
+			}
+		}
+		switch {
+		case len(candidates) == 0 || metric < bestMetric:
+			candidates, bestMetric = []*NetworkInterface{iface}, metric
+		case metric == bestMetric:
+			candidates = append(candidates, iface)
+		}
+	}
+
+	if len(candidates) == 0 {
+		return nil
+	}
+	if len(candidates) > 1 {
+		for _, iface := range candidates {
+			if connected(iface.IP) {
+				return iface
+			}
+		}
+	}
+	return candidates[0]
+}
+
+// parseWindowsDefaultRoutes parses the active IPv4 default routes from the
+// output of "route print -4 0.0.0.0". Each route names the interface by its
+// address, e.g.
+//
+//	Network Destination        Netmask          Gateway       Interface  Metric
+//	          0.0.0.0          0.0.0.0      192.168.1.1    192.168.1.20     35
+func parseWindowsDefaultRoutes(output string) []windowsRoute {
+	var routes []windowsRoute
+	active := false
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		switch {
+		case strings.HasPrefix(line, "Active Routes:"):
+			active = true
+			continue
+		case strings.HasPrefix(line, "Persistent Routes:"):
+			active = false
+			continue
+		}
+		if !active {
+			continue
+		}
+
+		fields := strings.Fields(line)
+		if len(fields) != 5 || fields[0] != "0.0.0.0" || fields[1] != "0.0.0.0" {
+			continue
+		}
+		metric, err := strconv.Atoi(fields[4])
+		if err != nil || net.ParseIP(fields[3]) == nil {
+			continue
+		}
+		routes = append(routes, windowsRoute{gateway: fields[2], interfaceIP: fields[3], metric: metric})
+	}
+	return routes
+}
+
+// parseWindowsConfig parses every interface block in the output of
+// "netsh interface ip show config", returning the interfaces and their metrics
+func parseWindowsConfig(output string) ([]*NetworkInterface, map[string]int) {
+	var result []*NetworkInterface
+	metrics := make(map[string]int)
+	var current *NetworkInterface
+
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if strings.Contains(line, "Configuration for interface") {
+			parts := strings.Split(line, "\"")
+			if len(parts) < 2 {
+				continue
+			}
+			// The loopback pseudo-interface has no gateway to switch
+			if strings.HasPrefix(parts[1], "Loopback Pseudo-Interface") {
+				current = nil
+				continue
+			}
+			current = &NetworkInterface{Name: parts[1], ServiceName: parts[1]}
+			result = append(result, current)
+			continue
+		}
+
+		if current == nil {
+			continue
+		}
+
+		if strings.HasPrefix(line, "IP Address:") && current.IP == "" {
+			current.IP = strings.TrimSpace(strings.TrimPrefix(line, "IP Address:"))
+		} else if strings.HasPrefix(line, "Subnet Prefix:") && current.Subnet == "" {
+			subnet := strings.TrimSpace(strings.Split(line, "(")[0])
+			current.Subnet = strings.TrimSpace(strings.TrimPrefix(subnet, "Subnet Prefix:"))
+		} else if strings.HasPrefix(line, "Default Gateway:") && current.Gateway == "" {
+			current.Gateway = strings.TrimSpace(strings.TrimPrefix(line, "Default Gateway:"))
+		} else if strings.HasPrefix(line, "InterfaceMetric:") {
+			metric, err := strconv.Atoi(strings.TrimSpace(strings.TrimPrefix(line, "InterfaceMetric:")))
+			if err == nil {
+				metrics[current.Name] = metric
+			}
+		}
+	}
+
+	// netsh reports the subnet as a network prefix, e.g. 192.168.1.0/24
+	for _, iface := range result {
+		if iface.Subnet != "" {
+			_ = iface.setSubnet(iface.Subnet)
+		}
+	}
+
+	return result, metrics
+}
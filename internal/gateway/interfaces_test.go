@@ -0,0 +1,165 @@
+package gateway
+
+import "testing"
+
+const netshConfigOutput = `
+Configuration for interface "Ethernet"
+    DHCP enabled:                         Yes
+    IP Address:                           192.168.1.20
+    Subnet Prefix:                        192.168.1.0/24 (mask 255.255.255.0)
+    Default Gateway:                      192.168.1.1
+    Gateway Metric:                       0
+    InterfaceMetric:                      25
+
+Configuration for interface "Wi-Fi 2"
+    DHCP enabled:                         Yes
+    IP Address:                           10.0.0.5
+    Subnet Prefix:                        10.0.0.0/24 (mask 255.255.255.0)
+    Default Gateway:                      10.0.0.1
+    Gateway Metric:                       0
+    InterfaceMetric:                      50
+
+Configuration for interface "Loopback Pseudo-Interface 1"
+    DHCP enabled:                         No
+    IP Address:                           127.0.0.1
+    Subnet Prefix:                        127.0.0.0/8 (mask 255.0.0.0)
+    InterfaceMetric:                      75
+`
+
+func TestParseWindowsConfig(t *testing.T) {
+	ifaces, metrics := parseWindowsConfig(netshConfigOutput)
+	if len(ifaces) != 2 {
+		t.Fatalf("got %d interfaces, want 2 (loopback filtered)", len(ifaces))
+	}
+
+	eth := ifaces[0]
+	if eth.Name != "Ethernet" || eth.IP != "192.168.1.20" || eth.Subnet != "255.255.255.0" || eth.PrefixLen != 24 || eth.Gateway != "192.168.1.1" {
+		t.Errorf("unexpected Ethernet: %+v", eth)
+	}
+	if ifaces[1].Name != "Wi-Fi 2" || metrics["Wi-Fi 2"] != 50 || metrics["Ethernet"] != 25 {
+		t.Errorf("unexpected Wi-Fi 2 or metrics: %+v %v", ifaces[1], metrics)
+	}
+}
+
+func TestGetWindowsInterfaceByName(t *testing.T) {
+	oldExec := execCommand
+	defer func() { execCommand = oldExec }()
+	execCommand = fakeExecCommand(map[string]string{
+		"netsh interface ip show config": netshConfigOutput,
+	})
+
+	iface, err := getWindowsInterface("Wi-Fi 2")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iface.IP != "10.0.0.5" || iface.Gateway != "10.0.0.1" {
+		t.Errorf("unexpected interface: %+v", iface)
+	}
+
+	if _, err := getWindowsInterface("Loopback Pseudo-Interface 1"); err == nil {
+		t.Error("expected loopback pseudo-interface to be rejected")
+	}
+}
+
+// routePrintOutput is "route print -4 0.0.0.0" on a machine whose VPN on
+// "Wi-Fi 2" carries the default route with a lower metric than Ethernet
+const routePrintOutput = `===========================================================================
+Interface List
+ 12...00 15 5d 01 02 03 ......Intel(R) Ethernet Connection
+ 18...00 15 5d 0a 0b 0c ......Intel(R) Wi-Fi 6 AX201
+  1...........................Software Loopback Interface 1
+===========================================================================
+
+IPv4 Route Table
+===========================================================================
+Active Routes:
+Network Destination        Netmask          Gateway       Interface  Metric
+          0.0.0.0          0.0.0.0      192.168.1.1    192.168.1.20     35
+          0.0.0.0          0.0.0.0         10.0.0.1        10.0.0.5      5
+===========================================================================
+Persistent Routes:
+  Network Address          Netmask  Gateway Address  Metric
+          0.0.0.0          0.0.0.0      192.168.1.254  Default
+===========================================================================
+`
+
+func TestParseWindowsDefaultRoutes(t *testing.T) {
+	routes := parseWindowsDefaultRoutes(routePrintOutput)
+	want := []windowsRoute{
+		{gateway: "192.168.1.1", interfaceIP: "192.168.1.20", metric: 35},
+		{gateway: "10.0.0.1", interfaceIP: "10.0.0.5", metric: 5},
+	}
+	if len(routes) != len(want) {
+		t.Fatalf("got %d routes, want %d: %+v", len(routes), len(want), routes)
+	}
+	for i := range want {
+		if routes[i] != want[i] {
+			t.Errorf("route %d = %+v, want %+v", i, routes[i], want[i])
+		}
+	}
+}
+
+func TestSelectWindowsDefault(t *testing.T) {
+	ifaces, metrics := parseWindowsConfig(netshConfigOutput)
+	offline := func(string) bool { return false }
+
+	// The default route owner wins over the lower interface metric
+	if got := selectWindowsDefault(ifaces, metrics, parseWindowsDefaultRoutes(routePrintOutput), offline); got == nil || got.Name != "Wi-Fi 2" {
+		t.Errorf("with routes: got %v, want Wi-Fi 2", got)
+	}
+
+	// Without routes the interface metric decides
+	if got := selectWindowsDefault(ifaces, metrics, nil, offline); got == nil || got.Name != "Ethernet" {
+		t.Errorf("without routes: got %v, want Ethernet", got)
+	}
+
+	// Routes through unknown interfaces are ignored
+	unknown := []windowsRoute{{gateway: "172.16.0.1", interfaceIP: "172.16.0.9", metric: 1}}
+	if got := selectWindowsDefault(ifaces, metrics, unknown, offline); got == nil || got.Name != "Ethernet" {
+		t.Errorf("with unknown routes: got %v, want Ethernet", got)
+	}
+
+	// Equal route metrics are broken by the connectivity check
+	tied := []windowsRoute{
+		{gateway: "192.168.1.1", interfaceIP: "192.168.1.20", metric: 35},
+		{gateway: "10.0.0.1", interfaceIP: "10.0.0.5", metric: 35},
+	}
+	var probed []string
+	connected := func(ip string) bool {
+		probed = append(probed, ip)
+		return ip == "10.0.0.5"
+	}
+	if got := selectWindowsDefault(ifaces, metrics, tied, connected); got == nil || got.Name != "Wi-Fi 2" {
+		t.Errorf("tied routes: got %v, want Wi-Fi 2", got)
+	}
+	if len(probed) != 2 {
+		t.Errorf("probed %v, want both tied interfaces", probed)
+	}
+}
+
+func TestGetWindowsInterfaceFollowsDefaultRoute(t *testing.T) {
+	oldExec := execCommand
+	defer func() { execCommand = oldExec }()
+	execCommand = fakeExecCommand(map[string]string{
+		"netsh interface ip show config": netshConfigOutput,
+		"route print -4 0.0.0.0":         routePrintOutput,
+	})
+
+	iface, err := getWindowsInterface("")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if iface.Name != "Wi-Fi 2" || !iface.IsDefault {
+		t.Errorf("got %+v, want Wi-Fi 2 as the default interface", iface)
+	}
+
+	ifaces, err := listWindowsInterfaces()
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, iface := range ifaces {
+		if iface.IsDefault != (iface.Name == "Wi-Fi 2") {
+			t.Errorf("%s IsDefault = %v", iface.Name, iface.IsDefault)
+		}
+	}
+}
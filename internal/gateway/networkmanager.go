@@ -0,0 +1,61 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// NetworkManagerConnection returns the name of the NetworkManager connection
+// active on device. It returns "" when nmcli is not installed, NetworkManager
+// is not running, or the device is not managed by it.
+func NetworkManagerConnection(device string) string {
+	if _, err := exec.LookPath("nmcli"); err != nil {
+		return ""
+	}
+
+	output, err := execCommand("nmcli", "-t", "-f", "RUNNING", "general").Output()
+	if err != nil || strings.TrimSpace(string(output)) != "running" {
+		return ""
+	}
+
+	output, err = execCommand("nmcli", "-g", "GENERAL.CONNECTION", "device", "show", device).Output()
+	if err != nil {
+		return ""
+	}
+
+	conn := strings.TrimSpace(string(output))
+	if conn == "--" {
+		return ""
+	}
+	return conn
+}
+
+// NetworkManagerSetting returns the value of a setting of a NetworkManager
+// connection, e.g. "ipv4.gateway"
+func NetworkManagerSetting(conn, setting string) (string, error) {
+	output, err := execCommand("nmcli", "-g", setting, "connection", "show", conn).Output()
+	if err != nil {
+		return "", fmt.Errorf("failed to read %s of connection %s: %w", setting, conn, err)
+	}
+	return strings.TrimSpace(string(output)), nil
+}
+
+// usesManualIPv4 reports whether a NetworkManager connection has a static
+// IPv4 configuration. NetworkManager rejects a gateway on DHCP profiles that
+// have no ipv4.addresses, so only these are switched through it.
+func usesManualIPv4(conn string) bool {
+	method, err := NetworkManagerSetting(conn, "ipv4.method")
+	return err == nil && method == "manual"
+}
+
+// switchNetworkManagerGateway changes the gateway of the connection active on
+// device. "nmcli device modify" applies the change to the running device only,
+// so the saved connection profile keeps its configured gateway.
+func switchNetworkManagerGateway(ctx context.Context, device, newGateway string) error {
+	if err := runPrivileged(ctx, "nmcli", "device", "modify", device, "ipv4.gateway", newGateway); err != nil {
+		return fmt.Errorf("failed to set gateway of device %s: %w", device, err)
+	}
+	return nil
+}
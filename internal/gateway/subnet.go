@@ -0,0 +1,109 @@
+package gateway
+
+import (
+	"encoding/hex"
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+)
+
+// ParseSubnetMask parses an IPv4 subnet mask given as a dotted mask
+// ("255.255.255.0"), a prefix length ("/24" or "24"), a CIDR network
+// ("192.168.1.0/24") or a hex mask ("0xffffff00")
+func ParseSubnetMask(subnet string) (net.IPMask, error) {
+	subnet = strings.TrimSpace(subnet)
+
+	switch {
+	case subnet == "":
+		return nil, fmt.Errorf("empty subnet")
+	case strings.HasPrefix(subnet, "0x"):
+		b, err := hex.DecodeString(strings.TrimPrefix(subnet, "0x"))
+		if err != nil || len(b) != net.IPv4len {
+			return nil, fmt.Errorf("invalid hex subnet mask: %s", subnet)
+		}
+		return validMask(net.IPMask(b), subnet)
+	case strings.Contains(subnet, "/") || !strings.Contains(subnet, "."):
+		prefix := subnet[strings.LastIndex(subnet, "/")+1:]
+		bits, err := strconv.Atoi(prefix)
+		if err != nil || bits < 0 || bits > 32 {
+			return nil, fmt.Errorf("invalid subnet prefix length: %s", subnet)
+		}
+		return net.CIDRMask(bits, 32), nil
+	default:
+		ip := net.ParseIP(subnet).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("invalid subnet mask: %s", subnet)
+		}
+		return validMask(net.IPMask(ip), subnet)
+	}
+}
+
+// validMask rejects masks whose one bits are not contiguous
+func validMask(mask net.IPMask, subnet string) (net.IPMask, error) {
+	if _, bits := mask.Size(); bits == 0 {
+		return nil, fmt.Errorf("non-contiguous subnet mask: %s", subnet)
+	}
+	return mask, nil
+}
+
+// NormalizeSubnet converts a subnet in any format accepted by ParseSubnetMask
+// to its dotted mask and prefix length, e.g. "/24" to "255.255.255.0" and 24
+func NormalizeSubnet(subnet string) (string, int, error) {
+	mask, err := ParseSubnetMask(subnet)
+	if err != nil {
+		return "", 0, err
+	}
+	ones, _ := mask.Size()
+	return net.IP(mask).String(), ones, nil
+}
+
+// setSubnet stores subnet as a dotted mask and prefix length and checks that
+// the interface IP lies within the resulting network. A CIDR subnet, as
+// reported on Windows, must name the network of the interface IP.
+func (n *NetworkInterface) setSubnet(subnet string) error {
+	mask, prefixLen, err := NormalizeSubnet(subnet)
+	if err != nil {
+		n.Subnet = subnet
+		return err
+	}
+	n.Subnet, n.PrefixLen = mask, prefixLen
+
+	network, err := n.Network()
+	if err != nil {
+		return err
+	}
+	if i := strings.Index(subnet, "/"); i > 0 {
+		if ip := net.ParseIP(subnet[:i]); ip != nil && !network.Contains(ip) {
+			return fmt.Errorf("IP %s is not within subnet %s", n.IP, subnet)
+		}
+	}
+	return nil
+}
+
+// Network returns the IPv4 network the interface IP belongs to
+func (n *NetworkInterface) Network() (*net.IPNet, error) {
+	ip := net.ParseIP(n.IP).To4()
+	if ip == nil {
+		return nil, fmt.Errorf("invalid interface IP: %q", n.IP)
+	}
+	mask, err := ParseSubnetMask(n.Subnet)
+	if err != nil {
+		return nil, err
+	}
+	return &net.IPNet{IP: ip.Mask(mask), Mask: mask}, nil
+}
+
+// InSubnet reports whether ip is on the same subnet as the interface, and so
+// can be used as its gateway
+func (n *NetworkInterface) InSubnet(ip string) (bool, error) {
+	addr := net.ParseIP(ip)
+	if addr == nil {
+		return false, fmt.Errorf("invalid IP address: %s", ip)
+	}
+	network, err := n.Network()
+	if err != nil {
+		return false, err
+	}
+	return network.Contains(addr), nil
+}
@@ -0,0 +1,62 @@
+package gateway
+
+import "testing"
+
+func TestNormalizeSubnet(t *testing.T) {
+	tests := []struct {
+		subnet    string
+		mask      string
+		prefixLen int
+	}{
+		{"255.255.255.0", "255.255.255.0", 24},
+		{"0xffff0000", "255.255.0.0", 16},
+		{"/26", "255.255.255.192", 26},
+		{"192.168.1.0/24", "255.255.255.0", 24},
+	}
+
+	for _, tt := range tests {
+		mask, prefixLen, err := NormalizeSubnet(tt.subnet)
+		if err != nil {
+			t.Errorf("NormalizeSubnet(%q) error = %v", tt.subnet, err)
+			continue
+		}
+		if mask != tt.mask || prefixLen != tt.prefixLen {
+			t.Errorf("NormalizeSubnet(%q) = %s, %d, want %s, %d", tt.subnet, mask, prefixLen, tt.mask, tt.prefixLen)
+		}
+	}
+}
+
+func TestSetSubnet(t *testing.T) {
+	iface := &NetworkInterface{IP: "192.168.1.20"}
+	if err := iface.setSubnet("192.168.1.0/24"); err != nil {
+		t.Fatal(err)
+	}
+	if iface.Subnet != "255.255.255.0" || iface.PrefixLen != 24 {
+		t.Errorf("got %s /%d, want 255.255.255.0 /24", iface.Subnet, iface.PrefixLen)
+	}
+
+	// The reported network does not contain the interface IP
+	iface = &NetworkInterface{IP: "10.0.0.5"}
+	if err := iface.setSubnet("192.168.1.0/24"); err == nil {
+		t.Error("expected an error for an IP outside the reported network")
+	}
+}
+
+func TestInSubnet(t *testing.T) {
+	iface := &NetworkInterface{IP: "192.168.1.20", Subnet: "255.255.255.0", PrefixLen: 24}
+
+	for ip, want := range map[string]bool{
+		"192.168.1.1":   true,
+		"192.168.1.254": true,
+		"192.168.2.1":   false,
+	} {
+		got, err := iface.InSubnet(ip)
+		if err != nil || got != want {
+			t.Errorf("InSubnet(%s) = %v, %v, want %v", ip, got, err, want)
+		}
+	}
+
+	if _, err := iface.InSubnet("not-an-ip"); err == nil {
+		t.Error("expected an error for an invalid IP")
+	}
+}
@@ -0,0 +1,130 @@
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"time"
+)
+
+// RouteDebounce is how long WatchDefaultRoute waits after a routing change
+// before reading the default route, so a burst of changes is read once
+const RouteDebounce = 500 * time.Millisecond
+
+// routePollInterval is how often the default route is read where routing
+// changes cannot be monitored
+var routePollInterval = 5 * time.Second
+
+// DefaultRoute is the interface and gateway of the default route; both are
+// empty when there is none
+type DefaultRoute struct {
+	Interface string `json:"interface"`
+	Gateway   string `json:"gateway"`
+}
+
+// String describes the route as "en0 via 192.168.1.1"
+func (r DefaultRoute) String() string {
+	switch {
+	case r.Interface == "":
+		return "none"
+	case r.Gateway == "":
+		return r.Interface + " (no gateway)"
+	default:
+		return fmt.Sprintf("%s via %s", r.Interface, r.Gateway)
+	}
+}
+
+// RouteEvent reports a change of the default route
+type RouteEvent struct {
+	Time     time.Time    `json:"time"`
+	Route    DefaultRoute `json:"route"`
+	Previous DefaultRoute `json:"previous"`
+}
+
+// readDefaultRoute returns the current default route, replaced in tests
+var readDefaultRoute = func() DefaultRoute {
+	iface, err := GetActiveInterface()
+	if err != nil {
+		return DefaultRoute{}
+	}
+	return DefaultRoute{Interface: iface.Name, Gateway: iface.Gateway}
+}
+
+// CurrentDefaultRoute returns the interface and gateway of the default route
+func CurrentDefaultRoute() DefaultRoute {
+	return readDefaultRoute()
+}
+
+// WatchDefaultRoute sends an event on the returned channel whenever the
+// interface or gateway of the default route changes, including when it goes
+// away, until ctx is done and the channel is closed. Routing changes are
+// monitored through netlink on Linux and "route -n monitor" on macOS; on
+// other systems, or when monitoring fails, the route is polled instead.
+func WatchDefaultRoute(ctx context.Context) <-chan RouteEvent {
+	events := make(chan RouteEvent)
+	initial := readDefaultRoute()
+	// Without a monitor the watch falls back to polling
+	triggers, _ := routeTriggers(ctx)
+	go watchRoutes(ctx, initial, triggers, RouteDebounce, readDefaultRoute, events)
+	return events
+}
+
+// watchRoutes reads the default route with read after each trigger, once
+// debounce has passed since the first of a burst, and sends an event to
+// events when it differs from the previous one. A nil or closed triggers
+// falls back to polling every routePollInterval. events is closed when ctx
+// is done.
+func watchRoutes(ctx context.Context, current DefaultRoute, triggers <-chan struct{}, debounce time.Duration, read func() DefaultRoute, events chan<- RouteEvent) {
+	defer close(events)
+
+	var poll <-chan time.Time
+	startPolling := func() {
+		ticker := time.NewTicker(routePollInterval)
+		go func() {
+			<-ctx.Done()
+			ticker.Stop()
+		}()
+		poll = ticker.C
+	}
+	if triggers == nil {
+		startPolling()
+	}
+
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case _, ok := <-triggers:
+			if !ok {
+				triggers = nil
+				startPolling()
+			} else if settle == nil {
+				settle = time.After(debounce)
+			}
+			continue
+		case <-settle:
+			settle = nil
+		case <-poll:
+		}
+
+		route := read()
+		if route == current {
+			continue
+		}
+		event := RouteEvent{Time: time.Now(), Route: route, Previous: current}
+		current = route
+		select {
+		case events <- event:
+		case <-ctx.Done():
+			return
+		}
+	}
+}
+
+// poke sends on triggers unless a trigger is already pending
+func poke(triggers chan<- struct{}) {
+	select {
+	case triggers <- struct{}{}:
+	default:
+	}
+}
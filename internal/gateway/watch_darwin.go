@@ -0,0 +1,38 @@
+//go:build darwin
+
+package gateway
+
+import (
+	"bufio"
+	"context"
+	"fmt"
+)
+
+// routeTriggers runs "route -n monitor", which prints every routing socket
+// message, and sends on the returned channel after each line. The channel
+// is closed when ctx is done or the command exits.
+func routeTriggers(ctx context.Context) (<-chan struct{}, error) {
+	cmd := execCommand("route", "-n", "monitor")
+	stdout, err := cmd.StdoutPipe()
+	if err != nil {
+		return nil, err
+	}
+	if err := cmd.Start(); err != nil {
+		return nil, fmt.Errorf("failed to start route monitor: %w", err)
+	}
+
+	triggers := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		cmd.Process.Kill()
+	}()
+	go func() {
+		defer close(triggers)
+		scanner := bufio.NewScanner(stdout)
+		for scanner.Scan() {
+			poke(triggers)
+		}
+		cmd.Wait()
+	}()
+	return triggers, nil
+}
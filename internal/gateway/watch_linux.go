@@ -0,0 +1,59 @@
+//go:build linux
+
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// rtnetlink multicast groups from linux/rtnetlink.h, which syscall lacks
+const (
+	rtmgrpLink       = 0x1
+	rtmgrpIPv4Ifaddr = 0x10
+	rtmgrpIPv4Route  = 0x40
+	rtmgrpIPv6Ifaddr = 0x100
+	rtmgrpIPv6Route  = 0x400
+)
+
+// routeTriggers subscribes to the link, address and route notifications of
+// rtnetlink and sends on the returned channel after each message. The
+// channel is closed when ctx is done or the socket fails.
+func routeTriggers(ctx context.Context) (<-chan struct{}, error) {
+	fd, err := syscall.Socket(syscall.AF_NETLINK, syscall.SOCK_RAW|syscall.SOCK_CLOEXEC, syscall.NETLINK_ROUTE)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open netlink socket: %w", err)
+	}
+	groups := uint32(rtmgrpLink | rtmgrpIPv4Ifaddr | rtmgrpIPv6Ifaddr | rtmgrpIPv4Route | rtmgrpIPv6Route)
+	if err := syscall.Bind(fd, &syscall.SockaddrNetlink{Family: syscall.AF_NETLINK, Groups: groups}); err != nil {
+		syscall.Close(fd)
+		return nil, fmt.Errorf("failed to subscribe to route changes: %w", err)
+	}
+	// A non-blocking descriptor goes through the runtime poller, so closing
+	// the file interrupts a pending read
+	if err := syscall.SetNonblock(fd, true); err != nil {
+		syscall.Close(fd)
+		return nil, err
+	}
+	sock := os.NewFile(uintptr(fd), "netlink")
+
+	triggers := make(chan struct{}, 1)
+	go func() {
+		<-ctx.Done()
+		sock.Close()
+	}()
+	go func() {
+		defer close(triggers)
+		buf := make([]byte, os.Getpagesize())
+		for {
+			// The messages are not parsed: any of them may move the default route
+			if _, err := sock.Read(buf); err != nil {
+				return
+			}
+			poke(triggers)
+		}
+	}()
+	return triggers, nil
+}
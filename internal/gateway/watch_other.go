@@ -0,0 +1,15 @@
+//go:build !linux && !darwin
+
+package gateway
+
+import (
+	"context"
+	"fmt"
+	"runtime"
+)
+
+// routeTriggers is not implemented on this system, so WatchDefaultRoute
+// polls the default route instead
+func routeTriggers(ctx context.Context) (<-chan struct{}, error) {
+	return nil, fmt.Errorf("route monitoring is not supported on %s", runtime.GOOS)
+}
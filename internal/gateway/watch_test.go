@@ -0,0 +1,133 @@
+package gateway
+
+import (
+	"context"
+	"sync"
+	"testing"
+	"time"
+)
+
+// fakeRoutes is a default route that tests change, counting the reads
+type fakeRoutes struct {
+	mu    sync.Mutex
+	route DefaultRoute
+	reads int
+}
+
+func (f *fakeRoutes) set(route DefaultRoute) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.route = route
+}
+
+func (f *fakeRoutes) read() DefaultRoute {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.reads++
+	return f.route
+}
+
+func (f *fakeRoutes) readCount() int {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	return f.reads
+}
+
+// nextEvent waits for an event, failing the test after a second
+func nextEvent(t *testing.T, events <-chan RouteEvent) RouteEvent {
+	t.Helper()
+	select {
+	case event := <-events:
+		return event
+	case <-time.After(time.Second):
+		t.Fatal("no route event")
+		return RouteEvent{}
+	}
+}
+
+func TestWatchRoutesDebounces(t *testing.T) {
+	home := DefaultRoute{Interface: "en0", Gateway: "192.168.1.1"}
+	office := DefaultRoute{Interface: "en0", Gateway: "10.0.0.1"}
+	routes := &fakeRoutes{route: home}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	triggers := make(chan struct{}, 1)
+	events := make(chan RouteEvent)
+	go watchRoutes(ctx, home, triggers, 50*time.Millisecond, routes.read, events)
+
+	// A burst of routing messages while roaming is read once
+	routes.set(office)
+	for i := 0; i < 5; i++ {
+		poke(triggers)
+		time.Sleep(time.Millisecond)
+	}
+	event := nextEvent(t, events)
+	if event.Route != office || event.Previous != home {
+		t.Errorf("event = %+v, want %v after %v", event, office, home)
+	}
+	if n := routes.readCount(); n != 1 {
+		t.Errorf("route read %d times for one burst", n)
+	}
+
+	// Messages that leave the default route alone send nothing
+	poke(triggers)
+	select {
+	case event := <-events:
+		t.Errorf("unexpected event %+v", event)
+	case <-time.After(150 * time.Millisecond):
+	}
+
+	// Losing the default route is reported too
+	routes.set(DefaultRoute{})
+	poke(triggers)
+	if event := nextEvent(t, events); event.Route.String() != "none" || event.Previous != office {
+		t.Errorf("event = %+v, want the route to go away", event)
+	}
+
+	cancel()
+	select {
+	case _, ok := <-events:
+		if ok {
+			t.Error("event after cancel")
+		}
+	case <-time.After(time.Second):
+		t.Error("events not closed after cancel")
+	}
+}
+
+func TestWatchRoutesPolls(t *testing.T) {
+	defer func(interval time.Duration) { routePollInterval = interval }(routePollInterval)
+	routePollInterval = 10 * time.Millisecond
+
+	routes := &fakeRoutes{route: DefaultRoute{Interface: "eth0", Gateway: "192.168.1.1"}}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	// A monitor that stops falls back to polling
+	triggers := make(chan struct{})
+	close(triggers)
+	events := make(chan RouteEvent)
+	go watchRoutes(ctx, routes.route, triggers, time.Millisecond, routes.read, events)
+
+	routes.set(DefaultRoute{Interface: "wlan0", Gateway: "192.168.1.1"})
+	if event := nextEvent(t, events); event.Route.Interface != "wlan0" {
+		t.Errorf("event = %+v, want the interface change", event)
+	}
+}
+
+func TestDefaultRouteString(t *testing.T) {
+	tests := []struct {
+		route DefaultRoute
+		want  string
+	}{
+		{DefaultRoute{Interface: "en0", Gateway: "192.168.1.1"}, "en0 via 192.168.1.1"},
+		{DefaultRoute{Interface: "utun3"}, "utun3 (no gateway)"},
+		{DefaultRoute{}, "none"},
+	}
+	for _, tt := range tests {
+		if got := tt.route.String(); got != tt.want {
+			t.Errorf("String() = %q, want %q", got, tt.want)
+		}
+	}
+}
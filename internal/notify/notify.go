@@ -0,0 +1,77 @@
+package notify
+
+import (
+	"fmt"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync/atomic"
+
+	"github.com/ourines/GateShift/internal/utils"
+)
+
+// enabled is non-zero when desktop notifications should be shown
+var enabled int32
+
+// SetEnabled turns desktop notifications on or off
+func SetEnabled(on bool) {
+	var v int32
+	if on {
+		v = 1
+	}
+	atomic.StoreInt32(&enabled, v)
+}
+
+// Enabled reports whether desktop notifications are turned on
+func Enabled() bool {
+	return atomic.LoadInt32(&enabled) != 0
+}
+
+// Send shows a desktop notification if notifications are enabled and
+// dry-run mode is off. Failures, including a missing notifier binary, are ignored.
+func Send(title, message string) {
+	if !Enabled() || utils.DryRun() {
+		return
+	}
+
+	var cmd *exec.Cmd
+	switch runtime.GOOS {
+	case "darwin":
+		script := fmt.Sprintf("display notification %s with title %s", appleScriptString(message), appleScriptString(title))
+		cmd = exec.Command("osascript", "-e", script)
+	case "linux":
+		cmd = exec.Command("notify-send", "--app-name=GateShift", title, message)
+	case "windows":
+		cmd = exec.Command("powershell", "-NoProfile", "-NonInteractive", "-Command", windowsToastScript(title, message))
+	default:
+		return
+	}
+
+	if _, err := exec.LookPath(cmd.Path); err != nil {
+		return
+	}
+	cmd.Run()
+}
+
+// appleScriptString quotes s as an AppleScript string literal
+func appleScriptString(s string) string {
+	s = strings.ReplaceAll(s, `\`, `\\`)
+	return `"` + strings.ReplaceAll(s, `"`, `\"`) + `"`
+}
+
+// powerShellString quotes s as a single-quoted PowerShell string literal
+func powerShellString(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// windowsToastScript builds a PowerShell script that shows a toast notification
+func windowsToastScript(title, message string) string {
+	return fmt.Sprintf(`[Windows.UI.Notifications.ToastNotificationManager, Windows.UI.Notifications, ContentType = WindowsRuntime] > $null
+$template = [Windows.UI.Notifications.ToastNotificationManager]::GetTemplateContent([Windows.UI.Notifications.ToastTemplateType]::ToastText02)
+$text = $template.GetElementsByTagName('text')
+$text.Item(0).AppendChild($template.CreateTextNode(%s)) > $null
+$text.Item(1).AppendChild($template.CreateTextNode(%s)) > $null
+$toast = [Windows.UI.Notifications.ToastNotification]::new($template)
+[Windows.UI.Notifications.ToastNotificationManager]::CreateToastNotifier('GateShift').Show($toast)`,
+		powerShellString(title), powerShellString(message))
+}
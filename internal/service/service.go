@@ -0,0 +1,273 @@
+package service
+
+import (
+	"bytes"
+	"encoding/xml"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/ourines/GateShift/internal/utils"
+)
+
+const (
+	// Label is the launchd label of the DNS service
+	Label = "com.ourines.gateshift.dns"
+	// UnitName is the systemd unit name of the DNS service
+	UnitName = "gateshift-dns.service"
+)
+
+// Options describes how the service manager should launch the DNS daemon
+type Options struct {
+	Executable  string
+	Args        []string
+	LogFile     string
+	Environment map[string]string
+}
+
+// launchctl/systemctl and writes under /Library and /etc need root; the
+// session is shared with the rest of the process
+var sudoSession = utils.NewSudoSession(15 * time.Minute)
+
+// Install registers the DNS daemon with the system service manager
+func Install(opts Options) error {
+	switch runtime.GOOS {
+	case "darwin":
+		return installLaunchd(opts)
+	case "linux":
+		return installSystemd(opts)
+	default:
+		return fmt.Errorf("service integration is not supported on %s", runtime.GOOS)
+	}
+}
+
+// Uninstall stops the DNS daemon and removes it from the system service manager
+func Uninstall() error {
+	switch runtime.GOOS {
+	case "darwin":
+		return uninstallLaunchd()
+	case "linux":
+		return uninstallSystemd()
+	default:
+		return fmt.Errorf("service integration is not supported on %s", runtime.GOOS)
+	}
+}
+
+// IsInstalled reports whether the DNS daemon is registered with the service manager
+func IsInstalled() bool {
+	switch runtime.GOOS {
+	case "darwin":
+		return fileExists(launchdPlistPath())
+	case "linux":
+		return fileExists(systemdSystemUnitPath())
+	default:
+		return false
+	}
+}
+
+// Start starts the installed DNS daemon through the service manager
+func Start() error {
+	switch runtime.GOOS {
+	case "darwin":
+		return sudoSession.RunWithPrivileges("launchctl", "load", launchdPlistPath())
+	case "linux":
+		return systemctl("start", UnitName)
+	default:
+		return fmt.Errorf("service integration is not supported on %s", runtime.GOOS)
+	}
+}
+
+// Stop stops the installed DNS daemon through the service manager
+func Stop() error {
+	switch runtime.GOOS {
+	case "darwin":
+		return sudoSession.RunWithPrivileges("launchctl", "unload", launchdPlistPath())
+	case "linux":
+		return systemctl("stop", UnitName)
+	default:
+		return fmt.Errorf("service integration is not supported on %s", runtime.GOOS)
+	}
+}
+
+// IsRunning asks the service manager whether the DNS daemon is running
+func IsRunning() bool {
+	switch runtime.GOOS {
+	case "darwin":
+		output, err := exec.Command("launchctl", "print", "system/"+Label).Output()
+		if err != nil {
+			return false
+		}
+		return strings.Contains(string(output), "state = running")
+	case "linux":
+		return exec.Command("systemctl", "is-active", "--quiet", UnitName).Run() == nil
+	default:
+		return false
+	}
+}
+
+// macOS specific implementations
+func launchdPlistPath() string {
+	return filepath.Join("/Library/LaunchDaemons", Label+".plist")
+}
+
+func installLaunchd(opts Options) error {
+	var buf bytes.Buffer
+	buf.WriteString(`<?xml version="1.0" encoding="UTF-8"?>
+<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">
+<plist version="1.0">
+<dict>
+`)
+	fmt.Fprintf(&buf, "\t<key>Label</key>\n\t<string>%s</string>\n", xmlEscape(Label))
+	buf.WriteString("\t<key>ProgramArguments</key>\n\t<array>\n")
+	for _, arg := range append([]string{opts.Executable}, opts.Args...) {
+		fmt.Fprintf(&buf, "\t\t<string>%s</string>\n", xmlEscape(arg))
+	}
+	buf.WriteString("\t</array>\n")
+	if len(opts.Environment) > 0 {
+		buf.WriteString("\t<key>EnvironmentVariables</key>\n\t<dict>\n")
+		for _, key := range sortedKeys(opts.Environment) {
+			fmt.Fprintf(&buf, "\t\t<key>%s</key>\n\t\t<string>%s</string>\n", xmlEscape(key), xmlEscape(opts.Environment[key]))
+		}
+		buf.WriteString("\t</dict>\n")
+	}
+	// 启动时运行，异常退出后自动重启
+	buf.WriteString("\t<key>RunAtLoad</key>\n\t<true/>\n")
+	buf.WriteString("\t<key>KeepAlive</key>\n\t<dict>\n\t\t<key>SuccessfulExit</key>\n\t\t<false/>\n\t</dict>\n")
+	if opts.LogFile != "" {
+		fmt.Fprintf(&buf, "\t<key>StandardOutPath</key>\n\t<string>%s</string>\n", xmlEscape(opts.LogFile))
+		fmt.Fprintf(&buf, "\t<key>StandardErrorPath</key>\n\t<string>%s</string>\n", xmlEscape(opts.LogFile))
+	}
+	buf.WriteString("</dict>\n</plist>\n")
+
+	if err := writePrivileged(launchdPlistPath(), buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write launchd plist: %w", err)
+	}
+	return nil
+}
+
+func uninstallLaunchd() error {
+	path := launchdPlistPath()
+	if !fileExists(path) {
+		return fmt.Errorf("DNS service is not installed")
+	}
+
+	// 服务可能未加载，忽略卸载错误
+	sudoSession.RunWithPrivileges("launchctl", "unload", path)
+
+	if err := sudoSession.RunWithPrivileges("rm", "-f", path); err != nil {
+		return fmt.Errorf("failed to remove launchd plist: %w", err)
+	}
+	return nil
+}
+
+// Linux specific implementations
+func systemdSystemUnitPath() string {
+	return filepath.Join("/etc/systemd/system", UnitName)
+}
+
+// systemctl runs systemctl with root privileges. The daemon binds port 53
+// and rewrites system DNS settings, so only a system unit can run it.
+func systemctl(args ...string) error {
+	return sudoSession.RunWithPrivileges("systemctl", args...)
+}
+
+func installSystemd(opts Options) error {
+	var buf bytes.Buffer
+	buf.WriteString("[Unit]\nDescription=GateShift DNS proxy\nAfter=network-online.target\nWants=network-online.target\n\n")
+	buf.WriteString("[Service]\nType=simple\n")
+	fmt.Fprintf(&buf, "ExecStart=%s\n", systemdQuote(append([]string{opts.Executable}, opts.Args...)))
+	for _, key := range sortedKeys(opts.Environment) {
+		fmt.Fprintf(&buf, "Environment=%s\n", systemdQuote([]string{key + "=" + opts.Environment[key]}))
+	}
+	if opts.LogFile != "" {
+		fmt.Fprintf(&buf, "StandardOutput=append:%s\nStandardError=append:%s\n", opts.LogFile, opts.LogFile)
+	}
+	buf.WriteString("Restart=on-failure\nRestartSec=2\n\n")
+	buf.WriteString("[Install]\nWantedBy=multi-user.target\n")
+
+	if err := writePrivileged(systemdSystemUnitPath(), buf.Bytes()); err != nil {
+		return fmt.Errorf("failed to write systemd unit: %w", err)
+	}
+
+	if err := systemctl("daemon-reload"); err != nil {
+		return fmt.Errorf("failed to reload systemd: %w", err)
+	}
+	if err := systemctl("enable", UnitName); err != nil {
+		return fmt.Errorf("failed to enable %s: %w", UnitName, err)
+	}
+	return nil
+}
+
+func uninstallSystemd() error {
+	if !IsInstalled() {
+		return fmt.Errorf("DNS service is not installed")
+	}
+
+	// 服务可能未运行，忽略停止错误
+	systemctl("stop", UnitName)
+	systemctl("disable", UnitName)
+
+	if err := sudoSession.RunWithPrivileges("rm", "-f", systemdSystemUnitPath()); err != nil {
+		return fmt.Errorf("failed to remove systemd unit: %w", err)
+	}
+	return systemctl("daemon-reload")
+}
+
+// writePrivileged writes data to a root-owned path via a temporary file
+func writePrivileged(path string, data []byte) error {
+	tmp, err := os.CreateTemp("", "gateshift-service-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(tmp.Name())
+
+	if _, err := tmp.Write(data); err != nil {
+		tmp.Close()
+		return err
+	}
+	if err := tmp.Close(); err != nil {
+		return err
+	}
+
+	if err := sudoSession.RunWithPrivileges("cp", tmp.Name(), path); err != nil {
+		return err
+	}
+	return sudoSession.RunWithPrivileges("chmod", "644", path)
+}
+
+func fileExists(path string) bool {
+	_, err := os.Stat(path)
+	return err == nil
+}
+
+func sortedKeys(m map[string]string) []string {
+	keys := make([]string, 0, len(m))
+	for key := range m {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+func xmlEscape(s string) string {
+	var buf bytes.Buffer
+	xml.EscapeText(&buf, []byte(s))
+	return buf.String()
+}
+
+// systemdQuote quotes command line words for use in a unit file
+func systemdQuote(words []string) string {
+	quoted := make([]string, len(words))
+	for i, word := range words {
+		if strings.ContainsAny(word, " \t\"\\") {
+			word = "\"" + strings.NewReplacer("\\", "\\\\", "\"", "\\\"").Replace(word) + "\""
+		}
+		quoted[i] = word
+	}
+	return strings.Join(quoted, " ")
+}
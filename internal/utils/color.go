@@ -0,0 +1,72 @@
+package utils
+
+import (
+	"os"
+	"sync/atomic"
+)
+
+// ANSI escape sequences used for colored output
+const (
+	ansiReset  = "\033[0m"
+	ansiBold   = "\033[1m"
+	ansiRed    = "\033[31m"
+	ansiGreen  = "\033[32m"
+	ansiYellow = "\033[33m"
+)
+
+// colorEnabled is non-zero when output may contain ANSI colors
+var colorEnabled int32
+
+// SetColor enables or disables colored output
+func SetColor(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&colorEnabled, v)
+}
+
+// ColorEnabled reports whether colored output is enabled
+func ColorEnabled() bool {
+	return atomic.LoadInt32(&colorEnabled) != 0
+}
+
+// ColorSupported reports whether colors should be written to f: it must be a
+// terminal and NO_COLOR (https://no-color.org) must be unset or empty
+func ColorSupported(f *os.File) bool {
+	if os.Getenv("NO_COLOR") != "" {
+		return false
+	}
+	info, err := f.Stat()
+	if err != nil {
+		return false
+	}
+	return info.Mode()&os.ModeCharDevice != 0
+}
+
+// Green colors s green when colored output is enabled
+func Green(s string) string {
+	return colorize(ansiGreen, s)
+}
+
+// Red colors s red when colored output is enabled
+func Red(s string) string {
+	return colorize(ansiRed, s)
+}
+
+// Yellow colors s yellow when colored output is enabled
+func Yellow(s string) string {
+	return colorize(ansiYellow, s)
+}
+
+// Bold makes s bold when colored output is enabled
+func Bold(s string) string {
+	return colorize(ansiBold, s)
+}
+
+func colorize(code, s string) string {
+	if !ColorEnabled() {
+		return s
+	}
+	return code + s + ansiReset
+}
@@ -0,0 +1,37 @@
+package utils
+
+import (
+	"os"
+	"testing"
+)
+
+func TestColorize(t *testing.T) {
+	defer SetColor(false)
+
+	SetColor(false)
+	if got := Green("Running"); got != "Running" {
+		t.Errorf("Green with colors disabled = %q", got)
+	}
+
+	SetColor(true)
+	if got := Red("Stopped"); got != "\033[31mStopped\033[0m" {
+		t.Errorf("Red with colors enabled = %q", got)
+	}
+}
+
+func TestColorSupported(t *testing.T) {
+	// A regular file is never a terminal
+	f, err := os.CreateTemp(t.TempDir(), "out")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	if ColorSupported(f) {
+		t.Error("colors supported on a regular file")
+	}
+
+	t.Setenv("NO_COLOR", "1")
+	if ColorSupported(os.Stdout) {
+		t.Error("colors supported with NO_COLOR set")
+	}
+}
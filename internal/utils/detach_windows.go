@@ -0,0 +1,9 @@
+//go:build windows
+
+package utils
+
+import "os/exec"
+
+// detach is a no-op on Windows, where elevated processes are started
+// through Start-Process and are already independent of the console
+func detach(cmd *exec.Cmd) {}
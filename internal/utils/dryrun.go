@@ -0,0 +1,32 @@
+package utils
+
+import (
+	"fmt"
+	"sync/atomic"
+)
+
+// dryRun is non-zero when commands that change system state should only be printed
+var dryRun int32
+
+// SetDryRun enables or disables dry-run mode
+func SetDryRun(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&dryRun, v)
+}
+
+// DryRun reports whether dry-run mode is enabled
+func DryRun() bool {
+	return atomic.LoadInt32(&dryRun) != 0
+}
+
+// PrintDryRun prints a command that would have been executed
+func PrintDryRun(name string, args ...string) {
+	if len(args) == 0 {
+		fmt.Printf("[dry-run] %s\n", name)
+		return
+	}
+	fmt.Printf("[dry-run] %s %s\n", name, QuoteArgs(args))
+}
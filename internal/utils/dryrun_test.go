@@ -0,0 +1,52 @@
+package utils
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestDryRunRunsNothing(t *testing.T) {
+	oldRun := runElevated
+	defer func() { runElevated = oldRun }()
+	defer SetDryRun(false)
+
+	runElevated = func(ctx context.Context, s *SudoSession, name string, args ...string) error {
+		t.Errorf("privileged command run in dry-run mode: %s %v", name, args)
+		return nil
+	}
+	SetDryRun(true)
+
+	if err := NewIndependentSudoSession(time.Minute).RunWithPrivileges("ip", "route", "del", "default"); err != nil {
+		t.Fatal(err)
+	}
+
+	marker := filepath.Join(t.TempDir(), "hook-ran")
+	if err := RunHook("on_proxy", "touch "+marker, nil, time.Second); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("hook command run in dry-run mode")
+	}
+}
+
+func TestDryRunStartsNothing(t *testing.T) {
+	defer SetDryRun(false)
+	SetDryRun(true)
+
+	dir := t.TempDir()
+	logFile := filepath.Join(dir, "daemon.log")
+	marker := filepath.Join(dir, "started")
+	if err := NewIndependentSudoSession(time.Minute).StartWithPrivileges(logFile, nil, "touch", marker); err != nil {
+		t.Fatal(err)
+	}
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(marker); !os.IsNotExist(err) {
+		t.Error("background command started in dry-run mode")
+	}
+	if _, err := os.Stat(logFile); !os.IsNotExist(err) {
+		t.Error("log file created in dry-run mode")
+	}
+}
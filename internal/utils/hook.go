@@ -0,0 +1,57 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"time"
+)
+
+// RunHook runs a user-defined command through the system shell with env
+// added to its environment. Its output is written to the log. The command is
+// killed once timeout elapses; a zero timeout means no limit. Empty commands
+// are skipped, and in dry-run mode the command is only printed.
+func RunHook(name, command string, env []string, timeout time.Duration) error {
+	if strings.TrimSpace(command) == "" {
+		return nil
+	}
+
+	shell, flag := "sh", "-c"
+	if runtime.GOOS == "windows" {
+		shell, flag = "cmd", "/C"
+	}
+
+	if DryRun() {
+		fmt.Printf("[dry-run] hook %s: %s %s %q\n", name, shell, flag, command)
+		return nil
+	}
+
+	ctx := context.Background()
+	if timeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, timeout)
+		defer cancel()
+	}
+
+	Debugf("Running hook %s: %s %s %q", name, shell, flag, command)
+	cmd := exec.CommandContext(ctx, shell, flag, command)
+	cmd.Env = append(os.Environ(), env...)
+	output, err := cmd.CombinedOutput()
+
+	for _, line := range strings.Split(strings.TrimRight(string(output), "\n"), "\n") {
+		if line != "" {
+			Logf("hook %s: %s", name, line)
+		}
+	}
+
+	if ctx.Err() == context.DeadlineExceeded {
+		return fmt.Errorf("hook %s timed out after %v", name, timeout)
+	}
+	if err != nil {
+		return fmt.Errorf("hook %s failed: %w", name, err)
+	}
+	return nil
+}
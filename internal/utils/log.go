@@ -0,0 +1,64 @@
+package utils
+
+import (
+	"fmt"
+	"log"
+	"sync/atomic"
+)
+
+// Level controls how much output the CLI and the DNS proxy produce
+type Level int32
+
+// Log levels selected by the --quiet and --verbose flags
+const (
+	// LevelQuiet prints only errors and essential results
+	LevelQuiet Level = iota - 1
+	// LevelNormal is the default output
+	LevelNormal
+	// LevelVerbose also prints the commands that are executed
+	LevelVerbose
+)
+
+// logLevel holds the current Level
+var logLevel int32
+
+// SetLogLevel sets the output level
+func SetLogLevel(level Level) {
+	atomic.StoreInt32(&logLevel, int32(level))
+}
+
+// LogLevel returns the output level
+func LogLevel() Level {
+	return Level(atomic.LoadInt32(&logLevel))
+}
+
+// Quiet reports whether only errors and essential results should print
+func Quiet() bool {
+	return LogLevel() <= LevelQuiet
+}
+
+// Verbose reports whether detailed diagnostics should print
+func Verbose() bool {
+	return LogLevel() >= LevelVerbose
+}
+
+// Infof prints an informational message to stdout unless in quiet mode
+func Infof(format string, args ...interface{}) {
+	if !Quiet() {
+		fmt.Printf(format, args...)
+	}
+}
+
+// Logf writes a diagnostic line to the log unless in quiet mode
+func Logf(format string, args ...interface{}) {
+	if !Quiet() {
+		log.Printf(format, args...)
+	}
+}
+
+// Debugf writes a diagnostic line to the log in verbose mode only
+func Debugf(format string, args ...interface{}) {
+	if Verbose() {
+		log.Printf(format, args...)
+	}
+}
@@ -0,0 +1,42 @@
+package utils
+
+import (
+	"bytes"
+	"log"
+	"strings"
+	"testing"
+)
+
+func TestLogLevels(t *testing.T) {
+	var buf bytes.Buffer
+	output := log.Writer()
+	log.SetOutput(&buf)
+	defer log.SetOutput(output)
+	defer SetLogLevel(LevelNormal)
+
+	tests := []struct {
+		level Level
+		want  []string
+	}{
+		{LevelQuiet, nil},
+		{LevelNormal, []string{"normal"}},
+		{LevelVerbose, []string{"normal", "debug"}},
+	}
+
+	for _, tt := range tests {
+		buf.Reset()
+		SetLogLevel(tt.level)
+		Logf("normal")
+		Debugf("debug")
+
+		var got []string
+		for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+			if line != "" {
+				got = append(got, line[strings.LastIndex(line, " ")+1:])
+			}
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("level %d: logged %v, want %v", tt.level, got, tt.want)
+		}
+	}
+}
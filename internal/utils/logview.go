@@ -0,0 +1,150 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// LineMatcher reports whether a log line should be shown
+type LineMatcher func(line string) bool
+
+// SubstringMatcher matches lines containing text, ignoring case. An empty
+// text matches every line.
+func SubstringMatcher(text string) LineMatcher {
+	text = strings.ToLower(text)
+	return func(line string) bool {
+		return strings.Contains(strings.ToLower(line), text)
+	}
+}
+
+// RegexMatcher matches lines against the regular expression pattern
+func RegexMatcher(pattern string) (LineMatcher, error) {
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, fmt.Errorf("invalid regular expression %q: %w", pattern, err)
+	}
+	return re.MatchString, nil
+}
+
+// tailChunkSize is how much of a file LastLines reads at a time
+const tailChunkSize = 64 * 1024
+
+// LastLines returns the last n lines of the file at path and the offset of
+// its end, from which FollowFile can continue
+func LastLines(path string, n int) ([]string, int64, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, 0, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, 0, err
+	}
+	size := info.Size()
+	if n <= 0 {
+		return nil, size, nil
+	}
+
+	// Read backwards until the chunk holds n complete lines
+	var data []byte
+	start := size
+	for start > 0 && bytes.Count(bytes.TrimSuffix(data, []byte("\n")), []byte("\n")) < n {
+		chunk := int64(tailChunkSize)
+		if start < chunk {
+			chunk = start
+		}
+		start -= chunk
+		buf := make([]byte, chunk)
+		if _, err := f.ReadAt(buf, start); err != nil && err != io.EOF {
+			return nil, 0, err
+		}
+		data = append(buf, data...)
+	}
+
+	text := strings.TrimSuffix(string(data), "\n")
+	if text == "" {
+		return nil, size, nil
+	}
+	lines := strings.Split(text, "\n")
+	if len(lines) > n {
+		lines = lines[len(lines)-n:]
+	}
+	return lines, size, nil
+}
+
+// FollowFile writes the matching lines appended to the file at path after
+// offset to w, checking for new data every interval until stop is closed.
+// When the file is rotated or truncated it is reopened from the start.
+func FollowFile(path string, offset int64, w io.Writer, match LineMatcher, interval time.Duration, stop <-chan struct{}) error {
+	f, err := os.Open(path)
+	if err != nil {
+		return err
+	}
+	defer func() { f.Close() }()
+
+	var partial []byte
+	buf := make([]byte, 32*1024)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		partial = followRead(f, &offset, buf, partial, w, match)
+
+		// Reopen when path now names a different file (rotation) or the
+		// file shrank below what was already read (truncation)
+		if info, err := os.Stat(path); err == nil {
+			current, err := f.Stat()
+			rotated := err != nil || !os.SameFile(info, current)
+			if rotated || info.Size() < offset {
+				if next, err := os.Open(path); err == nil {
+					if rotated {
+						// Drain what was written to the old file before it was rotated
+						followRead(f, &offset, buf, partial, w, match)
+					}
+					f.Close()
+					f, offset, partial = next, 0, nil
+					continue
+				}
+			}
+		}
+
+		select {
+		case <-stop:
+			return nil
+		case <-ticker.C:
+		}
+	}
+}
+
+// followRead writes the complete matching lines read from f at *offset to w
+// and returns the trailing partial line
+func followRead(f *os.File, offset *int64, buf, partial []byte, w io.Writer, match LineMatcher) []byte {
+	for {
+		n, err := f.ReadAt(buf, *offset)
+		*offset += int64(n)
+		partial = append(partial, buf[:n]...)
+
+		for {
+			i := bytes.IndexByte(partial, '\n')
+			if i < 0 {
+				break
+			}
+			line := string(partial[:i])
+			partial = partial[i+1:]
+			if match == nil || match(line) {
+				io.WriteString(w, line+"\n")
+			}
+		}
+
+		if err != nil || n == 0 {
+			return partial
+		}
+	}
+}
@@ -0,0 +1,160 @@
+package utils
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+)
+
+func writeLines(t *testing.T, path string, from, to int) {
+	t.Helper()
+	f, err := os.OpenFile(path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer f.Close()
+	for i := from; i <= to; i++ {
+		fmt.Fprintf(f, "line %d\n", i)
+	}
+}
+
+func TestLastLines(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	writeLines(t, path, 1, 10)
+
+	lines, offset, err := LastLines(path, 3)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(lines, ",") != "line 8,line 9,line 10" {
+		t.Errorf("LastLines = %v", lines)
+	}
+	if info, _ := os.Stat(path); offset != info.Size() {
+		t.Errorf("offset = %d, want file size %d", offset, info.Size())
+	}
+
+	// Asking for more lines than the file has returns them all
+	if lines, _, _ := LastLines(path, 100); len(lines) != 10 || lines[0] != "line 1" {
+		t.Errorf("LastLines(100) = %v", lines)
+	}
+}
+
+func TestLastLinesSpansChunks(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "test.log")
+	long := strings.Repeat("x", tailChunkSize)
+	if err := os.WriteFile(path, []byte("first\n"+long+"\nlast\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	lines, _, err := LastLines(path, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(lines) != 2 || lines[0] != long || lines[1] != "last" {
+		t.Errorf("LastLines returned %d lines ending in %q", len(lines), lines[len(lines)-1])
+	}
+}
+
+func TestSubstringMatcher(t *testing.T) {
+	match := SubstringMatcher("Google")
+	if !match("Query www.google.com. A") || match("Query example.com. A") {
+		t.Error("SubstringMatcher does not match case-insensitively")
+	}
+}
+
+func TestRegexMatcher(t *testing.T) {
+	sample := strings.Join([]string{
+		"2024/01/02 10:00:00 Query www.google.com. A from 192.168.1.10 -> NOERROR",
+		"2024/01/02 10:00:01 Query missing.example. A from 192.168.1.10 -> NXDOMAIN",
+		"2024/01/02 10:00:02 Query broken.example. AAAA from 192.168.1.11 -> SERVFAIL",
+		"2024/01/02 10:00:03 Query github.com. A from 192.168.1.11 -> NOERROR",
+	}, "\n")
+
+	match, err := RegexMatcher("NXDOMAIN|SERVFAIL")
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got []string
+	for _, line := range strings.Split(sample, "\n") {
+		if match(line) {
+			got = append(got, line)
+		}
+	}
+	if len(got) != 2 || !strings.Contains(got[0], "missing.example.") || !strings.Contains(got[1], "broken.example.") {
+		t.Errorf("RegexMatcher matched %q", got)
+	}
+}
+
+func TestRegexMatcherRejectsInvalid(t *testing.T) {
+	if _, err := RegexMatcher("NXDOMAIN|("); err == nil {
+		t.Error("RegexMatcher accepted an invalid pattern")
+	}
+}
+
+// syncBuffer is a bytes.Buffer safe for concurrent use
+type syncBuffer struct {
+	mu  sync.Mutex
+	buf bytes.Buffer
+}
+
+func (b *syncBuffer) Write(p []byte) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.Write(p)
+}
+
+func (b *syncBuffer) String() string {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.buf.String()
+}
+
+func waitFor(t *testing.T, out *syncBuffer, want string) {
+	t.Helper()
+	deadline := time.Now().Add(2 * time.Second)
+	for !strings.Contains(out.String(), want) {
+		if time.Now().After(deadline) {
+			t.Fatalf("output %q does not contain %q", out.String(), want)
+		}
+		time.Sleep(5 * time.Millisecond)
+	}
+}
+
+func TestFollowFileAcrossRotation(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	writeLines(t, path, 1, 2)
+	_, offset, err := LastLines(path, 0)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var out syncBuffer
+	stop := make(chan struct{})
+	done := make(chan error)
+	go func() {
+		done <- FollowFile(path, offset, &out, SubstringMatcher("line"), 10*time.Millisecond, stop)
+	}()
+
+	writeLines(t, path, 3, 3)
+	waitFor(t, &out, "line 3\n")
+
+	// Rotate the way RotatingFile does and keep writing to the new file
+	if err := os.Rename(path, path+".1"); err != nil {
+		t.Fatal(err)
+	}
+	writeLines(t, path, 4, 5)
+	waitFor(t, &out, "line 5\n")
+
+	close(stop)
+	if err := <-done; err != nil {
+		t.Fatal(err)
+	}
+	if got := out.String(); got != "line 3\nline 4\nline 5\n" {
+		t.Errorf("followed output = %q", got)
+	}
+}
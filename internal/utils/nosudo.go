@@ -0,0 +1,39 @@
+package utils
+
+import (
+	"errors"
+	"fmt"
+	"sync/atomic"
+)
+
+// ErrNoSudo is returned by SudoSession when a command needs elevated
+// privileges while they are disabled with SetNoSudo
+var ErrNoSudo = errors.New("elevated privileges are disabled (--no-sudo)")
+
+// noSudo is non-zero when no command may be run with elevated privileges
+var noSudo int32
+
+// SetNoSudo enables or disables read-only mode, in which SudoSession fails
+// right away instead of running a command with elevated privileges, so no
+// password prompt can appear
+func SetNoSudo(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&noSudo, v)
+}
+
+// NoSudo reports whether elevated privileges are disabled
+func NoSudo() bool {
+	return atomic.LoadInt32(&noSudo) != 0
+}
+
+// noSudoError builds the error returned for a privileged command refused
+// because elevated privileges are disabled
+func noSudoError(name string, args []string) error {
+	if len(args) == 0 {
+		return fmt.Errorf("%w: not running %s", ErrNoSudo, name)
+	}
+	return fmt.Errorf("%w: not running %s %s", ErrNoSudo, name, QuoteArgs(args))
+}
@@ -0,0 +1,30 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestNoSudoFailsFast(t *testing.T) {
+	oldRun := runElevated
+	defer func() { runElevated = oldRun }()
+	defer SetNoSudo(false)
+
+	runElevated = func(ctx context.Context, s *SudoSession, name string, args ...string) error {
+		t.Errorf("privileged command run with --no-sudo: %s %v", name, args)
+		return nil
+	}
+	SetNoSudo(true)
+
+	s := NewIndependentSudoSession(time.Minute)
+	if err := s.RunWithPrivileges("ip", "route", "del", "default"); !errors.Is(err, ErrNoSudo) {
+		t.Errorf("RunWithPrivileges = %v, want ErrNoSudo", err)
+	}
+	logFile := filepath.Join(t.TempDir(), "daemon.log")
+	if err := s.StartWithPrivileges(logFile, nil, "true"); !errors.Is(err, ErrNoSudo) {
+		t.Errorf("StartWithPrivileges = %v, want ErrNoSudo", err)
+	}
+}
@@ -0,0 +1,22 @@
+//go:build !windows
+
+package utils
+
+import (
+	"fmt"
+	"os"
+	"syscall"
+)
+
+// ChownLike changes the owner of path to the owner of ref
+func ChownLike(path, ref string) error {
+	info, err := os.Stat(ref)
+	if err != nil {
+		return err
+	}
+	stat, ok := info.Sys().(*syscall.Stat_t)
+	if !ok {
+		return fmt.Errorf("could not determine owner of %s", ref)
+	}
+	return os.Chown(path, int(stat.Uid), int(stat.Gid))
+}
@@ -0,0 +1,8 @@
+//go:build windows
+
+package utils
+
+// ChownLike is a no-op on Windows, where files are not owned by uid/gid
+func ChownLike(path, ref string) error {
+	return nil
+}
@@ -0,0 +1,120 @@
+package utils
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+)
+
+// RotatingFile is an io.Writer that appends to a file and rotates it once it
+// reaches maxSize bytes. Rotated files are renamed to path.1, path.2, ... with
+// at most keep of them retained. Rotation renames rather than truncates, so
+// readers following the file by name see the new file after a rotation.
+type RotatingFile struct {
+	mu      sync.Mutex
+	path    string
+	maxSize int64
+	keep    int
+	file    *os.File
+	size    int64
+}
+
+// OpenRotatingFile opens path for appending, creating it if needed
+func OpenRotatingFile(path string, maxSize int64, keep int) (*RotatingFile, error) {
+	if maxSize <= 0 {
+		return nil, fmt.Errorf("invalid maximum log size: %d", maxSize)
+	}
+	if keep < 0 {
+		return nil, fmt.Errorf("invalid number of rotated logs to keep: %d", keep)
+	}
+
+	r := &RotatingFile{path: path, maxSize: maxSize, keep: keep}
+	if err := r.open(); err != nil {
+		return nil, err
+	}
+	return r, nil
+}
+
+// Write appends p to the file, rotating it first when p would take it past
+// the maximum size
+func (r *RotatingFile) Write(p []byte) (int, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return 0, os.ErrClosed
+	}
+	if r.size > 0 && r.size+int64(len(p)) > r.maxSize {
+		if err := r.rotate(); err != nil {
+			return 0, err
+		}
+	}
+
+	n, err := r.file.Write(p)
+	r.size += int64(n)
+	return n, err
+}
+
+// Close closes the file
+func (r *RotatingFile) Close() error {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if r.file == nil {
+		return nil
+	}
+	err := r.file.Close()
+	r.file = nil
+	return err
+}
+
+func (r *RotatingFile) open() error {
+	file, err := os.OpenFile(r.path, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return err
+	}
+	info, err := file.Stat()
+	if err != nil {
+		file.Close()
+		return err
+	}
+
+	// The DNS service runs as root; leave its logs readable by the user
+	ChownLike(r.path, filepath.Dir(r.path))
+
+	r.file = file
+	r.size = info.Size()
+	return nil
+}
+
+// rotate shifts path.N to path.N+1, dropping the oldest, moves the current
+// file to path.1 and opens a new one
+func (r *RotatingFile) rotate() error {
+	if err := r.file.Close(); err != nil {
+		return err
+	}
+	r.file = nil
+
+	if r.keep == 0 {
+		if err := os.Remove(r.path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return r.open()
+	}
+
+	os.Remove(r.rotatedName(r.keep))
+	for i := r.keep - 1; i >= 1; i-- {
+		if err := os.Rename(r.rotatedName(i), r.rotatedName(i+1)); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	if err := os.Rename(r.path, r.rotatedName(1)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return r.open()
+}
+
+func (r *RotatingFile) rotatedName(n int) string {
+	return fmt.Sprintf("%s.%d", r.path, n)
+}
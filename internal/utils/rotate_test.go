@@ -0,0 +1,66 @@
+package utils
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestRotatingFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	r, err := OpenRotatingFile(path, 20, 2)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// Each line is 10 bytes, so every two lines fill the file
+	for _, line := range []string{"line-0001", "line-0002", "line-0003", "line-0004", "line-0005", "line-0006", "line-0007"} {
+		if _, err := r.Write([]byte(line + "\n")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	want := map[string]string{
+		path:        "line-0007\n",
+		path + ".1": "line-0005\nline-0006\n",
+		path + ".2": "line-0003\nline-0004\n",
+	}
+	for name, content := range want {
+		data, err := os.ReadFile(name)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if string(data) != content {
+			t.Errorf("%s = %q, want %q", filepath.Base(name), data, content)
+		}
+	}
+	if _, err := os.Stat(path + ".3"); !os.IsNotExist(err) {
+		t.Errorf("more than 2 rotated files kept")
+	}
+}
+
+func TestRotatingFileAppends(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "queries.log")
+	if err := os.WriteFile(path, []byte(strings.Repeat("x", 15)), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	r, err := OpenRotatingFile(path, 20, 1)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+
+	// The existing 15 bytes count towards the limit
+	if _, err := r.Write([]byte("0123456789")); err != nil {
+		t.Fatal(err)
+	}
+	if data, _ := os.ReadFile(path); string(data) != "0123456789" {
+		t.Errorf("current file = %q, want the new write only", data)
+	}
+	if data, _ := os.ReadFile(path + ".1"); len(data) != 15 {
+		t.Errorf("rotated file has %d bytes, want 15", len(data))
+	}
+}
@@ -1,128 +1,321 @@
 package utils
 
 import (
+	"context"
+	"encoding/base64"
+	"encoding/binary"
 	"fmt"
 	"os"
 	"os/exec"
-	"os/user"
-	"path/filepath"
 	"runtime"
+	"strings"
+	"sync"
 	"time"
+	"unicode/utf16"
 )
 
-// SudoSession manages elevated privileges
+// SudoSession manages elevated privileges. It is safe for concurrent use.
+// The session expires timeout after its last use; see SetSudoKeepAlive.
 type SudoSession struct {
-	timeout time.Duration
-	lastUse time.Time
+	mu           sync.Mutex
+	timeout      time.Duration
+	lastUse      time.Time
+	keepingAlive bool
 }
 
 var (
 	// Global sudo session
-	globalSession *SudoSession
+	globalSession     *SudoSession
+	globalSessionOnce sync.Once
 )
 
-// NewSudoSession creates a new sudo session with the specified timeout
+// NewSudoSession returns the process-wide sudo session shared by all callers.
+// The session keeps the longest timeout requested by any caller.
 func NewSudoSession(timeout time.Duration) *SudoSession {
-	if globalSession == nil {
-		globalSession = &SudoSession{
-			timeout: timeout,
-			lastUse: time.Now(),
-		}
+	globalSessionOnce.Do(func() {
+		globalSession = NewIndependentSudoSession(timeout)
+	})
+
+	globalSession.mu.Lock()
+	if timeout > globalSession.timeout {
+		globalSession.timeout = timeout
 	}
+	globalSession.mu.Unlock()
+
 	return globalSession
 }
 
+// NewIndependentSudoSession creates a sudo session that is not shared with
+// other callers
+func NewIndependentSudoSession(timeout time.Duration) *SudoSession {
+	return &SudoSession{
+		timeout: timeout,
+		lastUse: time.Now(),
+	}
+}
+
 // RunWithPrivileges runs a command with elevated privileges
 func (s *SudoSession) RunWithPrivileges(name string, args ...string) error {
+	return s.RunWithPrivilegesContext(context.Background(), name, args...)
+}
+
+// RunWithPrivilegesContext runs a command with elevated privileges, killing
+// it if ctx is done before it finishes
+func (s *SudoSession) RunWithPrivilegesContext(ctx context.Context, name string, args ...string) error {
 	// Update last use time
+	s.mu.Lock()
 	s.lastUse = time.Now()
+	s.mu.Unlock()
+
+	if NoSudo() {
+		return noSudoError(name, args)
+	}
+	if DryRun() {
+		switch runtime.GOOS {
+		case "windows":
+			PrintDryRun("powershell", "-NoProfile", "-NonInteractive", "-Command", elevationScript(name, args))
+		default:
+			if os.Geteuid() == 0 {
+				PrintDryRun(name, args...)
+			} else {
+				PrintDryRun("sudo", append([]string{name}, args...)...)
+			}
+		}
+		return nil
+	}
 
+	Debugf("Running with privileges: %s %s", name, QuoteArgs(args))
+	if err := runElevated(ctx, s, name, args...); err != nil {
+		return err
+	}
+	s.startKeepAlive()
+	return nil
+}
+
+// runElevated executes the command with the platform's elevation mechanism;
+// replaced in tests
+var runElevated = func(ctx context.Context, s *SudoSession, name string, args ...string) error {
 	switch runtime.GOOS {
 	case "darwin", "linux":
-		return s.runUnixSudo(name, args...)
+		return s.runUnixSudo(ctx, name, args...)
 	case "windows":
-		return s.runWindowsElevated(name, args...)
+		return s.runWindowsElevated(ctx, name, args...)
 	default:
 		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
 	}
 }
 
-// runUnixSudo runs a command with sudo on Unix-like systems
-func (s *SudoSession) runUnixSudo(name string, args ...string) error {
-	// Check if we're already running as root
-	currentUser, err := user.Current()
-	if err != nil {
-		return fmt.Errorf("failed to get current user: %w", err)
+// runContext runs cmd, which was created with exec.CommandContext(ctx, ...),
+// reporting a command killed because ctx was done as such rather than by
+// its exit status
+func runContext(ctx context.Context, name string, cmd *exec.Cmd) error {
+	err := cmd.Run()
+	if err == nil {
+		return nil
 	}
 
-	// If we're already root, just run the command
-	if currentUser.Username == "root" {
-		cmd := exec.Command(name, args...)
+	switch ctx.Err() {
+	case context.DeadlineExceeded:
+		return fmt.Errorf("%s did not finish in time and was stopped: %w", name, ctx.Err())
+	case context.Canceled:
+		return fmt.Errorf("%s was canceled: %w", name, ctx.Err())
+	}
+	return err
+}
+
+// StartWithPrivileges starts a long-running command with elevated privileges
+// in the background, detached from the terminal, appending its output to
+// logFile. env is added to the command's environment.
+func (s *SudoSession) StartWithPrivileges(logFile string, env []string, name string, args ...string) error {
+	s.mu.Lock()
+	s.lastUse = time.Now()
+	s.mu.Unlock()
+
+	if NoSudo() {
+		return noSudoError(name, args)
+	}
+	if DryRun() {
+		PrintDryRun(name, args...)
+		return nil
+	}
+
+	switch runtime.GOOS {
+	case "windows":
+		// Start-Process without -Wait returns once the elevated process is running
+		script := "Start-Process -FilePath " + powerShellQuote(name) + " -Verb RunAs -WindowStyle Hidden" + argumentList(args)
+		cmd := exec.Command("powershell", "-NoProfile", "-NonInteractive", "-EncodedCommand", encodePowerShell(script))
 		cmd.Stdout = os.Stdout
 		cmd.Stderr = os.Stderr
 		return cmd.Run()
+	case "darwin", "linux":
+	default:
+		return fmt.Errorf("unsupported operating system: %s", runtime.GOOS)
+	}
+
+	Debugf("Starting in background: %s %s", name, QuoteArgs(args))
+	out, err := os.OpenFile(logFile, os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0644)
+	if err != nil {
+		return fmt.Errorf("failed to open log file: %w", err)
 	}
+	defer out.Close()
 
-	// Create a temporary script to run the command with sudo
-	tempDir := os.TempDir()
-	scriptPath := filepath.Join(tempDir, fmt.Sprintf("proxy_sudo_%d.sh", time.Now().UnixNano()))
+	var cmd *exec.Cmd
+	if os.Geteuid() == 0 {
+		cmd = exec.Command(name, args...)
+	} else {
+		// Cache the sudo credential first, prompting if needed, so the
+		// background command never waits for a password
+		if exec.Command("sudo", "-n", "true").Run() != nil {
+			if useGUIPrompt() {
+				return startGUIElevated(logFile, env, name, args...)
+			}
+			fmt.Println("Requesting elevated privileges for network configuration...")
+			validate := exec.Command("sudo", "-v")
+			validate.Stdin = os.Stdin
+			validate.Stdout = os.Stdout
+			validate.Stderr = os.Stderr
+			if err := validate.Run(); err != nil {
+				return err
+			}
+		}
 
-	// Create the script
-	script := "#!/bin/bash\n"
-	script += fmt.Sprintf("%s %s\n", name, QuoteArgs(args))
+		// sudo resets the environment, so env is passed through env(1)
+		sudoArgs := append(append([]string{"-n", "env"}, env...), name)
+		cmd = exec.Command("sudo", append(sudoArgs, args...)...)
+	}
+	cmd.Env = append(os.Environ(), env...)
+	cmd.Stdout = out
+	cmd.Stderr = out
+	detach(cmd)
 
-	// Write the script to a file
-	if err := os.WriteFile(scriptPath, []byte(script), 0700); err != nil {
-		return fmt.Errorf("failed to create temporary script: %w", err)
+	if err := cmd.Start(); err != nil {
+		return err
 	}
-	defer os.Remove(scriptPath) // Clean up
+	return cmd.Process.Release()
+}
 
-	// Run the script with sudo
-	sudoCmd := exec.Command("sudo", "-n", scriptPath)
-	sudoCmd.Stdout = os.Stdout
-	sudoCmd.Stderr = os.Stderr
+// runUnixSudo runs a command with sudo on Unix-like systems
+func (s *SudoSession) runUnixSudo(ctx context.Context, name string, args ...string) error {
+	// If we're already root, just run the command
+	if os.Geteuid() == 0 {
+		cmd := exec.CommandContext(ctx, name, args...)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return runContext(ctx, name, cmd)
+	}
 
-	// Try to run without password first (if sudo timeout is still valid)
-	if err := sudoCmd.Run(); err == nil {
-		return nil
+	// Pass the command to sudo as an argument vector so nothing is
+	// interpreted by a shell
+	sudoArgs := append([]string{name}, args...)
+
+	// Run without a password prompt if the sudo credential cache is still
+	// valid; checking first avoids running the command twice when it fails
+	if exec.CommandContext(ctx, "sudo", "-n", "true").Run() == nil {
+		sudoCmd := exec.CommandContext(ctx, "sudo", append([]string{"-n"}, sudoArgs...)...)
+		sudoCmd.Stdout = os.Stdout
+		sudoCmd.Stderr = os.Stderr
+		return runContext(ctx, name, sudoCmd)
 	}
 
-	// If sudo -n failed, we need to ask for a password
+	// Otherwise we need to ask for a password; the deadline also covers the
+	// prompt, so a headless run cannot wait for one forever
+	if useGUIPrompt() {
+		return runGUIElevated(ctx, name, args...)
+	}
 	fmt.Println("Requesting elevated privileges for network configuration...")
-	sudoCmd = exec.Command("sudo", scriptPath)
+	sudoCmd := exec.CommandContext(ctx, "sudo", sudoArgs...)
+	sudoCmd.Stdin = os.Stdin
 	sudoCmd.Stdout = os.Stdout
 	sudoCmd.Stderr = os.Stderr
-	return sudoCmd.Run()
+	return runContext(ctx, name, sudoCmd)
 }
 
 // runWindowsElevated runs a command with elevated privileges on Windows
-func (s *SudoSession) runWindowsElevated(name string, args ...string) error {
-	// On Windows, we'll use PowerShell's Start-Process with -Verb RunAs
-	scriptPath := filepath.Join(os.TempDir(), fmt.Sprintf("proxy_elevated_%d.ps1", time.Now().UnixNano()))
+func (s *SudoSession) runWindowsElevated(ctx context.Context, name string, args ...string) error {
+	// The script is passed base64-encoded so no shell parses it; Start-Process
+	// gets the arguments as an array of PowerShell string literals
+	cmd := exec.CommandContext(ctx, "powershell", "-NoProfile", "-NonInteractive", "-EncodedCommand", encodePowerShell(elevationScript(name, args)))
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return runContext(ctx, name, cmd)
+}
 
-	// Create the PowerShell script
-	script := "Start-Process "
-	script += fmt.Sprintf("-FilePath '%s' ", name)
-	if len(args) > 0 {
-		script += fmt.Sprintf("-ArgumentList '%s' ", QuoteArgs(args))
+// elevationScript builds the PowerShell script that runs name with args
+// through UAC and exits with its exit code
+func elevationScript(name string, args []string) string {
+	script := "$p = Start-Process -FilePath " + powerShellQuote(name) + " -Verb RunAs -Wait -PassThru" + argumentList(args)
+	return script + "; exit $p.ExitCode"
+}
+
+// argumentList returns the -ArgumentList parameter of Start-Process for args
+func argumentList(args []string) string {
+	if len(args) == 0 {
+		return ""
 	}
-	script += "-Verb RunAs -Wait"
 
-	// Write the script to a file
-	if err := os.WriteFile(scriptPath, []byte(script), 0700); err != nil {
-		return fmt.Errorf("failed to create temporary script: %w", err)
+	// Start-Process joins -ArgumentList with spaces, so each element is
+	// first quoted for the Windows command line
+	quoted := make([]string, len(args))
+	for i, arg := range args {
+		quoted[i] = powerShellQuote(windowsEscapeArg(arg))
 	}
-	defer os.Remove(scriptPath) // Clean up
+	return " -ArgumentList @(" + strings.Join(quoted, ", ") + ")"
+}
 
-	// Run the PowerShell script
-	cmd := exec.Command("powershell", "-ExecutionPolicy", "Bypass", "-File", scriptPath)
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	return cmd.Run()
+// powerShellQuote returns s as a single-quoted PowerShell string literal
+func powerShellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", "''") + "'"
+}
+
+// windowsEscapeArg quotes an argument following the CommandLineToArgvW rules
+func windowsEscapeArg(s string) string {
+	if s == "" {
+		return `""`
+	}
+	if !strings.ContainsAny(s, " \t\n\v\"") {
+		return s
+	}
+
+	var b strings.Builder
+	b.WriteByte('"')
+	slashes := 0
+	for i := 0; i < len(s); i++ {
+		c := s[i]
+		switch c {
+		case '\\':
+			slashes++
+		case '"':
+			// Backslashes before a quote must be doubled, plus one for the quote
+			for ; slashes > 0; slashes-- {
+				b.WriteByte('\\')
+			}
+			b.WriteByte('\\')
+		default:
+			slashes = 0
+		}
+		b.WriteByte(c)
+	}
+	for ; slashes > 0; slashes-- {
+		b.WriteByte('\\')
+	}
+	b.WriteByte('"')
+	return b.String()
+}
+
+// encodePowerShell encodes a script for powershell -EncodedCommand (base64 of UTF-16LE)
+func encodePowerShell(script string) string {
+	units := utf16.Encode([]rune(script))
+	buf := make([]byte, 2*len(units))
+	for i, u := range units {
+		binary.LittleEndian.PutUint16(buf[2*i:], u)
+	}
+	return base64.StdEncoding.EncodeToString(buf)
 }
 
 // IsExpired checks if the sudo session has expired
 func (s *SudoSession) IsExpired() bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
 	return time.Since(s.lastUse) > s.timeout
 }
 
@@ -0,0 +1,122 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+func TestNewSudoSessionIsShared(t *testing.T) {
+	a := NewSudoSession(time.Minute)
+	b := NewSudoSession(time.Hour)
+	if a != b {
+		t.Fatal("NewSudoSession returned different sessions")
+	}
+	if a.IsExpired() {
+		t.Error("fresh session reported as expired")
+	}
+
+	// The shorter timeout does not shrink the shared session
+	NewSudoSession(time.Second)
+	a.mu.Lock()
+	timeout := a.timeout
+	a.mu.Unlock()
+	if timeout < time.Hour {
+		t.Errorf("shared session timeout = %v, want at least 1h", timeout)
+	}
+}
+
+func TestRunWithPrivilegesConcurrent(t *testing.T) {
+	oldRun := runElevated
+	defer func() { runElevated = oldRun }()
+
+	var calls int32
+	runElevated = func(ctx context.Context, s *SudoSession, name string, args ...string) error {
+		atomic.AddInt32(&calls, 1)
+		return nil
+	}
+
+	const workers = 32
+	var wg sync.WaitGroup
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			session := NewSudoSession(time.Duration(i+1) * time.Minute)
+			if err := session.RunWithPrivileges("true", "arg"); err != nil {
+				t.Errorf("RunWithPrivileges: %v", err)
+			}
+			session.IsExpired()
+		}(i)
+	}
+	wg.Wait()
+
+	if calls != workers {
+		t.Errorf("elevated %d commands, want %d", calls, workers)
+	}
+}
+
+func TestRunContextReportsTimeout(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses sleep(1)")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+
+	start := time.Now()
+	err := runContext(ctx, "sleep", exec.CommandContext(ctx, "sleep", "10"))
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("runContext error = %v, want a deadline error", err)
+	}
+	if !strings.Contains(err.Error(), "sleep did not finish in time") {
+		t.Errorf("error %q does not name the command", err)
+	}
+	if elapsed := time.Since(start); elapsed > 5*time.Second {
+		t.Errorf("command was not killed at the deadline (ran for %v)", elapsed)
+	}
+}
+
+func TestWindowsEscapeArg(t *testing.T) {
+	tests := map[string]string{
+		"":                `""`,
+		"plain":           "plain",
+		"with space":      `"with space"`,
+		`say "hi"`:        `"say \"hi\""`,
+		`C:\dir\`:         `C:\dir\`,
+		`C:\my dir\`:      `"C:\my dir\\"`,
+		`a\"b`:            `"a\\\"b"`,
+		"Wi-Fi 2":         `"Wi-Fi 2"`,
+		"gateway=1.2.3.4": "gateway=1.2.3.4",
+	}
+	for in, want := range tests {
+		if got := windowsEscapeArg(in); got != want {
+			t.Errorf("windowsEscapeArg(%q) = %s, want %s", in, got, want)
+		}
+	}
+}
+
+func TestElevationScript(t *testing.T) {
+	got := elevationScript("netsh", []string{"interface", "name=Wi-Fi 2", "it's"})
+	want := `$p = Start-Process -FilePath 'netsh' -Verb RunAs -Wait -PassThru -ArgumentList @('interface', '"name=Wi-Fi 2"', 'it''s'); exit $p.ExitCode`
+	if got != want {
+		t.Errorf("elevationScript =\n%s\nwant\n%s", got, want)
+	}
+
+	if got := elevationScript("ipconfig", nil); got != `$p = Start-Process -FilePath 'ipconfig' -Verb RunAs -Wait -PassThru; exit $p.ExitCode` {
+		t.Errorf("elevationScript without args = %s", got)
+	}
+}
+
+func TestEncodePowerShell(t *testing.T) {
+	// "ab" in UTF-16LE is 61 00 62 00
+	if got := encodePowerShell("ab"); got != "YQBiAA==" {
+		t.Errorf("encodePowerShell = %s", got)
+	}
+}
@@ -0,0 +1,176 @@
+package utils
+
+import (
+	"os"
+	"os/exec"
+	"path/filepath"
+	"runtime"
+	"strings"
+	"sync/atomic"
+	"time"
+)
+
+// sudoKeepAlive is non-zero when the sudo credential is kept fresh for the
+// session window
+var sudoKeepAlive int32
+
+// SetSudoKeepAlive enables or disables keeping the sudo credential fresh.
+//
+// When enabled, the first successful privileged command of a session
+// refreshes sudo's timestamp at once and then in the background until the
+// session has not been used for its timeout. The time of the last privileged
+// command is recorded, so a later GateShift command started within the
+// session timeout refreshes the timestamp as it starts (see
+// ResumeSudoSession) and does not prompt again, as long as sudo's own
+// timestamp has not expired in between. The tradeoff: while the timestamp is
+// fresh, any process of the user allowed to use it (on the same terminal,
+// unless sudo's timestamp_type is global) can run sudo without a password.
+// It is off by default.
+func SetSudoKeepAlive(enabled bool) {
+	var v int32
+	if enabled {
+		v = 1
+	}
+	atomic.StoreInt32(&sudoKeepAlive, v)
+}
+
+// SudoKeepAlive reports whether the sudo credential is kept fresh
+func SudoKeepAlive() bool {
+	return atomic.LoadInt32(&sudoKeepAlive) != 0
+}
+
+// keepAliveInterval is how often the sudo timestamp is refreshed; well
+// below sudo's default timestamp_timeout of 5 minutes
+var keepAliveInterval = time.Minute
+
+// refreshSudo extends sudo's timestamp without prompting; replaced in tests
+var refreshSudo = func() error {
+	return exec.Command("sudo", "-n", "-v").Run()
+}
+
+// keepAliveSupported reports whether sudo's timestamp can be kept fresh: not
+// on Windows, which has no sudo, nor as root, which needs none; replaced in
+// tests
+var keepAliveSupported = func() bool {
+	return runtime.GOOS != "windows" && os.Geteuid() != 0
+}
+
+// sudoSessionPath is where the time of the last privileged command is
+// recorded for later commands; replaced in tests
+var sudoSessionPath = func() string {
+	return filepath.Join(ConfigDir(), "sudo-session")
+}
+
+// recordSudoUse records t as the time of the last privileged command
+func recordSudoUse(t time.Time) {
+	path := sudoSessionPath()
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		Debugf("Could not record the sudo session: %v", err)
+		return
+	}
+	if err := os.WriteFile(path, []byte(t.Format(time.RFC3339Nano)), 0600); err != nil {
+		Debugf("Could not record the sudo session: %v", err)
+	}
+}
+
+// lastSudoUse returns the time recorded by recordSudoUse, reporting false
+// when there is none
+func lastSudoUse() (time.Time, bool) {
+	data, err := os.ReadFile(sudoSessionPath())
+	if err != nil {
+		return time.Time{}, false
+	}
+	t, err := time.Parse(time.RFC3339Nano, strings.TrimSpace(string(data)))
+	if err != nil {
+		return time.Time{}, false
+	}
+	return t, true
+}
+
+// startKeepAlive records a successful privileged command and, if enabled,
+// starts keeping the sudo timestamp fresh unless it already is
+func (s *SudoSession) startKeepAlive() {
+	if !SudoKeepAlive() || !keepAliveSupported() {
+		return
+	}
+	recordSudoUse(time.Now())
+	s.beginKeepAlive()
+}
+
+// ResumeSudoSession continues keeping the sudo timestamp fresh for the
+// process-wide session when keep-alive is enabled and an earlier command's
+// last privileged command ran less than timeout ago. The timestamp is
+// refreshed at once, before sudo's own timeout can expire it while this
+// command runs, and then until timeout after that privileged command.
+func ResumeSudoSession(timeout time.Duration) {
+	resumeSudoSession(NewSudoSession(timeout))
+}
+
+// resumeSudoSession is ResumeSudoSession for s
+func resumeSudoSession(s *SudoSession) {
+	if !SudoKeepAlive() || !keepAliveSupported() || NoSudo() || DryRun() {
+		return
+	}
+	last, ok := lastSudoUse()
+	if !ok {
+		return
+	}
+
+	s.mu.Lock()
+	if time.Since(last) > s.timeout {
+		s.mu.Unlock()
+		return
+	}
+	if last.Before(s.lastUse) {
+		s.lastUse = last
+	}
+	s.mu.Unlock()
+
+	Debugf("Resuming the sudo session of %s", last.Format(time.RFC3339))
+	s.beginKeepAlive()
+}
+
+// beginKeepAlive refreshes the sudo timestamp at once and then in the
+// background, unless that is already being done
+func (s *SudoSession) beginKeepAlive() {
+	s.mu.Lock()
+	if s.keepingAlive {
+		s.mu.Unlock()
+		return
+	}
+	s.keepingAlive = true
+	s.mu.Unlock()
+
+	if err := refreshSudo(); err != nil {
+		Debugf("Could not refresh the sudo credential: %v", err)
+		s.mu.Lock()
+		s.keepingAlive = false
+		s.mu.Unlock()
+		return
+	}
+	go s.keepAlive(keepAliveInterval)
+}
+
+// keepAlive refreshes the sudo timestamp every interval until the session
+// expires or the credential can no longer be refreshed without a password
+func (s *SudoSession) keepAlive(interval time.Duration) {
+	defer func() {
+		s.mu.Lock()
+		s.keepingAlive = false
+		s.mu.Unlock()
+	}()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for range ticker.C {
+		if s.IsExpired() {
+			Debugf("Sudo session expired, no longer refreshing the credential")
+			return
+		}
+		if err := refreshSudo(); err != nil {
+			Debugf("Could not refresh the sudo credential: %v", err)
+			return
+		}
+	}
+}
@@ -0,0 +1,209 @@
+package utils
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"sync/atomic"
+	"testing"
+	"time"
+)
+
+// stubRefresh replaces the sudo refresh and its interval for a test,
+// counting refreshes in calls
+func stubRefresh(t *testing.T, err error, calls *int32) {
+	oldRefresh, oldInterval := refreshSudo, keepAliveInterval
+	t.Cleanup(func() { refreshSudo, keepAliveInterval = oldRefresh, oldInterval })
+
+	keepAliveInterval = 5 * time.Millisecond
+	refreshSudo = func() error {
+		atomic.AddInt32(calls, 1)
+		return err
+	}
+}
+
+func TestKeepAliveStopsWhenSessionExpires(t *testing.T) {
+	var calls int32
+	stubRefresh(t, nil, &calls)
+
+	s := NewIndependentSudoSession(50 * time.Millisecond)
+	s.keepingAlive = true
+	done := make(chan struct{})
+	go func() {
+		s.keepAlive(keepAliveInterval)
+		close(done)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("keepAlive did not stop after the session expired")
+	}
+	if n := atomic.LoadInt32(&calls); n == 0 {
+		t.Error("credential was never refreshed before the session expired")
+	}
+	if !s.IsExpired() {
+		t.Error("keepAlive stopped before the session expired")
+	}
+	if s.keepingAlive {
+		t.Error("keepingAlive still set after keepAlive returned")
+	}
+}
+
+func TestKeepAliveStopsWhenRefreshFails(t *testing.T) {
+	var calls int32
+	stubRefresh(t, errors.New("a password is required"), &calls)
+
+	s := NewIndependentSudoSession(time.Hour)
+	s.keepAlive(keepAliveInterval)
+	if calls != 1 {
+		t.Errorf("refreshed %d times after a failure, want 1", calls)
+	}
+}
+
+func TestKeepAliveDisabledByDefault(t *testing.T) {
+	var calls int32
+	stubRefresh(t, nil, &calls)
+
+	if SudoKeepAlive() {
+		t.Fatal("keep-alive enabled by default")
+	}
+	s := NewIndependentSudoSession(time.Hour)
+	s.startKeepAlive()
+	time.Sleep(20 * time.Millisecond)
+	if calls != 0 || s.keepingAlive {
+		t.Errorf("disabled keep-alive refreshed %d times", calls)
+	}
+}
+
+// enableKeepAlive turns keep-alive on for a test as a user who is not root,
+// recording the sudo session in a temporary file
+func enableKeepAlive(t *testing.T) string {
+	oldSupported, oldPath := keepAliveSupported, sudoSessionPath
+	path := filepath.Join(t.TempDir(), "sudo-session")
+	t.Cleanup(func() {
+		keepAliveSupported, sudoSessionPath = oldSupported, oldPath
+		SetSudoKeepAlive(false)
+	})
+
+	keepAliveSupported = func() bool { return true }
+	sudoSessionPath = func() string { return path }
+	SetSudoKeepAlive(true)
+	return path
+}
+
+func TestFirstElevationRefreshesAtOnce(t *testing.T) {
+	var calls int32
+	stubRefresh(t, nil, &calls)
+	keepAliveInterval = time.Hour
+	enableKeepAlive(t)
+
+	oldRun := runElevated
+	defer func() { runElevated = oldRun }()
+	runElevated = func(ctx context.Context, s *SudoSession, name string, args ...string) error { return nil }
+
+	s := NewIndependentSudoSession(time.Hour)
+	before := time.Now()
+	if err := s.RunWithPrivileges("true"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("refreshed %d times after the first elevation, want 1 at once", n)
+	}
+	last, ok := lastSudoUse()
+	if !ok || last.Before(before) {
+		t.Errorf("last sudo use = %v, %v, want the time of the elevation", last, ok)
+	}
+
+	// Later elevations only record their time while the refresh runs
+	if err := s.RunWithPrivileges("true"); err != nil {
+		t.Fatal(err)
+	}
+	if n := atomic.LoadInt32(&calls); n != 1 {
+		t.Errorf("refreshed %d times after the second elevation, want 1", n)
+	}
+}
+
+func TestFailedElevationIsNotRecorded(t *testing.T) {
+	var calls int32
+	stubRefresh(t, nil, &calls)
+	path := enableKeepAlive(t)
+
+	oldRun := runElevated
+	defer func() { runElevated = oldRun }()
+	runElevated = func(ctx context.Context, s *SudoSession, name string, args ...string) error {
+		return errors.New("incorrect password")
+	}
+
+	s := NewIndependentSudoSession(time.Hour)
+	if err := s.RunWithPrivileges("true"); err == nil {
+		t.Fatal("expected the elevation to fail")
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Error("failed elevation recorded")
+	}
+	if calls != 0 {
+		t.Errorf("refreshed %d times after a failed elevation", calls)
+	}
+}
+
+func TestResumeSudoSession(t *testing.T) {
+	tests := []struct {
+		name    string
+		lastUse time.Duration // ago; zero records none
+		want    int32
+	}{
+		{"recent command", 3 * time.Minute, 1},
+		{"expired session", 20 * time.Minute, 0},
+		{"no earlier command", 0, 0},
+	}
+
+	for _, tt := range tests {
+		var calls int32
+		stubRefresh(t, nil, &calls)
+		keepAliveInterval = time.Hour
+		path := enableKeepAlive(t)
+		if tt.lastUse != 0 {
+			recordSudoUse(time.Now().Add(-tt.lastUse))
+		}
+
+		s := NewIndependentSudoSession(15 * time.Minute)
+		resumeSudoSession(s)
+		if n := atomic.LoadInt32(&calls); n != tt.want {
+			t.Errorf("%s: refreshed %d times, want %d", tt.name, n, tt.want)
+		}
+		// The resumed session ends timeout after the earlier command
+		if tt.want > 0 {
+			s.mu.Lock()
+			ends := s.lastUse.Add(s.timeout)
+			s.mu.Unlock()
+			if until := time.Until(ends); until > 13*time.Minute {
+				t.Errorf("%s: session ends in %v, want 12 minutes after the earlier command", tt.name, until)
+			}
+		}
+		os.Remove(path)
+	}
+}
+
+func TestResumeSudoSessionDisabled(t *testing.T) {
+	var calls int32
+	stubRefresh(t, nil, &calls)
+	enableKeepAlive(t)
+	recordSudoUse(time.Now())
+
+	for name, setup := range map[string]func(){
+		"keep-alive off": func() { SetSudoKeepAlive(false) },
+		"--no-sudo":      func() { SetNoSudo(true) },
+		"--dry-run":      func() { SetDryRun(true) },
+	} {
+		SetSudoKeepAlive(true)
+		setup()
+		resumeSudoSession(NewIndependentSudoSession(time.Hour))
+		SetNoSudo(false)
+		SetDryRun(false)
+		if calls != 0 {
+			t.Errorf("%s: refreshed %d times", name, calls)
+		}
+	}
+}
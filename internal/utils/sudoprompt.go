@@ -0,0 +1,173 @@
+package utils
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"runtime"
+	"strings"
+	"sync"
+)
+
+// Ways to ask for the password when elevated privileges are needed
+const (
+	// SudoPromptAuto uses the terminal when there is one and a graphical
+	// prompt otherwise
+	SudoPromptAuto = "auto"
+	// SudoPromptTerminal always asks on the terminal
+	SudoPromptTerminal = "terminal"
+	// SudoPromptGUI always uses a graphical prompt: SUDO_ASKPASS with
+	// sudo -A on Linux, an administrator dialog on macOS
+	SudoPromptGUI = "gui"
+)
+
+var (
+	sudoPromptMu sync.Mutex
+	sudoPrompt   = SudoPromptAuto
+)
+
+// ValidateSudoPrompt checks that mode is a known password prompt mode
+func ValidateSudoPrompt(mode string) error {
+	switch mode {
+	case SudoPromptAuto, SudoPromptTerminal, SudoPromptGUI:
+		return nil
+	}
+	return fmt.Errorf("invalid sudo prompt %q (must be %s, %s or %s)", mode, SudoPromptAuto, SudoPromptTerminal, SudoPromptGUI)
+}
+
+// SetSudoPrompt sets how the password is asked for when elevated privileges
+// are needed. It has no effect on Windows, where UAC always prompts.
+func SetSudoPrompt(mode string) error {
+	if err := ValidateSudoPrompt(mode); err != nil {
+		return err
+	}
+	sudoPromptMu.Lock()
+	defer sudoPromptMu.Unlock()
+	sudoPrompt = mode
+	return nil
+}
+
+// SudoPrompt returns the password prompt mode
+func SudoPrompt() string {
+	sudoPromptMu.Lock()
+	defer sudoPromptMu.Unlock()
+	return sudoPrompt
+}
+
+// hasTerminal reports whether the process has a controlling terminal to ask
+// for a password on; replaced in tests
+var hasTerminal = func() bool {
+	tty, err := os.Open("/dev/tty")
+	if err != nil {
+		return false
+	}
+	tty.Close()
+	return true
+}
+
+// useGUIPrompt reports whether the password should be asked for with a
+// graphical prompt rather than on the terminal
+func useGUIPrompt() bool {
+	switch SudoPrompt() {
+	case SudoPromptGUI:
+		return true
+	case SudoPromptTerminal:
+		return false
+	default:
+		return !hasTerminal()
+	}
+}
+
+// askpassPrograms are graphical askpass helpers looked for when SUDO_ASKPASS
+// is not set
+var askpassPrograms = []string{
+	"ssh-askpass",
+	"ksshaskpass",
+	"lxqt-openssh-askpass",
+	"/usr/lib/ssh/ssh-askpass",
+	"/usr/libexec/openssh/gnome-ssh-askpass",
+	"/usr/libexec/openssh/ssh-askpass",
+}
+
+// askpassProgram returns the askpass helper for sudo -A
+func askpassProgram() (string, error) {
+	if askpass := os.Getenv("SUDO_ASKPASS"); askpass != "" {
+		return askpass, nil
+	}
+	for _, name := range askpassPrograms {
+		if path, err := exec.LookPath(name); err == nil {
+			return path, nil
+		}
+	}
+	return "", fmt.Errorf("no terminal to ask for the sudo password on and no askpass program found; set SUDO_ASKPASS to a graphical askpass program or run from a terminal")
+}
+
+// guiCommand returns the command that runs script, a /bin/sh command line,
+// as root after asking for the password with a graphical prompt
+func guiCommand(ctx context.Context, script string) (*exec.Cmd, error) {
+	switch runtime.GOOS {
+	case "darwin":
+		return exec.CommandContext(ctx, "osascript", "-e", adminAppleScript(script)), nil
+	case "linux":
+		askpass, err := askpassProgram()
+		if err != nil {
+			return nil, err
+		}
+		cmd := exec.CommandContext(ctx, "sudo", "-A", "/bin/sh", "-c", script)
+		cmd.Env = append(os.Environ(), "SUDO_ASKPASS="+askpass)
+		return cmd, nil
+	default:
+		return nil, fmt.Errorf("graphical password prompt is not supported on %s", runtime.GOOS)
+	}
+}
+
+// runGUIElevated runs a command as root, asking for the password with a
+// graphical prompt
+func runGUIElevated(ctx context.Context, name string, args ...string) error {
+	cmd, err := guiCommand(ctx, shellCommand(name, args))
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return runContext(ctx, name, cmd)
+}
+
+// startGUIElevated starts a long-running command as root in the background,
+// asking for the password with a graphical prompt, and appends its output to
+// logFile. env is added to the command's environment.
+func startGUIElevated(logFile string, env []string, name string, args ...string) error {
+	// The shell started as root backgrounds the command and returns, so the
+	// prompt is the only thing waited for
+	envArgs := append(append(append([]string{}, env...), name), args...)
+	script := shellCommand("env", envArgs) + " >> " + shellQuote(logFile) + " 2>&1 < /dev/null &"
+	cmd, err := guiCommand(context.Background(), script)
+	if err != nil {
+		return err
+	}
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+	return cmd.Run()
+}
+
+// adminAppleScript returns the AppleScript that runs script through an
+// administrator privileges dialog
+func adminAppleScript(script string) string {
+	quoted := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(script)
+	return `do shell script "` + quoted + `" with administrator privileges`
+}
+
+// shellCommand returns name and args as a /bin/sh command line
+func shellCommand(name string, args []string) string {
+	words := make([]string, 0, len(args)+1)
+	for _, word := range append([]string{name}, args...) {
+		words = append(words, shellQuote(word))
+	}
+	return strings.Join(words, " ")
+}
+
+// shellQuote returns s as a single-quoted /bin/sh word
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
@@ -0,0 +1,74 @@
+package utils
+
+import (
+	"os/exec"
+	"runtime"
+	"strings"
+	"testing"
+)
+
+func TestUseGUIPrompt(t *testing.T) {
+	oldTerminal := hasTerminal
+	defer func() {
+		hasTerminal = oldTerminal
+		SetSudoPrompt(SudoPromptAuto)
+	}()
+
+	tests := []struct {
+		mode     string
+		terminal bool
+		want     bool
+	}{
+		{SudoPromptAuto, true, false},
+		{SudoPromptAuto, false, true},
+		{SudoPromptTerminal, false, false},
+		{SudoPromptGUI, true, true},
+	}
+	for _, tt := range tests {
+		terminal := tt.terminal
+		hasTerminal = func() bool { return terminal }
+		if err := SetSudoPrompt(tt.mode); err != nil {
+			t.Fatal(err)
+		}
+		if got := useGUIPrompt(); got != tt.want {
+			t.Errorf("useGUIPrompt() with %s, terminal %v = %v, want %v", tt.mode, tt.terminal, got, tt.want)
+		}
+	}
+
+	if err := SetSudoPrompt("dialog"); err == nil {
+		t.Error("SetSudoPrompt accepted an invalid mode")
+	}
+	if SudoPrompt() != SudoPromptGUI {
+		t.Errorf("invalid mode changed the prompt to %s", SudoPrompt())
+	}
+}
+
+func TestShellCommandQuoting(t *testing.T) {
+	if runtime.GOOS == "windows" {
+		t.Skip("uses /bin/sh")
+	}
+
+	args := []string{"it's", "a b", `"$HOME"`, "`id`", `back\slash`}
+	out, err := exec.Command("/bin/sh", "-c", shellCommand("printf", append([]string{`%s\n`}, args...))).Output()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := strings.Split(strings.TrimSuffix(string(out), "\n"), "\n"); strings.Join(got, "|") != strings.Join(args, "|") {
+		t.Errorf("shell received %q, want %q", got, args)
+	}
+}
+
+func TestAdminAppleScript(t *testing.T) {
+	got := adminAppleScript(shellCommand("route", []string{"-n", `a"b\c`}))
+	want := `do shell script "'route' '-n' 'a\"b\\c'" with administrator privileges`
+	if got != want {
+		t.Errorf("adminAppleScript = %s, want %s", got, want)
+	}
+}
+
+func TestAskpassProgramPrefersEnvironment(t *testing.T) {
+	t.Setenv("SUDO_ASKPASS", "/opt/askpass")
+	if got, err := askpassProgram(); err != nil || got != "/opt/askpass" {
+		t.Errorf("askpassProgram() = %q, %v, want /opt/askpass", got, err)
+	}
+}
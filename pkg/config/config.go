@@ -1,54 +1,246 @@
 package config
 
 import (
+	"errors"
 	"fmt"
 	"net"
 	"os"
 	"path/filepath"
+	"reflect"
+	"strings"
+	"time"
 
 	"github.com/spf13/viper"
+
+	"github.com/ourines/GateShift/internal/dns"
+	"github.com/ourines/GateShift/internal/utils"
 )
 
-// Config holds all configuration for the application
+// Config holds all configuration for the application. proxy_gateway may be a
+// single address or a list tried in order.
 type Config struct {
-	ProxyGateway   string    `mapstructure:"proxy_gateway"`
-	DefaultGateway string    `mapstructure:"default_gateway"`
-	DNS            DNSConfig `mapstructure:"dns"`
+	ProxyGateways  []string    `mapstructure:"proxy_gateway"`
+	DefaultGateway string      `mapstructure:"default_gateway"`
+	DNS            DNSConfig   `mapstructure:"dns"`
+	Hooks          HooksConfig `mapstructure:"hooks"`
+	Notifications  bool        `mapstructure:"notifications"`
+	SudoPrompt     string      `mapstructure:"sudo_prompt"`
+	SudoKeepAlive  bool        `mapstructure:"sudo_keepalive"`
+}
+
+// HooksConfig holds commands run after a gateway switch
+type HooksConfig struct {
+	OnProxy   string        `mapstructure:"on_proxy"`
+	OnDefault string        `mapstructure:"on_default"`
+	Timeout   time.Duration `mapstructure:"timeout"`
 }
 
 // DNSConfig holds DNS proxy configuration
 type DNSConfig struct {
-	ListenAddr  string   `mapstructure:"listen_addr"`
-	UpstreamDNS []string `mapstructure:"upstream_dns"`
+	ListenAddr         string   `mapstructure:"listen_addr"`
+	ListenPort         int      `mapstructure:"listen_port"`
+	UpstreamDNS        []string `mapstructure:"upstream_dns"`
+	FallbackDNS        string   `mapstructure:"fallback_dns"`
+	Strategy           string   `mapstructure:"strategy"`
+	MetricsAddr        string   `mapstructure:"metrics_addr"`
+	LogFormat          string   `mapstructure:"log_format"`
+	ControlAddr        string   `mapstructure:"control_addr"`
+	FilterAAAA         bool     `mapstructure:"filter_aaaa"`
+	QueryLogMaxSize    int      `mapstructure:"query_log_max_size"`
+	QueryLogKeep       int      `mapstructure:"query_log_keep"`
+	AllNetworkServices bool     `mapstructure:"all_network_services"`
+	Blocklist          []string `mapstructure:"blocklist"`
+	BlocklistResponse  string   `mapstructure:"blocklist_response"`
+	// Recursion is how the recursion desired bit of client queries is
+	// handled: forward, force, strip or require
+	Recursion string `mapstructure:"recursion"`
+	// HostsFile is a hosts-format file answering A and AAAA queries for the
+	// names it lists; empty disables it
+	HostsFile string `mapstructure:"hosts_file"`
+	// IdleTimeout stops the DNS service after this long without queries;
+	// zero disables it
+	IdleTimeout time.Duration `mapstructure:"idle_timeout"`
+	// UpstreamRetries is how many times a query to an upstream that timed
+	// out is sent again, waiting RetryBackoff before the first retry and
+	// twice as long before each further one
+	UpstreamRetries int           `mapstructure:"upstream_retries"`
+	RetryBackoff    time.Duration `mapstructure:"retry_backoff"`
+	// CacheMinTTL and CacheMaxTTL clamp how long responses are cached,
+	// whatever their record TTLs; zero leaves that side unbounded
+	CacheMinTTL time.Duration `mapstructure:"cache_min_ttl"`
+	CacheMaxTTL time.Duration `mapstructure:"cache_max_ttl"`
+	// ServeStaleMaxAge answers queries the upstreams fail from cache entries
+	// that expired up to this long ago; zero disables it
+	ServeStaleMaxAge time.Duration `mapstructure:"serve_stale_max_age"`
+	// QNAMEMinimization looks up the parent of each name before forwarding
+	// it, answering NXDOMAIN without sending the name when the parent does
+	// not exist
+	QNAMEMinimization bool `mapstructure:"qname_minimization"`
+	// MaxInflight is how many queries are processed at once; queries
+	// received beyond it are dropped until some are answered
+	MaxInflight int `mapstructure:"max_inflight"`
 }
 
-// Validate checks if the configuration is valid
+// Validate checks if the configuration is valid. It reports every problem
+// found, joined with errors.Join; use Problems to list them.
 func (c *Config) Validate() error {
+	var errs []error
+
+	// 验证 IP 地址格式
 	if c.DefaultGateway == "" {
-		return fmt.Errorf("default gateway is required")
+		errs = append(errs, fmt.Errorf("default gateway is required"))
+	} else if net.ParseIP(c.DefaultGateway) == nil {
+		errs = append(errs, fmt.Errorf("invalid default gateway IP address: %s", c.DefaultGateway))
+	}
+	if len(c.ProxyGateways) == 0 {
+		errs = append(errs, fmt.Errorf("proxy gateway is required"))
 	}
-	if c.ProxyGateway == "" {
-		return fmt.Errorf("proxy gateway is required")
+	seen := make(map[string]bool, len(c.ProxyGateways))
+	for _, gw := range c.ProxyGateways {
+		if net.ParseIP(gw) == nil {
+			errs = append(errs, fmt.Errorf("invalid proxy gateway IP address: %s", gw))
+		} else if seen[gw] {
+			errs = append(errs, fmt.Errorf("duplicate proxy gateway: %s", gw))
+		}
+		seen[gw] = true
 	}
 
-	// 验证 IP 地址格式
-	if net.ParseIP(c.DefaultGateway) == nil {
-		return fmt.Errorf("invalid default gateway IP address: %s", c.DefaultGateway)
+	if c.Hooks.Timeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid hook timeout: %v", c.Hooks.Timeout))
+	}
+
+	if c.SudoPrompt != "" {
+		errs = append(errs, utils.ValidateSudoPrompt(c.SudoPrompt))
+	}
+
+	errs = append(errs, Problems(c.DNS.Validate())...)
+	return errors.Join(errs...)
+}
+
+// normalizeUpstreams adds the default port 53 to the upstream and fallback
+// servers written without one, as hand-edited or older configuration files
+// may have them. Entries that are not valid are kept for Validate to report.
+func (d *DNSConfig) normalizeUpstreams() {
+	for i, server := range d.UpstreamDNS {
+		if normalized, err := dns.NormalizeUpstream(server); err == nil {
+			d.UpstreamDNS[i] = normalized
+		}
+	}
+	if d.FallbackDNS != "" {
+		if normalized, err := dns.NormalizeUpstream(d.FallbackDNS); err == nil {
+			d.FallbackDNS = normalized
+		}
+	}
+}
+
+// Validate checks if the DNS configuration is valid, reporting every problem
+// found like Config.Validate
+func (d *DNSConfig) Validate() error {
+	var errs []error
+
+	if net.ParseIP(d.ListenAddr) == nil {
+		errs = append(errs, fmt.Errorf("invalid DNS listen address: %s", d.ListenAddr))
+	}
+	if d.ListenPort < 1 || d.ListenPort > 65535 {
+		errs = append(errs, fmt.Errorf("invalid DNS listen port: %d", d.ListenPort))
+	}
+
+	// 上游服务器与选择策略的校验规则由 DNS 代理定义
+	for _, server := range d.UpstreamDNS {
+		errs = append(errs, dns.ValidateUpstream(server))
+	}
+
+	if d.FallbackDNS != "" {
+		if err := dns.ValidateUpstream(d.FallbackDNS); err != nil {
+			errs = append(errs, fmt.Errorf("invalid fallback DNS server: %w", err))
+		}
 	}
-	if net.ParseIP(c.ProxyGateway) == nil {
-		return fmt.Errorf("invalid proxy gateway IP address: %s", c.ProxyGateway)
+
+	if d.Strategy != "" {
+		errs = append(errs, dns.ValidateStrategy(d.Strategy))
 	}
 
-	return nil
+	for _, domain := range d.Blocklist {
+		if _, err := dns.NormalizeBlockedDomain(domain); err != nil {
+			errs = append(errs, fmt.Errorf("invalid blocklist entry: %w", err))
+		}
+	}
+	if d.BlocklistResponse != "" {
+		errs = append(errs, dns.ValidateBlockResponse(d.BlocklistResponse))
+	}
+	if d.Recursion != "" {
+		errs = append(errs, dns.ValidateRecursionMode(d.Recursion))
+	}
+	if d.HostsFile != "" && !filepath.IsAbs(d.HostsFile) {
+		errs = append(errs, fmt.Errorf("hosts file must be an absolute path: %s", d.HostsFile))
+	}
+
+	if d.LogFormat != "" && d.LogFormat != "text" && d.LogFormat != "json" {
+		errs = append(errs, fmt.Errorf("invalid DNS log format: %s (must be text or json)", d.LogFormat))
+	}
+
+	if d.QueryLogMaxSize < 1 {
+		errs = append(errs, fmt.Errorf("invalid query log size: %d MB (must be at least 1)", d.QueryLogMaxSize))
+	}
+	if d.QueryLogKeep < 0 {
+		errs = append(errs, fmt.Errorf("invalid number of query logs to keep: %d", d.QueryLogKeep))
+	}
+	if d.IdleTimeout < 0 {
+		errs = append(errs, fmt.Errorf("invalid DNS idle timeout: %v", d.IdleTimeout))
+	}
+	errs = append(errs, dns.ValidateRetries(d.UpstreamRetries, d.RetryBackoff))
+	errs = append(errs, dns.ValidateCacheTTL(d.CacheMinTTL, d.CacheMaxTTL))
+	errs = append(errs, dns.ValidateServeStale(d.ServeStaleMaxAge))
+	errs = append(errs, dns.ValidateMaxInflight(d.MaxInflight))
+
+	if d.ControlAddr != "" {
+		host, _, err := net.SplitHostPort(d.ControlAddr)
+		if err != nil {
+			errs = append(errs, fmt.Errorf("invalid control API address %s: %w", d.ControlAddr, err))
+		} else if ip := net.ParseIP(host); ip == nil || !ip.IsLoopback() {
+			errs = append(errs, fmt.Errorf("control API address must be a loopback address: %s", d.ControlAddr))
+		}
+	}
+
+	if d.MetricsAddr != "" {
+		if _, _, err := net.SplitHostPort(d.MetricsAddr); err != nil {
+			errs = append(errs, fmt.Errorf("invalid metrics address %s: %w", d.MetricsAddr, err))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// Problems splits an error returned by Validate or ValidateFile into the
+// individual problems it reports
+func Problems(err error) []error {
+	if err == nil {
+		return nil
+	}
+	joined, ok := err.(interface{ Unwrap() []error })
+	if !ok {
+		return []error{err}
+	}
+
+	var problems []error
+	for _, e := range joined.Unwrap() {
+		problems = append(problems, Problems(e)...)
+	}
+	return problems
+}
+
+// configFile overrides the default configuration file path when set
+var configFile string
+
+// SetConfigFile sets the configuration file used by LoadConfig and SaveConfig.
+// An empty path selects the default location.
+func SetConfigFile(path string) {
+	configFile = path
 }
 
 // GetConfigDir returns the path to the configuration directory
 func GetConfigDir() string {
-	home, err := os.UserHomeDir()
-	if err != nil {
-		home = os.Getenv("HOME")
-	}
-	return filepath.Join(home, ".gateshift")
+	return utils.ConfigDir()
 }
 
 // GetDefaultConfigPath returns the path to the default configuration file
@@ -56,45 +248,144 @@ func GetDefaultConfigPath() string {
 	return filepath.Join(GetConfigDir(), "config.yaml")
 }
 
-// LoadConfig loads the configuration from file or creates default one if it doesn't exist
+// GetConfigPath returns the path to the configuration file in use
+func GetConfigPath() string {
+	if configFile != "" {
+		return configFile
+	}
+	return GetDefaultConfigPath()
+}
+
+// EnvPrefix is the prefix of the environment variables that override
+// configuration values. The rest of the name is the key in upper case with
+// dots replaced by underscores, e.g. GATESHIFT_DNS_LISTEN_PORT for
+// dns.listen_port. Lists take a comma-separated value.
+const EnvPrefix = "GATESHIFT"
+
+// bindEnv makes environment variables override the configuration file and
+// defaults in v
+func bindEnv(v *viper.Viper) {
+	v.SetEnvPrefix(EnvPrefix)
+	v.SetEnvKeyReplacer(strings.NewReplacer(".", "_"))
+	v.AutomaticEnv()
+}
+
+// LoadConfig loads the configuration from file or creates default one if it
+// doesn't exist. Environment variables override values from the file, which
+// override the defaults; see EnvPrefix.
 func LoadConfig() (*Config, error) {
-	configDir := GetConfigDir()
-	if err := os.MkdirAll(configDir, 0755); err != nil {
+	configPath := GetConfigPath()
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
 		return nil, fmt.Errorf("could not create config directory: %w", err)
 	}
 
-	viper.SetConfigName("config")
+	viper.SetConfigFile(configPath)
 	viper.SetConfigType("yaml")
-	viper.AddConfigPath(configDir)
 
 	// Set defaults
-	viper.SetDefault("proxy_gateway", "192.168.31.100")
-	viper.SetDefault("default_gateway", "192.168.31.1")
-	viper.SetDefault("dns.listen_addr", "127.0.0.1")
-	viper.SetDefault("dns.upstream_dns", []string{"1.1.1.1:53", "8.8.8.8:53"})
-
-	// Try to read config file
-	if err := viper.ReadInConfig(); err != nil {
-		// If config file doesn't exist, create it with defaults
-		if _, ok := err.(viper.ConfigFileNotFoundError); ok {
-			configFile := filepath.Join(configDir, "config.yaml")
-			if err := viper.SafeWriteConfigAs(configFile); err != nil {
-				return nil, fmt.Errorf("could not write default config: %w", err)
-			}
-		} else {
-			return nil, fmt.Errorf("could not read config: %w", err)
+	setDefaults(viper.GetViper())
+	bindEnv(viper.GetViper())
+
+	// If config file doesn't exist, create it with defaults. They are written
+	// from a separate instance so environment overrides do not end up in it.
+	if _, err := os.Stat(configPath); os.IsNotExist(err) {
+		defaults := viper.New()
+		setDefaults(defaults)
+		if err := defaults.SafeWriteConfigAs(configPath); err != nil {
+			return nil, fmt.Errorf("could not write default config: %w", err)
 		}
+	} else if err := viper.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("could not read config: %w", err)
 	}
 
 	var config Config
 	if err := viper.Unmarshal(&config); err != nil {
 		return nil, fmt.Errorf("could not unmarshal config: %w", err)
 	}
+	config.DNS.normalizeUpstreams()
 
 	return &config, nil
 }
 
-// SaveConfig saves the configuration to file
+// LoadSudoSettings returns the sudo_prompt and sudo_keepalive settings of the
+// configuration file, or their environment overrides, without creating the
+// file when it does not exist
+func LoadSudoSettings() (prompt string, keepAlive bool, err error) {
+	v := viper.New()
+	setDefaults(v)
+	bindEnv(v)
+	v.SetConfigFile(GetConfigPath())
+	v.SetConfigType("yaml")
+
+	if err := v.ReadInConfig(); err != nil && !os.IsNotExist(err) {
+		return "", false, fmt.Errorf("could not read config: %w", err)
+	}
+	return v.GetString("sudo_prompt"), v.GetBool("sudo_keepalive"), nil
+}
+
+// setDefaults registers the default value of every configuration key
+func setDefaults(v *viper.Viper) {
+	v.SetDefault("proxy_gateway", "192.168.31.100")
+	v.SetDefault("default_gateway", "192.168.31.1")
+	v.SetDefault("dns.listen_addr", "127.0.0.1")
+	v.SetDefault("dns.listen_port", 53)
+	v.SetDefault("dns.upstream_dns", []string{"8.8.8.8:53", "1.1.1.1:53"})
+	v.SetDefault("dns.fallback_dns", "9.9.9.9:53")
+	v.SetDefault("dns.strategy", "parallel")
+	v.SetDefault("dns.metrics_addr", "")
+	v.SetDefault("dns.log_format", "text")
+	v.SetDefault("dns.control_addr", "")
+	v.SetDefault("dns.filter_aaaa", false)
+	v.SetDefault("dns.query_log_max_size", 10)
+	v.SetDefault("dns.query_log_keep", 5)
+	v.SetDefault("dns.all_network_services", false)
+	v.SetDefault("dns.blocklist", []string{})
+	v.SetDefault("dns.blocklist_response", "nxdomain")
+	v.SetDefault("dns.recursion", "forward")
+	v.SetDefault("dns.hosts_file", "")
+	v.SetDefault("dns.idle_timeout", "0s")
+	v.SetDefault("dns.upstream_retries", dns.DefaultUpstreamRetries)
+	v.SetDefault("dns.retry_backoff", dns.DefaultRetryBackoff.String())
+	v.SetDefault("dns.cache_min_ttl", "0s")
+	v.SetDefault("dns.cache_max_ttl", "0s")
+	v.SetDefault("dns.serve_stale_max_age", "0s")
+	v.SetDefault("dns.qname_minimization", false)
+	v.SetDefault("dns.max_inflight", dns.DefaultMaxInflight)
+	v.SetDefault("hooks.on_proxy", "")
+	v.SetDefault("hooks.on_default", "")
+	v.SetDefault("hooks.timeout", "30s")
+	v.SetDefault("notifications", false)
+	v.SetDefault("sudo_prompt", utils.SudoPromptAuto)
+	v.SetDefault("sudo_keepalive", false)
+}
+
+// ValidateFile loads the configuration file at path without making it the
+// active configuration and validates it
+func ValidateFile(path string) (*Config, error) {
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("could not read config: %w", err)
+	}
+
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		return nil, fmt.Errorf("could not unmarshal config: %w", err)
+	}
+	config.DNS.normalizeUpstreams()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
+
+// SaveConfig saves the configuration to file. Only the values that differ
+// from the configuration LoadConfig returns now are written, so values that
+// come from environment variables stay out of the file unless config changes
+// them; the rest of the file is kept as it is.
 func SaveConfig(config *Config) error {
 	// 验证配置
 	if err := config.Validate(); err != nil {
@@ -102,40 +393,137 @@ func SaveConfig(config *Config) error {
 	}
 
 	// 确保配置目录存在
-	configDir := GetConfigDir()
-	if err := os.MkdirAll(configDir, 0755); err != nil {
+	configPath := GetConfigPath()
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
+		return fmt.Errorf("could not create config directory: %w", err)
+	}
+
+	file, err := readConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	effective, err := readConfigFile(configPath)
+	if err != nil {
+		return err
+	}
+	bindEnv(effective)
+	var current Config
+	if err := effective.Unmarshal(&current); err != nil {
+		return fmt.Errorf("could not unmarshal config: %w", err)
+	}
+	current.DNS.normalizeUpstreams()
+
+	// 与当前生效的配置比较，只写入被修改的键
+	before, after := viper.New(), viper.New()
+	setValues(before, &current)
+	setValues(after, config)
+	for _, key := range after.AllKeys() {
+		if !reflect.DeepEqual(before.Get(key), after.Get(key)) {
+			file.Set(key, after.Get(key))
+		}
+	}
+	return file.WriteConfigAs(configPath)
+}
+
+// writeConfig replaces the configuration file with every value of config
+func writeConfig(config *Config) error {
+	configPath := GetConfigPath()
+	if err := os.MkdirAll(filepath.Dir(configPath), 0755); err != nil {
 		return fmt.Errorf("could not create config directory: %w", err)
 	}
 
-	viper.Set("proxy_gateway", config.ProxyGateway)
-	viper.Set("default_gateway", config.DefaultGateway)
-	viper.Set("dns.listen_addr", config.DNS.ListenAddr)
-	viper.Set("dns.upstream_dns", config.DNS.UpstreamDNS)
+	v := viper.New()
+	setValues(v, config)
+	return v.WriteConfigAs(configPath)
+}
 
-	// 如果配置文件不存在，使用 SafeWriteConfigAs
-	configFile := viper.ConfigFileUsed()
-	if configFile == "" {
-		configFile = filepath.Join(configDir, "config.yaml")
-		return viper.SafeWriteConfigAs(configFile)
+// readConfigFile returns a viper instance holding the defaults and the
+// configuration file at path, when it exists, without environment overrides
+func readConfigFile(path string) (*viper.Viper, error) {
+	v := viper.New()
+	setDefaults(v)
+	v.SetConfigFile(path)
+	v.SetConfigType("yaml")
+	if _, err := os.Stat(path); os.IsNotExist(err) {
+		return v, nil
+	}
+	if err := v.ReadInConfig(); err != nil {
+		return nil, fmt.Errorf("could not read config: %w", err)
 	}
+	return v, nil
+}
 
-	return viper.WriteConfig()
+// setValues sets every configuration key in v from config
+func setValues(v *viper.Viper, config *Config) {
+	// 单个代理网关仍写为字符串，保持与旧版本配置文件兼容
+	if len(config.ProxyGateways) == 1 {
+		v.Set("proxy_gateway", config.ProxyGateways[0])
+	} else {
+		v.Set("proxy_gateway", config.ProxyGateways)
+	}
+	v.Set("default_gateway", config.DefaultGateway)
+	v.Set("dns.listen_addr", config.DNS.ListenAddr)
+	v.Set("dns.listen_port", config.DNS.ListenPort)
+	v.Set("dns.upstream_dns", config.DNS.UpstreamDNS)
+	v.Set("dns.fallback_dns", config.DNS.FallbackDNS)
+	v.Set("dns.strategy", config.DNS.Strategy)
+	v.Set("dns.metrics_addr", config.DNS.MetricsAddr)
+	v.Set("dns.log_format", config.DNS.LogFormat)
+	v.Set("dns.control_addr", config.DNS.ControlAddr)
+	v.Set("dns.filter_aaaa", config.DNS.FilterAAAA)
+	v.Set("dns.query_log_max_size", config.DNS.QueryLogMaxSize)
+	v.Set("dns.query_log_keep", config.DNS.QueryLogKeep)
+	v.Set("dns.all_network_services", config.DNS.AllNetworkServices)
+	v.Set("dns.blocklist", config.DNS.Blocklist)
+	v.Set("dns.blocklist_response", config.DNS.BlocklistResponse)
+	v.Set("dns.recursion", config.DNS.Recursion)
+	v.Set("dns.hosts_file", config.DNS.HostsFile)
+	v.Set("dns.idle_timeout", config.DNS.IdleTimeout.String())
+	v.Set("dns.upstream_retries", config.DNS.UpstreamRetries)
+	v.Set("dns.retry_backoff", config.DNS.RetryBackoff.String())
+	v.Set("dns.cache_min_ttl", config.DNS.CacheMinTTL.String())
+	v.Set("dns.cache_max_ttl", config.DNS.CacheMaxTTL.String())
+	v.Set("dns.serve_stale_max_age", config.DNS.ServeStaleMaxAge.String())
+	v.Set("dns.qname_minimization", config.DNS.QNAMEMinimization)
+	v.Set("dns.max_inflight", config.DNS.MaxInflight)
+	v.Set("hooks.on_proxy", config.Hooks.OnProxy)
+	v.Set("hooks.on_default", config.Hooks.OnDefault)
+	v.Set("hooks.timeout", config.Hooks.Timeout.String())
+	v.Set("notifications", config.Notifications)
+	v.Set("sudo_prompt", config.SudoPrompt)
+	v.Set("sudo_keepalive", config.SudoKeepAlive)
 }
 
 // ResetToDefaults resets all configuration to default values
 func ResetToDefaults() (*Config, error) {
 	// Create a new default config
 	config := &Config{
-		ProxyGateway:   "192.168.31.100",
+		ProxyGateways:  []string{"192.168.31.100"},
 		DefaultGateway: "192.168.31.1",
 		DNS: DNSConfig{
-			ListenAddr:  "127.0.0.1",
-			UpstreamDNS: []string{"1.1.1.1:53", "8.8.8.8:53"},
+			ListenAddr:        "127.0.0.1",
+			ListenPort:        53,
+			UpstreamDNS:       []string{"8.8.8.8:53", "1.1.1.1:53"},
+			FallbackDNS:       "9.9.9.9:53",
+			Strategy:          "parallel",
+			LogFormat:         "text",
+			ControlAddr:       "",
+			QueryLogMaxSize:   10,
+			QueryLogKeep:      5,
+			BlocklistResponse: "nxdomain",
+			Recursion:         "forward",
+			UpstreamRetries:   dns.DefaultUpstreamRetries,
+			RetryBackoff:      dns.DefaultRetryBackoff,
+			MaxInflight:       dns.DefaultMaxInflight,
+		},
+		Hooks: HooksConfig{
+			Timeout: 30 * time.Second,
 		},
+		SudoPrompt: utils.SudoPromptAuto,
 	}
 
-	// Save the default config
-	if err := SaveConfig(config); err != nil {
+	// Save the default config, replacing every value in the file
+	if err := writeConfig(config); err != nil {
 		return nil, fmt.Errorf("failed to save default configuration: %w", err)
 	}
 
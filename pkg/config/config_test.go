@@ -0,0 +1,417 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+
+	"github.com/ourines/GateShift/internal/dns"
+)
+
+func validDNSConfig() DNSConfig {
+	return DNSConfig{
+		ListenAddr:      "127.0.0.1",
+		ListenPort:      53,
+		UpstreamDNS:     []string{"8.8.8.8:53", "1.1.1.1:53"},
+		Strategy:        "parallel",
+		QueryLogMaxSize: 10,
+		QueryLogKeep:    5,
+		MaxInflight:     dns.DefaultMaxInflight,
+	}
+}
+
+func TestDNSConfigValidate(t *testing.T) {
+	tests := []struct {
+		name    string
+		modify  func(d *DNSConfig)
+		wantErr string
+	}{
+		{"valid", func(d *DNSConfig) {}, ""},
+		{"empty strategy", func(d *DNSConfig) { d.Strategy = "" }, ""},
+		{"bad listen address", func(d *DNSConfig) { d.ListenAddr = "localhost" }, "listen address"},
+		{"port zero", func(d *DNSConfig) { d.ListenPort = 0 }, "listen port"},
+		{"port too large", func(d *DNSConfig) { d.ListenPort = 70000 }, "listen port"},
+		{"upstream without port", func(d *DNSConfig) { d.UpstreamDNS = []string{"8.8.8.8"} }, "upstream"},
+		{"upstream DoH", func(d *DNSConfig) { d.UpstreamDNS = []string{"https://dns.google/dns-query"} }, "not supported"},
+		{"unknown strategy", func(d *DNSConfig) { d.Strategy = "random" }, "strategy"},
+		{"public control address", func(d *DNSConfig) { d.ControlAddr = "0.0.0.0:5380" }, "loopback"},
+		{"query log size zero", func(d *DNSConfig) { d.QueryLogMaxSize = 0 }, "query log size"},
+		{"negative query log keep", func(d *DNSConfig) { d.QueryLogKeep = -1 }, "query logs to keep"},
+	}
+
+	for _, tt := range tests {
+		d := validDNSConfig()
+		tt.modify(&d)
+		err := d.Validate()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: error = %v, want containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestConfigValidateProxyGateways(t *testing.T) {
+	tests := []struct {
+		name     string
+		gateways []string
+		wantErr  string
+	}{
+		{"single", []string{"192.168.1.2"}, ""},
+		{"list", []string{"192.168.1.2", "192.168.1.3"}, ""},
+		{"empty", nil, "required"},
+		{"invalid entry", []string{"192.168.1.2", "proxy"}, "invalid proxy gateway"},
+		{"duplicate", []string{"192.168.1.2", "192.168.1.2"}, "duplicate"},
+	}
+
+	for _, tt := range tests {
+		c := Config{ProxyGateways: tt.gateways, DefaultGateway: "192.168.1.1", DNS: validDNSConfig()}
+		err := c.Validate()
+		switch {
+		case tt.wantErr == "" && err != nil:
+			t.Errorf("%s: unexpected error %v", tt.name, err)
+		case tt.wantErr != "" && (err == nil || !strings.Contains(err.Error(), tt.wantErr)):
+			t.Errorf("%s: error = %v, want containing %q", tt.name, err, tt.wantErr)
+		}
+	}
+}
+
+func TestConfigValidateReportsAllProblems(t *testing.T) {
+	dnsConfig := validDNSConfig()
+	dnsConfig.ListenAddr = "localhost"
+	dnsConfig.ListenPort = 0
+	dnsConfig.UpstreamDNS = []string{"8.8.8.8:53", "dns.google"}
+	c := Config{ProxyGateways: []string{"proxy"}, DNS: dnsConfig}
+
+	var got []string
+	for _, problem := range Problems(c.Validate()) {
+		got = append(got, problem.Error())
+	}
+	want := []string{
+		"default gateway is required",
+		"invalid proxy gateway",
+		"listen address",
+		"listen port",
+		"dns.google",
+	}
+	if len(got) != len(want) {
+		t.Fatalf("got %d problems, want %d:\n%s", len(got), len(want), strings.Join(got, "\n"))
+	}
+	for i := range want {
+		if !strings.Contains(got[i], want[i]) {
+			t.Errorf("problem %d = %q, want containing %q", i, got[i], want[i])
+		}
+	}
+
+	if problems := Problems(nil); problems != nil {
+		t.Errorf("Problems(nil) = %v, want nil", problems)
+	}
+}
+
+func TestConfigValidateMultipleErrors(t *testing.T) {
+	tests := []struct {
+		name   string
+		config Config
+		want   []string
+	}{
+		{
+			"missing gateways",
+			Config{DNS: validDNSConfig()},
+			[]string{"default gateway is required", "proxy gateway is required"},
+		},
+		{
+			"malformed gateways",
+			Config{ProxyGateways: []string{"proxy", "192.168.1.2", "192.168.1.2"}, DefaultGateway: "router", DNS: validDNSConfig()},
+			[]string{"invalid default gateway", "invalid proxy gateway", "duplicate proxy gateway"},
+		},
+		{
+			"gateway and DNS problems",
+			Config{ProxyGateways: []string{"192.168.1.2"}, DNS: DNSConfig{ListenPort: 70000, QueryLogMaxSize: 10, MaxInflight: 1}},
+			[]string{"default gateway is required", "listen address", "listen port"},
+		},
+	}
+
+	for _, tt := range tests {
+		err := tt.config.Validate()
+		if err == nil {
+			t.Errorf("%s: expected an error", tt.name)
+			continue
+		}
+		problems := Problems(err)
+		if len(problems) != len(tt.want) {
+			t.Errorf("%s: got %d problems (%v), want %d", tt.name, len(problems), err, len(tt.want))
+			continue
+		}
+		for i, want := range tt.want {
+			if !strings.Contains(problems[i].Error(), want) {
+				t.Errorf("%s: problem %d = %q, want containing %q", tt.name, i, problems[i], want)
+			}
+			// Every problem also appears in the combined message
+			if !strings.Contains(err.Error(), problems[i].Error()) {
+				t.Errorf("%s: %q missing from %q", tt.name, problems[i], err)
+			}
+		}
+	}
+}
+
+func TestLoadConfigProxyGateway(t *testing.T) {
+	tests := []struct {
+		name string
+		yaml string
+		want []string
+	}{
+		{"single value", "proxy_gateway: 192.168.1.2\n", []string{"192.168.1.2"}},
+		{"list", "proxy_gateway:\n  - 192.168.1.2\n  - 192.168.1.3\n", []string{"192.168.1.2", "192.168.1.3"}},
+	}
+
+	defer SetConfigFile("")
+	for _, tt := range tests {
+		viper.Reset()
+		path := filepath.Join(t.TempDir(), "config.yaml")
+		if err := os.WriteFile(path, []byte(tt.yaml), 0644); err != nil {
+			t.Fatal(err)
+		}
+		SetConfigFile(path)
+
+		cfg, err := LoadConfig()
+		if err != nil {
+			t.Fatalf("%s: %v", tt.name, err)
+		}
+		if !reflect.DeepEqual(cfg.ProxyGateways, tt.want) {
+			t.Errorf("%s: ProxyGateways = %v, want %v", tt.name, cfg.ProxyGateways, tt.want)
+		}
+	}
+}
+
+func TestLoadConfigNormalizesUpstreams(t *testing.T) {
+	defer SetConfigFile("")
+	viper.Reset()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	yaml := "dns:\n  upstream_dns:\n    - 8.8.8.8\n    - 1.1.1.1:5353\n    - 2606:4700:4700::1111\n    - \"[2001:4860:4860::8888]\"\n  fallback_dns: 9.9.9.9\n"
+	if err := os.WriteFile(path, []byte(yaml), 0644); err != nil {
+		t.Fatal(err)
+	}
+	SetConfigFile(path)
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{"8.8.8.8:53", "1.1.1.1:5353", "[2606:4700:4700::1111]:53", "[2001:4860:4860::8888]:53"}
+	if !reflect.DeepEqual(cfg.DNS.UpstreamDNS, want) {
+		t.Errorf("UpstreamDNS = %v, want %v", cfg.DNS.UpstreamDNS, want)
+	}
+	if cfg.DNS.FallbackDNS != "9.9.9.9:53" {
+		t.Errorf("FallbackDNS = %q, want %q", cfg.DNS.FallbackDNS, "9.9.9.9:53")
+	}
+}
+
+func TestNormalizeUpstreamsKeepsMalformedEntries(t *testing.T) {
+	d := validDNSConfig()
+	d.UpstreamDNS = []string{"8.8.8.8", "dns.google", "1.1.1.1:99999"}
+	d.FallbackDNS = "https://dns.quad9.net/dns-query"
+	d.normalizeUpstreams()
+
+	want := []string{"8.8.8.8:53", "dns.google", "1.1.1.1:99999"}
+	if !reflect.DeepEqual(d.UpstreamDNS, want) {
+		t.Errorf("UpstreamDNS = %v, want %v", d.UpstreamDNS, want)
+	}
+	if err := d.Validate(); len(Problems(err)) != 3 {
+		t.Errorf("Validate() = %v, want 3 problems", err)
+	}
+}
+
+func TestValidateFile(t *testing.T) {
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+		return path
+	}
+
+	cfg, err := ValidateFile(write("valid.yaml", "proxy_gateway: 192.168.1.2\ndefault_gateway: 192.168.1.1\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DefaultGateway != "192.168.1.1" || cfg.DNS.ListenPort != 53 {
+		t.Errorf("unexpected config %+v", cfg)
+	}
+
+	for name, content := range map[string]string{
+		"bad-gateway.yaml": "proxy_gateway: router\n",
+		"bad-dns.yaml":     "dns:\n  strategy: random\n",
+		"bad-yaml.yaml":    "proxy_gateway: [\n",
+	} {
+		if _, err := ValidateFile(write(name, content)); err == nil {
+			t.Errorf("%s: expected a validation error", name)
+		}
+	}
+}
+
+func TestLoadSudoSettings(t *testing.T) {
+	defer SetConfigFile("")
+	dir := t.TempDir()
+
+	// A missing file is not created and gives the default
+	path := filepath.Join(dir, "missing.yaml")
+	SetConfigFile(path)
+	if mode, keepAlive, err := LoadSudoSettings(); err != nil || mode != "auto" || keepAlive {
+		t.Errorf("LoadSudoSettings() = %q, %v, %v, want auto, false", mode, keepAlive, err)
+	}
+	if _, err := os.Stat(path); !os.IsNotExist(err) {
+		t.Errorf("LoadSudoSettings created %s", path)
+	}
+
+	path = filepath.Join(dir, "config.yaml")
+	if err := os.WriteFile(path, []byte("sudo_prompt: gui\nsudo_keepalive: true\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	SetConfigFile(path)
+	if mode, keepAlive, err := LoadSudoSettings(); err != nil || mode != "gui" || !keepAlive {
+		t.Errorf("LoadSudoSettings() = %q, %v, %v, want gui, true", mode, keepAlive, err)
+	}
+
+	if err := os.WriteFile(path, []byte("sudo_prompt: dialog\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := ValidateFile(path); err == nil {
+		t.Error("ValidateFile accepted an invalid sudo_prompt")
+	}
+}
+
+func TestLoadConfigEnvOverrides(t *testing.T) {
+	defer SetConfigFile("")
+	viper.Reset()
+	defer viper.Reset()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "proxy_gateway: 192.168.1.2\ndefault_gateway: 192.168.1.1\ndns:\n  listen_port: 53\n  strategy: sequential\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	SetConfigFile(path)
+
+	t.Setenv("GATESHIFT_PROXY_GATEWAY", "10.0.0.2,10.0.0.3")
+	t.Setenv("GATESHIFT_DNS_LISTEN_PORT", "5353")
+	t.Setenv("GATESHIFT_DNS_UPSTREAM_DNS", "9.9.9.9:53,1.0.0.1:53")
+	t.Setenv("GATESHIFT_DNS_FILTER_AAAA", "true")
+	t.Setenv("GATESHIFT_HOOKS_TIMEOUT", "5s")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Environment over file over defaults
+	if want := []string{"10.0.0.2", "10.0.0.3"}; !reflect.DeepEqual(cfg.ProxyGateways, want) {
+		t.Errorf("ProxyGateways = %v, want %v", cfg.ProxyGateways, want)
+	}
+	if cfg.DNS.ListenPort != 5353 {
+		t.Errorf("ListenPort = %d, want 5353", cfg.DNS.ListenPort)
+	}
+	if want := []string{"9.9.9.9:53", "1.0.0.1:53"}; !reflect.DeepEqual(cfg.DNS.UpstreamDNS, want) {
+		t.Errorf("UpstreamDNS = %v, want %v", cfg.DNS.UpstreamDNS, want)
+	}
+	if !cfg.DNS.FilterAAAA {
+		t.Error("FilterAAAA = false, want true")
+	}
+	if cfg.Hooks.Timeout != 5*time.Second {
+		t.Errorf("Hooks.Timeout = %v, want 5s", cfg.Hooks.Timeout)
+	}
+	if cfg.DefaultGateway != "192.168.1.1" || cfg.DNS.Strategy != "sequential" {
+		t.Errorf("file values not kept: %+v", cfg)
+	}
+	if cfg.DNS.FallbackDNS != "9.9.9.9:53" {
+		t.Errorf("FallbackDNS = %q, want the default", cfg.DNS.FallbackDNS)
+	}
+
+	t.Setenv("GATESHIFT_SUDO_PROMPT", "gui")
+	if mode, _, err := LoadSudoSettings(); err != nil || mode != "gui" {
+		t.Errorf("LoadSudoSettings() = %q, %v, want gui", mode, err)
+	}
+}
+
+func TestLoadConfigEnvNotWrittenToNewFile(t *testing.T) {
+	defer SetConfigFile("")
+	viper.Reset()
+	defer viper.Reset()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	SetConfigFile(path)
+	t.Setenv("GATESHIFT_DNS_LISTEN_PORT", "5353")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.DNS.ListenPort != 5353 {
+		t.Errorf("ListenPort = %d, want 5353", cfg.DNS.ListenPort)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(data), "5353") {
+		t.Errorf("environment override written to the default config:\n%s", data)
+	}
+}
+
+func TestSaveConfigKeepsEnvOverridesOutOfFile(t *testing.T) {
+	defer SetConfigFile("")
+	viper.Reset()
+	defer viper.Reset()
+
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	content := "proxy_gateway: 192.168.1.2\ndefault_gateway: 192.168.1.1\ndns:\n  listen_port: 53\n  strategy: priority\n"
+	if err := os.WriteFile(path, []byte(content), 0644); err != nil {
+		t.Fatal(err)
+	}
+	SetConfigFile(path)
+	t.Setenv("GATESHIFT_DNS_LISTEN_PORT", "5353")
+	t.Setenv("GATESHIFT_DNS_UPSTREAM_DNS", "9.9.9.9:53,1.0.0.1:53")
+
+	cfg, err := LoadConfig()
+	if err != nil {
+		t.Fatal(err)
+	}
+	cfg.DNS.FilterAAAA = true
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+
+	saved, err := ValidateFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !saved.DNS.FilterAAAA {
+		t.Error("changed value not saved")
+	}
+	if saved.DNS.ListenPort != 53 {
+		t.Errorf("listen_port = %d in the file, want 53 rather than the environment override", saved.DNS.ListenPort)
+	}
+	if want := []string{"8.8.8.8:53", "1.1.1.1:53"}; !reflect.DeepEqual(saved.DNS.UpstreamDNS, want) {
+		t.Errorf("upstream_dns = %v in the file, want the default %v", saved.DNS.UpstreamDNS, want)
+	}
+	if saved.DNS.Strategy != "priority" || saved.DefaultGateway != "192.168.1.1" {
+		t.Errorf("file values not kept: %+v", saved)
+	}
+
+	// A value the command changes is saved even when the environment
+	// overrides it
+	cfg.DNS.ListenPort = 5300
+	if err := SaveConfig(cfg); err != nil {
+		t.Fatal(err)
+	}
+	if saved, err = ValidateFile(path); err != nil || saved.DNS.ListenPort != 5300 {
+		t.Errorf("listen_port after changing it = %v, %v, want 5300", saved.DNS.ListenPort, err)
+	}
+}
@@ -0,0 +1,94 @@
+package config
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+
+	"github.com/spf13/viper"
+	"gopkg.in/yaml.v3"
+)
+
+// ExportVersion is the version of the export format written by Export.
+// Import accepts files of this and earlier versions, as well as plain
+// configuration files, which have no version.
+const ExportVersion = 1
+
+// exportVersionKey is the top-level key holding the export format version
+const exportVersionKey = "gateshift_export_version"
+
+// Export writes the complete configuration, including settings left at
+// their defaults, to w as YAML that Import accepts on another machine
+func Export(config *Config, w io.Writer) error {
+	v := viper.New()
+	setValues(v, config)
+	v.Set(exportVersionKey, ExportVersion)
+
+	data, err := yaml.Marshal(v.AllSettings())
+	if err != nil {
+		return fmt.Errorf("could not encode config: %w", err)
+	}
+	_, err = w.Write(data)
+	return err
+}
+
+// Redacted replaces the value of secret settings in Settings
+const Redacted = "<redacted>"
+
+// secretKeys are the keys whose values Settings redacts. No current setting
+// is secret; keys added here stay in the configuration file and exports.
+var secretKeys []string
+
+// Settings returns config as the nested keys and values SaveConfig writes,
+// with the values of secret settings replaced by Redacted for display
+func Settings(config *Config) map[string]interface{} {
+	v := viper.New()
+	setValues(v, config)
+	for _, key := range secretKeys {
+		if v.GetString(key) != "" {
+			v.Set(key, Redacted)
+		}
+	}
+	return v.AllSettings()
+}
+
+// Import reads a configuration written by Export, or a configuration file,
+// from r and returns it once it is valid. With merge, settings missing from
+// r keep their value in current; otherwise they get their defaults. Lists
+// such as the blocklist are replaced as a whole, not merged.
+func Import(r io.Reader, current *Config, merge bool) (*Config, error) {
+	data, err := io.ReadAll(r)
+	if err != nil {
+		return nil, fmt.Errorf("could not read import: %w", err)
+	}
+
+	v := viper.New()
+	v.SetConfigType("yaml")
+	setDefaults(v)
+	if merge && current != nil {
+		// Values set with Set would take precedence over the import, so the
+		// current configuration is merged in as config file values instead
+		existing := viper.New()
+		setValues(existing, current)
+		if err := v.MergeConfigMap(existing.AllSettings()); err != nil {
+			return nil, err
+		}
+	}
+	if err := v.MergeConfig(bytes.NewReader(data)); err != nil {
+		return nil, fmt.Errorf("could not parse import: %w", err)
+	}
+
+	if version := v.GetInt(exportVersionKey); version > ExportVersion {
+		return nil, fmt.Errorf("the file was exported by a newer version of GateShift (format %d, this version supports up to %d)", version, ExportVersion)
+	}
+
+	var config Config
+	if err := v.Unmarshal(&config); err != nil {
+		return nil, fmt.Errorf("could not unmarshal config: %w", err)
+	}
+	config.DNS.normalizeUpstreams()
+	if err := config.Validate(); err != nil {
+		return nil, err
+	}
+	return &config, nil
+}
@@ -0,0 +1,118 @@
+package config
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+)
+
+func exportTestConfig() *Config {
+	return &Config{
+		ProxyGateways:  []string{"10.0.0.2", "10.0.0.3"},
+		DefaultGateway: "10.0.0.1",
+		DNS: DNSConfig{
+			ListenAddr:        "127.0.0.1",
+			ListenPort:        5353,
+			UpstreamDNS:       []string{"9.9.9.9:53"},
+			FallbackDNS:       "1.1.1.1:53",
+			Strategy:          "priority",
+			LogFormat:         "json",
+			QueryLogMaxSize:   20,
+			QueryLogKeep:      2,
+			Blocklist:         []string{"ads.example.com"},
+			BlocklistResponse: "zeroip",
+			MaxInflight:       512,
+		},
+		Hooks:         HooksConfig{OnProxy: "echo proxy", Timeout: 10 * time.Second},
+		Notifications: true,
+		SudoPrompt:    "gui",
+	}
+}
+
+func TestExportImportRoundTrip(t *testing.T) {
+	want := exportTestConfig()
+
+	var buf bytes.Buffer
+	if err := Export(want, &buf); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(buf.String(), "gateshift_export_version: 1") {
+		t.Errorf("export has no format version:\n%s", buf.String())
+	}
+
+	got, err := Import(&buf, nil, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("imported %+v, want %+v", got, want)
+	}
+}
+
+func TestImportMergeAndReplace(t *testing.T) {
+	current := exportTestConfig()
+	file := "default_gateway: 192.168.1.1\ndns:\n  blocklist:\n    - tracker.net\n"
+
+	merged, err := Import(strings.NewReader(file), current, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if merged.DefaultGateway != "192.168.1.1" || !reflect.DeepEqual(merged.DNS.Blocklist, []string{"tracker.net"}) {
+		t.Errorf("merge did not apply the imported settings: %+v", merged)
+	}
+	if merged.DNS.ListenPort != 5353 || merged.DNS.BlocklistResponse != "zeroip" || merged.Hooks.OnProxy != "echo proxy" {
+		t.Errorf("merge lost existing settings: %+v", merged)
+	}
+
+	replaced, err := Import(strings.NewReader(file), current, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if replaced.DNS.ListenPort != 53 || replaced.DNS.BlocklistResponse != "nxdomain" || replaced.Hooks.OnProxy != "" {
+		t.Errorf("replace kept existing settings: %+v", replaced)
+	}
+}
+
+func TestImportRejects(t *testing.T) {
+	for name, file := range map[string]string{
+		"newer format":   "gateshift_export_version: 99\n",
+		"invalid config": "default_gateway: router\n",
+		"invalid yaml":   "proxy_gateway: [\n",
+	} {
+		if _, err := Import(strings.NewReader(file), nil, false); err == nil {
+			t.Errorf("%s: Import succeeded", name)
+		}
+	}
+}
+
+func TestSettingsRedactsSecrets(t *testing.T) {
+	cfg := exportTestConfig()
+	cfg.Hooks.OnProxy = "curl -H 'Authorization: token' https://example.com"
+
+	settings := Settings(cfg)
+	hooks := settings["hooks"].(map[string]interface{})
+	if hooks["on_proxy"] != cfg.Hooks.OnProxy {
+		t.Errorf("on_proxy = %v, want it unredacted", hooks["on_proxy"])
+	}
+	if settings["default_gateway"] != cfg.DefaultGateway {
+		t.Errorf("default_gateway = %v, want %s", settings["default_gateway"], cfg.DefaultGateway)
+	}
+
+	defer func(keys []string) { secretKeys = keys }(secretKeys)
+	secretKeys = []string{"hooks.on_proxy", "hooks.on_default"}
+
+	settings = Settings(cfg)
+	hooks = settings["hooks"].(map[string]interface{})
+	if hooks["on_proxy"] != Redacted {
+		t.Errorf("on_proxy = %v, want %s", hooks["on_proxy"], Redacted)
+	}
+	// Empty secrets are shown as they are
+	if hooks["on_default"] != "" {
+		t.Errorf("on_default = %v, want empty", hooks["on_default"])
+	}
+	if cfg.Hooks.OnProxy == Redacted {
+		t.Error("Settings modified the config")
+	}
+}